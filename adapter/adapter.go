@@ -0,0 +1,331 @@
+// Package adapter exposes the Poe OpenAI adapter as an http.Handler, to be served on its own or
+// mounted in another Go server.
+//
+// An Adapter is created with New, its dependencies and settings given as Options; those left
+// unset are built from the configuration, by default the built-in one, which serves the API with
+// in-memory stores and a Poe client with its default settings:
+//
+//	a, err := adapter.New(
+//		adapter.WithLogger(logger),
+//		adapter.WithConfig(cfg),
+//		adapter.WithMiddleware(middleware.RequestID, middleware.Recoverer),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	defer a.Close()
+//	a.Mount(router, "/poe")
+//
+// Start runs the upstream probes and the discovery of the bot settings in the background. The
+// start command is a wrapper around it, and tests can serve requests with
+// httptest.NewRecorder and a fake backend, see WithBackend.
+package adapter
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/config"
+	"github.com/supergeoff/poepenai/handlers"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// Adapter serves the OpenAI, Anthropic and Ollama compatible APIs backed by Poe, the health
+// checks, and optionally the admin pages. It implements http.Handler.
+type Adapter struct {
+	handlers *handlers.AppHandlers
+	router   *chi.Mux
+	admin    http.Handler
+
+	logger *slog.Logger
+	config *config.Config
+	// upstream is the backend of the Poe queries, counted by the upstream statistics, without the
+	// query cache: the probes and the settings discovery go through it.
+	upstream client.Backend
+	// recorder is the exchange recorder of the configuration, closed by Close, or nil.
+	recorder *service.Recorder
+}
+
+// New creates an Adapter configured by opts. The dependencies not set by opts are built from the
+// configuration (see WithConfig); the Adapter must then be closed with Close.
+func New(opts ...Option) (*Adapter, error) {
+	o := options{
+		logger: slog.Default(),
+		config: config.Default(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cfg := o.config
+	if o.backend == nil {
+		poeClient, err := cfg.PoeClient()
+		if err != nil {
+			return nil, err
+		}
+		if mode, _ := client.ParseCassetteMode(cfg.Cassette.Mode); mode != client.CassetteOff {
+			o.logger.Info("Using Poe cassette", "mode", cfg.Cassette.Mode, "dir", cfg.Cassette.Dir)
+		}
+		o.backend = cfg.Poe.Backend(poeClient)
+	}
+	if o.runtime == nil {
+		o.runtime = service.NewRuntimeConfigStore(cfg.RuntimeConfig(nil))
+	}
+	if o.imageConfig == nil {
+		imageConfig, err := cfg.Images.ImageConfig()
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Images.ConfigFile != "" {
+			o.logger.Info("Loaded images config", "path", cfg.Images.ConfigFile)
+		}
+		o.imageConfig = &imageConfig
+	}
+	if o.responseStore == nil {
+		o.responseStore = service.NewMemoryResponseStore(cfg.Stores.ResponseSize)
+	}
+	if o.conversationStore == nil {
+		conversationStore, err := cfg.Stores.ConversationStore()
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Stores.ConversationDir != "" {
+			o.logger.Info("Using file conversation store", "dir", cfg.Stores.ConversationDir)
+		}
+		o.conversationStore = conversationStore
+	}
+	if o.contextConfig == nil {
+		contextConfig, err := cfg.Context.ContextConfig()
+		if err != nil {
+			return nil, err
+		}
+		o.contextConfig = &contextConfig
+	}
+	if o.queryCache == nil {
+		queryCache, queryCacheConfig, err := cfg.QueryCache.QueryCache()
+		if err != nil {
+			return nil, err
+		}
+		if cfg.QueryCache.Dir != "" {
+			o.logger.Info("Using file query cache", "dir", cfg.QueryCache.Dir)
+		}
+		o.queryCache = queryCache
+		o.queryCacheConfig = queryCacheConfig
+	}
+	if o.coalesceConfig == nil {
+		coalesceConfig := cfg.Coalescing.CoalesceConfig()
+		o.coalesceConfig = &coalesceConfig
+	}
+	if o.exchanges == nil {
+		exchanges, err := cfg.Inspector.ExchangeStore()
+		if err != nil {
+			return nil, err
+		}
+		o.exchanges = exchanges
+	}
+	if o.redactor == nil {
+		redactor, err := cfg.Logging.Redact.RecordingRedactor()
+		if err != nil {
+			return nil, err
+		}
+		o.redactor = redactor
+	}
+	if o.lifecycle == nil {
+		o.lifecycle = service.NewLifecycle()
+	}
+	if o.upstream == nil {
+		o.upstream = service.NewUpstreamHealth(cfg.Health.ProbeBot)
+	}
+	recorder, err := cfg.Recording.Recorder()
+	if err != nil {
+		return nil, err
+	}
+	if recorder != nil {
+		o.logger.Info("Recording exchanges", "dir", cfg.Recording.Dir)
+		o.exchangeSinks = append(o.exchangeSinks, recorder)
+	}
+
+	// Queries are recorded with their exchanges however they are served, only the queries not
+	// joining an in-flight one go through the cache, and only those sent to Poe are counted.
+	upstream := client.Chain(
+		o.backend,
+		client.Observe(func(_ string, attempts int, err error) {
+			o.upstream.RecordQuery(attempts, err)
+		}),
+	)
+	queryCache := service.NewQueryCacheManager(o.queryCache, o.queryCacheConfig)
+	coalescer := service.NewQueryCoalescer(*o.coalesceConfig)
+	backend := client.Chain(
+		upstream,
+		client.Record(service.RecordExchangeQuery),
+		client.Coalesce(coalescer),
+		client.CacheQueries(queryCache),
+	)
+
+	appHandlers := handlers.NewAppHandlers(
+		o.logger,
+		backend,
+		o.ringBufferLogger,
+		o.logsTemplate,
+		o.inspectorTemplate,
+		*o.imageConfig,
+		o.responseStore,
+		o.runtime,
+		service.NewConversationManager(o.conversationStore),
+		service.NewContextManager(*o.contextConfig, o.runtime),
+		queryCache,
+		coalescer,
+		o.exchanges,
+		o.logLevels,
+	)
+	appHandlers.ReloadConfig = o.reloadConfig
+	appHandlers.Lifecycle = o.lifecycle
+	appHandlers.Upstream = o.upstream
+	appHandlers.Version = o.version
+	appHandlers.ConfigHash = o.configHash
+
+	a := &Adapter{
+		handlers: appHandlers,
+		logger:   o.logger,
+		config:   cfg,
+		upstream: upstream,
+		recorder: recorder,
+	}
+	var adminRoutes http.Handler
+	if o.adminAuth != nil {
+		adminRoutes = newAdminRouter(appHandlers, o.adminAuth)
+		adminRouter := chi.NewRouter()
+		adminRouter.Use(o.middlewares...)
+		adminRouter.Mount("/admin", adminRoutes)
+		a.admin = adminRouter
+		if o.adminSeparate {
+			adminRoutes = nil
+		}
+	}
+	a.router = newRouter(appHandlers, &o, adminRoutes)
+	return a, nil
+}
+
+// Start starts the background tasks of the adapter, until ctx is done: the upstream probes of
+// the configured bot (health.probe_bot), and the discovery of the settings of the bots of the
+// catalog if configured (poe.discover_settings).
+func (a *Adapter) Start(ctx context.Context) {
+	health := a.config.Health
+	if health.ProbeBot != "" {
+		a.logger.Info("Probing Poe", "bot_name", health.ProbeBot, "interval", time.Duration(health.ProbeInterval))
+		go a.handlers.Upstream.RunProbes(
+			ctx,
+			a.logger,
+			time.Duration(health.ProbeInterval),
+			time.Duration(health.ProbeTimeout),
+			upstreamProbe(a.upstream, health.ProbeBot, a.config.Auth.PoeAPIKey),
+		)
+	}
+	if a.config.Poe.DiscoverSettings {
+		go a.discoverBotSettings(ctx, a.config.Auth.PoeAPIKey)
+	}
+}
+
+// Close closes the exchange recorder of the configuration, if any.
+func (a *Adapter) Close() error {
+	if a.recorder == nil {
+		return nil
+	}
+	return a.recorder.Close()
+}
+
+// ServeHTTP serves the APIs, the health checks (/healthz and /readyz) and, unless they are
+// served separately, the admin pages under /admin.
+func (a *Adapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.router.ServeHTTP(w, r)
+}
+
+// AdminHandler returns the handler of the admin pages, served under /admin, to be served
+// separately from the APIs. It returns nil if the admin pages are disabled, see WithAdmin.
+func (a *Adapter) AdminHandler() http.Handler {
+	return a.admin
+}
+
+// Handlers returns the HTTP handlers of the adapter, whose fields must not be changed once it
+// serves requests.
+func (a *Adapter) Handlers() *handlers.AppHandlers {
+	return a.handlers
+}
+
+// Mount mounts the adapter under prefix on r, e.g. "/poe" to serve the chat completions API at
+// /poe/v1/chat/completions. Routers of the standard library can use http.StripPrefix instead.
+func (a *Adapter) Mount(r chi.Router, prefix string) {
+	r.Mount(prefix, a)
+}
+
+// newRouter creates the HTTP router serving appHandlers. API requests are authenticated and
+// limited as configured. API exchanges are kept for the request inspector, and recorded to the
+// configured sinks. The admin routes are mounted under /admin unless they are nil. The health
+// checks are served without middlewares, so that probes do not flood the access log.
+func newRouter(appHandlers *handlers.AppHandlers, o *options, adminRoutes http.Handler) *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/healthz", appHandlers.HandleHealthz)
+	r.Get("/readyz", appHandlers.HandleReadyz)
+
+	r.Group(func(r chi.Router) {
+		r.Use(o.middlewares...)
+
+		r.Group(func(r chi.Router) {
+			// The live log stream is long-lived; only API requests time out.
+			if o.config.Server.RequestTimeout > 0 {
+				r.Use(middleware.Timeout(time.Duration(o.config.Server.RequestTimeout)))
+			}
+			if o.config.Limits.MaxBodyBytes > 0 {
+				r.Use(middleware.RequestSize(o.config.Limits.MaxBodyBytes))
+			}
+			sinks := append([]service.ExchangeSink{appHandlers.Exchanges}, o.exchangeSinks...)
+			r.Use(handlers.RecordExchanges(appHandlers.Logger, service.NewRedactingSink(o.redactor, sinks...)))
+			r.Use(handlers.APIKeys(appHandlers.Logger, appHandlers.Runtime))
+
+			r.Post("/v1/chat/completions", appHandlers.HandleChatCompletions)
+			r.Post("/v1/completions", appHandlers.HandleCompletions)
+			r.Post("/v1/images/generations", appHandlers.HandleImageGenerations)
+			r.Post("/v1/images/edits", appHandlers.HandleImageEdits)
+			r.Post("/v1/responses", appHandlers.HandleResponses)
+			r.Get("/v1/responses/{responseID}", appHandlers.HandleGetResponse)
+			r.Post("/v1/messages", appHandlers.HandleAnthropicMessages)
+
+			// Ollama-compatible API. Clients usually send no credentials; auth.poe_api_key (or
+			// the POE_API_KEY environment variable) then provides the key if
+			// auth.allow_anonymous_ollama is set.
+			r.Post("/api/chat", appHandlers.HandleOllamaChat)
+			r.Post("/api/generate", appHandlers.HandleOllamaGenerate)
+			r.Get("/api/tags", appHandlers.HandleOllamaTags)
+			r.Post("/api/show", appHandlers.HandleOllamaShow)
+		})
+
+		if adminRoutes != nil {
+			r.Mount("/admin", adminRoutes)
+		}
+	})
+	return r
+}
+
+// newAdminRouter creates the router of the admin pages, to be mounted under /admin, behind the
+// adminAuth middleware. The logs and request inspector pages show prompts and responses,
+// /loglevel changes the log levels at runtime, /config reloads the configuration, and /info
+// reports the version, uptime, upstream health and query cache counts.
+func newAdminRouter(appHandlers *handlers.AppHandlers, adminAuth func(http.Handler) http.Handler) *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(adminAuth)
+	r.Get("/logs", appHandlers.HandleLogsPage)
+	r.Get("/logs/stream", appHandlers.HandleLogsStream)
+	r.Get("/inspector", appHandlers.HandleInspectorList)
+	r.Get("/inspector/requests/*", appHandlers.HandleInspectorDetail)
+	r.Get("/loglevel", appHandlers.HandleGetLogLevel)
+	r.Post("/loglevel", appHandlers.HandleSetLogLevel)
+	r.Delete("/loglevel", appHandlers.HandleResetLogLevel)
+	r.Get("/config", appHandlers.HandleConfigStatus)
+	r.Post("/config/reload", appHandlers.HandleReloadConfig)
+	r.Get("/info", appHandlers.HandleAdminInfo)
+	return r
+}
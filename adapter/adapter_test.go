@@ -0,0 +1,287 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/config"
+	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// fakeBackend is a client.Backend answering every query with events, or with the same text if
+// there are none, and settings requests with settings.
+type fakeBackend struct {
+	events   []types.PoeSSEEvent
+	settings map[string]*types.PoeSettingsResponse
+
+	mu      sync.Mutex
+	queries []string // Bot name and API key of each query.
+}
+
+func (b *fakeBackend) StreamQuery(
+	ctx context.Context,
+	botName string,
+	req *types.PoeQueryRequest,
+	apiKey string,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	b.mu.Lock()
+	b.queries = append(b.queries, botName+" "+apiKey)
+	b.mu.Unlock()
+	events := b.events
+	if events == nil {
+		events = []types.PoeSSEEvent{{Event: "text", Data: `{"text":"Hello"}`}, {Event: "done", Data: "{}"}}
+	}
+	eventChan := make(chan types.PoeSSEEvent, len(events))
+	errChan := make(chan error)
+	for _, event := range events {
+		eventChan <- event
+	}
+	close(eventChan)
+	close(errChan)
+	return eventChan, errChan
+}
+
+func (b *fakeBackend) FetchSettings(ctx context.Context, botName string, apiKey string) (*types.PoeSettingsResponse, error) {
+	settings, ok := b.settings[botName]
+	if !ok {
+		return nil, errors.New("no settings")
+	}
+	return settings, nil
+}
+
+func (b *fakeBackend) ReportFeedback(
+	ctx context.Context,
+	botName string,
+	req *types.PoeReportFeedbackRequest,
+	apiKey string,
+) error {
+	return nil
+}
+
+func (b *fakeBackend) UploadFile(
+	ctx context.Context,
+	apiKey string,
+	name string,
+	contentType string,
+	data []byte,
+) (*types.PoeAttachment, error) {
+	return nil, errors.New("no uploads")
+}
+
+func (b *fakeBackend) DownloadFile(ctx context.Context, url string) ([]byte, string, error) {
+	return nil, "", errors.New("no downloads")
+}
+
+func (b *fakeBackend) Queries() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.queries...)
+}
+
+// testConfig returns a configuration with a virtual key restricted to the GPT-4o model, and
+// without passthrough keys.
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Auth.PoeAPIKey = "poe-platform-api-key"
+	cfg.Auth.AllowPassthrough = false
+	cfg.Auth.Keys = []config.KeyConfig{{Name: "restricted", Key: "sk-virtual-restricted-key", Models: []string{"GPT-4o"}}}
+	require.NoError(t, cfg.Validate())
+	return cfg
+}
+
+func chatRequest(model string, apiKey string, cache string) *http.Request {
+	body := `{"model":"` + model + `","messages":[{"role":"user","content":"Hi"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		r.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	if cache != "" {
+		r.Header.Set(service.QueryCacheHeader, cache)
+	}
+	return r
+}
+
+func TestAdapterChatCompletions(t *testing.T) {
+	backend := &fakeBackend{}
+	a, err := New(WithBackend(backend), WithConfig(testConfig(t)))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, chatRequest("GPT-4o", "sk-virtual-restricted-key", ""))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp types.OpenAIChatCompletionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Choices, 1)
+	require.NotNil(t, resp.Choices[0].Message.Content)
+	assert.Equal(t, "Hello", *resp.Choices[0].Message.Content)
+	assert.Equal(t, []string{"GPT-4o poe-platform-api-key"}, backend.Queries())
+}
+
+func TestAdapterVirtualKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		model  string
+		apiKey string
+		want   int
+	}{
+		{"allowed model", "GPT-4o", "sk-virtual-restricted-key", http.StatusOK},
+		{"model not allowed", "Claude-Sonnet-4", "sk-virtual-restricted-key", http.StatusForbidden},
+		{"unknown key", "GPT-4o", "sk-unknown-key", http.StatusUnauthorized},
+		{"no credentials", "GPT-4o", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(WithBackend(&fakeBackend{}), WithConfig(testConfig(t)))
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			a.ServeHTTP(w, chatRequest(tt.model, tt.apiKey, ""))
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestAdapterQueryCacheStats(t *testing.T) {
+	backend := &fakeBackend{}
+	passThrough := func(next http.Handler) http.Handler { return next }
+	a, err := New(WithBackend(backend), WithConfig(testConfig(t)), WithAdmin(passThrough, false))
+	require.NoError(t, err)
+
+	for i, want := range []string{"miss", "hit"} {
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, chatRequest("GPT-4o", "sk-virtual-restricted-key", "on"))
+		require.Equal(t, http.StatusOK, w.Code, "request %d: %s", i, w.Body)
+		assert.Equal(t, want, w.Header().Get(service.QueryCacheHeader), "request %d", i)
+		// Queries are stored once their response is sent.
+		require.Eventually(t, func() bool {
+			return a.Handlers().QueryCache.Stats().Stores == 1
+		}, time.Second, time.Millisecond, "request %d: the query was not stored", i)
+	}
+	assert.Len(t, backend.Queries(), 1)
+
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/info", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var info struct {
+		QueryCache service.QueryCacheStats `json:"query_cache"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, service.QueryCacheStats{Hits: 1, Misses: 1, Stores: 1}, info.QueryCache)
+}
+
+func TestAdapterFromConfig(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Stores.ConversationDir = t.TempDir()
+	cfg.Recording.Dir = t.TempDir()
+	cfg.Coalescing.Enabled = true
+	a, err := New(WithBackend(&fakeBackend{}), WithConfig(cfg))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, chatRequest("GPT-4o", "sk-virtual-restricted-key", ""))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	conversations, err := os.ReadDir(cfg.Stores.ConversationDir)
+	require.NoError(t, err)
+	assert.Len(t, conversations, 1, "the conversation is stored in the configured directory")
+
+	// Exchanges are recorded in the background once their queries are over.
+	recording := filepath.Join(cfg.Recording.Dir, "exchanges.jsonl")
+	require.Eventually(t, func() bool {
+		exchanges, err := service.ReadExchanges(recording)
+		return err == nil && len(exchanges) == 1
+	}, time.Second, time.Millisecond, "the exchange is recorded in the configured directory")
+	require.NoError(t, a.Close())
+
+	exchanges, err := service.ReadExchanges(recording)
+	require.NoError(t, err)
+	require.Len(t, exchanges[0].Queries, 1)
+	assert.Equal(t, "GPT-4o", exchanges[0].Queries[0].BotName)
+}
+
+func TestAdapterInvalidConfig(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Context.Strategy = "forget_everything"
+	_, err := New(WithBackend(&fakeBackend{}), WithConfig(cfg))
+	assert.Error(t, err)
+}
+
+func TestAdapterDiscoverBotSettings(t *testing.T) {
+	allowAttachments := false
+	settings := &types.PoeSettingsResponse{AllowAttachments: &allowAttachments, IntroductionMessage: "Hi"}
+	backend := &fakeBackend{settings: map[string]*types.PoeSettingsResponse{"GPT-4o": settings}}
+	a, err := New(WithBackend(backend), WithConfig(testConfig(t)))
+	require.NoError(t, err)
+
+	a.discoverBotSettings(context.Background(), "poe-key")
+	for _, model := range a.handlers.Runtime.Current().Catalog.List() {
+		if model.ID == "GPT-4o" {
+			assert.Same(t, settings, model.Settings)
+			assert.False(t, model.Vision)
+		} else {
+			assert.Nil(t, model.Settings, "bots without settings are skipped")
+		}
+	}
+}
+
+func TestUpstreamProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  []types.PoeSSEEvent
+		wantErr string
+	}{
+		{name: "healthy"},
+		{
+			name:    "bot error",
+			events:  []types.PoeSSEEvent{{Event: "error", Data: `{"text":"Overloaded"}`}},
+			wantErr: "probe bot GPT-4o reported an error: Overloaded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{events: tt.events}
+			err := upstreamProbe(backend, "GPT-4o", "poe-key")(context.Background())
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, []string{"GPT-4o poe-key"}, backend.Queries())
+		})
+	}
+}
+
+func TestAdapterHealth(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Health.ProbeBot = "GPT-4o"
+	backend := &fakeBackend{}
+	a, err := New(WithBackend(backend), WithConfig(cfg))
+	require.NoError(t, err)
+
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, serve("/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, serve("/readyz"), "not probed yet")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.Start(ctx)
+	assert.Eventually(t, func() bool { return serve("/readyz") == http.StatusOK }, time.Second, time.Millisecond)
+	assert.Contains(t, backend.Queries(), "GPT-4o poe-platform-api-key")
+}
@@ -0,0 +1,188 @@
+package adapter
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/config"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// Option configures an Adapter, see New.
+type Option func(*options)
+
+// options are the settings and dependencies of an Adapter, set by the Options given to New.
+type options struct {
+	logger      *slog.Logger
+	backend     client.Backend
+	config      *config.Config
+	runtime     *service.RuntimeConfigStore
+	middlewares []func(http.Handler) http.Handler
+
+	// The settings and dependencies left nil are built from the configuration by New.
+	imageConfig       *service.ImageConfig
+	responseStore     service.ResponseStore
+	conversationStore service.ConversationStore
+	contextConfig     *service.ContextConfig
+	queryCache        service.QueryCache
+	queryCacheConfig  service.QueryCacheConfig
+	coalesceConfig    *service.CoalesceConfig
+	exchanges         *service.ExchangeStore
+	exchangeSinks     []service.ExchangeSink
+	redactor          *service.Redactor
+
+	adminAuth         func(http.Handler) http.Handler
+	adminSeparate     bool
+	ringBufferLogger  *service.RingBufferLogWriter
+	logsTemplate      *template.Template
+	inspectorTemplate *template.Template
+	logLevels         *service.LogLevelController
+	reloadConfig      func(source string) (service.ReloadStatus, error)
+	lifecycle         *service.Lifecycle
+	upstream          *service.UpstreamHealth
+	version           string
+	configHash        func() string
+}
+
+// WithLogger sets the logger of the adapter, slog.Default() by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithBackend sets the backend serving the Poe bots, by default the client.PoeClient of the
+// configuration with its retries and rate limit, see config.Config.PoeClient and
+// config.PoeConfig.Backend. The adapter adds the exchange recording, query coalescing, query cache
+// and upstream statistics around it. Tests can set a fake one.
+func WithBackend(backend client.Backend) Option {
+	return func(o *options) { o.backend = backend }
+}
+
+// WithConfig sets the configuration of the adapter: its runtime configuration (models catalog,
+// routing, virtual keys and rate limits, unless set by WithRuntimeConfig), the request timeout
+// and the maximum request body size, and the dependencies not set by other Options (the Poe
+// client, stores, query cache, coalescing, exchange recording and upstream probes). The built-in
+// configuration applies by default.
+func WithConfig(c *config.Config) Option {
+	return func(o *options) { o.config = c }
+}
+
+// WithRuntimeConfig sets the store of the runtime configuration, so that it can be reloaded
+// while the adapter serves requests.
+func WithRuntimeConfig(runtime *service.RuntimeConfigStore) Option {
+	return func(o *options) { o.runtime = runtime }
+}
+
+// WithMiddleware adds middlewares in front of the API and admin routes, e.g. middleware.RequestID
+// and handlers.AccessLog. The health checks do not go through them.
+func WithMiddleware(middlewares ...func(http.Handler) http.Handler) Option {
+	return func(o *options) { o.middlewares = append(o.middlewares, middlewares...) }
+}
+
+// WithImageConfig sets the bot profiles of the images API, those of the configuration by default.
+func WithImageConfig(imageConfig service.ImageConfig) Option {
+	return func(o *options) { o.imageConfig = &imageConfig }
+}
+
+// WithResponseStore sets the store of the Responses API responses, kept in memory as configured
+// by default.
+func WithResponseStore(store service.ResponseStore) Option {
+	return func(o *options) { o.responseStore = store }
+}
+
+// WithConversationStore sets the store of the conversations, the configured one by default.
+func WithConversationStore(store service.ConversationStore) Option {
+	return func(o *options) { o.conversationStore = store }
+}
+
+// WithContext configures context-window management, as configured by default.
+func WithContext(contextConfig service.ContextConfig) Option {
+	return func(o *options) { o.contextConfig = &contextConfig }
+}
+
+// WithQueryCache sets the query cache and its settings, the configured ones by default.
+func WithQueryCache(cache service.QueryCache, cacheConfig service.QueryCacheConfig) Option {
+	return func(o *options) {
+		o.queryCache = cache
+		o.queryCacheConfig = cacheConfig
+	}
+}
+
+// WithCoalescing configures the coalescing of identical in-flight queries, as configured by
+// default.
+func WithCoalescing(coalesceConfig service.CoalesceConfig) Option {
+	return func(o *options) { o.coalesceConfig = &coalesceConfig }
+}
+
+// WithExchangeStore sets the store of the recent exchanges shown by the request inspector, the
+// configured one by default.
+func WithExchangeStore(store *service.ExchangeStore) Option {
+	return func(o *options) { o.exchanges = store }
+}
+
+// WithExchangeSinks adds sinks recording the API exchanges, besides the request inspector and the
+// configured service.Recorder, if any.
+func WithExchangeSinks(sinks ...service.ExchangeSink) Option {
+	return func(o *options) { o.exchangeSinks = append(o.exchangeSinks, sinks...) }
+}
+
+// WithRedactor sets the redactor of the recorded exchanges, the configured one by default (see
+// config.RedactConfig.RecordingRedactor).
+func WithRedactor(redactor *service.Redactor) Option {
+	return func(o *options) { o.redactor = redactor }
+}
+
+// WithAdmin serves the admin pages behind the auth middleware (see handlers.AdminAuth), under
+// /admin of the adapter, or only from Adapter.AdminHandler if separate. The admin pages are not
+// served by default.
+func WithAdmin(auth func(http.Handler) http.Handler, separate bool) Option {
+	return func(o *options) {
+		o.adminAuth = auth
+		o.adminSeparate = separate
+	}
+}
+
+// WithLogViewer sets the log buffer and the template of the live log viewer admin page, which is
+// unavailable without them.
+func WithLogViewer(ringBufferLogger *service.RingBufferLogWriter, logsTemplate *template.Template) Option {
+	return func(o *options) {
+		o.ringBufferLogger = ringBufferLogger
+		o.logsTemplate = logsTemplate
+	}
+}
+
+// WithInspectorTemplate sets the template of the request inspector admin pages, which are
+// unavailable without it.
+func WithInspectorTemplate(inspectorTemplate *template.Template) Option {
+	return func(o *options) { o.inspectorTemplate = inspectorTemplate }
+}
+
+// WithLogLevels sets the controller of the log levels, which can then be changed from the admin
+// pages.
+func WithLogLevels(logLevels *service.LogLevelController) Option {
+	return func(o *options) { o.logLevels = logLevels }
+}
+
+// WithReloadConfig sets the function reloading the runtime configuration from the admin pages.
+func WithReloadConfig(reload func(source string) (service.ReloadStatus, error)) Option {
+	return func(o *options) { o.reloadConfig = reload }
+}
+
+// WithHealth sets the lifecycle and the upstream health statistics reported by the readiness
+// check and the admin info page. By default, the adapter starts its lifecycle when created, and
+// collects the upstream statistics, probing the configured bot.
+func WithHealth(lifecycle *service.Lifecycle, upstream *service.UpstreamHealth) Option {
+	return func(o *options) {
+		o.lifecycle = lifecycle
+		o.upstream = upstream
+	}
+}
+
+// WithVersion sets the version and the configuration hash reported by the admin info page.
+func WithVersion(version string, configHash func() string) Option {
+	return func(o *options) {
+		o.version = version
+		o.configHash = configHash
+	}
+}
@@ -0,0 +1,51 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// upstreamProbe returns the upstream probe querying probeBot with poeAPIKey, which fails if the
+// query fails or the bot reports an error.
+func upstreamProbe(backend client.Backend, probeBot, poeAPIKey string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		eventChan, errChan := backend.StreamQuery(ctx, probeBot, service.BuildProbeQuery(poeAPIKey), poeAPIKey)
+		var events []types.PoeSSEEvent
+		for event := range eventChan {
+			events = append(events, event)
+		}
+		if err := <-errChan; err != nil {
+			return err
+		}
+		if botResp := service.AggregateBotEvents(events); botResp.ErrorText != "" {
+			return fmt.Errorf("probe bot %s reported an error: %s", probeBot, botResp.ErrorText)
+		}
+		return nil
+	}
+}
+
+// discoverBotSettings fetches the settings of the bots of the current models catalog with
+// poeAPIKey, and records them in it. Bots whose settings cannot be fetched are logged and
+// skipped; their settings are fetched again when a request needs them.
+func (a *Adapter) discoverBotSettings(ctx context.Context, poeAPIKey string) {
+	runtime := a.handlers.Runtime
+	discovered := 0
+	for _, model := range runtime.Current().Catalog.List() {
+		settings, err := a.upstream.FetchSettings(ctx, model.ID, poeAPIKey)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			a.logger.Warn("Failed to fetch bot settings", "bot_name", model.ID, "error", err)
+			continue
+		}
+		// The catalog may have been swapped by a reload in the meantime.
+		runtime.Current().Catalog.SetSettings(model.ID, settings)
+		discovered++
+	}
+	a.logger.Info("Discovered bot settings", "bots", discovered)
+}
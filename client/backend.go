@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// Backend is an upstream serving Poe bots. PoeClient implements it with the Poe APIs, and
+// Middlewares add behavior around it (retries, caching, coalescing, metrics, rate limiting,
+// recording).
+type Backend interface {
+	// StreamQuery sends a query to a bot and streams its response events. The error channel
+	// receives the error of the query if it fails, and both channels are closed once it is over.
+	StreamQuery(
+		ctx context.Context,
+		botName string,
+		request *types.PoeQueryRequest,
+		apiKey string,
+	) (<-chan types.PoeSSEEvent, <-chan error)
+	// FetchSettings returns the settings of a bot. They must not be modified, as backends may
+	// share them between calls.
+	FetchSettings(ctx context.Context, botName string, apiKey string) (*types.PoeSettingsResponse, error)
+	// ReportFeedback reports the feedback of a user on a message of a bot.
+	ReportFeedback(ctx context.Context, botName string, feedback *types.PoeReportFeedbackRequest, apiKey string) error
+	// UploadFile uploads a file to attach to a query.
+	UploadFile(ctx context.Context, apiKey, name, contentType string, data []byte) (*types.PoeAttachment, error)
+	// DownloadFile fetches a file sent by a bot, returning its content and content type.
+	DownloadFile(ctx context.Context, url string) ([]byte, string, error)
+}
+
+// Middleware decorates a Backend. Decorators usually embed the Backend they decorate, so that
+// the methods they leave alone are passed through.
+type Middleware func(next Backend) Backend
+
+// Chain returns backend decorated by middlewares, the first one being the outermost: it sees the
+// requests first, and their outcome last.
+func Chain(backend Backend, middlewares ...Middleware) Backend {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		backend = middlewares[i](backend)
+	}
+	return backend
+}
+
+// retryObserverKey is the context key of the function notified of query retries.
+type retryObserverKey struct{}
+
+// WithRetryObserver returns a copy of ctx whose queries call observe before each retry made by
+// Retry, with the number of the attempt about to be made (2 for the first retry).
+func WithRetryObserver(ctx context.Context, observe func(botName string, attempt int)) context.Context {
+	return context.WithValue(ctx, retryObserverKey{}, observe)
+}
+
+// retryObserver returns the function notified of query retries for ctx, if any.
+func retryObserver(ctx context.Context) (func(botName string, attempt int), bool) {
+	observe, ok := ctx.Value(retryObserverKey{}).(func(string, int))
+	return observe, ok
+}
+
+// QueryOptions are the options of the queries made for a client request, carried by their
+// context (see WithQueryOptions), for the middlewares serving them from the query cache or from
+// identical in-flight queries.
+type QueryOptions struct {
+	// Cache is the opt-in of the client request to the query cache: "on", "off", or empty to
+	// follow the configuration. See service.QueryCacheHeader.
+	Cache string
+	// Seed is the seed of the client request, if any, which Poe queries do not carry.
+	Seed *int
+	// OnCache, if set, is told how CacheQueries served each query: "hit", "miss" or "bypass".
+	OnCache func(outcome string)
+	// OnCoalesced, if set, is called for each query Coalesce served from an identical in-flight
+	// query.
+	OnCoalesced func()
+}
+
+// queryOptionsKey is the context key of the QueryOptions of the queries.
+type queryOptionsKey struct{}
+
+// WithQueryOptions returns a copy of ctx whose queries have options.
+func WithQueryOptions(ctx context.Context, options QueryOptions) context.Context {
+	return context.WithValue(ctx, queryOptionsKey{}, options)
+}
+
+// QueryOptionsFromContext returns the options of the queries of ctx, or the zero QueryOptions if
+// it has none.
+func QueryOptionsFromContext(ctx context.Context) QueryOptions {
+	options, _ := ctx.Value(queryOptionsKey{}).(QueryOptions)
+	return options
+}
+
+// pipe forwards the events of a query to eventChan, calling onEvent with each one unless it is
+// nil, until the query is over or ctx is done. It returns the error of the query and whether
+// events were forwarded.
+func pipe(
+	ctx context.Context,
+	eventChan chan<- types.PoeSSEEvent,
+	events <-chan types.PoeSSEEvent,
+	errs <-chan error,
+	onEvent func(types.PoeSSEEvent),
+) (bool, error) {
+	forwarded := false
+	for event := range events {
+		select {
+		case eventChan <- event:
+			forwarded = true
+			if onEvent != nil {
+				onEvent(event)
+			}
+		case <-ctx.Done():
+			// The query stops with ctx; its remaining events are discarded.
+			go func() {
+				for range events {
+				}
+			}()
+			return forwarded, ctx.Err()
+		}
+	}
+	return forwarded, <-errs
+}
+
+// tap returns the events of a query like StreamQuery, calling onEvent with each one unless it is
+// nil, and onDone with the error of the query (nil on success) once it is over.
+func tap(
+	ctx context.Context,
+	events <-chan types.PoeSSEEvent,
+	errs <-chan error,
+	onEvent func(types.PoeSSEEvent),
+	onDone func(error),
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	eventChan := make(chan types.PoeSSEEvent)
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(eventChan)
+		defer close(errChan)
+		_, err := pipe(ctx, eventChan, events, errs, onEvent)
+		onDone(err)
+		if err != nil {
+			errChan <- err
+		}
+	}()
+	return eventChan, errChan
+}
+
+// sleep waits for d, or until ctx is done, in which case it returns its error.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CassetteMode selects how a CassetteTransport handles requests.
+type CassetteMode string
+
+const (
+	// CassetteOff sends requests to Poe without recording them.
+	CassetteOff CassetteMode = "off"
+	// CassetteRecord sends requests to Poe and saves the responses to the cassette.
+	CassetteRecord CassetteMode = "record"
+	// CassettePlayback serves responses from the cassette, without network access.
+	CassettePlayback CassetteMode = "playback"
+)
+
+// cassetteVolatileFields are the JSON fields of Poe requests left out of cassette keys, as they
+// change between identical requests.
+var cassetteVolatileFields = map[string]bool{
+	"api_key":         true,
+	"conversation_id": true,
+	"message_id":      true,
+	"user_id":         true,
+	"timestamp":       true,
+}
+
+// ErrCassetteMiss is returned in playback mode for requests missing from the cassette.
+var ErrCassetteMiss = errors.New("request not found in cassette")
+
+// ParseCassetteMode parses a cassette mode name; an empty name is CassetteOff.
+func ParseCassetteMode(name string) (CassetteMode, error) {
+	switch mode := CassetteMode(strings.ToLower(name)); mode {
+	case "", CassetteOff:
+		return CassetteOff, nil
+	case CassetteRecord, CassettePlayback:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown cassette mode %q (want off, record or playback)", name)
+	}
+}
+
+// cassetteEntry is a recorded Poe response, stored as a JSON file named after its key.
+type cassetteEntry struct {
+	Key         string    `json:"key"`
+	Method      string    `json:"method"`
+	URL         string    `json:"url"`
+	StatusCode  int       `json:"status_code"`
+	ContentType string    `json:"content_type"`
+	Body        string    `json:"body"` // The raw response body, e.g. the SSE stream of a bot query.
+	RecordedAt  time.Time `json:"recorded_at"`
+}
+
+// CassetteTransport is an http.RoundTripper recording Poe responses to a directory (the
+// cassette) or serving them from it, keyed by the canonical hash of the request. It is safe for
+// concurrent use.
+type CassetteTransport struct {
+	mode CassetteMode
+	dir  string
+	next http.RoundTripper
+}
+
+// NewCassetteTransport creates a CassetteTransport using dir in the given mode, creating the
+// directory when recording. next sends the recorded requests; http.DefaultTransport is used if nil.
+func NewCassetteTransport(mode CassetteMode, dir string, next http.RoundTripper) (*CassetteTransport, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("cassette mode %s needs a cassette directory", mode)
+	}
+	switch mode {
+	case CassetteRecord:
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create cassette directory %s: %w", dir, err)
+		}
+	case CassettePlayback:
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("failed to open cassette directory: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("cassette mode %s does not use a cassette", mode)
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &CassetteTransport{mode: mode, dir: dir, next: next}, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body for cassette: %w", err)
+		}
+	}
+	key := cassetteKey(req, body)
+	path := filepath.Join(t.dir, key+".json")
+
+	if t.mode == CassettePlayback {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			slog.Error("Request not found in cassette", "method", req.Method, "url", req.URL.String(), "key", key)
+			return nil, fmt.Errorf(
+				"%w: %s %s (key %s); record it with --poe-cassette-mode record",
+				ErrCassetteMiss,
+				req.Method,
+				req.URL,
+				key,
+			)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette entry %s: %w", key, err)
+		}
+		var entry cassetteEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("invalid cassette entry %s: %w", key, err)
+		}
+		slog.Debug("Serving Poe response from cassette", "url", req.URL.String(), "key", key)
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", entry.StatusCode, http.StatusText(entry.StatusCode)),
+			StatusCode:    entry.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {entry.ContentType}},
+			Body:          io.NopCloser(strings.NewReader(entry.Body)),
+			ContentLength: int64(len(entry.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Failures are not recorded, so that they are retried when recording again.
+		return resp, nil
+	}
+	resp.Body = &cassetteRecorder{
+		body: resp.Body,
+		path: path,
+		entry: cassetteEntry{
+			Key:         key,
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+		},
+	}
+	return resp, nil
+}
+
+// cassetteKey returns the canonical hash of a request: its method, its URL and its body, without
+// the volatile fields of JSON bodies and with a fixed multipart boundary.
+func cassetteKey(req *http.Request, body []byte) string {
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/json":
+		var decoded any
+		if err := json.Unmarshal(body, &decoded); err == nil {
+			if canonical, err := json.Marshal(stripVolatileFields(decoded)); err == nil {
+				body = canonical // Maps are marshaled with sorted keys.
+			}
+		}
+	case strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "":
+		body = bytes.ReplaceAll(body, []byte(params["boundary"]), []byte("cassette-boundary"))
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", req.Method, req.URL.String())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// stripVolatileFields removes the cassetteVolatileFields from a decoded JSON value, recursively.
+func stripVolatileFields(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for name, field := range v {
+			if cassetteVolatileFields[name] {
+				delete(v, name)
+				continue
+			}
+			v[name] = stripVolatileFields(field)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = stripVolatileFields(item)
+		}
+	}
+	return value
+}
+
+// cassetteRecorder is a response body saving a cassette entry once it has been read to the end.
+// Responses closed before the end, e.g. by a cancelled query, are not saved.
+type cassetteRecorder struct {
+	body  io.ReadCloser
+	path  string
+	entry cassetteEntry
+	buf   bytes.Buffer
+	saved bool
+}
+
+// Read implements io.Reader.
+func (cr *cassetteRecorder) Read(p []byte) (int, error) {
+	n, err := cr.body.Read(p)
+	cr.buf.Write(p[:n])
+	if errors.Is(err, io.EOF) && !cr.saved {
+		cr.saved = true
+		cr.save()
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (cr *cassetteRecorder) Close() error {
+	return cr.body.Close()
+}
+
+// save writes the cassette entry atomically. Failures are logged, as the response itself is fine.
+func (cr *cassetteRecorder) save() {
+	cr.entry.Body = cr.buf.String()
+	cr.entry.RecordedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(cr.entry, "", "  ")
+	if err != nil {
+		slog.Error("Failed to marshal cassette entry", "key", cr.entry.Key, "error", err)
+		return
+	}
+	tmpPath := cr.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o640); err != nil {
+		slog.Error("Failed to write cassette entry", "key", cr.entry.Key, "error", err)
+		return
+	}
+	if err := os.Rename(tmpPath, cr.path); err != nil {
+		slog.Error("Failed to save cassette entry", "key", cr.entry.Key, "error", err)
+		return
+	}
+	slog.Debug("Recorded Poe response to cassette", "url", cr.entry.URL, "key", cr.entry.Key)
+}
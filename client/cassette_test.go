@@ -0,0 +1,140 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCassetteMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    CassetteMode
+		wantErr bool
+	}{
+		{"", CassetteOff, false},
+		{"off", CassetteOff, false},
+		{"Record", CassetteRecord, false},
+		{"playback", CassettePlayback, false},
+		{"replay", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, err := ParseCassetteMode(tt.name)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, mode)
+		})
+	}
+}
+
+func TestNewCassetteTransport(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		mode    CassetteMode
+		dir     string
+		wantErr bool
+	}{
+		{"record", CassetteRecord, dir + "/new", false},
+		{"playback", CassettePlayback, dir, false},
+		{"no directory", CassetteRecord, "", true},
+		{"missing playback directory", CassettePlayback, dir + "/missing", true},
+		{"off", CassetteOff, dir, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCassetteTransport(tt.mode, tt.dir, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.DirExists(t, tt.dir)
+		})
+	}
+}
+
+// cassetteRequest sends a bot query with the given message ID through transport and returns the
+// response with its body.
+func cassetteRequest(t *testing.T, transport http.RoundTripper, url string, messageID string) (*http.Response, string, error) {
+	t.Helper()
+	body := `{"query":[{"role":"user","content":"Hi"}],"message_id":"` + messageID + `","api_key":"poe-key"}`
+	req, err := http.NewRequest(http.MethodPost, url+"/bot/GPT-4o", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(data), nil
+}
+
+func TestCassetteRecordAndPlayback(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "event: text\ndata: {\"text\":\"Hello\"}\n\nevent: done\ndata: {}\n\n")
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	recorder, err := NewCassetteTransport(CassetteRecord, dir, nil)
+	require.NoError(t, err)
+	_, recorded, err := cassetteRequest(t, recorder, server.URL, "message-1")
+	require.NoError(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the response read to the end is saved")
+
+	player, err := NewCassetteTransport(CassettePlayback, dir, nil)
+	require.NoError(t, err)
+	// Volatile fields such as the message ID are left out of the key.
+	resp, played, err := cassetteRequest(t, player, server.URL, "message-2")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, recorded, played)
+	assert.Equal(t, int32(1), requests.Load(), "playback does not send requests")
+}
+
+func TestCassettePlaybackMiss(t *testing.T) {
+	player, err := NewCassetteTransport(CassettePlayback, t.TempDir(), nil)
+	require.NoError(t, err)
+
+	_, _, err = cassetteRequest(t, player, "http://poe.invalid", "message-1")
+	require.ErrorIs(t, err, ErrCassetteMiss)
+	assert.Contains(t, err.Error(), "POST http://poe.invalid/bot/GPT-4o")
+	assert.Contains(t, err.Error(), "--poe-cassette-mode record")
+}
+
+func TestCassetteRecordSkipsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	recorder, err := NewCassetteTransport(CassetteRecord, dir, nil)
+	require.NoError(t, err)
+	resp, _, err := cassetteRequest(t, recorder, server.URL, "message-1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "failures are retried when recording again")
+}
@@ -16,17 +16,20 @@ import (
 )
 
 const (
-	// poeAPIBaseURL is the base URL for the Poe Bot Query API.
+	// poeAPIBaseURL is the default base URL for the Poe Bot Query API.
 	poeAPIBaseURL = "https://api.poe.com/bot/"
 	// defaultTimeout is the default HTTP client timeout for non-streaming operations.
 	defaultTimeout = 120 * time.Second
 	// sseReadTimeout is the HTTP client timeout used for SSE streaming requests,
 	// allowing for long-lived connections.
 	sseReadTimeout = 5 * time.Minute
-	// maxRetries is the number of times to retry a query on failure.
-	maxRetries = 2
-	// retrySleepTime is the base duration to wait before retrying.
-	retrySleepTime = 500 * time.Millisecond
+	// maxJSONResponseSize caps the size of the JSON responses of Poe bots (settings, feedback).
+	maxJSONResponseSize = 1 << 20 // 1 MiB
+	// poeProtocolVersion is the version of the Poe protocol of the requests made by the client.
+	poeProtocolVersion = "1.1"
+	// Types of the Poe protocol requests made by the client besides queries.
+	poeRequestTypeSettings       = "settings"
+	poeRequestTypeReportFeedback = "report_feedback"
 )
 
 // PoeClient facilitates communication with Poe bots via the Poe Bot Query API.
@@ -34,19 +37,29 @@ const (
 type PoeClient struct {
 	// HTTPClient is the underlying HTTP client used for making requests.
 	HTTPClient *http.Client
+	// BaseURL is the URL of the Poe Bot Query API, to which bot names are appended.
+	BaseURL string
+	// StreamTimeout is the HTTP client timeout used for SSE streaming requests.
+	StreamTimeout time.Duration
+	// DownloadHosts are the hosts, with their subdomains, from which DownloadFile fetches the
+	// files sent by bots.
+	DownloadHosts []string
 }
 
 // NewPoeClient creates and returns a new PoeClient with a default HTTP client configuration.
 func NewPoeClient() *PoeClient {
 	return &PoeClient{
 		HTTPClient: &http.Client{
-			Timeout: defaultTimeout, // Default timeout for general requests. SSE uses sseReadTimeout.
+			Timeout: defaultTimeout, // Default timeout for general requests. SSE uses StreamTimeout.
 		},
+		BaseURL:       poeAPIBaseURL,
+		StreamTimeout: sseReadTimeout,
+		DownloadHosts: defaultDownloadHosts,
 	}
 }
 
 // StreamQuery sends a query to the specified Poe bot and streams the response as Server-Sent Events.
-// It handles retries on failure.
+// It makes a single attempt; see Retry to retry failed queries.
 //
 // Parameters:
 //   - ctx: The context for the request, allowing for cancellation.
@@ -57,7 +70,7 @@ func NewPoeClient() *PoeClient {
 //
 // Returns:
 //   - A read-only channel for receiving PoeSSEEvent objects.
-//   - A read-only channel for receiving an error if the query ultimately fails after retries.
+//   - A read-only channel for receiving an error if the query fails.
 func (c *PoeClient) StreamQuery(
 	ctx context.Context,
 	botName string,
@@ -71,47 +84,79 @@ func (c *PoeClient) StreamQuery(
 		defer close(eventChan)
 		defer close(errChan)
 
-		var lastErr error
-		for i := 0; i < maxRetries+1; i++ {
-			if i > 0 {
-				slog.Info(
-					"Retrying query to Poe bot",
-					"bot_name", botName,
-					"attempt", i+1,
-					"max_attempts", maxRetries+1,
-				)
-				time.Sleep(retrySleepTime * time.Duration(i)) // Exponential backoff could be better
-			}
+		if err := c.performStreamQuery(ctx, botName, request, apiKey, eventChan); err != nil {
+			slog.Error("Error during stream query", "bot_name", botName, "error", err)
+			errChan <- err
+		}
+	}()
 
-			// Pass apiKey to performStreamQuery
-			err := c.performStreamQuery(ctx, botName, request, apiKey, eventChan)
-			if err == nil {
-				return // Success
-			}
+	return eventChan, errChan
+}
 
-			lastErr = err
-			slog.Error(
-				"Error during stream query attempt",
-				"bot_name", botName,
-				"attempt", i+1,
-				"error", err,
-			)
+// FetchSettings sends a settings request to the specified Poe bot and returns its settings.
+func (c *PoeClient) FetchSettings(ctx context.Context, botName string, apiKey string) (*types.PoeSettingsResponse, error) {
+	request := &types.PoeSettingsRequest{Version: poeProtocolVersion, Type: poeRequestTypeSettings}
+	var settings types.PoeSettingsResponse
+	if err := c.postJSON(ctx, botName, request, apiKey, &settings); err != nil {
+		return nil, fmt.Errorf("failed to fetch settings of bot %s: %w", botName, err)
+	}
+	return &settings, nil
+}
 
-			// Check if context is cancelled before retrying
-			select {
-			case <-ctx.Done():
-				errChan <- fmt.Errorf("context cancelled during retry: %w", ctx.Err())
-				return
-			default:
-			}
+// ReportFeedback reports the feedback of a user on a message of the specified Poe bot.
+func (c *PoeClient) ReportFeedback(
+	ctx context.Context,
+	botName string,
+	feedback *types.PoeReportFeedbackRequest,
+	apiKey string,
+) error {
+	request := *feedback
+	request.Version, request.Type = poeProtocolVersion, poeRequestTypeReportFeedback
+	if err := c.postJSON(ctx, botName, &request, apiKey, nil); err != nil {
+		return fmt.Errorf("failed to report feedback to bot %s: %w", botName, err)
+	}
+	return nil
+}
+
+// postJSON sends request to the specified Poe bot as JSON, and decodes the JSON response into
+// response unless it is nil.
+func (c *PoeClient) postJSON(ctx context.Context, botName string, request any, apiKey string, response any) error {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal Poe request: %w", err)
+	}
+	url := strings.TrimSuffix(c.BaseURL, "/") + "/" + botName
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-			// TODO: Implement more sophisticated retry logic (e.g., based on error type)
-			// For now, retrying on any error from performStreamQuery.
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute HTTP request to Poe: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Warn("Failed to close response body", "error", err)
 		}
-		errChan <- fmt.Errorf("failed to query bot %s after %d retries: %w", botName, maxRetries, lastErr)
 	}()
-
-	return eventChan, errChan
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxJSONResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read Poe response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("poe API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	if response == nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, response); err != nil {
+		return fmt.Errorf("failed to decode Poe response: %w", err)
+	}
+	return nil
 }
 
 // dispatchPoeEvent handles logging and sending a PoeSSEEvent.
@@ -195,7 +240,7 @@ func (c *PoeClient) performStreamQuery(
 	}
 	slog.Debug("Poe request JSON body to be sent", "body", string(jsonData))
 
-	url := poeAPIBaseURL + botName
+	url := strings.TrimSuffix(c.BaseURL, "/") + "/" + botName
 	slog.Debug("Poe API request URL", "url", url)
 
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
@@ -229,9 +274,11 @@ func (c *PoeClient) performStreamQuery(
 		},
 	)
 
-	// Custom client for SSE to handle longer timeouts during read
+	// Custom client for SSE to handle longer timeouts during read.
+	// It shares the transport of HTTPClient, so that a custom one (e.g. a fake backend) applies.
 	sseClient := &http.Client{
-		Timeout: sseReadTimeout,
+		Transport: c.HTTPClient.Transport,
+		Timeout:   c.StreamTimeout,
 	}
 
 	resp, err := sseClient.Do(req)
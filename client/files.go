@@ -0,0 +1,197 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+const (
+	// poeFileUploadURL is the endpoint of the Poe file upload API used to attach files to queries.
+	poeFileUploadURL = "https://www.quora.com/poe_api/file_upload_3RD_PARTY_POST"
+	// maxDownloadSize caps the size of files downloaded from Poe (e.g., generated images).
+	maxDownloadSize = 50 << 20 // 50 MiB
+)
+
+// defaultDownloadHosts are the hosts, with their subdomains, serving the files sent by Poe bots.
+var defaultDownloadHosts = []string{"poe.com", "poecdn.net", "quora.com", "quoracdn.net"}
+
+// UploadFile uploads a file through the Poe file upload API so that it can be attached
+// to a PoeProtocolMessage. It returns an attachment pointing at the uploaded file.
+//
+// Parameters:
+//   - ctx: The context for the request, allowing for cancellation.
+//   - apiKey: The Poe Platform API Key.
+//   - name: The file name reported to the bot.
+//   - contentType: The MIME type of the file.
+//   - data: The file content.
+func (c *PoeClient) UploadFile(
+	ctx context.Context,
+	apiKey string,
+	name string,
+	contentType string,
+	data []byte,
+) (*types.PoeAttachment, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Set(
+		"Content-Disposition",
+		fmt.Sprintf(`form-data; name="file"; filename=%q`, name),
+	)
+	partHeader.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(partHeader)
+	if err != nil {
+		slog.Error("Failed to create multipart part for Poe file upload", "error", err)
+		return nil, fmt.Errorf("failed to create multipart part: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		slog.Error("Failed to write file to multipart body for Poe file upload", "error", err)
+		return nil, fmt.Errorf("failed to write multipart body: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		slog.Error("Failed to finalize multipart body for Poe file upload", "error", err)
+		return nil, fmt.Errorf("failed to finalize multipart body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, poeFileUploadURL, &body)
+	if err != nil {
+		slog.Error("Failed to create HTTP request for Poe file upload", "error", err)
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	// The upload API expects the raw key, without the "Bearer" scheme.
+	req.Header.Set("Authorization", apiKey)
+
+	slog.Debug(
+		"Uploading file to Poe",
+		"file_name", name,
+		"content_type", contentType,
+		"size", len(data),
+	)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		slog.Error("Failed to execute Poe file upload request", "error", err)
+		return nil, fmt.Errorf("failed to execute Poe file upload request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Warn("Failed to close Poe file upload response body", "error", err)
+		}
+	}()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		slog.Error("Failed to read Poe file upload response body", "error", err)
+		return nil, fmt.Errorf("failed to read Poe file upload response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		slog.Error(
+			"Poe file upload failed",
+			"status_code", resp.StatusCode,
+			"response_body", string(respBytes),
+		)
+		return nil, fmt.Errorf(
+			"poe file upload failed with status %d: %s",
+			resp.StatusCode,
+			string(respBytes),
+		)
+	}
+
+	var uploadResp types.PoeFileUploadResponse
+	if err := json.Unmarshal(respBytes, &uploadResp); err != nil {
+		slog.Error("Failed to decode Poe file upload response", "error", err)
+		return nil, fmt.Errorf("failed to decode Poe file upload response: %w", err)
+	}
+	if uploadResp.AttachmentURL == "" {
+		slog.Error("Poe file upload response has no attachment URL", "response_body", string(respBytes))
+		return nil, fmt.Errorf("poe file upload response has no attachment_url")
+	}
+	if uploadResp.MimeType != "" {
+		contentType = uploadResp.MimeType
+	}
+	slog.Debug("Uploaded file to Poe", "attachment_url", uploadResp.AttachmentURL)
+
+	return &types.PoeAttachment{
+		URL:         uploadResp.AttachmentURL,
+		ContentType: contentType,
+		Name:        name,
+	}, nil
+}
+
+// DownloadFile fetches a file sent by a Poe bot (e.g., a generated image) and returns its content
+// along with its content type. Only https URLs on the DownloadHosts are fetched, including after
+// redirects, so that bots cannot make the adapter reach internal addresses. Downloads larger than
+// maxDownloadSize are rejected.
+func (c *PoeClient) DownloadFile(ctx context.Context, url string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		slog.Error("Failed to create HTTP request for file download", "error", err, "url", url)
+		return nil, "", fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	if err := c.checkDownloadURL(req.URL); err != nil {
+		slog.Error("Refused to download file", "error", err, "url", url)
+		return nil, "", err
+	}
+	downloadClient := &http.Client{
+		Transport: c.HTTPClient.Transport,
+		Timeout:   c.HTTPClient.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return c.checkDownloadURL(req.URL)
+		},
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		slog.Error("Failed to download file", "error", err, "url", url)
+		return nil, "", fmt.Errorf("failed to download file: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Warn("Failed to close file download response body", "error", err)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("File download failed", "status_code", resp.StatusCode, "url", url)
+		return nil, "", fmt.Errorf("file download failed with status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		slog.Error("Failed to read downloaded file", "error", err, "url", url)
+		return nil, "", fmt.Errorf("failed to read downloaded file: %w", err)
+	}
+	if len(data) > maxDownloadSize {
+		slog.Error("Downloaded file exceeds size limit", "url", url, "limit", maxDownloadSize)
+		return nil, "", fmt.Errorf("downloaded file exceeds %d bytes", maxDownloadSize)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// checkDownloadURL returns an error if u is not an https URL on one of the DownloadHosts.
+func (c *PoeClient) checkDownloadURL(u *url.URL) error {
+	if u.Scheme != "https" {
+		return fmt.Errorf("file download URL %s is not https", u.Redacted())
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range c.DownloadHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("file download host %s is not allowed", host)
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadFileAllowedHosts(t *testing.T) {
+	c := NewPoeClient()
+	tests := []struct {
+		name    string
+		url     string
+		wantErr string
+	}{
+		{"http", "http://pfst.cf2.poecdn.net/base/image/a.png", "is not https"},
+		{"loopback", "https://127.0.0.1/a.png", "host 127.0.0.1 is not allowed"},
+		{"metadata", "https://169.254.169.254/latest/meta-data/", "host 169.254.169.254 is not allowed"},
+		{"lookalike host", "https://evilpoecdn.net/a.png", "host evilpoecdn.net is not allowed"},
+		{"file scheme", "file:///etc/passwd", "is not https"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := c.DownloadFile(context.Background(), tt.url)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Fail(t, "the redirect to an internal address was followed")
+	}))
+	defer internal.Close()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, internal.URL, http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	}))
+	defer server.Close()
+
+	c := NewPoeClient()
+	c.HTTPClient = server.Client()
+	c.DownloadHosts = []string{"127.0.0.1"}
+
+	data, contentType, err := c.DownloadFile(context.Background(), server.URL+"/a.png")
+	require.NoError(t, err)
+	assert.Equal(t, "png", string(data))
+	assert.Equal(t, "image/png", contentType)
+
+	_, _, err = c.DownloadFile(context.Background(), server.URL+"/redirect")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not https")
+}
@@ -0,0 +1,430 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// Retry is a Middleware retrying the queries that fail before any event is received, at most
+// maxRetries times, waiting delay times the number of the retry before each. Queries failing once
+// their response has started are not retried, as their events were already passed on.
+func Retry(maxRetries int, delay time.Duration) Middleware {
+	return func(next Backend) Backend {
+		return &retryBackend{Backend: next, maxRetries: maxRetries, delay: delay}
+	}
+}
+
+// retryBackend is the Backend decorator of Retry.
+type retryBackend struct {
+	Backend
+	maxRetries int
+	delay      time.Duration
+}
+
+// StreamQuery implements Backend.
+func (b *retryBackend) StreamQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	eventChan := make(chan types.PoeSSEEvent)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(eventChan)
+		defer close(errChan)
+
+		var lastErr error
+		for attempt := 1; attempt <= b.maxRetries+1; attempt++ {
+			if attempt > 1 {
+				slog.Info(
+					"Retrying query to Poe bot",
+					"bot_name", botName,
+					"attempt", attempt,
+					"max_attempts", b.maxRetries+1,
+				)
+				if observe, ok := retryObserver(ctx); ok {
+					observe(botName, attempt)
+				}
+				if err := sleep(ctx, b.delay*time.Duration(attempt-1)); err != nil {
+					errChan <- fmt.Errorf("context cancelled during retry: %w", err)
+					return
+				}
+			}
+
+			events, errs := b.Backend.StreamQuery(ctx, botName, request, apiKey)
+			forwarded, err := pipe(ctx, eventChan, events, errs, nil)
+			if err == nil {
+				return
+			}
+			// A cassette miss would fail again, and a started response cannot be taken back;
+			// other errors may be transient.
+			if forwarded || ctx.Err() != nil || errors.Is(err, ErrCassetteMiss) {
+				errChan <- err
+				return
+			}
+			lastErr = err
+		}
+		errChan <- fmt.Errorf("failed to query bot %s after %d retries: %w", botName, b.maxRetries, lastErr)
+	}()
+
+	return eventChan, errChan
+}
+
+// QueryObserver is notified when a query ends, with the number of attempts made (see Retry) and
+// its final error (nil on success).
+type QueryObserver func(botName string, attempts int, err error)
+
+// Observe is a Middleware notifying observer of the outcome of each query, to collect metrics.
+// It must be outside of Retry to count the attempts.
+func Observe(observer QueryObserver) Middleware {
+	return func(next Backend) Backend {
+		return &observeBackend{Backend: next, observer: observer}
+	}
+}
+
+// observeBackend is the Backend decorator of Observe.
+type observeBackend struct {
+	Backend
+	observer QueryObserver
+}
+
+// StreamQuery implements Backend.
+func (b *observeBackend) StreamQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	var attempts atomic.Int64
+	attempts.Store(1)
+	previous, hasPrevious := retryObserver(ctx)
+	ctx = WithRetryObserver(ctx, func(botName string, attempt int) {
+		attempts.Store(int64(attempt))
+		if hasPrevious {
+			previous(botName, attempt)
+		}
+	})
+	events, errs := b.Backend.StreamQuery(ctx, botName, request, apiKey)
+	return tap(ctx, events, errs, nil, func(err error) {
+		b.observer(botName, int(attempts.Load()), err)
+	})
+}
+
+// Limiter decides whether a request identified by key may be made now, with a rate limit of
+// requestsPerMinute if positive or its default one, and otherwise after how long it may be
+// retried. service.RateLimiter implements it.
+type Limiter interface {
+	Allow(key string, requestsPerMinute int) (bool, time.Duration)
+}
+
+// RateLimit is a Middleware limiting the rate of the requests sent to the bots with each Poe API
+// key with limiter. Requests over the limit wait until they are allowed, or their context is done.
+func RateLimit(limiter Limiter) Middleware {
+	return func(next Backend) Backend {
+		return &rateLimitBackend{Backend: next, limiter: limiter}
+	}
+}
+
+// rateLimitBackend is the Backend decorator of RateLimit.
+type rateLimitBackend struct {
+	Backend
+	limiter Limiter
+}
+
+// wait waits until a request with apiKey is allowed, or ctx is done.
+func (b *rateLimitBackend) wait(ctx context.Context, botName string, apiKey string) error {
+	key := "poe:" + keyFingerprint(apiKey)
+	for {
+		allowed, retryAfter := b.limiter.Allow(key, 0)
+		if allowed {
+			return nil
+		}
+		slog.Debug("Waiting for the Poe rate limit", "bot_name", botName, "wait", retryAfter)
+		if err := sleep(ctx, retryAfter); err != nil {
+			return fmt.Errorf("context cancelled while waiting for the Poe rate limit: %w", err)
+		}
+	}
+}
+
+// StreamQuery implements Backend.
+func (b *rateLimitBackend) StreamQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	eventChan := make(chan types.PoeSSEEvent)
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(eventChan)
+		defer close(errChan)
+		if err := b.wait(ctx, botName, apiKey); err != nil {
+			errChan <- err
+			return
+		}
+		events, errs := b.Backend.StreamQuery(ctx, botName, request, apiKey)
+		if _, err := pipe(ctx, eventChan, events, errs, nil); err != nil {
+			errChan <- err
+		}
+	}()
+	return eventChan, errChan
+}
+
+// FetchSettings implements Backend.
+func (b *rateLimitBackend) FetchSettings(ctx context.Context, botName string, apiKey string) (*types.PoeSettingsResponse, error) {
+	if err := b.wait(ctx, botName, apiKey); err != nil {
+		return nil, err
+	}
+	return b.Backend.FetchSettings(ctx, botName, apiKey)
+}
+
+// ReportFeedback implements Backend.
+func (b *rateLimitBackend) ReportFeedback(
+	ctx context.Context,
+	botName string,
+	feedback *types.PoeReportFeedbackRequest,
+	apiKey string,
+) error {
+	if err := b.wait(ctx, botName, apiKey); err != nil {
+		return err
+	}
+	return b.Backend.ReportFeedback(ctx, botName, feedback, apiKey)
+}
+
+// keyFingerprint identifies apiKey without revealing it, like service.KeyFingerprint.
+func keyFingerprint(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// CacheSettings is a Middleware caching the settings of each bot for ttl, per Poe API key, as
+// bots may answer differently to different keys. Failed fetches are
+// cached for at most settingsErrorTTL, so that a bot without settings is not asked for them on
+// every request. The settings of a bot are fetched again once it asks for it in the meta event of
+// a response (see types.PoeMetaEventData).
+func CacheSettings(ttl time.Duration) Middleware {
+	return func(next Backend) Backend {
+		return &settingsCacheBackend{Backend: next, ttl: ttl, entries: make(map[settingsCacheKey]settingsCacheEntry)}
+	}
+}
+
+// settingsErrorTTL is the longest time a failed fetch of the settings of a bot is cached by
+// CacheSettings.
+const settingsErrorTTL = time.Minute
+
+// settingsCacheKey identifies the settings of a bot fetched with a Poe API key, by its fingerprint.
+type settingsCacheKey struct {
+	botName        string
+	keyFingerprint string
+}
+
+// settingsCacheEntry is the outcome of a fetch of the settings of a bot cached by CacheSettings.
+type settingsCacheEntry struct {
+	settings  *types.PoeSettingsResponse
+	err       error
+	fetchedAt time.Time
+}
+
+// settingsCacheBackend is the Backend decorator of CacheSettings.
+type settingsCacheBackend struct {
+	Backend
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[settingsCacheKey]settingsCacheEntry
+}
+
+// StreamQuery implements Backend.
+func (b *settingsCacheBackend) StreamQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	events, errs := b.Backend.StreamQuery(ctx, botName, request, apiKey)
+	return tap(ctx, events, errs, func(event types.PoeSSEEvent) {
+		if event.Event != "meta" {
+			return
+		}
+		var meta types.PoeMetaEventData
+		if err := json.Unmarshal([]byte(event.Data), &meta); err == nil && meta.RefetchSettings {
+			b.mu.Lock()
+			for key := range b.entries {
+				if key.botName == botName {
+					delete(b.entries, key)
+				}
+			}
+			b.mu.Unlock()
+		}
+	}, func(error) {})
+}
+
+// FetchSettings implements Backend.
+func (b *settingsCacheBackend) FetchSettings(ctx context.Context, botName string, apiKey string) (*types.PoeSettingsResponse, error) {
+	key := settingsCacheKey{botName: botName, keyFingerprint: keyFingerprint(apiKey)}
+	b.mu.Lock()
+	entry, ok := b.entries[key]
+	b.mu.Unlock()
+	if ok && entry.err == nil && time.Since(entry.fetchedAt) < b.ttl {
+		return entry.settings, nil
+	}
+	if ok && entry.err != nil && time.Since(entry.fetchedAt) < min(b.ttl, settingsErrorTTL) {
+		return nil, entry.err
+	}
+
+	settings, err := b.Backend.FetchSettings(ctx, botName, apiKey)
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		// The caller gave up; the bot may well answer the next one.
+		return nil, err
+	}
+	b.mu.Lock()
+	b.entries[key] = settingsCacheEntry{settings: settings, err: err, fetchedAt: time.Now()}
+	b.mu.Unlock()
+	return settings, err
+}
+
+// QueryStarter starts a query, with the channel semantics of Backend.StreamQuery.
+type QueryStarter func(ctx context.Context) (<-chan types.PoeSSEEvent, <-chan error)
+
+// QueryRecorder returns the events of a query to botName like StreamQuery, recording them as they
+// are consumed. service.RecordExchangeQuery is one, adding the query to the exchange being
+// recorded for ctx.
+type QueryRecorder func(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	events <-chan types.PoeSSEEvent,
+	errs <-chan error,
+) (<-chan types.PoeSSEEvent, <-chan error)
+
+// Record is a Middleware passing each query sent to the backend to record. It must be outside of
+// CacheQueries and Coalesce, so that the queries they serve are recorded too.
+func Record(record QueryRecorder) Middleware {
+	return func(next Backend) Backend {
+		return &recordBackend{Backend: next, record: record}
+	}
+}
+
+// recordBackend is the Backend decorator of Record.
+type recordBackend struct {
+	Backend
+	record QueryRecorder
+}
+
+// StreamQuery implements Backend.
+func (b *recordBackend) StreamQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	events, errs := b.Backend.StreamQuery(ctx, botName, request, apiKey)
+	return b.record(ctx, botName, request, events, errs)
+}
+
+// QueryCache serves queries from the responses of identical earlier queries.
+// service.QueryCacheManager implements it.
+type QueryCache interface {
+	// CacheQuery returns the events of the query to botName with apiKey like StreamQuery: if
+	// options opt the query in, from the cache if it has them, or else from start, caching them;
+	// otherwise from start. It returns how the query was served: "hit", "miss" or "bypass".
+	CacheQuery(
+		ctx context.Context,
+		botName string,
+		request *types.PoeQueryRequest,
+		apiKey string,
+		options QueryOptions,
+		start QueryStarter,
+	) (<-chan types.PoeSSEEvent, <-chan error, string)
+}
+
+// CacheQueries is a Middleware serving the queries from cache, as opted in by the QueryOptions of
+// their context, and reporting the outcome to their OnCache function.
+func CacheQueries(cache QueryCache) Middleware {
+	return func(next Backend) Backend {
+		return &queryCacheBackend{Backend: next, cache: cache}
+	}
+}
+
+// queryCacheBackend is the Backend decorator of CacheQueries.
+type queryCacheBackend struct {
+	Backend
+	cache QueryCache
+}
+
+// StreamQuery implements Backend.
+func (b *queryCacheBackend) StreamQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	options := QueryOptionsFromContext(ctx)
+	events, errs, outcome := b.cache.CacheQuery(ctx, botName, request, apiKey, options, func(ctx context.Context) (<-chan types.PoeSSEEvent, <-chan error) {
+		return b.Backend.StreamQuery(ctx, botName, request, apiKey)
+	})
+	if options.OnCache != nil {
+		options.OnCache(outcome)
+	}
+	return events, errs
+}
+
+// QueryCoalescer lets identical concurrent queries share one upstream query.
+// service.QueryCoalescer implements it.
+type QueryCoalescer interface {
+	// CoalesceQuery returns the events of the query to botName with apiKey like StreamQuery: from
+	// an identical in-flight query if the query may share one (see QueryOptions), or else from
+	// start. joined reports whether an in-flight query was joined.
+	CoalesceQuery(
+		ctx context.Context,
+		botName string,
+		request *types.PoeQueryRequest,
+		apiKey string,
+		options QueryOptions,
+		start QueryStarter,
+	) (events <-chan types.PoeSSEEvent, errs <-chan error, joined bool)
+}
+
+// Coalesce is a Middleware letting identical concurrent queries share one upstream query with
+// coalescer, and calling the OnCoalesced function of the QueryOptions of those joining one. It
+// must be outside of CacheQueries, so that the queries joining an in-flight one are not looked up
+// and stored again.
+func Coalesce(coalescer QueryCoalescer) Middleware {
+	return func(next Backend) Backend {
+		return &coalesceBackend{Backend: next, coalescer: coalescer}
+	}
+}
+
+// coalesceBackend is the Backend decorator of Coalesce.
+type coalesceBackend struct {
+	Backend
+	coalescer QueryCoalescer
+}
+
+// StreamQuery implements Backend.
+func (b *coalesceBackend) StreamQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	options := QueryOptionsFromContext(ctx)
+	events, errs, joined := b.coalescer.CoalesceQuery(ctx, botName, request, apiKey, options, func(ctx context.Context) (<-chan types.PoeSSEEvent, <-chan error) {
+		return b.Backend.StreamQuery(ctx, botName, request, apiKey)
+	})
+	if joined && options.OnCoalesced != nil {
+		options.OnCoalesced()
+	}
+	return events, errs
+}
@@ -0,0 +1,369 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// fakeBackend is a Backend answering each query with events, counting the queries and settings
+// fetches it receives. The first failures queries fail with failErr before any event.
+type fakeBackend struct {
+	events   []types.PoeSSEEvent
+	settings func(botName string, apiKey string) (*types.PoeSettingsResponse, error)
+	failures int
+	failErr  error
+
+	mu      sync.Mutex
+	queries int
+	fetches int
+}
+
+func (b *fakeBackend) StreamQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	b.mu.Lock()
+	b.queries++
+	fail := b.queries <= b.failures
+	b.mu.Unlock()
+	eventChan := make(chan types.PoeSSEEvent, len(b.events))
+	errChan := make(chan error, 1)
+	if fail {
+		errChan <- b.failErr
+	} else {
+		for _, event := range b.events {
+			eventChan <- event
+		}
+	}
+	close(eventChan)
+	close(errChan)
+	return eventChan, errChan
+}
+
+func (b *fakeBackend) FetchSettings(ctx context.Context, botName string, apiKey string) (*types.PoeSettingsResponse, error) {
+	b.mu.Lock()
+	b.fetches++
+	b.mu.Unlock()
+	return b.settings(botName, apiKey)
+}
+
+func (b *fakeBackend) ReportFeedback(context.Context, string, *types.PoeReportFeedbackRequest, string) error {
+	return nil
+}
+
+func (b *fakeBackend) UploadFile(context.Context, string, string, string, []byte) (*types.PoeAttachment, error) {
+	return nil, errors.New("not supported")
+}
+
+func (b *fakeBackend) DownloadFile(context.Context, string) ([]byte, string, error) {
+	return nil, "", errors.New("not supported")
+}
+
+// collect returns the events of a query, and its error.
+func collect(events <-chan types.PoeSSEEvent, errs <-chan error) ([]types.PoeSSEEvent, error) {
+	var received []types.PoeSSEEvent
+	for event := range events {
+		received = append(received, event)
+	}
+	return received, <-errs
+}
+
+var testEvents = []types.PoeSSEEvent{
+	{Event: "text", Data: `{"text":"Hello"}`},
+	{Event: "done", Data: "{}"},
+}
+
+func TestRecord(t *testing.T) {
+	backend := &fakeBackend{events: testEvents}
+	var recordedBot string
+	var recorded []types.PoeSSEEvent
+	var done sync.WaitGroup
+	done.Add(1)
+	recorder := func(
+		ctx context.Context,
+		botName string,
+		request *types.PoeQueryRequest,
+		events <-chan types.PoeSSEEvent,
+		errs <-chan error,
+	) (<-chan types.PoeSSEEvent, <-chan error) {
+		recordedBot = botName
+		return tap(ctx, events, errs, func(event types.PoeSSEEvent) {
+			recorded = append(recorded, event)
+		}, func(error) { done.Done() })
+	}
+
+	recording := Chain(backend, Record(recorder))
+	events, err := collect(recording.StreamQuery(context.Background(), "GPT-4o", &types.PoeQueryRequest{}, "key"))
+	require.NoError(t, err)
+	done.Wait()
+	assert.Equal(t, testEvents, events)
+	assert.Equal(t, "GPT-4o", recordedBot)
+	assert.Equal(t, testEvents, recorded)
+}
+
+// fakeQueryCache is a QueryCache serving the queries of the bot named hit from cached events.
+type fakeQueryCache struct {
+	hit     string
+	cached  []types.PoeSSEEvent
+	options []QueryOptions
+}
+
+func (c *fakeQueryCache) CacheQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+	options QueryOptions,
+	start QueryStarter,
+) (<-chan types.PoeSSEEvent, <-chan error, string) {
+	c.options = append(c.options, options)
+	if botName != c.hit {
+		events, errs := start(ctx)
+		return events, errs, "miss"
+	}
+	eventChan := make(chan types.PoeSSEEvent, len(c.cached))
+	errChan := make(chan error)
+	for _, event := range c.cached {
+		eventChan <- event
+	}
+	close(eventChan)
+	close(errChan)
+	return eventChan, errChan, "hit"
+}
+
+func TestCacheQueries(t *testing.T) {
+	cachedEvents := []types.PoeSSEEvent{{Event: "text", Data: `{"text":"Cached"}`}, {Event: "done", Data: "{}"}}
+	seed := 42
+	tests := []struct {
+		name        string
+		botName     string
+		wantEvents  []types.PoeSSEEvent
+		wantOutcome string
+		wantQueries int
+	}{
+		{name: "hit", botName: "cached-bot", wantEvents: cachedEvents, wantOutcome: "hit", wantQueries: 0},
+		{name: "miss", botName: "GPT-4o", wantEvents: testEvents, wantOutcome: "miss", wantQueries: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{events: testEvents}
+			cache := &fakeQueryCache{hit: "cached-bot", cached: cachedEvents}
+			var outcome string
+			ctx := WithQueryOptions(context.Background(), QueryOptions{
+				Cache:   "on",
+				Seed:    &seed,
+				OnCache: func(o string) { outcome = o },
+			})
+
+			cached := Chain(backend, CacheQueries(cache))
+			events, err := collect(cached.StreamQuery(ctx, tt.botName, &types.PoeQueryRequest{}, "key"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantEvents, events)
+			assert.Equal(t, tt.wantOutcome, outcome)
+			assert.Equal(t, tt.wantQueries, backend.queries)
+			require.Len(t, cache.options, 1)
+			assert.Equal(t, "on", cache.options[0].Cache)
+			assert.Equal(t, &seed, cache.options[0].Seed)
+		})
+	}
+}
+
+func TestCacheQueriesWithoutOptions(t *testing.T) {
+	backend := &fakeBackend{events: testEvents}
+	cache := &fakeQueryCache{}
+
+	cached := Chain(backend, CacheQueries(cache))
+	events, err := collect(cached.StreamQuery(context.Background(), "GPT-4o", &types.PoeQueryRequest{}, "key"))
+	require.NoError(t, err)
+	assert.Equal(t, testEvents, events)
+	require.Len(t, cache.options, 1)
+	assert.Zero(t, cache.options[0].Cache)
+	assert.Nil(t, cache.options[0].Seed)
+}
+
+// fakeQueryCoalescer is a QueryCoalescer joining the queries after the first one to it.
+type fakeQueryCoalescer struct {
+	started bool
+}
+
+func (c *fakeQueryCoalescer) CoalesceQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+	options QueryOptions,
+	start QueryStarter,
+) (<-chan types.PoeSSEEvent, <-chan error, bool) {
+	joined := c.started
+	c.started = true
+	if joined {
+		eventChan := make(chan types.PoeSSEEvent)
+		errChan := make(chan error)
+		close(eventChan)
+		close(errChan)
+		return eventChan, errChan, true
+	}
+	events, errs := start(ctx)
+	return events, errs, false
+}
+
+func TestCoalesce(t *testing.T) {
+	backend := &fakeBackend{events: testEvents}
+	coalesced := Chain(backend, Coalesce(&fakeQueryCoalescer{}))
+
+	for _, wantJoined := range []bool{false, true} {
+		joined := false
+		ctx := WithQueryOptions(context.Background(), QueryOptions{OnCoalesced: func() { joined = true }})
+		_, err := collect(coalesced.StreamQuery(ctx, "GPT-4o", &types.PoeQueryRequest{}, "key"))
+		require.NoError(t, err)
+		assert.Equal(t, wantJoined, joined)
+	}
+	assert.Equal(t, 1, backend.queries)
+}
+
+func TestCacheSettings(t *testing.T) {
+	allowAttachments := false
+	fetchSettings := func(botName string, apiKey string) (*types.PoeSettingsResponse, error) {
+		if botName == "broken-bot" {
+			return nil, errors.New("no settings")
+		}
+		return &types.PoeSettingsResponse{AllowAttachments: &allowAttachments, IntroductionMessage: botName}, nil
+	}
+	backend := &fakeBackend{
+		events:   []types.PoeSSEEvent{{Event: "meta", Data: `{"refetch_settings":true}`}, {Event: "done", Data: "{}"}},
+		settings: fetchSettings,
+	}
+	cached := Chain(backend, CacheSettings(time.Hour))
+	ctx := context.Background()
+
+	for range 2 {
+		settings, err := cached.FetchSettings(ctx, "GPT-4o", "key")
+		require.NoError(t, err)
+		assert.Equal(t, "GPT-4o", settings.IntroductionMessage)
+	}
+	assert.Equal(t, 1, backend.fetches, "settings are cached")
+
+	settings, err := cached.FetchSettings(ctx, "Claude-Sonnet-4", "key")
+	require.NoError(t, err)
+	assert.Equal(t, "Claude-Sonnet-4", settings.IntroductionMessage, "settings are cached per bot")
+	assert.Equal(t, 2, backend.fetches)
+
+	for range 2 {
+		_, err = cached.FetchSettings(ctx, "broken-bot", "key")
+		assert.EqualError(t, err, "no settings")
+	}
+	assert.Equal(t, 3, backend.fetches, "failed fetches are cached")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	backend.settings = func(string, string) (*types.PoeSettingsResponse, error) { return nil, canceled.Err() }
+	for range 2 {
+		_, err = cached.FetchSettings(canceled, "Llama-3-70b", "key")
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+	assert.Equal(t, 5, backend.fetches, "fetches given up by the caller are not cached")
+	backend.settings = fetchSettings
+
+	// A meta event asking for it refetches the settings of the bot.
+	_, err = collect(cached.StreamQuery(ctx, "GPT-4o", &types.PoeQueryRequest{}, "key"))
+	require.NoError(t, err)
+	_, err = cached.FetchSettings(ctx, "GPT-4o", "key")
+	require.NoError(t, err)
+	assert.Equal(t, 6, backend.fetches)
+}
+
+func TestRetry(t *testing.T) {
+	errUnavailable := errors.New("unavailable")
+	tests := []struct {
+		name        string
+		failures    int
+		failErr     error
+		wantErr     error
+		wantQueries int
+	}{
+		{"success", 0, errUnavailable, nil, 1},
+		{"transient failure", 2, errUnavailable, nil, 3},
+		{"persistent failure", 5, errUnavailable, errUnavailable, 3},
+		{"cassette miss", 1, ErrCassetteMiss, ErrCassetteMiss, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{events: testEvents, failures: tt.failures, failErr: tt.failErr}
+			var attempts []int
+			var observed int
+			ctx := WithRetryObserver(context.Background(), func(_ string, attempt int) {
+				attempts = append(attempts, attempt)
+			})
+
+			retried := Chain(backend, Observe(func(botName string, n int, err error) {
+				assert.Equal(t, "GPT-4o", botName)
+				assert.Equal(t, tt.wantQueries, n)
+				observed++
+			}), Retry(2, 0))
+			events, err := collect(retried.StreamQuery(ctx, "GPT-4o", &types.PoeQueryRequest{}, "key"))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Empty(t, events)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, testEvents, events)
+			}
+			assert.Equal(t, tt.wantQueries, backend.queries)
+			assert.Equal(t, 1, observed, "each query is observed once")
+			// The observers of the context are still notified of the retries.
+			assert.Len(t, attempts, tt.wantQueries-1)
+		})
+	}
+}
+
+// fakeLimiter is a Limiter refusing the first denials requests, to be retried after a millisecond.
+type fakeLimiter struct {
+	denials int
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func (l *fakeLimiter) Allow(key string, requestsPerMinute int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keys = append(l.keys, key)
+	return len(l.keys) > l.denials, time.Millisecond
+}
+
+func TestRateLimit(t *testing.T) {
+	backend := &fakeBackend{
+		events: testEvents,
+		settings: func(string, string) (*types.PoeSettingsResponse, error) {
+			return &types.PoeSettingsResponse{}, nil
+		},
+	}
+	limiter := &fakeLimiter{denials: 2}
+	limited := Chain(backend, RateLimit(limiter))
+
+	events, err := collect(limited.StreamQuery(context.Background(), "GPT-4o", &types.PoeQueryRequest{}, "poe-key"))
+	require.NoError(t, err)
+	assert.Equal(t, testEvents, events)
+	require.Len(t, limiter.keys, 3, "requests over the limit wait")
+	assert.Equal(t, "poe:"+keyFingerprint("poe-key"), limiter.keys[0], "the API key is not revealed")
+
+	_, err = limited.FetchSettings(context.Background(), "GPT-4o", "poe-key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, backend.fetches)
+
+	limiter.denials = 100
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = collect(limited.StreamQuery(ctx, "GPT-4o", &types.PoeQueryRequest{}, "poe-key"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, backend.queries, "queries cancelled while waiting are not sent")
+}
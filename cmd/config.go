@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/spf13/cobra"
+	"github.com/supergeoff/poepenai/config"
+)
+
+// Config print flags
+var (
+	configPrintEffectiveFlag bool
+	configPrintFormatFlag    string
+	configPrintSecretsFlag   bool
+)
+
+// configFlags are the flags of configuration settings, overriding them when set. Each flag sets
+// a field of flagConfig, copied to the loaded configuration by loadConfig.
+var configFlags = []struct {
+	name string
+	// field returns a pointer to the field of c set by the flag.
+	field func(c *config.Config) any
+}{
+	{"listen", func(c *config.Config) any { return &c.Server.Listen }},
+	{"admin-listen", func(c *config.Config) any { return &c.Server.AdminListen }},
+	{"admin-token", func(c *config.Config) any { return &c.Auth.Admin.Token }},
+	{"admin-user", func(c *config.Config) any { return &c.Auth.Admin.Username }},
+	{"admin-password", func(c *config.Config) any { return &c.Auth.Admin.Password }},
+	{"loglevel", func(c *config.Config) any { return &c.Logging.Level }},
+	{"log-level-revert", func(c *config.Config) any { return &c.Logging.LevelRevert }},
+	{"log-format", func(c *config.Config) any { return &c.Logging.Format }},
+	{"log-buffer-size", func(c *config.Config) any { return &c.Logging.BufferSize }},
+	{"log-dir", func(c *config.Config) any { return &c.Logging.Dir }},
+	{"log-retention", func(c *config.Config) any { return &c.Logging.Retention }},
+	{"access-log-sample-rate", func(c *config.Config) any { return &c.Logging.AccessLogSampleRate }},
+	{"redact-keys", func(c *config.Config) any { return &c.Logging.Redact.Keys }},
+	{"redact-content", func(c *config.Config) any { return &c.Logging.Redact.Content }},
+	{"redact-content-length", func(c *config.Config) any { return &c.Logging.Redact.ContentLength }},
+	{"redact-rules", func(c *config.Config) any { return &c.Logging.Redact.Rules }},
+	{"redact-recordings", func(c *config.Config) any { return &c.Logging.Redact.Recordings }},
+	{"images-config", func(c *config.Config) any { return &c.Images.ConfigFile }},
+	{"response-store-size", func(c *config.Config) any { return &c.Stores.ResponseSize }},
+	{"conversation-store-dir", func(c *config.Config) any { return &c.Stores.ConversationDir }},
+	{"conversation-store-size", func(c *config.Config) any { return &c.Stores.ConversationSize }},
+	{"context-strategy", func(c *config.Config) any { return &c.Context.Strategy }},
+	{"context-default-window", func(c *config.Config) any { return &c.Context.DefaultWindow }},
+	{"context-reserve-tokens", func(c *config.Config) any { return &c.Context.ReserveTokens }},
+	{"summary-bot", func(c *config.Config) any { return &c.Context.SummaryBot }},
+	{"query-cache-dir", func(c *config.Config) any { return &c.QueryCache.Dir }},
+	{"query-cache-size", func(c *config.Config) any { return &c.QueryCache.Size }},
+	{"query-cache-ttl", func(c *config.Config) any { return &c.QueryCache.TTL }},
+	{"query-cache-models", func(c *config.Config) any { return &c.QueryCache.Models }},
+	{"query-cache-replay-gap", func(c *config.Config) any { return &c.QueryCache.ReplayMaxGap }},
+	{"coalesce", func(c *config.Config) any { return &c.Coalescing.Enabled }},
+	{"coalesce-nondeterministic", func(c *config.Config) any { return &c.Coalescing.NonDeterministic }},
+	{"record-dir", func(c *config.Config) any { return &c.Recording.Dir }},
+	{"record-max-bytes", func(c *config.Config) any { return &c.Recording.MaxBytes }},
+	{"record-max-files", func(c *config.Config) any { return &c.Recording.MaxFiles }},
+	{"inspector-size", func(c *config.Config) any { return &c.Inspector.Size }},
+	{"inspector-dir", func(c *config.Config) any { return &c.Inspector.Dir }},
+	{"poe-cassette-mode", func(c *config.Config) any { return &c.Cassette.Mode }},
+	{"poe-cassette-dir", func(c *config.Config) any { return &c.Cassette.Dir }},
+}
+
+// configFilePath returns the path of the configuration file, or an empty string if there is none.
+func configFilePath() string {
+	if configFileFlag != "" {
+		return configFileFlag
+	}
+	return os.Getenv("POEPENAI_CONFIG")
+}
+
+// loadConfig loads the effective configuration of cmd: the defaults, overridden by the
+// configuration file, the environment variables and the flags set, in that order. It fails if the
+// result is invalid.
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
+	loaded, err := config.Load(configFilePath())
+	if err != nil {
+		return nil, err
+	}
+	if err := loaded.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, fmt.Errorf("invalid configuration environment variables:\n%w", err)
+	}
+	for _, flag := range configFlags {
+		if cmd.Flags().Changed(flag.name) {
+			reflect.ValueOf(flag.field(loaded)).Elem().Set(reflect.ValueOf(flag.field(flagConfig)).Elem())
+		}
+	}
+	if err := loaded.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return loaded, nil
+}
+
+// configCmd groups the configuration subcommands.
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Validates and prints the configuration",
+	Long: `Validates and prints the configuration of the adapter. Settings come from the built-in
+defaults, overridden by the configuration file (--config or POEPENAI_CONFIG; YAML, TOML or JSON),
+then by the POEPENAI_<SECTION>_<SETTING> environment variables (e.g. POEPENAI_SERVER_LISTEN), then
+by the flags set on the command line.`,
+	// The configuration subcommands only need the configuration, not the logger and the other
+	// dependencies set up by rootCmd.PersistentPreRunE.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		loadedConfig, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		cfg = loadedConfig
+		return nil
+	},
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:          "validate",
+	Short:        "Validates the configuration",
+	Long:         `Loads the configuration like the start command, and reports all its errors.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Invalid configurations fail in configCmd.PersistentPreRunE.
+		source := "built-in defaults"
+		if configFile := configFilePath(); configFile != "" {
+			source = configFile
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Configuration is valid (%s)\n", source)
+		return nil
+	},
+}
+
+// configPrintCmd represents the config print command
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Prints the configuration",
+	Long: `Prints the configuration file merged with the defaults, or with --effective the configuration
+used by the start command, including the environment variables and flags. Secrets are masked
+unless --show-secrets is set.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		printed := cfg
+		if !configPrintEffectiveFlag {
+			loaded, err := config.Load(configFilePath())
+			if err != nil {
+				return err
+			}
+			printed = loaded
+		}
+		if !configPrintSecretsFlag {
+			printed = printed.Redacted()
+		}
+		data, err := config.Marshal(printed, configPrintFormatFlag)
+		if err != nil {
+			return err
+		}
+		_, err = cmd.OutOrStdout().Write(data)
+		return err
+	},
+}
+
+func init() {
+	configPrintCmd.Flags().
+		BoolVar(&configPrintEffectiveFlag, "effective", false, "Print the effective configuration, including environment variables and flags")
+	configPrintCmd.Flags().
+		StringVar(&configPrintFormatFlag, "format", config.FormatYAML, "Output format (yaml, toml, json)")
+	configPrintCmd.Flags().
+		BoolVar(&configPrintSecretsFlag, "show-secrets", false, "Print API keys, tokens and passwords instead of masking them")
+
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configPrintCmd)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/config"
+)
+
+func TestConfigFlags(t *testing.T) {
+	for _, flag := range configFlags {
+		assert.NotNil(t, startCmd.Flag(flag.name), "flag --%s of the configuration", flag.name)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(
+		"server:\n  listen: \":9090\"\nlogging:\n  level: debug\n  format: text\n  buffer_size: 10\n",
+	), 0o600))
+	configFileFlag = path
+	t.Setenv("POEPENAI_LOGGING_LEVEL", "warn")
+	t.Setenv("POEPENAI_LOGGING_FORMAT", "text")
+
+	require.NoError(t, startCmd.ParseFlags([]string{"--log-format", "json"}))
+	t.Cleanup(func() {
+		configFileFlag = ""
+		flag := startCmd.Flag("log-format")
+		require.NoError(t, flag.Value.Set(flag.DefValue))
+		flag.Changed = false
+	})
+
+	loaded, err := loadConfig(startCmd)
+	require.NoError(t, err)
+	assert.Equal(t, ":9090", loaded.Server.Listen, "the file overrides the defaults")
+	assert.Equal(t, 10, loaded.Logging.BufferSize)
+	assert.Equal(t, "warn", loaded.Logging.Level, "the environment overrides the file")
+	assert.Equal(t, "json", loaded.Logging.Format, "the flags set override the environment")
+	assert.Equal(t, config.Default().Server.AdminListen, loaded.Server.AdminListen)
+
+	t.Setenv("POEPENAI_LOGGING_BUFFER_SIZE", "0")
+	_, err = loadConfig(startCmd)
+	assert.ErrorContains(t, err, "logging.buffer_size", "the effective configuration is validated")
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/supergeoff/poepenai/config"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// Health flags
+var (
+	healthReadyFlag   bool
+	healthTimeoutFlag time.Duration
+)
+
+// healthCmd represents the health command
+var healthCmd = &cobra.Command{
+	Use:   "health",
+	Short: "Checks the health of a running adapter",
+	Long: `Probes the /healthz endpoint of the adapter running on this host, or /readyz with --ready,
+at the API server address of the effective configuration (the configuration file, the
+environment variables such as PORT or POEPENAI_SERVER_LISTEN, and --listen). It exits with a
+non-zero status if the adapter is unreachable or unhealthy, e.g. for container health checks.`,
+	SilenceUsage: true,
+	// The health check only needs the configuration, not the logger and the other dependencies
+	// set up by rootCmd.PersistentPreRunE.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		loadedConfig, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		cfg = loadedConfig
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := "/healthz"
+		if healthReadyFlag {
+			path = "/readyz"
+		}
+		probeURL, err := localURL(cfg.Server.Listen, path)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(cmd.Context(), healthTimeoutFlag)
+		defer cancel()
+		if err := probe(ctx, probeURL); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "OK %s\n", probeURL)
+		return nil
+	},
+}
+
+// localURL returns the URL of path on the server listening on listen from this host: servers
+// listening on every interface are reached on the loopback one.
+func localURL(listen string, path string) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid server address %q: %w", listen, err)
+	}
+	switch ip := net.ParseIP(host); {
+	case host == "" || (ip != nil && ip.Equal(net.IPv4zero)):
+		host = "127.0.0.1"
+	case ip != nil && ip.Equal(net.IPv6unspecified):
+		host = "::1"
+	}
+	return (&url.URL{Scheme: "http", Host: net.JoinHostPort(host, port), Path: path}).String(), nil
+}
+
+// probe gets probeURL, and fails unless it answers with a 2xx status.
+func probe(ctx context.Context, probeURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("health check failed: %s answered %s", probeURL, resp.Status)
+	}
+	return nil
+}
+
+// shutdownServers drains and stops servers: the adapter reports not ready for the drain delay,
+// then in-flight requests are given the shutdown timeout to complete.
+func shutdownServers(lifecycle *service.Lifecycle, servers ...*http.Server) error {
+	lifecycle.StartDraining()
+	if drainDelay := time.Duration(cfg.Server.DrainDelay); drainDelay > 0 {
+		logger.Info("Draining before shutdown", "drain_delay", drainDelay)
+		time.Sleep(drainDelay)
+	}
+
+	logger.Info("Shutting down", "timeout", time.Duration(cfg.Server.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+	var errs []error
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to shut down server %s: %w", server.Addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func init() {
+	healthCmd.Flags().
+		StringVar(&flagConfig.Server.Listen, "listen", config.Default().Server.Listen, "Address of the API server (or set PORT)")
+	healthCmd.Flags().
+		BoolVar(&healthReadyFlag, "ready", false, "Probe the readiness of the adapter instead of its liveness")
+	healthCmd.Flags().
+		DurationVar(&healthTimeoutFlag, "timeout", 5*time.Second, "Time after which the health check fails")
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalURL(t *testing.T) {
+	tests := []struct {
+		listen  string
+		want    string
+		wantErr bool
+	}{
+		{":8080", "http://127.0.0.1:8080/healthz", false},
+		{"0.0.0.0:9000", "http://127.0.0.1:9000/healthz", false},
+		{"[::]:8080", "http://[::1]:8080/healthz", false},
+		{"10.0.0.2:8080", "http://10.0.0.2:8080/healthz", false},
+		{"localhost:8080", "http://localhost:8080/healthz", false},
+		{"8080", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.listen, func(t *testing.T) {
+			got, err := localURL(tt.listen, "/healthz")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"healthy", http.StatusOK, false},
+		{"unhealthy", http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/readyz", r.URL.Path)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			err := probe(context.Background(), server.URL+"/readyz")
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "503")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	assert.Error(t, probe(context.Background(), server.URL+"/healthz"), "unreachable servers are unhealthy")
+}
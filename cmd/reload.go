@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/supergeoff/poepenai/config"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// configReloader reloads the runtime configuration of the start command, from the configuration
+// file, the environment and the flags like at startup.
+type configReloader struct {
+	mu      sync.Mutex
+	cmd     *cobra.Command
+	runtime *service.RuntimeConfigStore
+	// startup is the configuration loaded at startup, whose settings read only at startup apply
+	// until the next restart.
+	startup *config.Config
+	// hash is the hash of the configuration last loaded.
+	hash string
+}
+
+// newConfigReloader creates the reloader of the runtime configuration loaded from startup.
+func newConfigReloader(cmd *cobra.Command, runtime *service.RuntimeConfigStore, startup *config.Config) *configReloader {
+	return &configReloader{cmd: cmd, runtime: runtime, startup: startup, hash: startup.Hash()}
+}
+
+// ConfigHash returns the hash of the configuration last loaded.
+func (r *configReloader) ConfigHash() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hash
+}
+
+// Reload loads the configuration and makes it the current runtime configuration if it is valid,
+// reporting source as the reload trigger. Changes to settings read only at startup are logged
+// and ignored until the next restart.
+func (r *configReloader) Reload(source string) (service.ReloadStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	loaded, err := loadConfig(r.cmd)
+	if err != nil {
+		status := r.runtime.ReloadFailed(source, err)
+		logger.Error("Failed to reload configuration, keeping the current one", "source", source, "error", err)
+		return status, err
+	}
+	if ignored := startupSettingsChanged(r.startup, loaded); len(ignored) > 0 {
+		logger.Warn("Configuration changes that require a restart are ignored", "settings", ignored)
+	}
+	next := loaded.RuntimeConfig(r.runtime.Current())
+	status := r.runtime.Swap(next, source)
+	r.hash = loaded.Hash()
+	logger.Info(
+		"Configuration reloaded",
+		"source", source,
+		"generation", status.Generation,
+		"models", len(next.Catalog.List()),
+		"aliases", next.Router.Aliases(),
+		"keys", next.Keys.Len(),
+		"config_hash", r.hash,
+	)
+	return status, nil
+}
+
+// Watch reloads the configuration each time the configuration file changes, until ctx is done.
+// It does nothing without a configuration file.
+func (r *configReloader) Watch(ctx context.Context) error {
+	path := configFilePath()
+	if path == "" {
+		return nil
+	}
+	logger.Info("Watching config file for changes", "path", path)
+	return config.Watch(ctx, logger, path, func() {
+		_, _ = r.Reload(service.ReloadSourceFile)
+	})
+}
+
+// startupSettingsChanged returns the sections of the configuration read only at startup that
+// differ between previous and next.
+func startupSettingsChanged(previous, next *config.Config) []string {
+	var changed []string
+	sections := []struct {
+		name           string
+		previous, next any
+	}{
+		{"server", previous.Server, next.Server},
+		{"poe", previous.Poe, next.Poe},
+		{"auth.admin", previous.Auth.Admin, next.Auth.Admin},
+		{"limits.max_body_bytes", previous.Limits.MaxBodyBytes, next.Limits.MaxBodyBytes},
+		{"logging", previous.Logging, next.Logging},
+		{"health", previous.Health, next.Health},
+		{"images", previous.Images, next.Images},
+		{"stores", previous.Stores, next.Stores},
+		{"context", previous.Context, next.Context},
+		{"query_cache", previous.QueryCache, next.QueryCache},
+		{"coalescing", previous.Coalescing, next.Coalescing},
+		{"recording", previous.Recording, next.Recording},
+		{"inspector", previous.Inspector, next.Inspector},
+		{"cassette", previous.Cassette, next.Cassette},
+	}
+	for _, section := range sections {
+		if !reflect.DeepEqual(section.previous, section.next) {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
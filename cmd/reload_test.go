@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/config"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// reloaderWithFile returns a configReloader of the start command loading the configuration file
+// at the returned path, which initially holds content.
+func reloaderWithFile(t *testing.T, content string) (*configReloader, string) {
+	t.Helper()
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	configFileFlag = path
+	t.Cleanup(func() { configFileFlag = "" })
+
+	startup, err := loadConfig(startCmd)
+	require.NoError(t, err)
+	return newConfigReloader(startCmd, service.NewRuntimeConfigStore(startup.RuntimeConfig(nil)), startup), path
+}
+
+func TestConfigReloaderReload(t *testing.T) {
+	reloader, path := reloaderWithFile(t, "routing:\n  aliases:\n    fast: GPT-4o-Mini\n")
+	startupHash := reloader.ConfigHash()
+	limiter := reloader.runtime.Current().Limiter
+
+	require.NoError(t, os.WriteFile(path, []byte(
+		"routing:\n  aliases:\n    fast: Claude-Haiku-3.5\nlimits:\n  requests_per_minute: 10\n",
+	), 0o600))
+	status, err := reloader.Reload(service.ReloadSourceAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 2, status.Generation)
+	assert.Equal(t, service.ReloadSourceAdmin, status.Source)
+	current := reloader.runtime.Current()
+	assert.Equal(t, "Claude-Haiku-3.5", current.Router.Resolve("fast"))
+	assert.Same(t, limiter, current.Limiter, "request counts survive reloads")
+	assert.NotEqual(t, startupHash, reloader.ConfigHash())
+
+	// Invalid configurations are reported, and the current one is kept.
+	require.NoError(t, os.WriteFile(path, []byte("routing:\n  aliases:\n    fast: \"\"\n"), 0o600))
+	status, err = reloader.Reload(service.ReloadSourceFile)
+	require.Error(t, err)
+	assert.Equal(t, 2, status.Generation)
+	assert.Contains(t, status.LastError, `routing.aliases["fast"]`)
+	assert.Equal(t, "Claude-Haiku-3.5", reloader.runtime.Current().Router.Resolve("fast"))
+}
+
+func TestStartupSettingsChanged(t *testing.T) {
+	previous := config.Default()
+	next := config.Default()
+	next.Routing.Aliases = map[string]string{"fast": "GPT-4o-Mini"}
+	next.Auth.Keys = []config.KeyConfig{{Name: "client", Key: "sk-virtual-client-key"}}
+	next.Limits.RequestsPerMinute = 10
+	assert.Empty(t, startupSettingsChanged(previous, next), "runtime settings apply on reload")
+
+	next.Server.Listen = ":9090"
+	next.Auth.Admin.Token = "admin-token"
+	next.Limits.MaxBodyBytes = 1 << 20
+	next.QueryCache.Size = 10
+	assert.Equal(t, []string{"server", "auth.admin", "limits.max_body_bytes", "query_cache"}, startupSettingsChanged(previous, next))
+}
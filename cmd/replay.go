@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/supergeoff/poepenai/adapter"
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/config"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// Replay backends.
+const (
+	// replayBackendFake answers Poe queries with the recorded events.
+	replayBackendFake = "fake"
+	// replayBackendReal sends Poe queries to Poe.
+	replayBackendReal = "real"
+)
+
+// Replay flags
+var (
+	replayFileFlag    string
+	replayIDFlag      string
+	replayBackendFlag string
+	replayAPIKeyFlag  string
+)
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replays recorded exchanges and diffs the responses",
+	Long: `Re-runs exchanges recorded with 'start --record-dir' through the adapter, against a fake Poe
+backend serving the recorded Poe events or against the real Poe API, and compares the responses
+with the recorded ones. Generated IDs and timestamps are ignored by the comparison.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if logger == nil {
+			return fmt.Errorf(
+				"logger not initialized, PersistentPreRunE might have failed or was skipped",
+			)
+		}
+		if replayFileFlag == "" {
+			return fmt.Errorf("--file is required")
+		}
+		if replayBackendFlag != replayBackendFake && replayBackendFlag != replayBackendReal {
+			return fmt.Errorf("invalid replay backend %q (want %s or %s)", replayBackendFlag, replayBackendFake, replayBackendReal)
+		}
+		apiKey := replayAPIKeyFlag
+		if apiKey == "" {
+			apiKey = cfg.Auth.PoeAPIKey
+		}
+		if replayBackendFlag == replayBackendReal && apiKey == "" {
+			return fmt.Errorf("the real backend needs a Poe API key (--poe-api-key or POE_API_KEY)")
+		}
+		if apiKey == "" {
+			apiKey = "replay" // The fake backend does not check it.
+		}
+
+		exchanges, err := service.ReadExchanges(replayFileFlag)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		var replayed, failed int
+		for _, exchange := range exchanges {
+			if replayIDFlag != "" && exchange.ID != replayIDFlag {
+				continue
+			}
+			replayed++
+			diff, err := replayExchange(exchange, cfg, apiKey, replayBackendFlag == replayBackendFake)
+			if err != nil {
+				return fmt.Errorf("failed to replay exchange %s: %w", exchange.ID, err)
+			}
+			if diff == "" {
+				fmt.Fprintf(out, "PASS %s %s %s\n", exchange.ID, exchange.Method, exchange.Path)
+				continue
+			}
+			failed++
+			fmt.Fprintf(out, "FAIL %s %s %s\n%s\n", exchange.ID, exchange.Method, exchange.Path, diff)
+		}
+
+		if replayIDFlag != "" && replayed == 0 {
+			return fmt.Errorf("no exchange with id %s in %s", replayIDFlag, replayFileFlag)
+		}
+		fmt.Fprintf(out, "%d exchanges replayed, %d differ\n", replayed, failed)
+		if failed > 0 {
+			return fmt.Errorf("%d of %d replayed exchanges differ", failed, replayed)
+		}
+		return nil
+	},
+}
+
+// replayConfig returns a copy of base to replay exchanges with: exchanges are served again with
+// fresh in-memory stores, without being recorded, cached, coalesced or rate limited, and the Poe
+// API key of the replay is passed through, whatever the virtual keys.
+func replayConfig(base *config.Config) *config.Config {
+	c := *base
+	c.Stores.ConversationDir = ""
+	c.QueryCache.Dir = ""
+	c.Coalescing = config.CoalescingConfig{}
+	c.Recording.Dir = ""
+	c.Inspector.Dir = ""
+	c.Cassette.Mode = string(client.CassetteOff)
+	c.Auth.Keys = nil
+	c.Auth.AllowPassthrough = true
+	c.Limits.RequestsPerMinute = 0
+	return &c
+}
+
+// replayExchange serves the recorded request of exchange again, with fresh handlers configured
+// after base (see replayConfig), and returns the differences between the recorded and the new
+// response. The Poe queries are answered with the recorded events if fake, sent to Poe with
+// apiKey otherwise.
+func replayExchange(exchange *service.Exchange, base *config.Config, apiKey string, fake bool) (string, error) {
+	if exchange.RequestTruncated {
+		return "", errors.New("the recorded request is truncated")
+	}
+	replayConfig := replayConfig(base)
+	options := []adapter.Option{
+		adapter.WithLogger(logger),
+		adapter.WithConfig(replayConfig),
+		adapter.WithQueryCache(service.NewMemoryQueryCache(0, 0), service.QueryCacheConfig{Disabled: true}),
+	}
+	var transport *service.ReplayTransport
+	if fake {
+		transport = service.NewReplayTransport(exchange.Queries)
+		replayClient := client.NewPoeClient()
+		replayClient.HTTPClient.Transport = transport
+		options = append(options, adapter.WithBackend(replayConfig.Poe.Backend(replayClient)))
+	}
+
+	router, err := adapter.New(options...)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = router.Close() }()
+
+	req := httptest.NewRequest(exchange.Method, exchange.Path, strings.NewReader(exchange.Request))
+	for name, value := range exchange.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	var diff strings.Builder
+	if recorder.Code != exchange.Status {
+		fmt.Fprintf(&diff, "status: recorded %d, replayed %d\n", exchange.Status, recorder.Code)
+	}
+	if transport != nil {
+		if mismatches := transport.Mismatches(); mismatches > 0 {
+			fmt.Fprintf(&diff, "poe queries: %d differ from the recorded ones\n", mismatches)
+		}
+		if remaining := transport.Remaining(); remaining > 0 {
+			fmt.Fprintf(&diff, "poe queries: %d recorded ones were not sent\n", remaining)
+		}
+	}
+	if exchange.Truncated {
+		logger.Warn("Recorded response is truncated, comparing the recorded part", "exchange_id", exchange.ID)
+	}
+	replayedBody := recorder.Body.String()
+	if exchange.Truncated && len(replayedBody) > len(exchange.Response) {
+		replayedBody = replayedBody[:len(exchange.Response)]
+	}
+	diff.WriteString(service.DiffResponses(
+		service.NormalizeResponse(exchange.Response),
+		service.NormalizeResponse(replayedBody),
+	))
+	return diff.String(), nil
+}
+
+func init() {
+	replayCmd.Flags().
+		StringVar(&replayFileFlag, "file", "", "JSONL file of recorded exchanges")
+	replayCmd.Flags().
+		StringVar(&replayIDFlag, "id", "", "ID of the exchange to replay (all if empty)")
+	replayCmd.Flags().
+		StringVar(&replayBackendFlag, "backend", replayBackendFake, "Poe backend the exchanges are replayed against (fake, real)")
+	replayCmd.Flags().
+		StringVar(&replayAPIKeyFlag, "poe-api-key", "", "Poe API key of the real backend (defaults to POE_API_KEY)")
+}
@@ -1,20 +1,22 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
+	"syscall"
 	"time"
 
-	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/spf13/cobra"
-	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/adapter"
+	"github.com/supergeoff/poepenai/config"
 	"github.com/supergeoff/poepenai/handlers"
 	"github.com/supergeoff/poepenai/service"
 )
@@ -24,15 +26,23 @@ const (
 	AppVersion   = "0.1.0"     // Application version
 )
 
-// Flags
-var logLevelFlag string
+var (
+	// configFileFlag is the path of the configuration file, see package config.
+	configFileFlag string
+	// flagConfig holds the values of the flags of configuration settings, which override the
+	// loaded configuration when set, see configFlags.
+	flagConfig = config.Default()
+)
 
 // Global instances for dependencies
 var (
-	logger           *slog.Logger
-	poeClient        *client.PoeClient
-	ringBufferLogger *service.RingBufferLogWriter
-	logsTemplate     *template.Template
+	// cfg is the effective configuration, loaded by the PersistentPreRunE of the commands.
+	cfg               *config.Config
+	logger            *slog.Logger
+	ringBufferLogger  *service.RingBufferLogWriter
+	logLevels         *service.LogLevelController
+	logsTemplate      *template.Template
+	inspectorTemplate *template.Template
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -41,72 +51,65 @@ var rootCmd = &cobra.Command{
 	Short: "An OpenAI-compatible API adapter for Poe.",
 	Long:  `Proxies OpenAI API requests to Poe bots, providing an OpenAI-compatible interface.`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		loadedConfig, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		cfg = loadedConfig
+
+		// The configuration is valid, so its redaction settings and log level parse.
+		redactor, err := cfg.Logging.Redact.Redactor()
+		if err != nil {
+			return err
+		}
+
 		// Initialize ringBufferLogger first as it's needed by multiWriter
-		ringBufferLogger = service.NewRingBufferLogWriter() // Default size
-		multiWriter := io.MultiWriter(os.Stdout, ringBufferLogger)
-
-		programLogLevel := new(slog.LevelVar) // Default is Info
-		switch strings.ToLower(logLevelFlag) {
-		case "debug":
-			programLogLevel.Set(slog.LevelDebug)
-		case "info":
-			programLogLevel.Set(slog.LevelInfo)
-		case "warn":
-			programLogLevel.Set(slog.LevelWarn)
-		case "error":
-			programLogLevel.Set(slog.LevelError)
-		default:
-			// Use a temporary basic logger for this initial error message
-			// This will print to Stderr if the main logger isn't set up yet.
-			slog.Error(
-				"Invalid log level specified. Defaulting to INFO.",
-				"specified_level",
-				logLevelFlag,
+		ringBufferLogger = service.NewRingBufferLogWriterWithSize(cfg.Logging.BufferSize)
+		if cfg.Logging.Dir != "" {
+			retainingLogger, err := service.NewRingBufferLogWriterWithRetention(
+				cfg.Logging.BufferSize,
+				cfg.Logging.Dir,
+				time.Duration(cfg.Logging.Retention),
 			)
-			programLogLevel.Set(slog.LevelInfo)
+			if err != nil {
+				return err
+			}
+			ringBufferLogger = retainingLogger
+		}
+		programLogLevel, err := service.ParseLogLevel(cfg.Logging.Level)
+		if err != nil {
+			return err
 		}
+		// The level can then be changed at runtime, see the /admin/loglevel endpoint.
+		logLevels = service.NewLogLevelController(programLogLevel, time.Duration(cfg.Logging.LevelRevert))
 
+		// The ring buffer parses JSON entries, whatever the format of stdout.
+		handlerOptions := &slog.HandlerOptions{Level: logLevels}
+		var stdoutHandler slog.Handler
+		switch cfg.Logging.Format {
+		case "text":
+			stdoutHandler = slog.NewTextHandler(os.Stdout, handlerOptions)
+		default:
+			stdoutHandler = slog.NewJSONHandler(os.Stdout, handlerOptions)
+		}
+		// Records are redacted before reaching stdout and the ring buffer (and its files).
 		logger = slog.New(
-			slog.NewJSONHandler(multiWriter, &slog.HandlerOptions{Level: programLogLevel}),
+			logLevels.Handler(
+				service.NewRedactingHandler(
+					service.NewMultiHandler(stdoutHandler, slog.NewJSONHandler(ringBufferLogger, handlerOptions)),
+					redactor,
+				),
+			),
 		)
 		slog.SetDefault(logger) // Make it the default for any package-level slog calls
 
-		// Initialize other dependencies
-		poeClient = client.NewPoeClient()
-
-		// Load HTML templates
-		var err error
-		templatePathsToTry := []string{
-			filepath.Join(
-				"..",
-				templatesDir,
-				"logger.html",
-			), // From cmd/ up to poepenai/templates/
-			filepath.Join("apps", "poepenai", templatesDir, "logger.html"), // From project root
-			filepath.Join(
-				templatesDir,
-				"logger.html",
-			), // Relative to CWD (e.g. if CWD is cmd/)
-			"../templates/logger.html", // Simpler relative for common case
-			"templates/logger.html",    // If templates is in CWD
+		if configFile := configFilePath(); configFile != "" {
+			logger.Info("Loaded config file", "path", configFile)
 		}
 
-		var templateLoaded bool
-		for _, p := range templatePathsToTry {
-			logsTemplate, err = template.ParseFiles(p)
-			if err == nil {
-				logger.Info("Successfully parsed logs template", "path", p)
-				templateLoaded = true
-				break
-			}
-			logger.Debug("Failed to parse logs template at path", "error", err, "path_tried", p)
-		}
-		if !templateLoaded {
-			logger.Warn(
-				"All attempts to parse logs template failed. Logs page will not render correctly.",
-			)
-			// logsTemplate will remain nil, handlers should check for this.
-		}
+		// Load HTML templates; handlers check for nil templates.
+		logsTemplate = loadTemplate("logger.html")
+		inspectorTemplate = loadTemplate("inspector.html")
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
@@ -117,61 +120,224 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// loadTemplate parses the HTML template file name from the templates directory, looking for it
+// relative to the usual working directories. It returns nil if the template cannot be loaded.
+func loadTemplate(name string) *template.Template {
+	templatePathsToTry := []string{
+		filepath.Join("..", templatesDir, name),               // From cmd/ up to poepenai/templates/
+		filepath.Join("apps", "poepenai", templatesDir, name), // From project root
+		filepath.Join(templatesDir, name),                     // Relative to CWD (e.g. if CWD is cmd/)
+	}
+	for _, p := range templatePathsToTry {
+		tmpl, err := template.ParseFiles(p)
+		if err == nil {
+			logger.Info("Successfully parsed template", "path", p)
+			return tmpl
+		}
+		logger.Debug("Failed to parse template at path", "error", err, "path_tried", p)
+	}
+	logger.Warn(
+		"All attempts to parse template failed. Its pages will not render correctly.",
+		"template", name,
+	)
+	return nil
+}
+
 // startCmd represents the start command
 var startCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Starts the Poe OpenAI Adapter server",
 	Long:  `Initializes and starts the HTTP server that listens for OpenAI API requests.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// logger, poeClient, ringBufferLogger, logsTemplate are initialized by rootCmd.PersistentPreRunE.
+		// logger, ringBufferLogger and the templates are initialized by rootCmd.PersistentPreRunE.
 		if logger == nil {
 			return fmt.Errorf(
 				"logger not initialized, PersistentPreRunE might have failed or was skipped",
 			)
 		}
-		if poeClient == nil {
-			return fmt.Errorf("poe client not initialized")
+
+		// The configuration validation requires credentials unless the admin pages are only
+		// served locally.
+		adminAuth, err := handlers.AdminAuth(logger, handlers.AdminAuthConfig{
+			Token:    cfg.Auth.Admin.Token,
+			Username: cfg.Auth.Admin.Username,
+			Password: cfg.Auth.Admin.Password,
+		})
+		if err != nil {
+			return err
 		}
-		// ringBufferLogger and logsTemplate can be nil if their setup failed, handlers should manage this.
 
-		appHandlers := handlers.NewAppHandlers(logger, poeClient, ringBufferLogger, logsTemplate)
+		// Routing, catalog, keys and rate limits are reloaded when the configuration file
+		// changes, on SIGHUP, and from the admin pages.
+		runtime := service.NewRuntimeConfigStore(cfg.RuntimeConfig(nil))
+		reloader := newConfigReloader(cmd, runtime, cfg)
 
-		r := chi.NewRouter()
-		r.Use(middleware.RequestID)
-		r.Use(middleware.RealIP)
-		// Using slog for request logging via a custom middleware would be ideal.
-		// For now, Chi's logger will provide some basic request logging.
-		r.Use(middleware.Logger)
-		r.Use(middleware.Recoverer)
-		r.Use(middleware.Timeout(60 * time.Second))
+		adminListen := cfg.Server.AdminListen
+		poeAdapter, err := adapter.New(
+			adapter.WithLogger(logger),
+			adapter.WithConfig(cfg),
+			adapter.WithMiddleware(
+				middleware.RequestID,
+				middleware.RealIP,
+				handlers.AccessLog(logger, handlers.AccessLogConfig{
+					SampleRate:   cfg.Logging.AccessLogSampleRate,
+					LogLevels:    logLevels,
+					MaxBodyBytes: cfg.Limits.MaxBodyBytes,
+				}),
+				middleware.Recoverer,
+			),
+			adapter.WithRuntimeConfig(runtime),
+			adapter.WithAdmin(adminAuth, adminListen != ""),
+			adapter.WithLogViewer(ringBufferLogger, logsTemplate),
+			adapter.WithInspectorTemplate(inspectorTemplate),
+			adapter.WithLogLevels(logLevels),
+			adapter.WithReloadConfig(reloader.Reload),
+			adapter.WithVersion(AppVersion, reloader.ConfigHash),
+		)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = poeAdapter.Close() }()
 
-		r.Post("/v1/chat/completions", appHandlers.HandleChatCompletions)
-		r.Get("/logs", appHandlers.HandleLogsPage)
+		// SIGINT and SIGTERM drain the servers and shut them down gracefully.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 
-		port := os.Getenv("PORT")
-		if port == "" {
-			port = "8080"
+		if err := reloader.Watch(ctx); err != nil {
+			logger.Warn("Config file changes will not be reloaded automatically", "error", err)
 		}
+		// Poe is probed in the background if configured, making the adapter not ready while it
+		// fails.
+		poeAdapter.Start(ctx)
 
-		logger.Info("Starting Poe OpenAI Adapter server", "port", port, "log_level", logLevelFlag)
-		if err := http.ListenAndServe(":"+port, r); err != nil {
-			logger.Error("Failed to start server", "error", err)
-			return fmt.Errorf("failed to start server: %w", err)
+		servers := []*http.Server{{Addr: cfg.Server.Listen, Handler: poeAdapter}}
+		if adminListen != "" {
+			servers = append(servers, &http.Server{Addr: adminListen, Handler: poeAdapter.AdminHandler()})
 		}
-		return nil
+
+		// SIGHUP reloads the configuration and SIGUSR1 toggles debug logs, except on Windows.
+		defer handleSignals(reloader)()
+
+		serverErrors := make(chan error, len(servers))
+		for i, server := range servers {
+			name := "Poe OpenAI Adapter server"
+			if i > 0 {
+				name = "admin server"
+			}
+			go func() {
+				logger.Info("Starting "+name, "address", server.Addr, "log_level", cfg.Logging.Level)
+				if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					logger.Error("Failed to start "+name, "error", err)
+					serverErrors <- fmt.Errorf("failed to start %s: %w", name, err)
+				}
+			}()
+		}
+
+		select {
+		case err := <-serverErrors:
+			return err
+		case <-ctx.Done():
+		}
+		// A second signal stops the adapter immediately.
+		stop()
+		return shutdownServers(poeAdapter.Handlers().Lifecycle, servers...)
 	},
 }
 
 func init() {
+	// The flags of configuration settings default to the built-in configuration.
+	defaults := config.Default()
 	// Persistent flags are available to the command and all its children
 	rootCmd.PersistentFlags().
-		StringVar(&logLevelFlag, "loglevel", "info", "Log level (debug, info, warn, error)")
+		StringVar(&configFileFlag, "config", "", "Path to a YAML, TOML or JSON configuration file (or set POEPENAI_CONFIG); its settings are overridden by POEPENAI_* environment variables, then by the flags set")
+	rootCmd.PersistentFlags().
+		StringVar(&flagConfig.Server.Listen, "listen", defaults.Server.Listen, "Address of the API server (or set PORT)")
+	rootCmd.PersistentFlags().
+		StringVar(&flagConfig.Logging.Level, "loglevel", defaults.Logging.Level, "Log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().
+		DurationVar((*time.Duration)(&flagConfig.Logging.LevelRevert), "log-level-revert", time.Duration(defaults.Logging.LevelRevert), "Time after which log level changes made at runtime (admin endpoint, SIGUSR1) revert by default")
+	rootCmd.PersistentFlags().
+		StringVar(&flagConfig.Logging.Format, "log-format", defaults.Logging.Format, "Format of the logs written to stdout (json, text)")
+	rootCmd.PersistentFlags().
+		IntVar(&flagConfig.Logging.BufferSize, "log-buffer-size", defaults.Logging.BufferSize, "Number of log entries kept in memory for the /admin/logs page")
+	rootCmd.PersistentFlags().
+		StringVar(&flagConfig.Logging.Dir, "log-dir", defaults.Logging.Dir, "Directory where log entries are also retained as daily JSONL files, reloaded on startup (not retained if empty)")
+	rootCmd.PersistentFlags().
+		DurationVar((*time.Duration)(&flagConfig.Logging.Retention), "log-retention", time.Duration(defaults.Logging.Retention), "Time retained log files are kept (0: forever)")
+	rootCmd.PersistentFlags().
+		StringSliceVar(&flagConfig.Logging.Redact.Keys, "redact-keys", defaults.Logging.Redact.Keys, "Names of fields, log attributes and headers whose values are redacted, in addition to API keys, tokens, passwords and cookies")
+	rootCmd.PersistentFlags().
+		StringVar(&flagConfig.Logging.Redact.Content, "redact-content", defaults.Logging.Redact.Content, "How message content is logged, and recorded with --redact-recordings (keep, hash, truncate)")
+	rootCmd.PersistentFlags().
+		IntVar(&flagConfig.Logging.Redact.ContentLength, "redact-content-length", defaults.Logging.Redact.ContentLength, "Number of characters of message content kept by --redact-content truncate")
+	rootCmd.PersistentFlags().
+		StringSliceVar(&flagConfig.Logging.Redact.Rules, "redact-rules", defaults.Logging.Redact.Rules, "Patterns masked in logs, and in recorded exchanges with --redact-recordings: email, card, or name=regexp")
+	rootCmd.PersistentFlags().
+		BoolVar(&flagConfig.Logging.Redact.Recordings, "redact-recordings", defaults.Logging.Redact.Recordings, "Also apply --redact-content and --redact-rules to recorded and inspected exchanges, whose Poe queries then no longer match for replay (secrets are always redacted)")
+	rootCmd.PersistentFlags().
+		Float64Var(&flagConfig.Logging.AccessLogSampleRate, "access-log-sample-rate", defaults.Logging.AccessLogSampleRate, "Fraction of successful requests written to the access log, between 0 and 1 (failed requests are always logged)")
+	rootCmd.PersistentFlags().
+		StringVar(&flagConfig.Server.AdminListen, "admin-listen", defaults.Server.AdminListen, "Address of the admin pages (/admin/logs, /admin/inspector, /admin/info); served under /admin on the API port if empty, which requires credentials")
+	rootCmd.PersistentFlags().
+		StringVar(&flagConfig.Auth.Admin.Token, "admin-token", "", "Static token of the admin pages, sent as a Bearer token or as the basic auth password (or set POEPENAI_ADMIN_TOKEN)")
+	rootCmd.PersistentFlags().
+		StringVar(&flagConfig.Auth.Admin.Username, "admin-user", defaults.Auth.Admin.Username, "Basic auth username of the admin pages")
+	rootCmd.PersistentFlags().
+		StringVar(&flagConfig.Auth.Admin.Password, "admin-password", "", "Basic auth password of the admin pages (or set POEPENAI_ADMIN_PASSWORD)")
+	startCmd.Flags().
+		StringVar(&flagConfig.Images.ConfigFile, "images-config", defaults.Images.ConfigFile, "Path to a JSON file with images API bot profiles")
+	startCmd.Flags().
+		IntVar(&flagConfig.Stores.ResponseSize, "response-store-size", defaults.Stores.ResponseSize, "Number of Responses API responses kept in memory for previous_response_id")
+	startCmd.Flags().
+		StringVar(&flagConfig.Stores.ConversationDir, "conversation-store-dir", defaults.Stores.ConversationDir, "Directory where conversations are stored as JSON files (in memory if empty)")
+	startCmd.Flags().
+		IntVar(&flagConfig.Stores.ConversationSize, "conversation-store-size", defaults.Stores.ConversationSize, "Number of conversations kept by the in-memory conversation store")
+	startCmd.Flags().
+		StringVar(&flagConfig.Context.Strategy, "context-strategy", defaults.Context.Strategy, "How conversations exceeding a bot's context window are shortened (none, drop_oldest, middle_out, summarize); estimated at four characters per token")
+	startCmd.Flags().
+		IntVar(&flagConfig.Context.DefaultWindow, "context-default-window", defaults.Context.DefaultWindow, "Context window in tokens of bots missing from the catalog (0: never shorten)")
+	startCmd.Flags().
+		IntVar(&flagConfig.Context.ReserveTokens, "context-reserve-tokens", defaults.Context.ReserveTokens, "Tokens of the context window kept free for the bot reply")
+	startCmd.Flags().
+		StringVar(&flagConfig.Context.SummaryBot, "summary-bot", defaults.Context.SummaryBot, "Poe bot summarizing older messages for the summarize context strategy")
+	startCmd.Flags().
+		StringVar(&flagConfig.QueryCache.Dir, "query-cache-dir", defaults.QueryCache.Dir, "Directory where cached Poe responses are stored as JSON files (in memory if empty)")
+	startCmd.Flags().
+		IntVar(&flagConfig.QueryCache.Size, "query-cache-size", defaults.QueryCache.Size, "Maximum number of cached Poe responses")
+	startCmd.Flags().
+		DurationVar((*time.Duration)(&flagConfig.QueryCache.TTL), "query-cache-ttl", time.Duration(defaults.QueryCache.TTL), "Time cached Poe responses are served for")
+	startCmd.Flags().
+		StringSliceVar(&flagConfig.QueryCache.Models, "query-cache-models", defaults.QueryCache.Models, "Poe bots whose responses are always cached (otherwise opt in per request with X-Poe-Cache: on or a seed)")
+	startCmd.Flags().
+		DurationVar((*time.Duration)(&flagConfig.QueryCache.ReplayMaxGap), "query-cache-replay-gap", time.Duration(defaults.QueryCache.ReplayMaxGap), "Maximum delay between replayed events of a cached response")
+	startCmd.Flags().
+		BoolVar(&flagConfig.Coalescing.Enabled, "coalesce", defaults.Coalescing.Enabled, "Share one upstream Poe query between identical concurrent requests")
+	startCmd.Flags().
+		BoolVar(&flagConfig.Coalescing.NonDeterministic, "coalesce-nondeterministic", defaults.Coalescing.NonDeterministic, "Also coalesce requests without a seed or an explicit temperature of 0")
+
+	startCmd.Flags().
+		StringVar(&flagConfig.Recording.Dir, "record-dir", defaults.Recording.Dir, "Directory where served exchanges are recorded as JSONL files, for the replay command (not recorded if empty)")
+	startCmd.Flags().
+		Int64Var(&flagConfig.Recording.MaxBytes, "record-max-bytes", defaults.Recording.MaxBytes, "Size in bytes after which the exchange recording file is rotated")
+	startCmd.Flags().
+		IntVar(&flagConfig.Recording.MaxFiles, "record-max-files", defaults.Recording.MaxFiles, "Number of exchange recording files kept, including the current one")
+	startCmd.Flags().
+		IntVar(&flagConfig.Inspector.Size, "inspector-size", defaults.Inspector.Size, "Number of recent requests kept for the request inspector")
+	startCmd.Flags().
+		StringVar(&flagConfig.Inspector.Dir, "inspector-dir", defaults.Inspector.Dir, "Directory where the requests of the request inspector are persisted (in memory only if empty)")
+	startCmd.Flags().
+		StringVar(&flagConfig.Cassette.Mode, "poe-cassette-mode", defaults.Cassette.Mode, "Record Poe responses to the cassette, or serve them from it without network access (off, record, playback)")
+	startCmd.Flags().
+		StringVar(&flagConfig.Cassette.Dir, "poe-cassette-dir", defaults.Cassette.Dir, "Directory of the Poe cassette")
 
 	// Set the version for the --version flag
 	rootCmd.Version = AppVersion
 
 	// Add subcommands
 	rootCmd.AddCommand(startCmd)
+	rootCmd.AddCommand(replayCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(healthCmd)
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
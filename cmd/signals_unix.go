@@ -0,0 +1,38 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/supergeoff/poepenai/service"
+)
+
+// handleSignals reloads the configuration with reloader on SIGHUP, and turns debug logs on for
+// --log-level-revert, or back off, on SIGUSR1. The returned function stops handling them, once
+// the signal being handled, if any, is.
+func handleSignals(reloader *configReloader) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				logger.Info("Configuration reload requested by SIGHUP")
+				_, _ = reloader.Reload(service.ReloadSourceSignal)
+				continue
+			}
+			level := logLevels.ToggleDebug()
+			logger.Info("Log level toggled by SIGUSR1", "level", level, "revert_after", logLevels.RevertAfter())
+		}
+	}()
+	return func() {
+		// No signal is sent on the channel once signal.Stop returns.
+		signal.Stop(signals)
+		close(signals)
+		<-done
+	}
+}
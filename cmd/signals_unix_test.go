@@ -0,0 +1,40 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/service"
+)
+
+func TestHandleSignalsToggleDebug(t *testing.T) {
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	logLevels = service.NewLogLevelController(slog.LevelInfo, time.Hour)
+	defer handleSignals(nil)()
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+	require.Eventually(t, func() bool { return logLevels.State().Level == slog.LevelDebug }, time.Second, time.Millisecond)
+	assert.NotNil(t, logLevels.State().ExpiresAt, "debug logs revert after --log-level-revert")
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+	require.Eventually(t, func() bool { return logLevels.State().Level == slog.LevelInfo }, time.Second, time.Millisecond)
+}
+
+func TestHandleSignalsReload(t *testing.T) {
+	reloader, path := reloaderWithFile(t, "routing:\n  aliases:\n    fast: GPT-4o-Mini\n")
+	defer handleSignals(reloader)()
+
+	require.NoError(t, os.WriteFile(path, []byte("routing:\n  aliases:\n    fast: Claude-Haiku-3.5\n"), 0o600))
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGHUP))
+	require.Eventually(t, func() bool { return reloader.runtime.Status().Generation == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, service.ReloadSourceSignal, reloader.runtime.Status().Source)
+	assert.Equal(t, "Claude-Haiku-3.5", reloader.runtime.Current().Router.Resolve("fast"))
+}
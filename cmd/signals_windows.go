@@ -0,0 +1,7 @@
+package cmd
+
+// handleSignals does nothing on Windows, which has no SIGHUP and SIGUSR1: the configuration is
+// reloaded when its file changes and from the admin pages, where the log levels are set too.
+func handleSignals(*configReloader) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,711 @@
+// Package config defines the configuration of the adapter, loaded from a YAML, TOML or JSON file
+// and overridden by environment variables and command-line flags.
+//
+// Settings are layered, each layer overriding the previous ones:
+//
+//  1. the built-in defaults, see Default;
+//  2. the configuration file, whose format is given by its extension (.yaml or .yml, .toml,
+//     .json); unknown settings are errors;
+//  3. environment variables, see ApplyEnv: POEPENAI_ followed by the upper-cased path of a
+//     setting, e.g. POEPENAI_SERVER_LISTEN or POEPENAI_POE_RETRY_MAX_RETRIES, and the legacy
+//     PORT, POE_API_KEY, POEPENAI_ADMIN_TOKEN and POEPENAI_ADMIN_PASSWORD variables;
+//  4. the command-line flags explicitly set.
+//
+// The models catalog, routing aliases and virtual keys can only be set in the file.
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/service"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration file formats, named after their usual file extension.
+const (
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+	FormatJSON = "json"
+)
+
+// minKeyLength is the minimum length of the virtual keys, which must not be guessable.
+const minKeyLength = 16
+
+// Config is the configuration of the adapter.
+type Config struct {
+	Server  ServerConfig  `json:"server" yaml:"server" toml:"server"`
+	Poe     PoeConfig     `json:"poe" yaml:"poe" toml:"poe"`
+	Models  ModelsConfig  `json:"models" yaml:"models" toml:"models"`
+	Routing RoutingConfig `json:"routing" yaml:"routing" toml:"routing"`
+	Auth    AuthConfig    `json:"auth" yaml:"auth" toml:"auth"`
+	Limits  LimitsConfig  `json:"limits" yaml:"limits" toml:"limits"`
+	Logging LoggingConfig `json:"logging" yaml:"logging" toml:"logging"`
+	Health  HealthConfig  `json:"health" yaml:"health" toml:"health"`
+
+	Images     ImagesConfig     `json:"images" yaml:"images" toml:"images"`
+	Stores     StoresConfig     `json:"stores" yaml:"stores" toml:"stores"`
+	Context    ContextConfig    `json:"context" yaml:"context" toml:"context"`
+	QueryCache QueryCacheConfig `json:"query_cache" yaml:"query_cache" toml:"query_cache"`
+	Coalescing CoalescingConfig `json:"coalescing" yaml:"coalescing" toml:"coalescing"`
+	Recording  RecordingConfig  `json:"recording" yaml:"recording" toml:"recording"`
+	Inspector  InspectorConfig  `json:"inspector" yaml:"inspector" toml:"inspector"`
+	Cassette   CassetteConfig   `json:"cassette" yaml:"cassette" toml:"cassette"`
+}
+
+// ServerConfig configures the HTTP servers.
+type ServerConfig struct {
+	// Listen is the address of the API server.
+	Listen string `json:"listen" yaml:"listen" toml:"listen"`
+	// AdminListen is the address of the admin pages, served under /admin on the API server if
+	// empty, which then requires admin credentials.
+	AdminListen string `json:"admin_listen" yaml:"admin_listen" toml:"admin_listen"`
+	// RequestTimeout is the time after which API requests are cancelled.
+	RequestTimeout Duration `json:"request_timeout" yaml:"request_timeout" toml:"request_timeout"`
+	// DrainDelay is the time the servers keep serving after a shutdown signal while reporting
+	// not ready, so that load balancers stop sending them requests first.
+	DrainDelay Duration `json:"drain_delay" yaml:"drain_delay" toml:"drain_delay"`
+	// ShutdownTimeout is the time given to in-flight requests to complete on shutdown.
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+// HealthConfig configures the readiness checks.
+type HealthConfig struct {
+	// ProbeBot is the Poe bot periodically queried to check that Poe is reachable, which makes the
+	// adapter not ready while it fails. Poe is not probed if empty.
+	ProbeBot string `json:"probe_bot" yaml:"probe_bot" toml:"probe_bot"`
+	// ProbeInterval is the time between two probes.
+	ProbeInterval Duration `json:"probe_interval" yaml:"probe_interval" toml:"probe_interval"`
+	// ProbeTimeout is the time after which a probe fails.
+	ProbeTimeout Duration `json:"probe_timeout" yaml:"probe_timeout" toml:"probe_timeout"`
+}
+
+// PoeConfig configures the Poe Bot Query API client.
+type PoeConfig struct {
+	// BaseURL is the URL of the Poe Bot Query API, to which bot names are appended.
+	BaseURL string `json:"base_url" yaml:"base_url" toml:"base_url"`
+	// Timeout is the timeout of the requests to Poe, and StreamTimeout the one of the queries
+	// streaming bot responses.
+	Timeout       Duration    `json:"timeout" yaml:"timeout" toml:"timeout"`
+	StreamTimeout Duration    `json:"stream_timeout" yaml:"stream_timeout" toml:"stream_timeout"`
+	Retry         RetryConfig `json:"retry" yaml:"retry" toml:"retry"`
+	// RequestsPerMinute limits the requests sent to Poe with each Poe API key (no limit if 0), and
+	// Burst is the number of requests sent at once (RequestsPerMinute if 0). Requests over the
+	// limit wait.
+	RequestsPerMinute int `json:"requests_per_minute" yaml:"requests_per_minute" toml:"requests_per_minute"`
+	Burst             int `json:"burst" yaml:"burst" toml:"burst"`
+	// SettingsTTL is how long the settings of the bots (e.g. whether they accept attachments) are
+	// cached, and DiscoverSettings fetches them at startup for the bots of the models catalog, with
+	// auth.poe_api_key. Otherwise they are fetched when first needed.
+	SettingsTTL      Duration `json:"settings_ttl" yaml:"settings_ttl" toml:"settings_ttl"`
+	DiscoverSettings bool     `json:"discover_settings" yaml:"discover_settings" toml:"discover_settings"`
+}
+
+// RetryConfig is the retry policy of the failed Poe queries.
+type RetryConfig struct {
+	// MaxRetries is the number of retries of a failed query.
+	MaxRetries int `json:"max_retries" yaml:"max_retries" toml:"max_retries"`
+	// Delay is the wait before the first retry, growing linearly with the following ones.
+	Delay Duration `json:"delay" yaml:"delay" toml:"delay"`
+}
+
+// ModelsConfig configures the models catalog.
+type ModelsConfig struct {
+	// IncludeDefaults keeps the built-in catalog, see service.DefaultModelCatalog; Bots add to it
+	// or replace its entries.
+	IncludeDefaults bool          `json:"include_defaults" yaml:"include_defaults" toml:"include_defaults"`
+	Bots            []ModelConfig `json:"bots" yaml:"bots" toml:"bots"`
+}
+
+// ModelConfig is an entry of the models catalog, see service.ModelInfo.
+type ModelConfig struct {
+	ID              string `json:"id" yaml:"id" toml:"id"`
+	OwnedBy         string `json:"owned_by,omitempty" yaml:"owned_by,omitempty" toml:"owned_by,omitempty"`
+	Family          string `json:"family,omitempty" yaml:"family,omitempty" toml:"family,omitempty"`
+	Description     string `json:"description,omitempty" yaml:"description,omitempty" toml:"description,omitempty"`
+	ContextWindow   int    `json:"context_window,omitempty" yaml:"context_window,omitempty" toml:"context_window,omitempty"`
+	Vision          bool   `json:"vision,omitempty" yaml:"vision,omitempty" toml:"vision,omitempty"`
+	ImageGeneration bool   `json:"image_generation,omitempty" yaml:"image_generation,omitempty" toml:"image_generation,omitempty"`
+}
+
+// RoutingConfig configures how the models requested by clients map to Poe bots.
+type RoutingConfig struct {
+	// Aliases maps model names to the Poe bots queried for them, e.g. gpt-4 to GPT-4o.
+	Aliases map[string]string `json:"aliases" yaml:"aliases" toml:"aliases"`
+}
+
+// AuthConfig configures the credentials of the clients and of the admin pages.
+type AuthConfig struct {
+	// PoeAPIKey is the Poe Platform API Key used for the virtual keys without their own, and for
+	// the Ollama clients sending no credentials if AllowAnonymousOllama is set.
+	PoeAPIKey string `json:"poe_api_key" yaml:"poe_api_key" toml:"poe_api_key"`
+	// AllowPassthrough lets clients send their own Poe Platform API Key instead of a virtual key.
+	AllowPassthrough bool `json:"allow_passthrough" yaml:"allow_passthrough" toml:"allow_passthrough"`
+	// AllowAnonymousOllama lets Ollama clients send no credentials, spending PoeAPIKey. Anyone
+	// reaching the API server can then query the bots. Otherwise, requests without credentials
+	// are rejected when Keys are configured.
+	AllowAnonymousOllama bool        `json:"allow_anonymous_ollama" yaml:"allow_anonymous_ollama" toml:"allow_anonymous_ollama"`
+	Keys                 []KeyConfig `json:"keys" yaml:"keys" toml:"keys"`
+	Admin                AdminConfig `json:"admin" yaml:"admin" toml:"admin"`
+}
+
+// KeyConfig is a virtual key, see service.VirtualKey.
+type KeyConfig struct {
+	Name              string   `json:"name" yaml:"name" toml:"name"`
+	Key               string   `json:"key" yaml:"key" toml:"key"`
+	PoeAPIKey         string   `json:"poe_api_key,omitempty" yaml:"poe_api_key,omitempty" toml:"poe_api_key,omitempty"`
+	Models            []string `json:"models,omitempty" yaml:"models,omitempty" toml:"models,omitempty"`
+	RequestsPerMinute int      `json:"requests_per_minute,omitempty" yaml:"requests_per_minute,omitempty" toml:"requests_per_minute,omitempty"`
+}
+
+// AdminConfig is the credentials of the admin pages, see handlers.AdminAuthConfig.
+type AdminConfig struct {
+	Token    string `json:"token" yaml:"token" toml:"token"`
+	Username string `json:"username" yaml:"username" toml:"username"`
+	Password string `json:"password" yaml:"password" toml:"password"`
+}
+
+// LimitsConfig limits the API requests; zero values mean no limit.
+type LimitsConfig struct {
+	// MaxBodyBytes is the maximum size of a request body.
+	MaxBodyBytes int64 `json:"max_body_bytes" yaml:"max_body_bytes" toml:"max_body_bytes"`
+	// RequestsPerMinute is the number of requests per minute of each client (virtual key, Poe API
+	// key or IP address), unless set for its virtual key, and Burst the number of requests it can
+	// make at once (RequestsPerMinute if 0).
+	RequestsPerMinute int `json:"requests_per_minute" yaml:"requests_per_minute" toml:"requests_per_minute"`
+	Burst             int `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+// LoggingConfig configures the logs.
+type LoggingConfig struct {
+	// Level is the minimum level of the logs (debug, info, warn, error).
+	Level string `json:"level" yaml:"level" toml:"level"`
+	// Format is the format of the logs written to stdout (json, text).
+	Format string `json:"format" yaml:"format" toml:"format"`
+	// LevelRevert is the time after which the log level changes made at runtime revert.
+	LevelRevert Duration `json:"level_revert" yaml:"level_revert" toml:"level_revert"`
+	// BufferSize is the number of log entries kept in memory for the logs page.
+	BufferSize int `json:"buffer_size" yaml:"buffer_size" toml:"buffer_size"`
+	// Dir is the directory where log entries are retained as daily files for Retention (forever
+	// if 0); they are not retained if it is empty.
+	Dir       string   `json:"dir" yaml:"dir" toml:"dir"`
+	Retention Duration `json:"retention" yaml:"retention" toml:"retention"`
+	// AccessLogSampleRate is the fraction of successful requests in the access log.
+	AccessLogSampleRate float64      `json:"access_log_sample_rate" yaml:"access_log_sample_rate" toml:"access_log_sample_rate"`
+	Redact              RedactConfig `json:"redact" yaml:"redact" toml:"redact"`
+}
+
+// RedactConfig configures the redaction of logs and recorded exchanges, see service.RedactConfig.
+type RedactConfig struct {
+	Keys          []string `json:"keys" yaml:"keys" toml:"keys"`
+	Content       string   `json:"content" yaml:"content" toml:"content"`
+	ContentLength int      `json:"content_length" yaml:"content_length" toml:"content_length"`
+	Rules         []string `json:"rules" yaml:"rules" toml:"rules"`
+	// Recordings also applies Content and Rules to recorded exchanges, which only get their
+	// secrets redacted otherwise, as redacted Poe queries no longer match for replay.
+	Recordings bool `json:"recordings" yaml:"recordings" toml:"recordings"`
+}
+
+// ImagesConfig configures the images API.
+type ImagesConfig struct {
+	// ConfigFile is the path of a JSON file of image bot profiles, see service.LoadImageConfig.
+	// The built-in profiles are used if empty.
+	ConfigFile string `json:"config_file" yaml:"config_file" toml:"config_file"`
+}
+
+// StoresConfig configures the stores of the Responses API responses and of the conversations.
+type StoresConfig struct {
+	// ResponseSize is the number of responses kept in memory for previous_response_id.
+	ResponseSize int `json:"response_size" yaml:"response_size" toml:"response_size"`
+	// ConversationDir is the directory where conversations are stored as JSON files; they are
+	// kept in memory, ConversationSize at most, if it is empty.
+	ConversationDir  string `json:"conversation_dir" yaml:"conversation_dir" toml:"conversation_dir"`
+	ConversationSize int    `json:"conversation_size" yaml:"conversation_size" toml:"conversation_size"`
+}
+
+// ContextConfig configures context-window management, see service.ContextConfig.
+type ContextConfig struct {
+	// Strategy shortens conversations exceeding a bot's context window (none, drop_oldest,
+	// middle_out, summarize).
+	Strategy      string `json:"strategy" yaml:"strategy" toml:"strategy"`
+	DefaultWindow int    `json:"default_window" yaml:"default_window" toml:"default_window"`
+	ReserveTokens int    `json:"reserve_tokens" yaml:"reserve_tokens" toml:"reserve_tokens"`
+	SummaryBot    string `json:"summary_bot" yaml:"summary_bot" toml:"summary_bot"`
+}
+
+// QueryCacheConfig configures the cache of Poe responses, see service.QueryCacheConfig.
+type QueryCacheConfig struct {
+	// Dir is the directory where cached responses are stored as JSON files; they are kept in
+	// memory if it is empty.
+	Dir          string   `json:"dir" yaml:"dir" toml:"dir"`
+	Size         int      `json:"size" yaml:"size" toml:"size"`
+	TTL          Duration `json:"ttl" yaml:"ttl" toml:"ttl"`
+	Models       []string `json:"models" yaml:"models" toml:"models"`
+	ReplayMaxGap Duration `json:"replay_max_gap" yaml:"replay_max_gap" toml:"replay_max_gap"`
+}
+
+// CoalescingConfig configures the coalescing of identical in-flight queries, see
+// service.CoalesceConfig.
+type CoalescingConfig struct {
+	Enabled          bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	NonDeterministic bool `json:"non_deterministic" yaml:"non_deterministic" toml:"non_deterministic"`
+}
+
+// RecordingConfig configures the recording of the served exchanges, see service.Recorder.
+type RecordingConfig struct {
+	// Dir is the directory of the recording files; exchanges are not recorded if it is empty.
+	Dir      string `json:"dir" yaml:"dir" toml:"dir"`
+	MaxBytes int64  `json:"max_bytes" yaml:"max_bytes" toml:"max_bytes"`
+	MaxFiles int    `json:"max_files" yaml:"max_files" toml:"max_files"`
+}
+
+// InspectorConfig configures the request inspector, see service.ExchangeStore.
+type InspectorConfig struct {
+	Size int `json:"size" yaml:"size" toml:"size"`
+	// Dir is the directory where the inspected requests are persisted, in memory only if empty.
+	Dir string `json:"dir" yaml:"dir" toml:"dir"`
+}
+
+// CassetteConfig configures the Poe cassette, see client.CassetteTransport.
+type CassetteConfig struct {
+	// Mode is off, record or playback.
+	Mode string `json:"mode" yaml:"mode" toml:"mode"`
+	Dir  string `json:"dir" yaml:"dir" toml:"dir"`
+}
+
+// Duration is a time.Duration written as a Go duration string, e.g. "1m30s", in every format.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q (want a Go duration, e.g. 30s or 5m)", text)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Listen:          ":8080",
+			AdminListen:     "127.0.0.1:8081",
+			RequestTimeout:  Duration(60 * time.Second),
+			ShutdownTimeout: Duration(30 * time.Second),
+		},
+		Poe: PoeConfig{
+			BaseURL:       "https://api.poe.com/bot/",
+			Timeout:       Duration(120 * time.Second),
+			StreamTimeout: Duration(5 * time.Minute),
+			Retry: RetryConfig{
+				MaxRetries: 2,
+				Delay:      Duration(500 * time.Millisecond),
+			},
+			SettingsTTL: Duration(time.Hour),
+		},
+		Models: ModelsConfig{IncludeDefaults: true},
+		Auth: AuthConfig{
+			AllowPassthrough: true,
+			Admin:            AdminConfig{Username: "admin"},
+		},
+		Logging: LoggingConfig{
+			Level:               "info",
+			Format:              "json",
+			LevelRevert:         Duration(15 * time.Minute),
+			BufferSize:          1000,
+			Retention:           Duration(7 * 24 * time.Hour),
+			AccessLogSampleRate: 1,
+			Redact: RedactConfig{
+				Content:       "keep",
+				ContentLength: 200,
+				Rules:         []string{"email", "card"},
+			},
+		},
+		Health: HealthConfig{
+			ProbeInterval: Duration(5 * time.Minute),
+			ProbeTimeout:  Duration(30 * time.Second),
+		},
+		Stores: StoresConfig{
+			ResponseSize:     1000,
+			ConversationSize: 1000,
+		},
+		Context: ContextConfig{
+			Strategy:      string(service.ContextStrategyNone),
+			ReserveTokens: 4096,
+			SummaryBot:    "GPT-4o-Mini",
+		},
+		QueryCache: QueryCacheConfig{
+			Size:         1000,
+			TTL:          Duration(24 * time.Hour),
+			ReplayMaxGap: Duration(20 * time.Millisecond),
+		},
+		Recording: RecordingConfig{
+			MaxBytes: 100 << 20,
+			MaxFiles: 10,
+		},
+		Inspector: InspectorConfig{Size: 200},
+		Cassette:  CassetteConfig{Mode: "off"},
+	}
+}
+
+// Load returns the default configuration overridden by the configuration file at path, or the
+// default configuration if path is empty. It does not validate it.
+func Load(path string) (*Config, error) {
+	config := Default()
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	format, err := FileFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := decode(data, format, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// FileFormat returns the format of the configuration file at path, given by its extension.
+func FileFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".toml":
+		return FormatTOML, nil
+	case ".json":
+		return FormatJSON, nil
+	default:
+		return "", fmt.Errorf("unsupported config file extension %q (want .yaml, .yml, .toml or .json)", filepath.Ext(path))
+	}
+}
+
+// decode decodes data in format into config, rejecting unknown settings.
+func decode(data []byte, format string, config *Config) error {
+	switch format {
+	case FormatYAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	case FormatTOML:
+		err := toml.NewDecoder(bytes.NewReader(data)).DisallowUnknownFields().Decode(config)
+		var strictErr *toml.StrictMissingError
+		if errors.As(err, &strictErr) {
+			return errors.New(strictErr.String())
+		}
+		return err
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(config)
+	default:
+		return fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+// Marshal encodes config in format (yaml, toml or json).
+func Marshal(config *Config, format string) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		return yaml.Marshal(config)
+	case FormatTOML:
+		return toml.Marshal(config)
+	case FormatJSON:
+		data, err := json.MarshalIndent(config, "", "  ")
+		return append(data, '\n'), err
+	default:
+		return nil, fmt.Errorf("unsupported config format %q (want yaml, toml or json)", format)
+	}
+}
+
+// Redacted returns a copy of c whose secrets are masked, for display.
+func (c *Config) Redacted() *Config {
+	const mask = "REDACTED"
+	redact := func(secret string) string {
+		if secret == "" {
+			return ""
+		}
+		return mask
+	}
+	redacted := *c
+	redacted.Auth.PoeAPIKey = redact(c.Auth.PoeAPIKey)
+	redacted.Auth.Admin.Token = redact(c.Auth.Admin.Token)
+	redacted.Auth.Admin.Password = redact(c.Auth.Admin.Password)
+	redacted.Auth.Keys = make([]KeyConfig, len(c.Auth.Keys))
+	for i, key := range c.Auth.Keys {
+		key.Key = redact(key.Key)
+		key.PoeAPIKey = redact(key.PoeAPIKey)
+		redacted.Auth.Keys[i] = key
+	}
+	return &redacted
+}
+
+// Validate checks the whole configuration, returning all its errors joined, each prefixed with
+// the path of the faulty setting.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(setting, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Listen); err != nil {
+		invalid("server.listen", "invalid address %q: %v", c.Server.Listen, err)
+	}
+	adminAuthEnabled := c.Auth.Admin.Token != "" || c.Auth.Admin.Password != ""
+	switch {
+	case c.Server.AdminListen == "":
+		if !adminAuthEnabled {
+			invalid("server.admin_listen", "admin pages served on the API server require auth.admin.token or auth.admin.password")
+		}
+	case !isLoopbackAddress(c.Server.AdminListen):
+		if _, _, err := net.SplitHostPort(c.Server.AdminListen); err != nil {
+			invalid("server.admin_listen", "invalid address %q: %v", c.Server.AdminListen, err)
+		} else if !adminAuthEnabled {
+			invalid("server.admin_listen", "%s is not a loopback address, auth.admin.token or auth.admin.password is required", c.Server.AdminListen)
+		}
+	}
+	if c.Server.RequestTimeout <= 0 {
+		invalid("server.request_timeout", "must be positive")
+	}
+	if c.Server.DrainDelay < 0 {
+		invalid("server.drain_delay", "must not be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		invalid("server.shutdown_timeout", "must be positive")
+	}
+
+	if baseURL, err := url.Parse(c.Poe.BaseURL); err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		invalid("poe.base_url", "invalid URL %q (want an absolute http or https URL)", c.Poe.BaseURL)
+	}
+	if c.Poe.Timeout <= 0 {
+		invalid("poe.timeout", "must be positive")
+	}
+	if c.Poe.StreamTimeout <= 0 {
+		invalid("poe.stream_timeout", "must be positive")
+	}
+	if c.Poe.Retry.MaxRetries < 0 {
+		invalid("poe.retry.max_retries", "must not be negative")
+	}
+	if c.Poe.Retry.Delay < 0 {
+		invalid("poe.retry.delay", "must not be negative")
+	}
+	if c.Poe.RequestsPerMinute < 0 {
+		invalid("poe.requests_per_minute", "must not be negative")
+	}
+	if c.Poe.Burst < 0 {
+		invalid("poe.burst", "must not be negative")
+	}
+	if c.Poe.SettingsTTL <= 0 {
+		invalid("poe.settings_ttl", "must be positive")
+	}
+	if c.Poe.DiscoverSettings && c.Auth.PoeAPIKey == "" {
+		invalid("poe.discover_settings", "requires auth.poe_api_key to query Poe")
+	}
+
+	modelIDs := make(map[string]bool, len(c.Models.Bots))
+	for i, model := range c.Models.Bots {
+		setting := fmt.Sprintf("models.bots[%d]", i)
+		if model.ID == "" {
+			invalid(setting+".id", "must not be empty")
+		} else if modelIDs[strings.ToLower(model.ID)] {
+			invalid(setting+".id", "duplicate model %q", model.ID)
+		}
+		modelIDs[strings.ToLower(model.ID)] = true
+		if model.ContextWindow < 0 {
+			invalid(setting+".context_window", "must not be negative")
+		}
+	}
+
+	aliases := make(map[string]bool, len(c.Routing.Aliases))
+	for alias := range c.Routing.Aliases {
+		aliases[strings.ToLower(alias)] = true
+	}
+	for alias, botName := range c.Routing.Aliases {
+		setting := fmt.Sprintf("routing.aliases[%q]", alias)
+		switch {
+		case strings.TrimSpace(alias) == "":
+			invalid("routing.aliases", "empty alias")
+		case strings.TrimSpace(botName) == "":
+			invalid(setting, "must name a Poe bot")
+		case aliases[strings.ToLower(botName)]:
+			invalid(setting, "%q is itself an alias; aliases do not chain", botName)
+		}
+	}
+
+	keyNames := make(map[string]bool, len(c.Auth.Keys))
+	keys := make(map[string]bool, len(c.Auth.Keys))
+	for i, key := range c.Auth.Keys {
+		setting := fmt.Sprintf("auth.keys[%d]", i)
+		if key.Name == "" {
+			invalid(setting+".name", "must not be empty")
+		} else if keyNames[key.Name] {
+			invalid(setting+".name", "duplicate key name %q", key.Name)
+		}
+		keyNames[key.Name] = true
+		if len(key.Key) < minKeyLength {
+			invalid(setting+".key", "must be at least %d characters long", minKeyLength)
+		} else if keys[key.Key] {
+			invalid(setting+".key", "duplicate key")
+		}
+		keys[key.Key] = true
+		if key.PoeAPIKey == "" && c.Auth.PoeAPIKey == "" {
+			invalid(setting+".poe_api_key", "must be set, as auth.poe_api_key is not")
+		}
+		for j, model := range key.Models {
+			if strings.TrimSpace(model) == "" {
+				invalid(fmt.Sprintf("%s.models[%d]", setting, j), "must not be empty")
+			}
+		}
+		if key.RequestsPerMinute < 0 {
+			invalid(setting+".requests_per_minute", "must not be negative")
+		}
+	}
+
+	if c.Limits.MaxBodyBytes < 0 {
+		invalid("limits.max_body_bytes", "must not be negative")
+	}
+	if c.Limits.RequestsPerMinute < 0 {
+		invalid("limits.requests_per_minute", "must not be negative")
+	}
+	if c.Limits.Burst < 0 {
+		invalid("limits.burst", "must not be negative")
+	}
+
+	if _, err := service.ParseLogLevel(c.Logging.Level); err != nil {
+		invalid("logging.level", "%v", err)
+	}
+	if c.Logging.Format != "json" && c.Logging.Format != "text" {
+		invalid("logging.format", "invalid log format %q (want json or text)", c.Logging.Format)
+	}
+	if c.Logging.LevelRevert < 0 {
+		invalid("logging.level_revert", "must not be negative")
+	}
+	if c.Logging.BufferSize <= 0 {
+		invalid("logging.buffer_size", "must be positive")
+	}
+	if c.Logging.Retention < 0 {
+		invalid("logging.retention", "must not be negative")
+	}
+	if c.Logging.AccessLogSampleRate < 0 || c.Logging.AccessLogSampleRate > 1 {
+		invalid("logging.access_log_sample_rate", "must be between 0 and 1")
+	}
+	if _, err := c.Logging.Redact.Redactor(); err != nil {
+		invalid("logging.redact", "%v", err)
+	}
+	if c.Logging.Redact.ContentLength <= 0 {
+		invalid("logging.redact.content_length", "must be positive")
+	}
+
+	if c.Auth.AllowAnonymousOllama && c.Auth.PoeAPIKey == "" {
+		invalid("auth.allow_anonymous_ollama", "requires auth.poe_api_key to query Poe")
+	}
+
+	if c.Health.ProbeBot != "" && c.Auth.PoeAPIKey == "" {
+		invalid("health.probe_bot", "requires auth.poe_api_key to query Poe")
+	}
+	if c.Health.ProbeInterval <= 0 {
+		invalid("health.probe_interval", "must be positive")
+	}
+	if c.Health.ProbeTimeout <= 0 {
+		invalid("health.probe_timeout", "must be positive")
+	}
+
+	if c.Stores.ResponseSize <= 0 {
+		invalid("stores.response_size", "must be positive")
+	}
+	if c.Stores.ConversationSize <= 0 {
+		invalid("stores.conversation_size", "must be positive")
+	}
+
+	if _, err := service.ParseContextStrategy(c.Context.Strategy); err != nil {
+		invalid("context.strategy", "%v", err)
+	}
+	if c.Context.DefaultWindow < 0 {
+		invalid("context.default_window", "must not be negative")
+	}
+	if c.Context.ReserveTokens < 0 {
+		invalid("context.reserve_tokens", "must not be negative")
+	}
+	if c.Context.Strategy == string(service.ContextStrategySummarize) && strings.TrimSpace(c.Context.SummaryBot) == "" {
+		invalid("context.summary_bot", "must name a Poe bot for the summarize strategy")
+	}
+
+	if c.QueryCache.Size <= 0 {
+		invalid("query_cache.size", "must be positive")
+	}
+	if c.QueryCache.TTL <= 0 {
+		invalid("query_cache.ttl", "must be positive")
+	}
+	if c.QueryCache.ReplayMaxGap < 0 {
+		invalid("query_cache.replay_max_gap", "must not be negative")
+	}
+
+	if c.Recording.MaxBytes <= 0 {
+		invalid("recording.max_bytes", "must be positive")
+	}
+	if c.Recording.MaxFiles <= 0 {
+		invalid("recording.max_files", "must be positive")
+	}
+
+	if c.Inspector.Size <= 0 {
+		invalid("inspector.size", "must be positive")
+	}
+
+	if mode, err := client.ParseCassetteMode(c.Cassette.Mode); err != nil {
+		invalid("cassette.mode", "%v", err)
+	} else if mode != client.CassetteOff && c.Cassette.Dir == "" {
+		invalid("cassette.dir", "must be set in cassette mode %s", mode)
+	}
+
+	return errors.Join(errs...)
+}
+
+// isLoopbackAddress reports whether the listen address only accepts local connections.
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Hash returns a short hash of the configuration, which identifies it without revealing its
+// secrets.
+func (c *Config) Hash() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAdminListen(t *testing.T) {
+	tests := []struct {
+		name        string
+		adminListen string
+		token       string
+		wantErr     bool
+	}{
+		{"loopback", "127.0.0.1:8081", "", false},
+		{"localhost", "localhost:8081", "", false},
+		{"IPv6 loopback", "[::1]:8081", "", false},
+		{"every interface", ":8081", "", true},
+		{"every interface with a token", ":8081", "admin-token", false},
+		{"API server", "", "", true},
+		{"API server with a token", "", "admin-token", false},
+		{"invalid address", "8081", "admin-token", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.Server.AdminListen = tt.adminListen
+			c.Auth.Admin.Token = tt.token
+			err := c.Validate()
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "server.admin_listen")
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "server:\n  listen: \":9090\"\npoe:\n  timeout: 45s\nrouting:\n  aliases:\n    gpt-4o: GPT-4o\n",
+		"config.toml": "[server]\nlisten = \":9090\"\n[poe]\ntimeout = \"45s\"\n[routing.aliases]\ngpt-4o = \"GPT-4o\"\n",
+		"config.json": `{"server":{"listen":":9090"},"poe":{"timeout":"45s"},"routing":{"aliases":{"gpt-4o":"GPT-4o"}}}`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+			c, err := Load(path)
+			require.NoError(t, err)
+			assert.Equal(t, ":9090", c.Server.Listen)
+			assert.Equal(t, Duration(45*time.Second), c.Poe.Timeout)
+			assert.Equal(t, map[string]string{"gpt-4o": "GPT-4o"}, c.Routing.Aliases)
+			assert.Equal(t, Default().Server.AdminListen, c.Server.AdminListen, "unset settings keep their defaults")
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{"unknown YAML setting", "config.yaml", "server:\n  port: 8080\n", "port"},
+		{"unknown TOML setting", "config.toml", "[server]\nport = 8080\n", "port"},
+		{"unknown JSON setting", "config.json", `{"server":{"port":8080}}`, "port"},
+		{"invalid duration", "config.yaml", "poe:\n  timeout: soon\n", "invalid duration"},
+		{"unsupported extension", "config.ini", "listen = :8080", "unsupported config file extension"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+			_, err := Load(path)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+	c, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, Default(), c, "without a file, the configuration is the default one")
+}
+
+func TestMarshal(t *testing.T) {
+	for _, format := range []string{FormatYAML, FormatTOML, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			want := Default()
+			want.Routing.Aliases = map[string]string{"gpt-4o": "GPT-4o"}
+			want.Auth.Keys = []KeyConfig{{Name: "client", Key: "sk-virtual-client-key", Models: []string{"GPT-4o"}}}
+			data, err := Marshal(want, format)
+			require.NoError(t, err)
+
+			got := Default()
+			require.NoError(t, decode(data, format, got))
+			assert.Equal(t, want.Routing, got.Routing, "printed configurations can be loaded again")
+			assert.Equal(t, want.Auth, got.Auth)
+			again, err := Marshal(got, format)
+			require.NoError(t, err)
+			assert.Equal(t, string(data), string(again))
+		})
+	}
+	_, err := Marshal(Default(), "ini")
+	assert.Error(t, err)
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"PORT":                                    "9000",
+		"POE_API_KEY":                             "poe-legacy-key",
+		"POEPENAI_AUTH_POE_API_KEY":               "poe-env-key",
+		"POEPENAI_POE_RETRY_MAX_RETRIES":          "5",
+		"POEPENAI_POE_TIMEOUT":                    "90s",
+		"POEPENAI_LOGGING_ACCESS_LOG_SAMPLE_RATE": "0.5",
+		"POEPENAI_COALESCING_ENABLED":             "true",
+		"POEPENAI_QUERY_CACHE_MODELS":             "GPT-4o, Claude-Sonnet-4,",
+		"POEPENAI_SERVER_ADMIN_LISTEN":            "",
+	}
+	c := Default()
+	require.NoError(t, c.ApplyEnv(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}))
+	assert.Equal(t, ":9000", c.Server.Listen)
+	assert.Equal(t, "poe-env-key", c.Auth.PoeAPIKey, "POEPENAI_ variables override the legacy ones")
+	assert.Equal(t, 5, c.Poe.Retry.MaxRetries)
+	assert.Equal(t, Duration(90*time.Second), c.Poe.Timeout)
+	assert.Equal(t, 0.5, c.Logging.AccessLogSampleRate)
+	assert.True(t, c.Coalescing.Enabled)
+	assert.Equal(t, []string{"GPT-4o", "Claude-Sonnet-4"}, c.QueryCache.Models)
+	assert.Equal(t, Default().Server.AdminListen, c.Server.AdminListen, "empty variables are ignored")
+
+	invalid := map[string]string{"POEPENAI_POE_BURST": "many", "POEPENAI_COALESCING_ENABLED": "sometimes"}
+	err := Default().ApplyEnv(func(name string) (string, bool) {
+		value, ok := invalid[name]
+		return value, ok
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "POEPENAI_POE_BURST")
+	assert.Contains(t, err.Error(), "POEPENAI_COALESCING_ENABLED")
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Default().Validate())
+
+	c := Default()
+	c.Server.RequestTimeout = 0
+	c.Logging.Level = "verbose"
+	c.Routing.Aliases = map[string]string{"fast": "quick", "quick": "GPT-4o"}
+	c.Auth.Keys = []KeyConfig{{Name: "client", Key: "short"}}
+	c.Cassette.Mode = "playback"
+	err := c.Validate()
+	require.Error(t, err)
+	for _, setting := range []string{
+		"server.request_timeout",
+		"logging.level",
+		`routing.aliases["fast"]`,
+		"auth.keys[0].key",
+		"auth.keys[0].poe_api_key",
+		"cassette.dir",
+	} {
+		assert.Contains(t, err.Error(), setting, "all the errors are reported")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := Default()
+	c.Auth.PoeAPIKey = "poe-secret-key"
+	c.Auth.Admin.Password = "admin-password"
+	c.Auth.Keys = []KeyConfig{{Name: "client", Key: "sk-virtual-client-key"}}
+
+	redacted := c.Redacted()
+	assert.Equal(t, "REDACTED", redacted.Auth.PoeAPIKey)
+	assert.Equal(t, "REDACTED", redacted.Auth.Admin.Password)
+	assert.Empty(t, redacted.Auth.Admin.Token, "unset secrets stay empty")
+	assert.Equal(t, "REDACTED", redacted.Auth.Keys[0].Key)
+	assert.Equal(t, "client", redacted.Auth.Keys[0].Name)
+	assert.Equal(t, "sk-virtual-client-key", c.Auth.Keys[0].Key, "the configuration is left unchanged")
+}
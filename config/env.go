@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding settings.
+const EnvPrefix = "POEPENAI"
+
+// ApplyEnv overrides the settings of c with the environment variables found by lookup (usually
+// os.LookupEnv): first the legacy variables, then POEPENAI_<PATH> for each setting of a scalar
+// or string list type, where PATH is the upper-cased path of the setting joined by underscores.
+// Lists are comma-separated. Empty variables are ignored.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	legacy := []struct {
+		name  string
+		apply func(string)
+	}{
+		{"PORT", func(port string) { c.Server.Listen = ":" + port }},
+		{"POE_API_KEY", func(key string) { c.Auth.PoeAPIKey = key }},
+		{"POEPENAI_ADMIN_TOKEN", func(token string) { c.Auth.Admin.Token = token }},
+		{"POEPENAI_ADMIN_PASSWORD", func(password string) { c.Auth.Admin.Password = password }},
+	}
+	for _, variable := range legacy {
+		if value, ok := lookup(variable.name); ok && value != "" {
+			variable.apply(value)
+		}
+	}
+
+	var errs []error
+	applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix, lookup, &errs)
+	return errors.Join(errs...)
+}
+
+// applyEnv sets the fields of the struct v from the environment variables named after prefix.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool), errs *[]error) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field := v.Field(i)
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		envName := prefix + "_" + strings.ToUpper(name)
+		switch {
+		case field.Kind() == reflect.Struct:
+			applyEnv(field, envName, lookup, errs)
+			continue
+		case field.Kind() == reflect.Map,
+			field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.String:
+			// Only set in the configuration file.
+			continue
+		}
+		value, ok := lookup(envName)
+		if !ok || value == "" {
+			continue
+		}
+		if err := setFromString(field, value); err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %w", envName, err))
+		}
+	}
+}
+
+// setFromString sets field from its string representation in an environment variable.
+func setFromString(field reflect.Value, value string) error {
+	if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
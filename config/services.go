@@ -0,0 +1,212 @@
+package config
+
+import (
+	"time"
+
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// Client returns the Poe client configured by c.
+func (c PoeConfig) Client() *client.PoeClient {
+	poeClient := client.NewPoeClient()
+	poeClient.HTTPClient.Timeout = time.Duration(c.Timeout)
+	poeClient.BaseURL = c.BaseURL
+	poeClient.StreamTimeout = time.Duration(c.StreamTimeout)
+	return poeClient
+}
+
+// PoeClient returns the Poe client configured by c, whose responses are recorded to or served from
+// the Poe cassette if it is enabled.
+func (c *Config) PoeClient() (*client.PoeClient, error) {
+	poeClient := c.Poe.Client()
+	mode, err := client.ParseCassetteMode(c.Cassette.Mode)
+	if err != nil {
+		return nil, err
+	}
+	if mode == client.CassetteOff {
+		return poeClient, nil
+	}
+	cassette, err := client.NewCassetteTransport(mode, c.Cassette.Dir, poeClient.HTTPClient.Transport)
+	if err != nil {
+		return nil, err
+	}
+	poeClient.HTTPClient.Transport = cassette
+	return poeClient, nil
+}
+
+// Backend returns backend decorated with the settings cache, the retries and the rate limit
+// configured by c, each retry being rate limited.
+func (c PoeConfig) Backend(backend client.Backend) client.Backend {
+	middlewares := []client.Middleware{
+		client.CacheSettings(time.Duration(c.SettingsTTL)),
+		client.Retry(c.Retry.MaxRetries, time.Duration(c.Retry.Delay)),
+	}
+	if c.RequestsPerMinute > 0 {
+		middlewares = append(middlewares, client.RateLimit(service.NewRateLimiter(c.RequestsPerMinute, c.Burst)))
+	}
+	return client.Chain(backend, middlewares...)
+}
+
+// Catalog returns the models catalog configured by c.
+func (c ModelsConfig) Catalog() *service.ModelCatalog {
+	catalog := service.NewModelCatalog(nil)
+	if c.IncludeDefaults {
+		catalog = service.DefaultModelCatalog()
+	}
+	for _, model := range c.Bots {
+		catalog.Put(service.ModelInfo{
+			ID:              model.ID,
+			OwnedBy:         model.OwnedBy,
+			Family:          model.Family,
+			Description:     model.Description,
+			ContextWindow:   model.ContextWindow,
+			Vision:          model.Vision,
+			ImageGeneration: model.ImageGeneration,
+		})
+	}
+	return catalog
+}
+
+// Router returns the model router configured by c.
+func (c RoutingConfig) Router() *service.ModelRouter {
+	return service.NewModelRouter(c.Aliases)
+}
+
+// KeyStore returns the virtual keys configured by c.
+func (c AuthConfig) KeyStore() *service.KeyStore {
+	keys := make([]service.VirtualKey, 0, len(c.Keys))
+	for _, key := range c.Keys {
+		keys = append(keys, service.VirtualKey{
+			Name:              key.Name,
+			Key:               key.Key,
+			PoeAPIKey:         key.PoeAPIKey,
+			Models:            key.Models,
+			RequestsPerMinute: key.RequestsPerMinute,
+		})
+	}
+	return service.NewKeyStore(keys, c.PoeAPIKey, c.AllowPassthrough, c.AllowAnonymousOllama)
+}
+
+// RateLimiter returns the rate limiter configured by c.
+func (c LimitsConfig) RateLimiter() *service.RateLimiter {
+	return service.NewRateLimiter(c.RequestsPerMinute, c.Burst)
+}
+
+// Redactor returns the redactor of logs and recorded exchanges configured by c.
+func (c RedactConfig) Redactor() (*service.Redactor, error) {
+	content, err := service.ParseContentRedaction(c.Content)
+	if err != nil {
+		return nil, err
+	}
+	redactConfig := service.RedactConfig{
+		SecretKeys:    c.Keys,
+		Content:       content,
+		ContentLength: c.ContentLength,
+	}
+	for _, spec := range c.Rules {
+		rule, err := service.ParseRedactionRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		redactConfig.Rules = append(redactConfig.Rules, rule)
+	}
+	return service.NewRedactor(redactConfig), nil
+}
+
+// RecordingRedactor returns the redactor of recorded exchanges configured by c: only secrets are
+// redacted unless Recordings is set.
+func (c RedactConfig) RecordingRedactor() (*service.Redactor, error) {
+	redactor, err := c.Redactor()
+	if err != nil {
+		return nil, err
+	}
+	if !c.Recordings {
+		return redactor.SecretsOnly(), nil
+	}
+	return redactor, nil
+}
+
+// RuntimeConfig returns the runtime configuration configured by c. The rate limiter of previous,
+// if any, is kept with the new limits, so that the request counts survive reloads, and so are the
+// bot settings discovered in its models catalog.
+func (c *Config) RuntimeConfig(previous *service.RuntimeConfig) *service.RuntimeConfig {
+	limiter := c.Limits.RateLimiter()
+	catalog := c.Models.Catalog()
+	if previous != nil {
+		limiter = previous.Limiter
+		limiter.SetLimits(c.Limits.RequestsPerMinute, c.Limits.Burst)
+		for _, model := range previous.Catalog.List() {
+			if model.Settings != nil {
+				catalog.SetSettings(model.ID, model.Settings)
+			}
+		}
+	}
+	return &service.RuntimeConfig{
+		Catalog: catalog,
+		Router:  c.Routing.Router(),
+		Keys:    c.Auth.KeyStore(),
+		Limiter: limiter,
+	}
+}
+
+// ConversationStore returns the conversation store configured by c.
+func (c StoresConfig) ConversationStore() (service.ConversationStore, error) {
+	if c.ConversationDir == "" {
+		return service.NewMemoryConversationStore(c.ConversationSize), nil
+	}
+	return service.NewFileConversationStore(c.ConversationDir)
+}
+
+// ContextConfig returns the context-window management settings configured by c.
+func (c ContextConfig) ContextConfig() (service.ContextConfig, error) {
+	strategy, err := service.ParseContextStrategy(c.Strategy)
+	if err != nil {
+		return service.ContextConfig{}, err
+	}
+	return service.ContextConfig{
+		Strategy:             strategy,
+		DefaultContextWindow: c.DefaultWindow,
+		ReserveTokens:        c.ReserveTokens,
+		SummaryBot:           c.SummaryBot,
+	}, nil
+}
+
+// QueryCache returns the query cache configured by c, and its settings.
+func (c QueryCacheConfig) QueryCache() (service.QueryCache, service.QueryCacheConfig, error) {
+	settings := service.QueryCacheConfig{Models: c.Models, ReplayMaxGap: time.Duration(c.ReplayMaxGap)}
+	if c.Dir == "" {
+		return service.NewMemoryQueryCache(c.Size, time.Duration(c.TTL)), settings, nil
+	}
+	cache, err := service.NewFileQueryCache(c.Dir, c.Size, time.Duration(c.TTL))
+	if err != nil {
+		return nil, settings, err
+	}
+	return cache, settings, nil
+}
+
+// ImageConfig returns the bot profiles of the images API configured by c.
+func (c ImagesConfig) ImageConfig() (service.ImageConfig, error) {
+	if c.ConfigFile == "" {
+		return service.DefaultImageConfig(), nil
+	}
+	return service.LoadImageConfig(c.ConfigFile)
+}
+
+// CoalesceConfig returns the coalescing settings configured by c.
+func (c CoalescingConfig) CoalesceConfig() service.CoalesceConfig {
+	return service.CoalesceConfig{Enabled: c.Enabled, NonDeterministic: c.NonDeterministic}
+}
+
+// Recorder returns the exchange recorder configured by c, or nil if exchanges are not recorded.
+func (c RecordingConfig) Recorder() (*service.Recorder, error) {
+	if c.Dir == "" {
+		return nil, nil
+	}
+	return service.NewRecorder(c.Dir, c.MaxBytes, c.MaxFiles)
+}
+
+// ExchangeStore returns the store of the request inspector configured by c.
+func (c InspectorConfig) ExchangeStore() (*service.ExchangeStore, error) {
+	return service.NewExchangeStore(c.Size, c.Dir)
+}
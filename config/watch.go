@@ -0,0 +1,63 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is the time a configuration file must stay unchanged before it is reloaded, as
+// editors usually write files in several steps.
+const watchDebounce = 250 * time.Millisecond
+
+// Watch calls onChange each time the configuration file at path is written, created or replaced,
+// until ctx is done. The directory of the file is watched rather than the file itself, so that
+// files replaced by a rename (as editors and Kubernetes config maps do) keep being watched.
+func Watch(ctx context.Context, logger *slog.Logger, path string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config file watcher: %w", err)
+	}
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return fmt.Errorf("failed to watch config file directory: %w", err)
+	}
+
+	go func() {
+		defer func() { _ = watcher.Close() }()
+		debounce := time.NewTimer(0)
+		<-debounce.C
+		for {
+			select {
+			case <-ctx.Done():
+				debounce.Stop()
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// Kubernetes config maps swap a symlinked directory, which changes the file
+				// without an event on its name.
+				if filepath.Clean(event.Name) != path && filepath.Base(event.Name) != "..data" {
+					continue
+				}
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) {
+					debounce.Reset(watchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warn("Config file watcher error", "path", path, "error", err)
+			case <-debounce.C:
+				onChange()
+			}
+		}
+	}()
+	return nil
+}
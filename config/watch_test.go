@@ -0,0 +1,43 @@
+package config
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("server:\n  listen: \":8080\"\n"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var changes atomic.Int32
+	require.NoError(t, Watch(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), path, func() { changes.Add(1) }))
+
+	// Several writes in a row are reloaded once.
+	for range 3 {
+		require.NoError(t, os.WriteFile(path, []byte("server:\n  listen: \":9090\"\n"), 0o600))
+	}
+	require.Eventually(t, func() bool { return changes.Load() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	// Files replaced by a rename keep being watched.
+	replacement := filepath.Join(dir, "config.yaml.tmp")
+	require.NoError(t, os.WriteFile(replacement, []byte("server:\n  listen: \":9091\"\n"), 0o600))
+	require.NoError(t, os.Rename(replacement, path))
+	require.Eventually(t, func() bool { return changes.Load() == 2 }, 2*time.Second, 10*time.Millisecond)
+
+	// Other files of the directory are ignored.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0o600))
+	time.Sleep(2 * watchDebounce)
+	assert.Equal(t, int32(2), changes.Load())
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// AccessLogConfig configures the access log.
+type AccessLogConfig struct {
+	// SampleRate is the fraction of successful requests logged, between 0 and 1. Requests
+	// failing with a 4xx or 5xx status are always logged.
+	SampleRate float64
+	// LogLevels, if set, is told the model of each request, so that its model overrides apply to
+	// all the records of the request.
+	LogLevels *service.LogLevelController
+	// MaxBodyBytes limits the request bodies read to find the requested model, as
+	// limits.max_body_bytes does for the handlers. If 0, bodies are not limited.
+	MaxBodyBytes int64
+}
+
+// AccessLog is a middleware logging one record per request served, once it is over: its request
+// ID, remote IP, method, path, status, response size and duration, and for API requests the
+// model and stream flag requested, the Poe bot queried, the number of retries of its queries and
+// the time to the first token. The requested model is shared with the next middlewares through the
+// request context. It must be installed after middleware.RequestID and middleware.RealIP.
+func AccessLog(logger *slog.Logger, config AccessLogConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			stats := service.NewRequestStats()
+			start := time.Now()
+			r = withRequestModel(w, r, config.MaxBodyBytes)
+			model, stream := requestModel(r)
+
+			if config.LogLevels != nil {
+				defer config.LogLevels.TrackRequest(middleware.GetReqID(r.Context()), model)()
+			}
+
+			ctx := service.WithRequestStats(r.Context(), stats)
+			ctx = client.WithRetryObserver(ctx, func(string, int) { stats.AddRetry() })
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status < http.StatusBadRequest && rand.Float64() >= config.SampleRate {
+				return
+			}
+
+			attrs := []slog.Attr{
+				slog.String("request_id", middleware.GetReqID(ctx)),
+				slog.String("remote_ip", remoteIP(r)),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Float64("duration_ms", durationMillis(time.Since(start))),
+			}
+			if model != "" {
+				attrs = append(attrs, slog.String("model", model))
+			}
+			if stream != nil {
+				attrs = append(attrs, slog.Bool("stream", *stream))
+			}
+			if botName, retries, firstToken := stats.Snapshot(); botName != "" {
+				attrs = append(attrs, slog.String("poe_bot", botName), slog.Int("retries", retries))
+				if firstToken > 0 {
+					attrs = append(attrs, slog.Float64("ttft_ms", durationMillis(firstToken)))
+				}
+			}
+
+			level := slog.LevelInfo
+			switch {
+			case status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case status >= http.StatusBadRequest:
+				level = slog.LevelWarn
+			}
+			logger.LogAttrs(ctx, level, "HTTP request", attrs...)
+		})
+	}
+}
+
+// observePoeQuery records the Poe bot of a query in the access log stats of ctx, if any, and
+// returns its event stream, timing its first token as it is consumed.
+func observePoeQuery(
+	ctx context.Context,
+	botName string,
+	eventChan <-chan types.PoeSSEEvent,
+	errChan <-chan error,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	stats, ok := service.RequestStatsFromContext(ctx)
+	if !ok {
+		return eventChan, errChan
+	}
+	stats.SetBot(botName)
+	return service.TimeFirstToken(ctx, stats, eventChan, errChan)
+}
+
+// requestedModelKey is the context key of the requestedModel of a request.
+type requestedModelKey struct{}
+
+// requestedModel is the model and stream flag of a JSON request body.
+type requestedModel struct {
+	Model  string `json:"model"`
+	Stream *bool  `json:"stream"`
+}
+
+// withRequestModel returns a copy of r whose context carries the model and stream flag of its
+// JSON body, read at most once per request, and whose body is restored. Bodies larger than
+// maxBodyBytes, if positive, are not parsed, and the handler gets the read error. Multipart
+// bodies (image uploads) are not read.
+func withRequestModel(w http.ResponseWriter, r *http.Request, maxBodyBytes int64) *http.Request {
+	if _, ok := r.Context().Value(requestedModelKey{}).(*requestedModel); ok {
+		return r
+	}
+	var requested requestedModel
+	r = r.WithContext(context.WithValue(r.Context(), requestedModelKey{}, &requested))
+	if r.Body == nil || r.Method != http.MethodPost || strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		return r
+	}
+	if maxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	}
+	body, err := io.ReadAll(r.Body)
+	// On a read error, the handler gets the same error after the part read here.
+	r.Body = replayedBody{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+	if err == nil {
+		_ = json.Unmarshal(body, &requested)
+	}
+	return r
+}
+
+// requestModel returns the model and stream flag of the JSON body of r, as found by
+// withRequestModel.
+func requestModel(r *http.Request) (string, *bool) {
+	requested, ok := r.Context().Value(requestedModelKey{}).(*requestedModel)
+	if !ok {
+		return "", nil
+	}
+	return requested.Model, requested.Stream
+}
+
+// remoteIP returns the IP address of the client of r, without the port.
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// durationMillis converts d to milliseconds, with a microsecond precision.
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// flakyBackend is a fakeBackend whose first query fails before any event.
+type flakyBackend struct {
+	*fakeBackend
+	once sync.Once
+}
+
+func (b *flakyBackend) StreamQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	failed := false
+	b.once.Do(func() { failed = true })
+	if !failed {
+		return b.fakeBackend.StreamQuery(ctx, botName, request, apiKey)
+	}
+	eventChan := make(chan types.PoeSSEEvent)
+	errChan := make(chan error, 1)
+	errChan <- errors.New("connection reset")
+	close(eventChan)
+	close(errChan)
+	return eventChan, errChan
+}
+
+// accessLogRecords serves r with the API routes behind AccessLog with config, and returns the
+// access log records written.
+func accessLogRecords(t *testing.T, config AccessLogConfig, r *http.Request) []map[string]any {
+	t.Helper()
+	backend := &fakeBackend{}
+	ah := newTestHandlers(t, backend, map[string]string{"gpt-4o": "GPT-4o"})
+	ah.Backend = client.Chain(&flakyBackend{fakeBackend: backend}, client.Retry(1, 0))
+
+	var buf bytes.Buffer
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID, middleware.RealIP)
+	router.Use(AccessLog(slog.New(slog.NewJSONHandler(&buf, nil)), config))
+	router.Post("/v1/chat/completions", ah.HandleChatCompletions)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAccessLog(t *testing.T) {
+	r := newTestRequest(http.MethodPost, "/v1/chat/completions",
+		`{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"Hi"}]}`)
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	records := accessLogRecords(t, AccessLogConfig{SampleRate: 1}, r)
+
+	require.Len(t, records, 1)
+	record := records[0]
+	assert.Equal(t, "HTTP request", record["msg"])
+	assert.Equal(t, "INFO", record["level"])
+	assert.NotEmpty(t, record["request_id"])
+	assert.Equal(t, "203.0.113.7", record["remote_ip"])
+	assert.Equal(t, "POST", record["method"])
+	assert.Equal(t, "/v1/chat/completions", record["path"])
+	assert.Equal(t, float64(http.StatusOK), record["status"])
+	assert.Positive(t, record["bytes"])
+	assert.Contains(t, record, "duration_ms")
+	assert.Equal(t, "gpt-4o", record["model"], "the requested model is logged")
+	assert.Equal(t, false, record["stream"])
+	assert.Equal(t, "GPT-4o", record["poe_bot"], "the routed bot is logged")
+	assert.Equal(t, float64(1), record["retries"])
+	assert.Contains(t, record, "ttft_ms")
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantLevel string // Empty if the request is not logged
+	}{
+		{"sampled out success", `{"model":"GPT-4o","messages":[{"role":"user","content":"Hi"}]}`, ""},
+		{"client error", `{"model":"GPT-4o"`, "WARN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := accessLogRecords(t, AccessLogConfig{SampleRate: 0},
+				newTestRequest(http.MethodPost, "/v1/chat/completions", tt.body))
+			if tt.wantLevel == "" {
+				assert.Empty(t, records)
+				return
+			}
+			require.Len(t, records, 1)
+			assert.Equal(t, tt.wantLevel, records[0]["level"])
+			assert.NotContains(t, records[0], "poe_bot", "no bot was queried")
+		})
+	}
+}
+
+func TestAccessLogMaxBodyBytes(t *testing.T) {
+	body := `{"model":"GPT-4o","messages":[{"role":"user","content":"` + strings.Repeat("a", 100) + `"}]}`
+	records := accessLogRecords(t, AccessLogConfig{SampleRate: 1, MaxBodyBytes: 64},
+		newTestRequest(http.MethodPost, "/v1/chat/completions", body))
+
+	require.Len(t, records, 1)
+	assert.NotContains(t, records[0], "model", "bodies over the limit are not parsed")
+	assert.GreaterOrEqual(t, records[0]["status"], float64(http.StatusBadRequest))
+}
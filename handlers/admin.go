@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// CSRFTokenHeader is the header carrying the CSRF token of the admin pages in their
+// state-changing requests. The pages set it on every HTMX request.
+const CSRFTokenHeader = "X-CSRF-Token"
+
+// adminRealm is the basic auth realm of the admin pages.
+const adminRealm = "poepenai admin"
+
+// adminCSRFTokenKey is the context key of the CSRF token of the admin pages.
+type adminCSRFTokenKey struct{}
+
+// AdminAuthConfig configures the authentication of the admin pages (logs, request inspector,
+// debug variables).
+type AdminAuthConfig struct {
+	// Token is a static admin token, sent as a Bearer token, or as the password of basic auth
+	// (with any username) so that browsers can prompt for it.
+	Token string
+	// Username and Password are basic auth credentials, checked if Password is set.
+	Username string
+	Password string
+}
+
+// Enabled reports whether c requires credentials.
+func (c AdminAuthConfig) Enabled() bool {
+	return c.Token != "" || c.Password != ""
+}
+
+// AdminAuth is a middleware authenticating the requests of the admin pages with config, which
+// must be served on a loopback address if it is not enabled. It also protects the admin pages
+// against CSRF, as browsers send basic auth credentials on their own: state-changing requests
+// must carry the CSRF token of the pages in the CSRFTokenHeader header, unless they are
+// authenticated with a Bearer token, and the pages cannot be framed by other sites. The token is
+// also sent in the CSRFTokenHeader header of the responses, for scripts.
+func AdminAuth(logger *slog.Logger, config AdminAuthConfig) (func(http.Handler) http.Handler, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate admin CSRF token: %w", err)
+	}
+	csrfToken := hex.EncodeToString(tokenBytes)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := middleware.GetReqID(r.Context())
+			localLogger := logger.With("request_id", requestID)
+
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("X-Frame-Options", "DENY")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Header().Set("Referrer-Policy", "same-origin")
+
+			bearer := config.Token != "" && config.bearerAuthenticated(r)
+			if config.Enabled() && !bearer && !config.authenticated(r) {
+				localLogger.Warn("Unauthenticated admin request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, adminRealm))
+				http.Error(w, "Admin authentication required", http.StatusUnauthorized)
+				return
+			}
+
+			w.Header().Set(CSRFTokenHeader, csrfToken)
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				if bearer {
+					break
+				}
+				if r.Header.Get("Sec-Fetch-Site") == "cross-site" ||
+					subtle.ConstantTimeCompare([]byte(r.Header.Get(CSRFTokenHeader)), []byte(csrfToken)) != 1 {
+					localLogger.Warn("Rejected admin request without a valid CSRF token", "method", r.Method, "path", r.URL.Path)
+					http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminCSRFTokenKey{}, csrfToken)))
+		})
+	}, nil
+}
+
+// bearerAuthenticated reports whether r carries the admin token of c as a Bearer token.
+func (c AdminAuthConfig) bearerAuthenticated(r *http.Request) bool {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && secretEqual(bearer, c.Token)
+}
+
+// authenticated reports whether r carries the basic auth credentials of c, or the admin token
+// as basic auth password.
+func (c AdminAuthConfig) authenticated(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	if c.Token != "" && secretEqual(password, c.Token) {
+		return true
+	}
+	return c.Password != "" && secretEqual(username, c.Username) && secretEqual(password, c.Password)
+}
+
+// secretEqual compares a credential to its expected value in constant time.
+func secretEqual(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// adminCSRFToken returns the CSRF token of the admin pages set by AdminAuth in ctx, or an empty
+// string if they are served without it.
+func adminCSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(adminCSRFTokenKey{}).(string)
+	return token
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAdminServer returns a page behind AdminAuth with config, answering the CSRF token it is
+// given, and the CSRF token that AdminAuth sends.
+func newAdminServer(t *testing.T, config AdminAuthConfig) (http.Handler, string) {
+	t.Helper()
+	adminAuth, err := AdminAuth(slog.New(slog.NewTextHandler(io.Discard, nil)), config)
+	require.NoError(t, err)
+	handler := adminAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, adminCSRFToken(r.Context()))
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/logs", nil)
+	r.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	csrfToken := w.Header().Get(CSRFTokenHeader)
+	require.NotEmpty(t, csrfToken)
+	return handler, csrfToken
+}
+
+func TestAdminAuthCredentials(t *testing.T) {
+	config := AdminAuthConfig{Token: "admin-token", Username: "admin", Password: "admin-password"}
+	tests := []struct {
+		name string
+		auth func(r *http.Request)
+		want int
+	}{
+		{"no credentials", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"bearer token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer admin-token") }, http.StatusOK},
+		{"wrong bearer token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer other-token") }, http.StatusUnauthorized},
+		{"basic auth", func(r *http.Request) { r.SetBasicAuth("admin", "admin-password") }, http.StatusOK},
+		{"token as basic auth password", func(r *http.Request) { r.SetBasicAuth("anyone", "admin-token") }, http.StatusOK},
+		{"wrong username", func(r *http.Request) { r.SetBasicAuth("root", "admin-password") }, http.StatusUnauthorized},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("admin", "admin-token2") }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, _ := newAdminServer(t, config)
+			r := httptest.NewRequest(http.MethodGet, "/admin/logs", nil)
+			tt.auth(r)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.want, w.Code)
+			assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+			if tt.want == http.StatusUnauthorized {
+				assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+				assert.Empty(t, w.Header().Get(CSRFTokenHeader), "the CSRF token is not sent to unauthenticated requests")
+			}
+		})
+	}
+}
+
+func TestAdminAuthCSRF(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		bearer  bool
+		csrf    string // "valid" for the token of the pages
+		fetched string // Sec-Fetch-Site header
+		want    int
+	}{
+		{"GET without token", http.MethodGet, false, "", "", http.StatusOK},
+		{"POST with token", http.MethodPost, false, "valid", "same-origin", http.StatusOK},
+		{"POST without token", http.MethodPost, false, "", "", http.StatusForbidden},
+		{"POST with wrong token", http.MethodPost, false, "forged", "", http.StatusForbidden},
+		{"cross-site POST with token", http.MethodPost, false, "valid", "cross-site", http.StatusForbidden},
+		{"DELETE without token", http.MethodDelete, false, "", "", http.StatusForbidden},
+		{"bearer POST without token", http.MethodPost, true, "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, csrfToken := newAdminServer(t, AdminAuthConfig{Token: "admin-token"})
+			r := httptest.NewRequest(tt.method, "/admin/loglevel", nil)
+			if tt.bearer {
+				r.Header.Set("Authorization", "Bearer admin-token")
+			} else {
+				// Browsers send basic auth credentials on their own.
+				r.SetBasicAuth("admin", "admin-token")
+			}
+			switch tt.csrf {
+			case "valid":
+				r.Header.Set(CSRFTokenHeader, csrfToken)
+			case "":
+			default:
+				r.Header.Set(CSRFTokenHeader, tt.csrf)
+			}
+			if tt.fetched != "" {
+				r.Header.Set("Sec-Fetch-Site", tt.fetched)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tt.want, w.Code, w.Body.String())
+			if tt.want == http.StatusOK {
+				assert.Equal(t, csrfToken, w.Body.String(), "the token is passed to the pages")
+			}
+		})
+	}
+}
+
+func TestAdminAuthDisabled(t *testing.T) {
+	handler, csrfToken := newAdminServer(t, AdminAuthConfig{})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/logs", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "loopback admin pages need no credentials")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/loglevel", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code, "CSRF tokens are still required")
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/loglevel", nil)
+	r.Header.Set(CSRFTokenHeader, csrfToken)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// uploadDataURIAttachments uploads the attachments of poeQueryReq given as data URIs (e.g., base64
+// images sent by the client) through the Poe file upload API, replacing them with the uploaded URLs.
+// Attachments with a regular URL are left untouched, as Poe fetches them itself.
+func (ah *AppHandlers) uploadDataURIAttachments(
+	ctx context.Context,
+	poeQueryReq *types.PoeQueryRequest,
+	poePlatformAPIKey string,
+) error {
+	for i := range poeQueryReq.Query {
+		for j := range poeQueryReq.Query[i].Attachments {
+			attachment := &poeQueryReq.Query[i].Attachments[j]
+			if !service.IsDataURI(attachment.URL) {
+				continue
+			}
+			mediaType, data, ok := service.ParseDataURI(attachment.URL)
+			if !ok {
+				return fmt.Errorf("invalid data URI in message %d", i)
+			}
+			uploaded, err := ah.Backend.UploadFile(
+				ctx,
+				poePlatformAPIKey,
+				attachment.Name,
+				mediaType,
+				data,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to upload attachment of message %d: %w", i, err)
+			}
+			*attachment = *uploaded
+		}
+	}
+	return nil
+}
@@ -28,7 +28,7 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 		"Received chat completion request",
 		"method", r.Method,
 		"url", r.URL.String(),
-		"headers", r.Header,
+		"headers", redactedHeaders(r.Header),
 	)
 
 	var openAIReq types.OpenAIChatCompletionRequest
@@ -55,22 +55,10 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 
 	localLogger.Debug("Decoded OpenAI request", "request_object", openAIReq)
 
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		localLogger.Warn("Missing Authorization header")
-		http.Error(w, "Missing Authorization header", http.StatusUnauthorized)
-		return
-	}
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-		localLogger.Warn("Invalid Authorization header format")
-		http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
-		return
-	}
-	poePlatformAPIKey := parts[1]
-	if poePlatformAPIKey == "" {
-		localLogger.Warn("Empty Bearer token")
-		http.Error(w, "Empty Bearer token", http.StatusUnauthorized)
+	poePlatformAPIKey, err := poeAPIKeyFromRequest(r)
+	if err != nil {
+		localLogger.Warn("Rejected request authentication", "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	localLogger.Debug("Extracted parameters for Poe query",
@@ -78,8 +66,8 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 		"poe_api_key_present", poePlatformAPIKey != "",
 	)
 
+	// Replaced by the ID of the last message, as stored, once the conversation is prepared.
 	messageID := service.GenerateID("msg")
-	conversationID := "poepenai-default-conversation" // TODO: Make this configurable or dynamic
 
 	localLogger.Info( // This can remain Info
 		"Processing chat completion request",
@@ -90,7 +78,7 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 	poeQueryReq, err := service.TransformOpenAIRequestToPoeQuery(
 		&openAIReq,
 		poePlatformAPIKey,
-		conversationID,
+		"", // Set below by the conversation manager.
 		messageID,
 	)
 	if err != nil {
@@ -103,6 +91,21 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Attachments are checked first, so that rejected requests neither change the conversation
+	// nor have it summarized.
+	if err := ah.checkAttachments(ctx, localLogger, openAIReq.Model, poeQueryReq, poePlatformAPIKey); err != nil {
+		localLogger.Warn("Rejected attachments", "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conversation := ah.prepareConversation(localLogger, w, r, poeQueryReq, callerFingerprint(r, poePlatformAPIKey))
+	ah.fitContext(ctx, w, localLogger, openAIReq.Model, poeQueryReq, poePlatformAPIKey)
+	if err := ah.uploadDataURIAttachments(ctx, poeQueryReq, poePlatformAPIKey); err != nil {
+		localLogger.Error("Error uploading attachments to Poe", "error", err)
+		http.Error(w, fmt.Sprintf("Error uploading attachments: %v", err), http.StatusBadGateway)
+		return
+	}
+
 	if poeQueryReqBytes, marshalErr := json.Marshal(poeQueryReq); marshalErr == nil {
 		localLogger.Debug(
 			"Transformed PoeQueryRequest for Poe API",
@@ -128,12 +131,22 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
-		eventChan, errChan := ah.PoeClient.StreamQuery(ctx, botName, poeQueryReq, poePlatformAPIKey)
+		eventChan, errChan := ah.streamPoeQuery(
+			ctx,
+			w,
+			r,
+			localLogger,
+			botName,
+			poeQueryReq,
+			poePlatformAPIKey,
+			openAIReq.Seed,
+		)
 
 		completionID := service.GenerateID("chatcmpl")
 		createdTimestamp := time.Now().Unix()
 		isFirstContentChunkEvent := true
 		hasMadeToolCallEvent := false
+		var streamedEvents []types.PoeSSEEvent // Kept to record the reply in the conversation.
 
 		for {
 			select {
@@ -160,6 +173,7 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 			case poeEvent, ok := <-eventChan:
 				if !ok {
 					localLogger.Info("Poe event channel closed by sender. Stream assumed complete.")
+					ah.recordConversationReply(localLogger, conversation, streamedEvents)
 					doneChunk := []byte("data: [DONE]\n\n")
 					if _, writeErr := w.Write(doneChunk); writeErr != nil {
 						localLogger.Error(
@@ -178,6 +192,7 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 					"data",
 					poeEvent.Data,
 				)
+				streamedEvents = append(streamedEvents, poeEvent)
 
 				openAIChunkBytes, err := service.TransformPoeEventToOpenAIChatCompletionChunk(
 					poeEvent,
@@ -186,6 +201,7 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 					createdTimestamp,
 					&isFirstContentChunkEvent,
 					&hasMadeToolCallEvent,
+					openAIReq.IncludeAttachments,
 				)
 				if err != nil {
 					localLogger.Error(
@@ -219,6 +235,7 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 
 				if poeEvent.Event == "done" {
 					localLogger.Info("Poe 'done' event processed. Sending final [DONE] marker.")
+					ah.recordConversationReply(localLogger, conversation, streamedEvents)
 					doneChunk := []byte("data: [DONE]\n\n")
 					if _, writeErr := w.Write(doneChunk); writeErr != nil {
 						localLogger.Error(
@@ -234,7 +251,16 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 		}
 	} else { // Non-streaming response
 		var allPoeEvents []types.PoeSSEEvent
-		eventChan, errChan := ah.PoeClient.StreamQuery(ctx, botName, poeQueryReq, poePlatformAPIKey)
+		eventChan, errChan := ah.streamPoeQuery(
+			ctx,
+			w,
+			r,
+			localLogger,
+			botName,
+			poeQueryReq,
+			poePlatformAPIKey,
+			openAIReq.Seed,
+		)
 
 	collectEventsLoop:
 		for {
@@ -266,6 +292,7 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 		}
 
 		localLogger.Info("Aggregating non-streaming response", "collected_event_count", len(allPoeEvents))
+		ah.recordConversationReply(localLogger, conversation, allPoeEvents)
 		completionID := service.GenerateID("chatcmpl")
 		createdTimestamp := time.Now().Unix()
 
@@ -274,6 +301,7 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 			openAIReq.Model,
 			completionID,
 			createdTimestamp,
+			openAIReq.IncludeAttachments,
 		)
 		if err != nil {
 			localLogger.Error("Error aggregating Poe response", "error", err)
@@ -294,3 +322,56 @@ func (ah *AppHandlers) HandleChatCompletions(w http.ResponseWriter, r *http.Requ
 		localLogger.Info("Successfully sent non-streaming response")
 	}
 }
+
+// prepareConversation assigns the conversation ID and persistent message IDs of poeQueryReq, made
+// with the key whose fingerprint is owner, through the conversation manager, and reports the
+// conversation ID in the X-Conversation-ID response header. The conversation is named by the
+// X-Conversation-ID request header if set, in which case that name is echoed back, as the
+// conversation ID sent to Poe is a hash including owner.
+// If the conversation store fails, the query still gets a derived conversation ID and nil is returned.
+func (ah *AppHandlers) prepareConversation(
+	localLogger *slog.Logger,
+	w http.ResponseWriter,
+	r *http.Request,
+	poeQueryReq *types.PoeQueryRequest,
+	owner string,
+) *service.Conversation {
+	clientConversationID := r.Header.Get(service.ConversationIDHeader)
+	conversation, err := ah.Conversations.Prepare(owner, clientConversationID, poeQueryReq)
+	if err != nil {
+		localLogger.Error("Failed to prepare conversation, continuing without history", "error", err)
+		poeQueryReq.ConversationID = service.DeriveConversationID(owner, clientConversationID, poeQueryReq.Query)
+		conversation = nil
+	} else {
+		localLogger.Debug(
+			"Prepared conversation for Poe query",
+			"conversation_id", conversation.ID,
+			"stored_message_count", len(conversation.Messages),
+		)
+	}
+
+	if clientConversationID != "" {
+		w.Header().Set(service.ConversationIDHeader, clientConversationID)
+	} else {
+		w.Header().Set(service.ConversationIDHeader, poeQueryReq.ConversationID)
+	}
+	return conversation
+}
+
+// recordConversationReply records the bot reply aggregated from poeEvents in the conversation.
+func (ah *AppHandlers) recordConversationReply(
+	localLogger *slog.Logger,
+	conversation *service.Conversation,
+	poeEvents []types.PoeSSEEvent,
+) {
+	if conversation == nil {
+		return
+	}
+	botResp := service.AggregateBotEvents(poeEvents)
+	if botResp.ErrorText != "" {
+		return // Failed replies are not part of the conversation.
+	}
+	if err := ah.Conversations.RecordReply(conversation, botResp.Text); err != nil {
+		localLogger.Error("Failed to record bot reply in conversation", "error", err)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/service"
+)
+
+func TestHandleChatCompletionsConversation(t *testing.T) {
+	backend := &fakeBackend{}
+	ah := newTestHandlers(t, backend, nil)
+	chat := func(body string, clientID string) string {
+		t.Helper()
+		r := newTestRequest(http.MethodPost, "/v1/chat/completions", body)
+		if clientID != "" {
+			r.Header.Set(service.ConversationIDHeader, clientID)
+		}
+		w := httptest.NewRecorder()
+		ah.HandleChatCompletions(w, r)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w.Header().Get(service.ConversationIDHeader)
+	}
+
+	first := chat(`{"model":"GPT-4o","messages":[{"role":"user","content":"Hello"}]}`, "")
+	second := chat(`{"model":"GPT-4o","messages":[`+
+		`{"role":"user","content":"Hello"},{"role":"assistant","content":"Hello"},`+
+		`{"role":"user","content":"How are you?"}]}`, "")
+	named := chat(`{"model":"GPT-4o","messages":[{"role":"user","content":"Hello"}]}`, "conversation-1")
+
+	queries := backend.Queries()
+	require.Len(t, queries, 3)
+	assert.Equal(t, first, queries[0].Request.ConversationID)
+	assert.Equal(t, first, second, "a conversation keeps its ID as it grows")
+	assert.Equal(t, second, queries[1].Request.ConversationID)
+	// The ID of a named conversation is echoed, and namespaced by the key of the client for Poe.
+	assert.Equal(t, "conversation-1", named)
+	assert.NotEqual(t, named, queries[2].Request.ConversationID)
+	assert.NotEqual(t, first, queries[2].Request.ConversationID)
+
+	// The history replayed to the bot keeps the IDs of its messages, and the query is identified by
+	// its current message.
+	firstQuery, secondQuery := queries[0].Request, queries[1].Request
+	assert.Equal(t, firstQuery.Query[0].MessageID, secondQuery.Query[0].MessageID)
+	assert.Equal(t, firstQuery.MessageID, firstQuery.Query[0].MessageID)
+	assert.Equal(t, secondQuery.Query[2].MessageID, secondQuery.MessageID)
+	assert.NotEqual(t, firstQuery.MessageID, secondQuery.MessageID)
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// maxCompletionsPerRequest caps the number of Poe queries (prompts × n) sent for a single
+// legacy completion request, as each completion is a separate query.
+const maxCompletionsPerRequest = 16
+
+// HandleCompletions is the HTTP handler for the legacy OpenAI-compatible /v1/completions endpoint.
+// Each prompt is sent to the Poe bot as a single user message, once per requested completion ('n'),
+// and the results are returned as "text_completion" objects. 'echo' is applied by the adapter.
+func (ah *AppHandlers) HandleCompletions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := middleware.GetReqID(ctx)
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	var completionReq types.OpenAICompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&completionReq); err != nil {
+		localLogger.Error("Invalid request body JSON", "error", err)
+		http.Error(w, fmt.Sprintf("Invalid request body JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	localLogger.Debug("Decoded OpenAI completion request", "request_object", completionReq)
+
+	poePlatformAPIKey, err := poeAPIKeyFromRequest(r)
+	if err != nil {
+		localLogger.Warn("Rejected request authentication", "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	prompts, err := service.CompletionPrompts(completionReq.Prompt)
+	if err != nil {
+		localLogger.Warn("Invalid completion prompt", "error", err)
+		http.Error(w, fmt.Sprintf("Invalid prompt: %v", err), http.StatusBadRequest)
+		return
+	}
+	n := completionReq.N
+	if n < 1 {
+		n = 1
+	}
+	if len(prompts)*n > maxCompletionsPerRequest {
+		http.Error(
+			w,
+			fmt.Sprintf("Too many completions requested: at most %d prompts × n are supported", maxCompletionsPerRequest),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	if completionReq.BestOf > n {
+		localLogger.Warn(
+			"OpenAI completion 'best_of' is not supported by Poe, returning n completions",
+			"best_of", completionReq.BestOf,
+			"n", n,
+		)
+	}
+
+	localLogger.Info(
+		"Processing completion request",
+		"model", completionReq.Model,
+		"stream", completionReq.Stream,
+		"prompts", len(prompts),
+		"n", n,
+		"echo", completionReq.Echo,
+	)
+
+	completionID := service.GenerateID("cmpl")
+	createdTimestamp := time.Now().Unix()
+
+	if completionReq.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			localLogger.Error("Streaming unsupported by the server")
+			http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+			return
+		}
+
+		// Choices are streamed one after the other; each chunk carries the index of its choice.
+		for promptIndex, prompt := range prompts {
+			for i := 0; i < n; i++ {
+				index := promptIndex*n + i
+				writeChunk := func(text string, finishReason string) error {
+					chunk, err := service.NewOpenAICompletionChunk(
+						completionID,
+						createdTimestamp,
+						completionReq.Model,
+						index,
+						text,
+						finishReason,
+					)
+					if err != nil {
+						return err
+					}
+					if _, err := w.Write(chunk); err != nil {
+						return fmt.Errorf("error writing chunk to stream: %w", err)
+					}
+					flusher.Flush()
+					return nil
+				}
+				if err := ah.streamCompletion(ctx, w, r, localLogger, &completionReq, prompt, poePlatformAPIKey, writeChunk); err != nil {
+					localLogger.Error("Error streaming completion", "choice_index", index, "error", err)
+					return
+				}
+			}
+		}
+
+		if _, err := w.Write([]byte("data: [DONE]\n\n")); err != nil {
+			localLogger.Error("Error writing final [DONE] to stream", "error", err)
+		}
+		flusher.Flush()
+		localLogger.Info("Completion stream completed")
+		return
+	}
+
+	completionResp := types.OpenAICompletionResponse{
+		ID:      completionID,
+		Object:  "text_completion",
+		Created: createdTimestamp,
+		Model:   completionReq.Model,
+		Choices: make([]types.OpenAICompletionChoice, 0, len(prompts)*n),
+		Usage:   &types.OpenAIUsage{}, // Poe does not provide token usage.
+	}
+	for promptIndex, prompt := range prompts {
+		for i := 0; i < n; i++ {
+			botResp, err := ah.queryCompletion(ctx, w, r, localLogger, &completionReq, prompt, poePlatformAPIKey)
+			if err != nil {
+				localLogger.Error("Error querying Poe for completion", "error", err)
+				http.Error(w, fmt.Sprintf("Error from Poe: %v", err), http.StatusBadGateway)
+				return
+			}
+			if botResp.ErrorText != "" {
+				localLogger.Error("Poe bot reported an error", "error_text", botResp.ErrorText)
+				http.Error(w, fmt.Sprintf("Error from Poe bot: %s", botResp.ErrorText), http.StatusBadGateway)
+				return
+			}
+			text := botResp.Text
+			if completionReq.Echo {
+				text = prompt + text
+			}
+			finishReason := "stop"
+			completionResp.Choices = append(completionResp.Choices, types.OpenAICompletionChoice{
+				Text:         text,
+				Index:        promptIndex*n + i,
+				FinishReason: &finishReason,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(completionResp); err != nil {
+		localLogger.Error("Error encoding non-streaming completion response", "error", err)
+	}
+	localLogger.Info("Successfully sent non-streaming completion response", "choices", len(completionResp.Choices))
+}
+
+// startCompletionQuery builds the Poe query for one completion of prompt and starts it.
+func (ah *AppHandlers) startCompletionQuery(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	localLogger *slog.Logger,
+	completionReq *types.OpenAICompletionRequest,
+	prompt string,
+	poePlatformAPIKey string,
+) (<-chan types.PoeSSEEvent, <-chan error, error) {
+	poeQueryReq, err := service.TransformOpenAICompletionRequestToPoeQuery(
+		completionReq,
+		prompt,
+		poePlatformAPIKey,
+		service.GenerateID("conv"),
+		service.GenerateID("msg"),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error transforming request: %w", err)
+	}
+	eventChan, errChan := ah.streamPoeQuery(
+		ctx,
+		w,
+		r,
+		localLogger,
+		completionReq.Model,
+		poeQueryReq,
+		poePlatformAPIKey,
+		completionReq.Seed,
+	)
+	return eventChan, errChan, nil
+}
+
+// queryCompletion sends one completion of prompt to Poe and returns the aggregated bot response.
+func (ah *AppHandlers) queryCompletion(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	localLogger *slog.Logger,
+	completionReq *types.OpenAICompletionRequest,
+	prompt string,
+	poePlatformAPIKey string,
+) (service.BotResponse, error) {
+	eventChan, errChan, err := ah.startCompletionQuery(ctx, w, r, localLogger, completionReq, prompt, poePlatformAPIKey)
+	if err != nil {
+		return service.BotResponse{}, err
+	}
+	events, err := collectPoeEvents(ctx, eventChan, errChan)
+	if err != nil {
+		return service.BotResponse{}, err
+	}
+	return service.AggregateBotEvents(events), nil
+}
+
+// streamCompletion sends one completion of prompt to Poe and passes its text to writeChunk as it
+// arrives, starting with the prompt itself if 'echo' is set and ending with a "stop" chunk.
+func (ah *AppHandlers) streamCompletion(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	localLogger *slog.Logger,
+	completionReq *types.OpenAICompletionRequest,
+	prompt string,
+	poePlatformAPIKey string,
+	writeChunk func(text string, finishReason string) error,
+) error {
+	eventChan, errChan, err := ah.startCompletionQuery(ctx, w, r, localLogger, completionReq, prompt, poePlatformAPIKey)
+	if err != nil {
+		return err
+	}
+	if completionReq.Echo {
+		if err := writeChunk(prompt, ""); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("client disconnected or context timed out during streaming: %w", ctx.Err())
+		case err, ok := <-errChan:
+			if ok && err != nil {
+				return fmt.Errorf("error from Poe stream: %w", err)
+			}
+			// A closed or nil error channel means the query finished; events are handled below.
+			errChan = nil
+		case poeEvent, ok := <-eventChan:
+			if !ok {
+				localLogger.Debug("Poe event channel closed by sender. Completion assumed complete.")
+				return writeChunk("", "stop")
+			}
+			botEvent, err := service.DecodePoeEvent(poeEvent)
+			if err != nil {
+				continue // Already logged by DecodePoeEvent
+			}
+			switch botEvent.Kind {
+			case service.BotEventText, service.BotEventReplace, service.BotEventToolCalls:
+				if botEvent.Text != "" {
+					if err := writeChunk(botEvent.Text, ""); err != nil {
+						return err
+					}
+				}
+			case service.BotEventFile:
+				if err := writeChunk(service.RenderPoeFileDelta(*botEvent.File), ""); err != nil {
+					return err
+				}
+			case service.BotEventError:
+				return fmt.Errorf("poe bot reported an error: %s", botEvent.ErrorText)
+			case service.BotEventDone:
+				return writeChunk("", "stop")
+			}
+		}
+	}
+}
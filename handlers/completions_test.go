@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/types"
+)
+
+func TestHandleCompletions(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantTexts   []string
+		wantPrompts []string
+	}{
+		{
+			name:        "single prompt",
+			body:        `{"model":"GPT-4o","prompt":"Say hello","stop":"\n"}`,
+			wantStatus:  http.StatusOK,
+			wantTexts:   []string{"Hello"},
+			wantPrompts: []string{"Say hello"},
+		},
+		{
+			name:        "echo",
+			body:        `{"model":"GPT-4o","prompt":"Say hello: ","echo":true}`,
+			wantStatus:  http.StatusOK,
+			wantTexts:   []string{"Say hello: Hello"},
+			wantPrompts: []string{"Say hello: "},
+		},
+		{
+			name:        "prompts and n",
+			body:        `{"model":"GPT-4o","prompt":["One","Two"],"n":2}`,
+			wantStatus:  http.StatusOK,
+			wantTexts:   []string{"Hello", "Hello", "Hello", "Hello"},
+			wantPrompts: []string{"One", "One", "Two", "Two"},
+		},
+		{name: "token arrays", body: `{"model":"GPT-4o","prompt":[[1,2,3]]}`, wantStatus: http.StatusBadRequest},
+		{name: "no prompt", body: `{"model":"GPT-4o"}`, wantStatus: http.StatusBadRequest},
+		{name: "too many completions", body: `{"model":"GPT-4o","prompt":["One","Two"],"n":9}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{}
+			ah := newTestHandlers(t, backend, nil)
+
+			w := httptest.NewRecorder()
+			ah.HandleCompletions(w, newTestRequest(http.MethodPost, "/v1/completions", tt.body))
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			queries := backend.Queries()
+			require.Len(t, queries, len(tt.wantPrompts))
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp types.OpenAICompletionResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, "text_completion", resp.Object)
+			require.Len(t, resp.Choices, len(tt.wantTexts))
+			for i, choice := range resp.Choices {
+				assert.Equal(t, i, choice.Index)
+				assert.Equal(t, tt.wantTexts[i], choice.Text)
+				require.NotNil(t, choice.FinishReason)
+				assert.Equal(t, "stop", *choice.FinishReason)
+			}
+			for i, query := range queries {
+				require.Len(t, query.Request.Query, 1)
+				assert.Equal(t, "user", query.Request.Query[0].Role)
+				assert.Equal(t, tt.wantPrompts[i], query.Request.Query[0].Content)
+			}
+		})
+	}
+}
+
+func TestHandleCompletionsStopSequences(t *testing.T) {
+	backend := &fakeBackend{}
+	ah := newTestHandlers(t, backend, nil)
+
+	w := httptest.NewRecorder()
+	ah.HandleCompletions(w, newTestRequest(http.MethodPost, "/v1/completions", `{"model":"GPT-4o","prompt":"Hi","stop":["\n","END"]}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	queries := backend.Queries()
+	require.Len(t, queries, 1)
+	assert.Equal(t, []string{"\n", "END"}, queries[0].Request.StopSequences)
+}
+
+func TestHandleCompletionsBotError(t *testing.T) {
+	backend := &fakeBackend{respond: func(string, *types.PoeQueryRequest) []types.PoeSSEEvent {
+		return []types.PoeSSEEvent{{Event: "error", Data: `{"text":"Bot unavailable"}`}}
+	}}
+	ah := newTestHandlers(t, backend, nil)
+
+	w := httptest.NewRecorder()
+	ah.HandleCompletions(w, newTestRequest(http.MethodPost, "/v1/completions", `{"model":"GPT-4o","prompt":"Hi"}`))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Contains(t, w.Body.String(), "Bot unavailable")
+}
+
+func TestHandleCompletionsStream(t *testing.T) {
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+
+	w := httptest.NewRecorder()
+	ah.HandleCompletions(w, newTestRequest(http.MethodPost, "/v1/completions",
+		`{"model":"GPT-4o","prompt":["One","Two"],"echo":true,"stream":true}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+
+	texts := map[int]string{}
+	finished := map[int]bool{}
+	var last string
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		last = data
+		if data == "[DONE]" {
+			continue
+		}
+		var chunk types.OpenAICompletionResponse
+		require.NoError(t, json.Unmarshal([]byte(data), &chunk))
+		assert.Equal(t, "text_completion", chunk.Object)
+		require.Len(t, chunk.Choices, 1)
+		choice := chunk.Choices[0]
+		texts[choice.Index] += choice.Text
+		if choice.FinishReason != nil {
+			assert.Equal(t, "stop", *choice.FinishReason)
+			finished[choice.Index] = true
+		}
+	}
+	assert.Equal(t, map[int]string{0: "OneHello", 1: "TwoHello"}, texts)
+	assert.Equal(t, map[int]bool{0: true, 1: true}, finished)
+	assert.Equal(t, "[DONE]", last)
+}
@@ -1,32 +1,284 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"html/template"
 	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/supergeoff/poepenai/client"
 	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
 )
 
 // AppHandlers holds dependencies for HTTP handlers.
 type AppHandlers struct {
-	Logger           *slog.Logger
-	PoeClient        *client.PoeClient
+	Logger *slog.Logger
+	// Backend serves the Poe bots: the Poe client, usually decorated with client.Middlewares.
+	Backend          client.Backend
 	RingBufferLogger *service.RingBufferLogWriter
 	LogsTemplate     *template.Template
+	// InspectorTemplate renders the request inspector pages; they are unavailable if it is nil.
+	InspectorTemplate *template.Template
+	ImageConfig       service.ImageConfig
+	ResponseStore     service.ResponseStore
+	// Runtime holds the models catalog, routing, virtual keys and rate limits, which can be
+	// reloaded while requests are served.
+	Runtime        *service.RuntimeConfigStore
+	Conversations  *service.ConversationManager
+	ContextManager *service.ContextManager
+	// QueryCache and Coalescer serve the queries of Backend (see client.CacheQueries and
+	// client.Coalesce); the admin info page reports their counts.
+	QueryCache *service.QueryCacheManager
+	Coalescer  *service.QueryCoalescer
+	// Exchanges keeps the recent exchanges shown by the request inspector.
+	Exchanges *service.ExchangeStore
+	// LogLevels controls the log levels at runtime; they cannot be changed if it is nil.
+	LogLevels *service.LogLevelController
+	// ReloadConfig reloads the runtime configuration, reporting the reload source; it cannot be
+	// reloaded from the admin pages if it is nil.
+	ReloadConfig func(source string) (service.ReloadStatus, error)
+	// Lifecycle reports the uptime and whether the adapter is draining; readiness ignores
+	// draining if it is nil.
+	Lifecycle *service.Lifecycle
+	// Upstream collects the health statistics of Poe; readiness ignores Poe if it is nil.
+	Upstream *service.UpstreamHealth
+	// Version is the version of the adapter reported by the admin info page.
+	Version string
+	// ConfigHash returns the hash of the loaded configuration, reported by the admin info page.
+	ConfigHash func() string
 }
 
 // NewAppHandlers creates a new AppHandlers struct with its dependencies.
 func NewAppHandlers(
 	logger *slog.Logger,
-	poeClient *client.PoeClient,
+	backend client.Backend,
 	ringBufferLogger *service.RingBufferLogWriter,
 	logsTemplate *template.Template,
+	inspectorTemplate *template.Template,
+	imageConfig service.ImageConfig,
+	responseStore service.ResponseStore,
+	runtime *service.RuntimeConfigStore,
+	conversations *service.ConversationManager,
+	contextManager *service.ContextManager,
+	queryCache *service.QueryCacheManager,
+	coalescer *service.QueryCoalescer,
+	exchanges *service.ExchangeStore,
+	logLevels *service.LogLevelController,
 ) *AppHandlers {
 	return &AppHandlers{
-		Logger:           logger,
-		PoeClient:        poeClient,
-		RingBufferLogger: ringBufferLogger,
-		LogsTemplate:     logsTemplate,
+		Logger:            logger,
+		Backend:           backend,
+		RingBufferLogger:  ringBufferLogger,
+		LogsTemplate:      logsTemplate,
+		InspectorTemplate: inspectorTemplate,
+		ImageConfig:       imageConfig,
+		ResponseStore:     responseStore,
+		Runtime:           runtime,
+		Conversations:     conversations,
+		ContextManager:    contextManager,
+		QueryCache:        queryCache,
+		Coalescer:         coalescer,
+		Exchanges:         exchanges,
+		LogLevels:         logLevels,
 	}
 }
+
+// poeAPIKeyFromRequest extracts the Poe Platform API Key from the Bearer token
+// in the request's Authorization header. Anthropic-style clients send the key in the
+// x-api-key header instead, which is accepted when no Authorization header is present.
+func poeAPIKeyFromRequest(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		if apiKey := r.Header.Get("X-Api-Key"); apiKey != "" {
+			return apiKey, nil
+		}
+		return "", errors.New("missing Authorization header")
+	}
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return "", errors.New("invalid Authorization header format")
+	}
+	if parts[1] == "" {
+		return "", errors.New("empty Bearer token")
+	}
+	return parts[1], nil
+}
+
+// collectPoeEvents drains the channels returned by Backend.StreamQuery and returns all events.
+// It returns an error if the query failed or if ctx is done before the stream completes.
+func collectPoeEvents(
+	ctx context.Context,
+	eventChan <-chan types.PoeSSEEvent,
+	errChan <-chan error,
+) ([]types.PoeSSEEvent, error) {
+	var events []types.PoeSSEEvent
+	for {
+		select {
+		case <-ctx.Done():
+			return events, fmt.Errorf("request timed out or client disconnected: %w", ctx.Err())
+		case err, ok := <-errChan:
+			if !ok || err == nil {
+				// The StreamQuery goroutine has exited; drain any events it sent before closing.
+				for event := range eventChan {
+					events = append(events, event)
+				}
+				return events, nil
+			}
+			return events, err
+		case event, ok := <-eventChan:
+			if !ok {
+				return events, nil
+			}
+			events = append(events, event)
+		}
+	}
+}
+
+// settingsFetchTimeout bounds the fetch of the settings of a bot on the path of a request.
+const settingsFetchTimeout = 10 * time.Second
+
+// botSettings returns the settings of the bot routed from botName, recording them in the models
+// catalog. It returns nil if they cannot be fetched, in which case the request goes on as if the
+// bot accepted it.
+func (ah *AppHandlers) botSettings(
+	ctx context.Context,
+	localLogger *slog.Logger,
+	botName string,
+	poePlatformAPIKey string,
+) *types.PoeSettingsResponse {
+	routedBotName := ah.Runtime.For(ctx).Router.Resolve(botName)
+	ctx, cancel := context.WithTimeout(ctx, settingsFetchTimeout)
+	defer cancel()
+	settings, err := ah.Backend.FetchSettings(ctx, routedBotName, poePlatformAPIKey)
+	if err != nil {
+		localLogger.Warn("Failed to fetch bot settings", "bot_name", routedBotName, "error", err)
+		return nil
+	}
+	// The current catalog rather than the pinned one, so that the settings survive reloads.
+	ah.Runtime.Current().Catalog.SetSettings(routedBotName, settings)
+	return settings
+}
+
+// checkBotAcceptsAttachments returns an error to report to the client if the bot routed from
+// botName does not accept attachments according to its settings, so that image inputs are
+// rejected before being uploaded.
+func (ah *AppHandlers) checkBotAcceptsAttachments(
+	ctx context.Context,
+	localLogger *slog.Logger,
+	botName string,
+	poePlatformAPIKey string,
+) error {
+	settings := ah.botSettings(ctx, localLogger, botName, poePlatformAPIKey)
+	if settings != nil && settings.AllowAttachments != nil && !*settings.AllowAttachments {
+		return fmt.Errorf("model %s does not accept image or file inputs", botName)
+	}
+	return nil
+}
+
+// checkAttachments is checkBotAcceptsAttachments for the attachments of poeQueryReq. The settings
+// of the bot are not fetched for queries without attachments.
+func (ah *AppHandlers) checkAttachments(
+	ctx context.Context,
+	localLogger *slog.Logger,
+	botName string,
+	poeQueryReq *types.PoeQueryRequest,
+	poePlatformAPIKey string,
+) error {
+	for _, message := range poeQueryReq.Query {
+		if len(message.Attachments) > 0 {
+			return ah.checkBotAcceptsAttachments(ctx, localLogger, botName, poePlatformAPIKey)
+		}
+	}
+	return nil
+}
+
+// fitContext shortens the conversation of poeQueryReq to fit into the context window of the bot
+// routed from botName, and reports the applied strategy and the number of removed messages in
+// response headers.
+// The summarize strategy queries the configured summary bot with the caller's Poe API key.
+func (ah *AppHandlers) fitContext(
+	ctx context.Context,
+	w http.ResponseWriter,
+	localLogger *slog.Logger,
+	botName string,
+	poeQueryReq *types.PoeQueryRequest,
+	poePlatformAPIKey string,
+) {
+	summarize := func(ctx context.Context, messages []types.PoeProtocolMessage) (string, error) {
+		summaryBot := ah.ContextManager.SummaryBot()
+		localLogger.Debug("Summarizing older messages", "summary_bot", summaryBot, "message_count", len(messages))
+		summaryQuery := service.BuildSummaryQuery(
+			messages,
+			poePlatformAPIKey,
+			service.GenerateID("conv"),
+			service.GenerateID("msg"),
+		)
+		eventChan, errChan := ah.Backend.StreamQuery(ctx, summaryBot, summaryQuery, poePlatformAPIKey)
+		eventChan, errChan = observePoeQuery(ctx, summaryBot, eventChan, errChan)
+		events, err := collectPoeEvents(ctx, eventChan, errChan)
+		if err != nil {
+			return "", fmt.Errorf("summary bot %s query failed: %w", summaryBot, err)
+		}
+		botResp := service.AggregateBotEvents(events)
+		if botResp.ErrorText != "" {
+			return "", fmt.Errorf("summary bot %s reported an error: %s", summaryBot, botResp.ErrorText)
+		}
+		if strings.TrimSpace(botResp.Text) == "" {
+			return "", fmt.Errorf("summary bot %s returned an empty summary", summaryBot)
+		}
+		return botResp.Text, nil
+	}
+
+	result := ah.ContextManager.Fit(ctx, ah.Runtime.For(ctx).Router.Resolve(botName), poeQueryReq, summarize)
+	if result.Removed > 0 {
+		w.Header().Set(service.ContextStrategyHeader, string(result.Strategy))
+		w.Header().Set(service.ContextRemovedMessagesHeader, strconv.Itoa(result.Removed))
+	}
+	localLogger.Debug(
+		"Checked conversation against the context budget",
+		"bot_name", botName,
+		"budget", result.Budget,
+		"estimated_tokens", result.EstimatedTokens,
+		"removed_messages", result.Removed,
+	)
+}
+
+// streamPoeQuery sends poeQueryReq to botName like Backend.StreamQuery, with the QueryOptions of
+// the request: the query cache opt-in of its QueryCacheHeader and seed, the seed of the client
+// request, if any. How the query was served is reported in the X-Poe-Cache and X-Poe-Coalesced
+// response headers. botName is the requested model, routed to the Poe bot queried for it by the
+// runtime configuration of the request.
+func (ah *AppHandlers) streamPoeQuery(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	localLogger *slog.Logger,
+	botName string,
+	poeQueryReq *types.PoeQueryRequest,
+	poePlatformAPIKey string,
+	seed *int,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	if routedBotName := ah.Runtime.For(ctx).Router.Resolve(botName); routedBotName != botName {
+		localLogger.Debug("Routing model to Poe bot", "model", botName, "bot_name", routedBotName)
+		botName = routedBotName
+	}
+	// The outcome is reported before StreamQuery returns, so before the response is written.
+	ctx = client.WithQueryOptions(ctx, client.QueryOptions{
+		Cache: r.Header.Get(service.QueryCacheHeader),
+		Seed:  seed,
+		OnCache: func(outcome string) {
+			w.Header().Set(service.QueryCacheHeader, outcome)
+		},
+		OnCoalesced: func() {
+			w.Header().Set(service.QueryCoalescedHeader, "true")
+		},
+	})
+	eventChan, errChan := ah.Backend.StreamQuery(ctx, botName, poeQueryReq, poePlatformAPIKey)
+	return observePoeQuery(ctx, botName, eventChan, errChan)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// testAPIKey is the Poe API key of the test requests.
+const testAPIKey = "poe-test-key"
+
+// fakeQuery is a query received by a fakeBackend.
+type fakeQuery struct {
+	BotName string
+	Request *types.PoeQueryRequest
+	APIKey  string
+}
+
+// fakeBackend is a client.Backend answering each query with the events returned by respond, or
+// with a "Hello" text if it is nil.
+type fakeBackend struct {
+	respond   func(botName string, request *types.PoeQueryRequest) []types.PoeSSEEvent
+	settings  *types.PoeSettingsResponse
+	downloads map[string][]byte
+
+	mu      sync.Mutex
+	queries []fakeQuery
+	uploads []string
+}
+
+func (b *fakeBackend) StreamQuery(
+	ctx context.Context,
+	botName string,
+	request *types.PoeQueryRequest,
+	apiKey string,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	b.mu.Lock()
+	b.queries = append(b.queries, fakeQuery{BotName: botName, Request: request, APIKey: apiKey})
+	b.mu.Unlock()
+
+	events := textEvents("Hello")
+	if b.respond != nil {
+		events = b.respond(botName, request)
+	}
+	eventChan := make(chan types.PoeSSEEvent, len(events))
+	errChan := make(chan error)
+	for _, event := range events {
+		eventChan <- event
+	}
+	close(eventChan)
+	close(errChan)
+	return eventChan, errChan
+}
+
+func (b *fakeBackend) FetchSettings(ctx context.Context, botName string, apiKey string) (*types.PoeSettingsResponse, error) {
+	if b.settings == nil {
+		return nil, errors.New("no settings")
+	}
+	return b.settings, nil
+}
+
+func (b *fakeBackend) ReportFeedback(
+	ctx context.Context,
+	botName string,
+	feedback *types.PoeReportFeedbackRequest,
+	apiKey string,
+) error {
+	return nil
+}
+
+func (b *fakeBackend) UploadFile(
+	ctx context.Context,
+	apiKey string,
+	name string,
+	contentType string,
+	data []byte,
+) (*types.PoeAttachment, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.uploads = append(b.uploads, name)
+	return &types.PoeAttachment{URL: "https://pfst.cf2.poecdn.net/" + name, ContentType: contentType, Name: name}, nil
+}
+
+func (b *fakeBackend) DownloadFile(ctx context.Context, url string) ([]byte, string, error) {
+	data, ok := b.downloads[url]
+	if !ok {
+		return nil, "", errors.New("not found")
+	}
+	return data, "image/png", nil
+}
+
+// Queries returns the queries received so far.
+func (b *fakeBackend) Queries() []fakeQuery {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]fakeQuery(nil), b.queries...)
+}
+
+// textEvents returns the events of a bot answering text.
+func textEvents(text string) []types.PoeSSEEvent {
+	return []types.PoeSSEEvent{
+		{Event: "text", Data: `{"text":"` + text + `"}`},
+		{Event: "done", Data: "{}"},
+	}
+}
+
+// newTestHandlers returns AppHandlers querying backend, routing the models with aliases, with
+// in-memory stores and without virtual keys.
+func newTestHandlers(t *testing.T, backend *fakeBackend, aliases map[string]string) *AppHandlers {
+	t.Helper()
+	exchanges, err := service.NewExchangeStore(0, "")
+	require.NoError(t, err)
+	catalog := service.DefaultModelCatalog()
+	runtime := service.NewRuntimeConfigStore(&service.RuntimeConfig{
+		Catalog: catalog,
+		Router:  service.NewModelRouter(aliases),
+		Keys:    service.NewKeyStore(nil, "", true, false),
+		Limiter: service.NewRateLimiter(0, 0),
+	})
+	return NewAppHandlers(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		backend,
+		nil,
+		nil,
+		nil,
+		service.DefaultImageConfig(),
+		service.NewMemoryResponseStore(0),
+		runtime,
+		service.NewConversationManager(service.NewMemoryConversationStore(0)),
+		service.NewContextManager(service.ContextConfig{}, runtime),
+		service.NewQueryCacheManager(service.NewMemoryQueryCache(0, 0), service.QueryCacheConfig{}),
+		service.NewQueryCoalescer(service.CoalesceConfig{}),
+		exchanges,
+		nil,
+	)
+}
+
+// newTestRequest returns a request to path with a JSON body, authenticated with testAPIKey.
+func newTestRequest(method string, path string, body string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+testAPIKey)
+	return r
+}
+
+func TestCheckAttachments(t *testing.T) {
+	const imageBody = `{"model":"fast","messages":[{"role":"user","content":[` +
+		`{"type":"text","text":"What is this?"},` +
+		`{"type":"image_url","image_url":{"url":"https://example.com/cat.png"}}]}]}`
+	const textBody = `{"model":"fast","messages":[{"role":"user","content":"Hello"}]}`
+	allow, deny := true, false
+	tests := []struct {
+		name       string
+		settings   *types.PoeSettingsResponse
+		body       string
+		wantStatus int
+		wantVision bool
+	}{
+		{"attachments accepted", &types.PoeSettingsResponse{AllowAttachments: &allow}, imageBody, http.StatusOK, true},
+		{"attachments refused", &types.PoeSettingsResponse{AllowAttachments: &deny}, imageBody, http.StatusBadRequest, false},
+		{"settings unavailable", nil, imageBody, http.StatusOK, true},
+		// The settings are not fetched for queries without attachments.
+		{"no attachments", &types.PoeSettingsResponse{AllowAttachments: &deny}, textBody, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{settings: tt.settings}
+			ah := newTestHandlers(t, backend, map[string]string{"fast": "GPT-4o"})
+
+			w := httptest.NewRecorder()
+			ah.HandleChatCompletions(w, newTestRequest(http.MethodPost, "/v1/chat/completions", tt.body))
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus == http.StatusBadRequest {
+				assert.Contains(t, w.Body.String(), "model fast does not accept image or file inputs")
+				assert.Empty(t, backend.Queries(), "refused inputs are not sent to the bot")
+			}
+
+			// The settings of the routed bot are recorded in the models catalog.
+			model, ok := ah.Runtime.Current().Catalog.Get("GPT-4o")
+			require.True(t, ok)
+			assert.Equal(t, tt.wantVision, model.Vision)
+			if tt.body == imageBody {
+				assert.Equal(t, tt.settings, model.Settings)
+			} else {
+				assert.Nil(t, model.Settings)
+			}
+		})
+	}
+}
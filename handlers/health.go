@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/supergeoff/poepenai/service"
+)
+
+// readiness is the response of the readiness endpoint, with the checks that failed if any.
+type readiness struct {
+	Ready  bool     `json:"ready"`
+	Failed []string `json:"failed,omitempty"`
+	// Upstream is the outcome of the upstream probes, if enabled.
+	Upstream *service.ProbeStatus `json:"upstream,omitempty"`
+}
+
+// buildInfo describes the build of the adapter binary.
+type buildInfo struct {
+	GoVersion   string `json:"go_version"`
+	Module      string `json:"module,omitempty"`
+	Revision    string `json:"revision,omitempty"`
+	CommittedAt string `json:"committed_at,omitempty"`
+	Modified    bool   `json:"modified,omitempty"`
+}
+
+// adminInfo is the response of the admin info endpoint.
+type adminInfo struct {
+	Version          string                `json:"version"`
+	Build            buildInfo             `json:"build"`
+	StartedAt        time.Time             `json:"started_at"`
+	UptimeSeconds    float64               `json:"uptime_seconds"`
+	ConfigHash       string                `json:"config_hash,omitempty"`
+	ConfigGeneration int                   `json:"config_generation"`
+	Draining         bool                  `json:"draining"`
+	Upstream         service.UpstreamStats `json:"upstream"`
+	// QueryCache and QueryCoalescing count the queries served from the query cache and shared
+	// between identical requests.
+	QueryCache      *service.QueryCacheStats      `json:"query_cache,omitempty"`
+	QueryCoalescing *service.QueryCoalescingStats `json:"query_coalescing,omitempty"`
+}
+
+// HandleHealthz is the HTTP handler for GET /healthz. It reports that the process is alive, and
+// never checks its dependencies.
+func (ah *AppHandlers) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// HandleReadyz is the HTTP handler for GET /readyz. It reports whether the adapter can serve
+// requests as JSON, with a 503 status if not: the runtime configuration must be loaded, the
+// adapter must not be draining before shutting down, and the last upstream probe, if enabled,
+// must have succeeded. Probes run in the background, so readiness checks never query Poe.
+func (ah *AppHandlers) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	status := readiness{Ready: true}
+	fail := func(check string) {
+		status.Ready = false
+		status.Failed = append(status.Failed, check)
+	}
+	if ah.Runtime == nil || ah.Runtime.Current() == nil {
+		fail("config")
+	}
+	if ah.Lifecycle != nil && ah.Lifecycle.Draining() {
+		fail("draining")
+	}
+	if ah.Upstream != nil {
+		if probe, ok := ah.Upstream.Probe(); ok {
+			status.Upstream = &probe
+			if !probe.Healthy {
+				fail("upstream")
+			}
+		}
+	}
+
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		ah.Logger.Error("Failed to encode readiness status", "error", err)
+	}
+}
+
+// HandleAdminInfo is the HTTP handler for GET /admin/info. It returns the version and build of
+// the adapter, its uptime, the hash of its configuration, the health statistics of Poe and the
+// query cache and coalescing counts as JSON.
+func (ah *AppHandlers) HandleAdminInfo(w http.ResponseWriter, r *http.Request) {
+	info := adminInfo{
+		Version: ah.Version,
+		Build:   readBuildInfo(),
+	}
+	if ah.Lifecycle != nil {
+		info.StartedAt = ah.Lifecycle.StartedAt()
+		info.UptimeSeconds = ah.Lifecycle.Uptime().Round(time.Second).Seconds()
+		info.Draining = ah.Lifecycle.Draining()
+	}
+	if ah.ConfigHash != nil {
+		info.ConfigHash = ah.ConfigHash()
+	}
+	if ah.Runtime != nil {
+		info.ConfigGeneration = ah.Runtime.Status().Generation
+	}
+	if ah.Upstream != nil {
+		info.Upstream = ah.Upstream.Stats()
+	}
+	if ah.QueryCache != nil {
+		stats := ah.QueryCache.Stats()
+		info.QueryCache = &stats
+	}
+	if ah.Coalescer != nil {
+		stats := ah.Coalescer.Stats()
+		info.QueryCoalescing = &stats
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		ah.Logger.Error("Failed to encode admin info", "error", err)
+	}
+}
+
+// readBuildInfo returns the build information embedded in the binary by the Go toolchain.
+func readBuildInfo() buildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return buildInfo{}
+	}
+	build := buildInfo{GoVersion: info.GoVersion, Module: info.Main.Version}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.CommittedAt = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/service"
+)
+
+func TestHandleHealthz(t *testing.T) {
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+	ah.Lifecycle = service.NewLifecycle()
+	ah.Lifecycle.StartDraining()
+
+	w := httptest.NewRecorder()
+	ah.HandleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "a draining adapter is alive")
+	assert.Equal(t, "ok\n", w.Body.String())
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+}
+
+func TestHandleReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		draining   bool
+		probeBot   string
+		probeErr   error
+		probed     bool
+		wantCode   int
+		wantFailed []string
+	}{
+		{name: "ready", wantCode: http.StatusOK},
+		{name: "draining", draining: true, wantCode: http.StatusServiceUnavailable, wantFailed: []string{"draining"}},
+		{name: "probe succeeded", probeBot: "GPT-4o", probed: true, wantCode: http.StatusOK},
+		{
+			name:       "probe failed",
+			probeBot:   "GPT-4o",
+			probeErr:   errors.New("unreachable"),
+			probed:     true,
+			wantCode:   http.StatusServiceUnavailable,
+			wantFailed: []string{"upstream"},
+		},
+		{
+			name:       "not probed yet",
+			probeBot:   "GPT-4o",
+			wantCode:   http.StatusServiceUnavailable,
+			wantFailed: []string{"upstream"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ah := newTestHandlers(t, &fakeBackend{}, nil)
+			ah.Lifecycle = service.NewLifecycle()
+			if tt.draining {
+				ah.Lifecycle.StartDraining()
+			}
+			ah.Upstream = service.NewUpstreamHealth(tt.probeBot)
+			if tt.probed {
+				ah.Upstream.RecordProbe(time.Millisecond, tt.probeErr)
+			}
+
+			w := httptest.NewRecorder()
+			ah.HandleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tt.wantCode, w.Code)
+			var status readiness
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+			assert.Equal(t, tt.wantCode == http.StatusOK, status.Ready)
+			assert.Equal(t, tt.wantFailed, status.Failed)
+			if tt.probeBot != "" {
+				require.NotNil(t, status.Upstream)
+				assert.Equal(t, tt.probeBot, status.Upstream.Bot)
+			} else {
+				assert.Nil(t, status.Upstream)
+			}
+		})
+	}
+}
+
+func TestHandleAdminInfo(t *testing.T) {
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+	ah.Version = "1.2.3"
+	ah.ConfigHash = func() string { return "abc123" }
+	ah.Lifecycle = service.NewLifecycle()
+	ah.Upstream = service.NewUpstreamHealth("")
+	ah.Upstream.RecordQuery(3, nil)
+	ah.Upstream.RecordQuery(1, errors.New("bad gateway"))
+
+	w := httptest.NewRecorder()
+	ah.HandleAdminInfo(w, httptest.NewRequest(http.MethodGet, "/admin/info", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var info adminInfo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, "1.2.3", info.Version)
+	assert.Equal(t, "abc123", info.ConfigHash)
+	assert.False(t, info.Draining)
+	assert.Equal(t, int64(2), info.Upstream.Queries)
+	assert.Equal(t, int64(1), info.Upstream.Failures)
+	assert.Equal(t, int64(2), info.Upstream.Retries)
+	assert.Equal(t, "bad gateway", info.Upstream.LastError)
+	assert.NotNil(t, info.QueryCache)
+	assert.NotNil(t, info.QueryCoalescing)
+}
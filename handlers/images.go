@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
+)
+
+const (
+	// maxImagesPerRequest caps the "n" parameter of images API requests.
+	maxImagesPerRequest = 10
+	// maxImageEditFormSize caps the size of multipart forms accepted by /v1/images/edits.
+	maxImageEditFormSize = 32 << 20 // 32 MiB
+)
+
+// HandleImageGenerations is the HTTP handler for the OpenAI-compatible /v1/images/generations endpoint.
+// The prompt is sent to a Poe image bot and the files it returns are mapped to OpenAI image data,
+// either as URLs or, if response_format is "b64_json", as base64-encoded downloads.
+func (ah *AppHandlers) HandleImageGenerations(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetReqID(r.Context())
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	poePlatformAPIKey, err := poeAPIKeyFromRequest(r)
+	if err != nil {
+		localLogger.Warn("Rejected request authentication", "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var imageReq types.OpenAIImageGenerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&imageReq); err != nil {
+		localLogger.Error("Invalid request body JSON", "error", err)
+		http.Error(w, fmt.Sprintf("Invalid request body JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	localLogger.Debug("Decoded OpenAI image generation request", "request_object", imageReq)
+
+	ah.generateImages(w, r, localLogger, &imageReq, nil, poePlatformAPIKey)
+}
+
+// HandleImageEdits is the HTTP handler for the OpenAI-compatible /v1/images/edits endpoint.
+// It accepts the OpenAI multipart form, uploads the image(s) to Poe and sends them as attachments
+// along with the prompt to the Poe image bot. The optional mask is uploaded as an extra attachment.
+func (ah *AppHandlers) HandleImageEdits(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := middleware.GetReqID(ctx)
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	poePlatformAPIKey, err := poeAPIKeyFromRequest(r)
+	if err != nil {
+		localLogger.Warn("Rejected request authentication", "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxImageEditFormSize); err != nil {
+		localLogger.Error("Invalid multipart form", "error", err)
+		http.Error(w, fmt.Sprintf("Invalid multipart form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	imageReq := types.OpenAIImageGenerationRequest{
+		Model:          r.FormValue("model"),
+		Prompt:         r.FormValue("prompt"),
+		Size:           r.FormValue("size"),
+		Quality:        r.FormValue("quality"),
+		ResponseFormat: r.FormValue("response_format"),
+		User:           r.FormValue("user"),
+	}
+	if n := r.FormValue("n"); n != "" {
+		imageReq.N, err = strconv.Atoi(n)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid n: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// OpenAI SDKs send a single "image" field, or "image[]" for multiple images.
+	var fileHeaders []*multipart.FileHeader
+	for _, field := range []string{"image", "image[]", "mask"} {
+		fileHeaders = append(fileHeaders, r.MultipartForm.File[field]...)
+	}
+	if len(r.MultipartForm.File["image"])+len(r.MultipartForm.File["image[]"]) == 0 {
+		http.Error(w, "Missing image file", http.StatusBadRequest)
+		return
+	}
+
+	editBot := imageReq.Model
+	if editBot == "" {
+		editBot = ah.ImageConfig.DefaultBot
+	}
+	if !checkModelAllowed(w, r, localLogger, editBot) {
+		return
+	}
+	if err := ah.checkBotAcceptsAttachments(ctx, localLogger, editBot, poePlatformAPIKey); err != nil {
+		localLogger.Warn("Rejected attachments", "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	attachments := make([]types.PoeAttachment, 0, len(fileHeaders))
+	for _, fileHeader := range fileHeaders {
+		file, err := fileHeader.Open()
+		if err != nil {
+			localLogger.Error("Failed to open uploaded file", "error", err, "file_name", fileHeader.Filename)
+			http.Error(w, "Failed to read uploaded file", http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(file)
+		if closeErr := file.Close(); closeErr != nil {
+			localLogger.Warn("Failed to close uploaded file", "error", closeErr)
+		}
+		if err != nil {
+			localLogger.Error("Failed to read uploaded file", "error", err, "file_name", fileHeader.Filename)
+			http.Error(w, "Failed to read uploaded file", http.StatusBadRequest)
+			return
+		}
+		contentType := fileHeader.Header.Get("Content-Type")
+		if contentType == "" || contentType == "application/octet-stream" {
+			contentType = http.DetectContentType(data)
+		}
+		attachment, err := ah.Backend.UploadFile(
+			ctx,
+			poePlatformAPIKey,
+			fileHeader.Filename,
+			contentType,
+			data,
+		)
+		if err != nil {
+			localLogger.Error("Failed to upload image to Poe", "error", err)
+			http.Error(w, fmt.Sprintf("Error uploading image to Poe: %v", err), http.StatusBadGateway)
+			return
+		}
+		attachments = append(attachments, *attachment)
+	}
+
+	ah.generateImages(w, r, localLogger, &imageReq, attachments, poePlatformAPIKey)
+}
+
+// generateImages queries the Poe image bot routed from the requested model until n images are
+// collected and writes the OpenAI images response. It is shared by the generations and edits
+// endpoints.
+func (ah *AppHandlers) generateImages(
+	w http.ResponseWriter,
+	r *http.Request,
+	localLogger *slog.Logger,
+	imageReq *types.OpenAIImageGenerationRequest,
+	attachments []types.PoeAttachment,
+	poePlatformAPIKey string,
+) {
+	ctx := r.Context()
+
+	if imageReq.Model == "" {
+		imageReq.Model = ah.ImageConfig.DefaultBot
+	}
+	if !checkModelAllowed(w, r, localLogger, imageReq.Model) {
+		return
+	}
+	n := imageReq.N
+	if n <= 0 {
+		n = 1
+	}
+	if n > maxImagesPerRequest {
+		http.Error(
+			w,
+			fmt.Sprintf("n must be at most %d", maxImagesPerRequest),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	if imageReq.ResponseFormat != "" && imageReq.ResponseFormat != "url" &&
+		imageReq.ResponseFormat != "b64_json" {
+		http.Error(w, "response_format must be 'url' or 'b64_json'", http.StatusBadRequest)
+		return
+	}
+	botName := ah.Runtime.For(ctx).Router.Resolve(imageReq.Model)
+	if botName != imageReq.Model {
+		localLogger.Debug("Routing model to Poe bot", "model", imageReq.Model, "bot_name", botName)
+	}
+	profile, ok := ah.ImageConfig.Bots[botName]
+	if !ok {
+		localLogger.Debug(
+			"No image profile configured for bot, size/quality/style will be ignored",
+			"bot_name", botName,
+		)
+	}
+
+	localLogger.Info(
+		"Processing image generation request",
+		"model", imageReq.Model,
+		"n", n,
+		"size", imageReq.Size,
+		"response_format", imageReq.ResponseFormat,
+		"attachments", len(attachments),
+	)
+
+	// Poe image bots generally return one image per query, so the bot is queried
+	// until enough images are collected. Each query is its own conversation, and is never served
+	// from the query cache, which would return the same image again.
+	ctx = client.WithQueryOptions(ctx, client.QueryOptions{Cache: "off"})
+	var files []types.PoeFileEventData
+	for attempt := 0; len(files) < n && attempt < n; attempt++ {
+		poeQueryReq, err := service.TransformOpenAIImageRequestToPoeQuery(
+			imageReq,
+			profile,
+			attachments,
+			poePlatformAPIKey,
+			service.GenerateID("conv"),
+			service.GenerateID("msg"),
+		)
+		if err != nil {
+			localLogger.Error("Error transforming image request to Poe query", "error", err)
+			http.Error(w, fmt.Sprintf("Error transforming request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		eventChan, errChan := ah.Backend.StreamQuery(ctx, botName, poeQueryReq, poePlatformAPIKey)
+		eventChan, errChan = observePoeQuery(ctx, botName, eventChan, errChan)
+		events, err := collectPoeEvents(ctx, eventChan, errChan)
+		if err != nil {
+			localLogger.Error("Error from Poe stream during image generation", "error", err)
+			http.Error(w, fmt.Sprintf("Error from Poe: %v", err), http.StatusBadGateway)
+			return
+		}
+		queryFiles, err := service.CollectPoeFiles(events)
+		if err != nil {
+			localLogger.Error("Poe image bot reported an error", "error", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		localLogger.Debug("Collected files from Poe image bot", "file_count", len(queryFiles))
+		files = append(files, queryFiles...)
+	}
+	if len(files) == 0 {
+		localLogger.Error("Poe image bot returned no files", "bot_name", botName)
+		http.Error(w, "Poe image bot returned no images", http.StatusBadGateway)
+		return
+	}
+	if len(files) > n {
+		files = files[:n]
+	}
+
+	imagesResp := types.OpenAIImagesResponse{
+		Created: time.Now().Unix(),
+		Data:    make([]types.OpenAIImageData, 0, len(files)),
+	}
+	for _, file := range files {
+		if imageReq.ResponseFormat != "b64_json" {
+			imagesResp.Data = append(imagesResp.Data, types.OpenAIImageData{URL: file.URL})
+			continue
+		}
+		data, _, err := ah.Backend.DownloadFile(ctx, file.URL)
+		if err != nil {
+			localLogger.Error("Failed to download generated image", "error", err)
+			http.Error(w, fmt.Sprintf("Error downloading image: %v", err), http.StatusBadGateway)
+			return
+		}
+		imagesResp.Data = append(imagesResp.Data, types.OpenAIImageData{
+			B64JSON: base64.StdEncoding.EncodeToString(data),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(imagesResp); err != nil {
+		localLogger.Error("Error encoding images response", "error", err)
+	}
+	localLogger.Info("Successfully sent images response", "image_count", len(imagesResp.Data))
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// imageEvents returns the events of an image bot sending the file at url.
+func imageEvents(url string) []types.PoeSSEEvent {
+	return []types.PoeSSEEvent{
+		{Event: "file", Data: `{"url":"` + url + `","content_type":"image/png","name":"image.png"}`},
+		{Event: "done", Data: "{}"},
+	}
+}
+
+func TestHandleImageGenerations(t *testing.T) {
+	const imageURL = "https://pfst.cf2.poecdn.net/base/image/cat.png"
+	tests := []struct {
+		name        string
+		body        string
+		aliases     map[string]string
+		wantStatus  int
+		wantBot     string
+		wantQueries int
+		wantData    []types.OpenAIImageData
+	}{
+		{
+			name:        "url",
+			body:        `{"model":"FLUX-pro-1.1","prompt":"a cat","size":"1792x1024"}`,
+			wantStatus:  http.StatusOK,
+			wantBot:     "FLUX-pro-1.1",
+			wantQueries: 1,
+			wantData:    []types.OpenAIImageData{{URL: imageURL}},
+		},
+		{
+			name:        "b64_json",
+			body:        `{"model":"FLUX-pro-1.1","prompt":"a cat","response_format":"b64_json"}`,
+			wantStatus:  http.StatusOK,
+			wantBot:     "FLUX-pro-1.1",
+			wantQueries: 1,
+			wantData:    []types.OpenAIImageData{{B64JSON: base64.StdEncoding.EncodeToString([]byte("png"))}},
+		},
+		{
+			name:        "n images",
+			body:        `{"model":"FLUX-pro-1.1","prompt":"a cat","n":2}`,
+			wantStatus:  http.StatusOK,
+			wantBot:     "FLUX-pro-1.1",
+			wantQueries: 2,
+			wantData:    []types.OpenAIImageData{{URL: imageURL}, {URL: imageURL}},
+		},
+		{
+			name:        "routed model",
+			body:        `{"model":"my-images","prompt":"a cat","size":"1792x1024"}`,
+			aliases:     map[string]string{"my-images": "FLUX-pro-1.1"},
+			wantStatus:  http.StatusOK,
+			wantBot:     "FLUX-pro-1.1",
+			wantQueries: 1,
+			wantData:    []types.OpenAIImageData{{URL: imageURL}},
+		},
+		{name: "too many images", body: `{"prompt":"a cat","n":11}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid response format", body: `{"prompt":"a cat","response_format":"png"}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{
+				respond: func(string, *types.PoeQueryRequest) []types.PoeSSEEvent {
+					return imageEvents(imageURL)
+				},
+				downloads: map[string][]byte{imageURL: []byte("png")},
+			}
+			ah := newTestHandlers(t, backend, tt.aliases)
+
+			w := httptest.NewRecorder()
+			ah.HandleImageGenerations(w, newTestRequest(http.MethodPost, "/v1/images/generations", tt.body))
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			queries := backend.Queries()
+			require.Len(t, queries, tt.wantQueries)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp types.OpenAIImagesResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.wantData, resp.Data)
+			for _, query := range queries {
+				assert.Equal(t, tt.wantBot, query.BotName)
+				assert.Equal(t, testAPIKey, query.APIKey)
+				// The size is translated with the profile of the routed bot.
+				if tt.aliases != nil {
+					assert.Contains(t, query.Request.Query[0].Content, "--aspect 16:9")
+				}
+			}
+		})
+	}
+}
+
+func TestHandleImageEdits(t *testing.T) {
+	const imageURL = "https://pfst.cf2.poecdn.net/base/image/edited.png"
+	allowAttachments := true
+	backend := &fakeBackend{
+		respond: func(string, *types.PoeQueryRequest) []types.PoeSSEEvent {
+			return imageEvents(imageURL)
+		},
+		settings: &types.PoeSettingsResponse{AllowAttachments: &allowAttachments},
+	}
+	ah := newTestHandlers(t, backend, nil)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("model", "FLUX-pro-1.1"))
+	require.NoError(t, form.WriteField("prompt", "add a hat"))
+	for _, field := range []string{"image", "mask"} {
+		part, err := form.CreateFormFile(field, field+".png")
+		require.NoError(t, err)
+		_, err = part.Write([]byte("\x89PNG\r\n\x1a\n"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, form.Close())
+	r := httptest.NewRequest(http.MethodPost, "/v1/images/edits", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer "+testAPIKey)
+
+	w := httptest.NewRecorder()
+	ah.HandleImageEdits(w, r)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp types.OpenAIImagesResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []types.OpenAIImageData{{URL: imageURL}}, resp.Data)
+
+	queries := backend.Queries()
+	require.Len(t, queries, 1)
+	attachments := queries[0].Request.Query[0].Attachments
+	require.Len(t, attachments, 2)
+	assert.Equal(t, "image.png", attachments[0].Name)
+	assert.Equal(t, "mask.png", attachments[1].Name)
+}
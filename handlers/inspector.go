@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// inspectorListData is the data of the request inspector list page.
+type inspectorListData struct {
+	Exchanges []service.ExchangeSummary
+}
+
+// inspectorDetailData is the data of the request inspector detail page.
+type inspectorDetailData struct {
+	Summary service.ExchangeSummary
+	Headers map[string]string
+	Request string // Pretty-printed if JSON
+	Queries []inspectorQuery
+	// Chunks are the chunks of a streamed response (SSE events or NDJSON lines); Response is set instead
+	// for other responses.
+	Chunks    []string
+	Response  string
+	Truncated bool
+}
+
+// inspectorQuery is a Poe query of the request inspector detail page.
+type inspectorQuery struct {
+	BotName string
+	Query   string // Pretty-printed JSON, with the API key redacted
+	Events  []inspectorEvent
+	Error   string
+}
+
+// inspectorEvent is a Poe event of the timeline of a query.
+type inspectorEvent struct {
+	OffsetMillis float64
+	Event        string
+	Data         string
+}
+
+// HandleInspectorList is the HTTP handler of the request inspector: it lists the recent API
+// requests, most recent first, linking to their details by request ID.
+func (ah *AppHandlers) HandleInspectorList(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetReqID(r.Context())
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	if ah.InspectorTemplate == nil || ah.Exchanges == nil {
+		localLogger.Error("Request inspector is not available")
+		http.Error(w, "Request inspector not available. Check server logs for details.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := inspectorListData{Exchanges: ah.Exchanges.List()}
+	if err := ah.InspectorTemplate.ExecuteTemplate(w, "list", data); err != nil {
+		localLogger.Error("Failed to execute inspector template", "error", err)
+		http.Error(w, "Failed to render inspector page", http.StatusInternalServerError)
+	}
+}
+
+// HandleInspectorDetail is the HTTP handler of the request inspector detail page of a request,
+// identified by its request ID at the end of the path. It shows the incoming body, the Poe
+// queries it was transformed into with their event timelines, and the response sent back.
+func (ah *AppHandlers) HandleInspectorDetail(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetReqID(r.Context())
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	if ah.InspectorTemplate == nil || ah.Exchanges == nil {
+		localLogger.Error("Request inspector is not available")
+		http.Error(w, "Request inspector not available. Check server logs for details.", http.StatusInternalServerError)
+		return
+	}
+
+	// Request IDs contain slashes, hence the wildcard.
+	exchangeID := chi.URLParam(r, "*")
+	exchange, ok := ah.Exchanges.Get(exchangeID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("No recent request with ID %s", exchangeID), http.StatusNotFound)
+		return
+	}
+
+	data := inspectorDetailData{
+		Summary:   service.SummarizeExchange(exchange),
+		Headers:   exchange.Headers,
+		Request:   prettyJSON(exchange.Request),
+		Truncated: exchange.Truncated,
+	}
+	for _, query := range exchange.Queries {
+		queryJSON, err := json.MarshalIndent(query.Query, "", "  ")
+		if err != nil {
+			localLogger.Warn("Failed to marshal recorded Poe query", "error", err)
+		}
+		view := inspectorQuery{
+			BotName: query.BotName,
+			Query:   string(queryJSON),
+			Error:   query.Error,
+			Events:  make([]inspectorEvent, 0, len(query.Events)),
+		}
+		for _, event := range query.Events {
+			view.Events = append(view.Events, inspectorEvent{
+				OffsetMillis: float64(event.Offset.Microseconds()) / 1000,
+				Event:        event.Event,
+				Data:         event.Data,
+			})
+		}
+		data.Queries = append(data.Queries, view)
+	}
+	data.Chunks = responseChunks(exchange.Response)
+	if data.Chunks == nil {
+		data.Response = prettyJSON(exchange.Response)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := ah.InspectorTemplate.ExecuteTemplate(w, "detail", data); err != nil {
+		localLogger.Error("Failed to execute inspector template", "error", err)
+		http.Error(w, "Failed to render inspector page", http.StatusInternalServerError)
+	}
+}
+
+// prettyJSON indents a JSON document, or returns it as is if it is not JSON.
+func prettyJSON(document string) string {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, []byte(document), "", "  "); err != nil {
+		return document
+	}
+	return indented.String()
+}
+
+// responseChunks splits a streamed response body into its chunks: SSE events, or lines of
+// newline-delimited JSON. It returns nil for other bodies.
+func responseChunks(response string) []string {
+	trimmed := strings.TrimSpace(response)
+	if strings.HasPrefix(trimmed, "data:") || strings.HasPrefix(trimmed, "event:") {
+		var chunks []string
+		for _, chunk := range strings.Split(trimmed, "\n\n") {
+			if chunk = strings.TrimSpace(chunk); chunk != "" {
+				chunks = append(chunks, chunk)
+			}
+		}
+		return chunks
+	}
+
+	lines := strings.Split(trimmed, "\n")
+	if len(lines) < 2 {
+		return nil
+	}
+	for _, line := range lines {
+		if !json.Valid([]byte(line)) {
+			return nil
+		}
+	}
+	return lines
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/service"
+)
+
+func TestInspector(t *testing.T) {
+	backend := &fakeBackend{}
+	ah := newTestHandlers(t, backend, nil)
+	ah.Backend = client.Chain(backend, client.Record(service.RecordExchangeQuery))
+	tmpl, err := template.ParseFiles("../templates/inspector.html")
+	require.NoError(t, err)
+	ah.InspectorTemplate = tmpl
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Group(func(r chi.Router) {
+		r.Use(RecordExchanges(ah.Logger, ah.Exchanges))
+		r.Post("/v1/chat/completions", ah.HandleChatCompletions)
+	})
+	r.Get("/admin/inspector", ah.HandleInspectorList)
+	r.Get("/admin/inspector/requests/*", ah.HandleInspectorDetail)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, newTestRequest(http.MethodPost, "/v1/chat/completions",
+		`{"model":"GPT-4o","messages":[{"role":"user","content":"Hi"}]}`))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	// Exchanges are recorded in the background once their queries are over.
+	require.Eventually(t, func() bool { return len(ah.Exchanges.List()) == 1 }, time.Second, time.Millisecond)
+	summary := ah.Exchanges.List()[0]
+	assert.Equal(t, "GPT-4o", summary.Model)
+	assert.Equal(t, 1, summary.Queries)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/inspector", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `href="/admin/inspector/requests/`+summary.ID+`"`)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/inspector/requests/"+summary.ID, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	body := w.Body.String()
+	assert.Contains(t, body, "Query 0 to GPT-4o")
+	assert.Contains(t, body, "&#34;Hello&#34;", "the events of the query are shown")
+	assert.Contains(t, body, `[REDACTED]`, "the API key of the query is redacted")
+	assert.NotContains(t, body, testAPIKey, "credentials are not recorded")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/inspector/requests/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestResponseChunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     []string
+	}{
+		{"SSE", "data: {\"a\":1}\n\ndata: [DONE]\n\n", []string{`data: {"a":1}`, "data: [DONE]"}},
+		{"named SSE events", "event: ping\ndata: {}\n\n", []string{"event: ping\ndata: {}"}},
+		{"NDJSON", "{\"done\":false}\n{\"done\":true}\n", []string{`{"done":false}`, `{"done":true}`}},
+		{"JSON", `{"choices":[]}`, nil},
+		{"text", "Bad Request\nInvalid body", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, responseChunks(tt.response))
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// APIKeys is a middleware authenticating the API requests with the virtual keys of the runtime
+// configuration, and limiting the rate of the requests of each client. It pins the current
+// runtime configuration to the request, so that its handlers keep it across reloads. Requests
+// made with a virtual key are sent to Poe with its Poe Platform API Key, and are rejected for the
+// models it does not allow. Other keys are passed through to Poe if the configuration allows it,
+// and rejected otherwise. Requests without credentials are rejected if virtual keys are
+// configured, unless anonymous Ollama requests are allowed; they are left to the handlers
+// otherwise, which reject them except for front-ends using the default Poe API Key (Ollama).
+func APIKeys(logger *slog.Logger, runtime *service.RuntimeConfigStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := middleware.GetReqID(r.Context())
+			localLogger := logger.With("request_id", requestID)
+
+			r = r.WithContext(runtime.Pin(r.Context()))
+			runtimeConfig := runtime.For(r.Context())
+			keys, limiter := runtimeConfig.Keys, runtimeConfig.Limiter
+
+			clientID := "ip:" + remoteIP(r)
+			requestsPerMinute := 0
+			if apiKey, err := poeAPIKeyFromRequest(r); err == nil {
+				r = r.WithContext(context.WithValue(r.Context(), callerKeyFingerprintKey{}, service.KeyFingerprint(apiKey)))
+				virtualKey, ok := keys.Lookup(apiKey)
+				switch {
+				case ok:
+					// The access log usually found the model already; the body is limited by
+					// middleware.RequestSize otherwise.
+					r = withRequestModel(w, r, 0)
+					r = r.WithContext(context.WithValue(r.Context(), virtualKeyContextKey{}, virtualKey))
+					if model, _ := requestModel(r); model != "" && !checkModelAllowed(w, r, localLogger, model) {
+						return
+					}
+					clientID, requestsPerMinute = "key:"+virtualKey.Name, virtualKey.RequestsPerMinute
+					r = r.Clone(r.Context())
+					r.Header.Set("Authorization", "Bearer "+virtualKey.PoeAPIKey)
+					r.Header.Del("X-Api-Key")
+					localLogger.Debug("Authenticated request with a virtual key", "key_name", virtualKey.Name)
+				case keys.AllowPassthrough():
+					clientID = "poe:" + service.KeyFingerprint(apiKey)
+				default:
+					localLogger.Warn("Rejected request with an unknown API key", "key_fingerprint", service.KeyFingerprint(apiKey))
+					http.Error(w, "Invalid API key", http.StatusUnauthorized)
+					return
+				}
+			} else if keys.Len() > 0 && !keys.AllowAnonymousOllama() {
+				localLogger.Warn("Rejected request without credentials", "reason", err.Error())
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if allowed, retryAfter := limiter.Allow(clientID, requestsPerMinute); !allowed {
+				localLogger.Warn("Rate limit exceeded", "client", clientID, "retry_after", retryAfter)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// callerKeyFingerprintKey is the context key of the fingerprint of the key a client sent, set by
+// APIKeys before virtual keys are swapped for their Poe Platform API Key.
+type callerKeyFingerprintKey struct{}
+
+// callerFingerprint returns the fingerprint of the key the client of r authenticated with, which
+// owns the responses and conversations it creates. Without APIKeys, it is the fingerprint of
+// poePlatformAPIKey.
+func callerFingerprint(r *http.Request, poePlatformAPIKey string) string {
+	if fingerprint, ok := r.Context().Value(callerKeyFingerprintKey{}).(string); ok {
+		return fingerprint
+	}
+	return service.KeyFingerprint(poePlatformAPIKey)
+}
+
+// virtualKeyContextKey is the context key of the virtual key a client authenticated with, set by
+// APIKeys.
+type virtualKeyContextKey struct{}
+
+// checkModelAllowed reports whether the virtual key the client of r authenticated with, if any,
+// allows model, and rejects the request otherwise. APIKeys checks the model of JSON bodies;
+// handlers check the others, such as those of multipart forms and default models.
+func checkModelAllowed(w http.ResponseWriter, r *http.Request, localLogger *slog.Logger, model string) bool {
+	virtualKey, ok := r.Context().Value(virtualKeyContextKey{}).(service.VirtualKey)
+	if !ok || virtualKey.AllowsModel(model) {
+		return true
+	}
+	localLogger.Warn("Model not allowed for the virtual key", "key_name", virtualKey.Name, "model", model)
+	http.Error(w, "Model not allowed for this API key", http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// logLevelChange is a log level change, sent as JSON or as the form values of the logs page.
+type logLevelChange struct {
+	Level string `json:"level"`
+	// Target is the kind of override (model or package), or empty for the global level.
+	Target string `json:"target"`
+	Name   string `json:"name"`
+	// Duration is the time after which the change reverts, as a Go duration: the default revert
+	// delay if empty, never if 0.
+	Duration string `json:"duration"`
+}
+
+// logLevelView is the data of the "loglevel" block of the logs template.
+type logLevelView struct {
+	State       service.LogLevelState
+	RevertAfter time.Duration
+	Levels      []string
+	Error       string
+}
+
+// HandleGetLogLevel is the HTTP handler for GET /admin/loglevel. It returns the current log
+// levels and overrides as JSON.
+func (ah *AppHandlers) HandleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	if ah.LogLevels == nil {
+		http.Error(w, "Log levels cannot be changed at runtime", http.StatusNotImplemented)
+		return
+	}
+	ah.writeLogLevel(w, r, "")
+}
+
+// HandleSetLogLevel is the HTTP handler for POST /admin/loglevel. It sets the global log level,
+// or the level of the records of a model or package, from a logLevelChange. It answers with the
+// new levels, as JSON or as the level controls of the logs page for HTMX requests.
+func (ah *AppHandlers) HandleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetReqID(r.Context())
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	if ah.LogLevels == nil {
+		http.Error(w, "Log levels cannot be changed at runtime", http.StatusNotImplemented)
+		return
+	}
+
+	var change logLevelChange
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+	} else {
+		change = logLevelChange{
+			Level:    r.FormValue("level"),
+			Target:   r.FormValue("target"),
+			Name:     strings.TrimSpace(r.FormValue("name")),
+			Duration: r.FormValue("duration"),
+		}
+	}
+
+	if err := ah.applyLogLevelChange(change); err != nil {
+		localLogger.Warn("Invalid log level change", "error", err)
+		if r.Header.Get("HX-Request") == "true" {
+			ah.writeLogLevel(w, r, err.Error())
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	localLogger.Info(
+		"Log level changed",
+		"level", change.Level,
+		"target", change.Target,
+		"name", change.Name,
+		"duration", change.Duration,
+	)
+	ah.writeLogLevel(w, r, "")
+}
+
+// HandleResetLogLevel is the HTTP handler for DELETE /admin/loglevel. It removes the override
+// of the target and name query parameters, or resets the global level to its default without
+// them. It answers like HandleSetLogLevel.
+func (ah *AppHandlers) HandleResetLogLevel(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetReqID(r.Context())
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	if ah.LogLevels == nil {
+		http.Error(w, "Log levels cannot be changed at runtime", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	if target := query.Get("target"); target != "" {
+		ah.LogLevels.RemoveOverride(service.LogLevelTarget{Kind: target, Name: query.Get("name")})
+		localLogger.Info("Log level override removed", "target", target, "name", query.Get("name"))
+	} else {
+		ah.LogLevels.ResetLevel()
+		localLogger.Info("Log level reset", "level", ah.LogLevels.State().DefaultLevel)
+	}
+	ah.writeLogLevel(w, r, "")
+}
+
+// applyLogLevelChange applies change to ah.LogLevels.
+func (ah *AppHandlers) applyLogLevelChange(change logLevelChange) error {
+	level, err := service.ParseLogLevel(change.Level)
+	if err != nil {
+		return err
+	}
+	duration := ah.LogLevels.RevertAfter()
+	if change.Duration != "" {
+		if duration, err = time.ParseDuration(change.Duration); err != nil || duration < 0 {
+			return fmt.Errorf("invalid duration %q (want a Go duration, e.g. 15m, or 0 for no revert)", change.Duration)
+		}
+	}
+	if change.Target == "" {
+		ah.LogLevels.SetLevel(level, duration)
+		return nil
+	}
+	return ah.LogLevels.SetOverride(service.LogLevelTarget{Kind: change.Target, Name: change.Name}, level, duration)
+}
+
+// writeLogLevel writes the current log levels, as the "loglevel" block of the logs template for
+// HTMX requests (with errorMessage), and as JSON otherwise.
+func (ah *AppHandlers) writeLogLevel(w http.ResponseWriter, r *http.Request, errorMessage string) {
+	if r.Header.Get("HX-Request") == "true" && ah.LogsTemplate != nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := ah.LogsTemplate.ExecuteTemplate(w, "loglevel", ah.logLevelView(errorMessage)); err != nil {
+			ah.Logger.Error("Failed to execute log level template", "error", err)
+			http.Error(w, "Failed to render log levels", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ah.LogLevels.State()); err != nil {
+		ah.Logger.Error("Failed to encode log levels", "error", err)
+	}
+}
+
+// logLevelView returns the data of the "loglevel" block of the logs template, or nil if the log
+// levels cannot be changed.
+func (ah *AppHandlers) logLevelView(errorMessage string) *logLevelView {
+	if ah.LogLevels == nil {
+		return nil
+	}
+	return &logLevelView{
+		State:       ah.LogLevels.State(),
+		RevertAfter: ah.LogLevels.RevertAfter(),
+		Levels:      []string{"DEBUG", "INFO", "WARN", "ERROR"},
+		Error:       errorMessage,
+	}
+}
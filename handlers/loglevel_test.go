@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/service"
+)
+
+func TestHandleSetLogLevel(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentType   string
+		body          string
+		wantStatus    int
+		wantLevel     slog.Level
+		wantExpires   bool
+		wantOverrides int
+	}{
+		{"JSON", "application/json", `{"level":"debug","duration":"0"}`, http.StatusOK, slog.LevelDebug, false, 0},
+		{"default duration", "application/json", `{"level":"error"}`, http.StatusOK, slog.LevelError, true, 0},
+		{
+			"form override",
+			"application/x-www-form-urlencoded",
+			url.Values{"level": {"debug"}, "target": {"model"}, "name": {" GPT-4o "}, "duration": {"15m"}}.Encode(),
+			http.StatusOK, slog.LevelInfo, false, 1,
+		},
+		{"invalid level", "application/json", `{"level":"verbose"}`, http.StatusBadRequest, slog.LevelInfo, false, 0},
+		{"invalid duration", "application/json", `{"level":"debug","duration":"-1m"}`, http.StatusBadRequest, slog.LevelInfo, false, 0},
+		{"invalid target", "application/json", `{"level":"debug","target":"user","name":"bob"}`, http.StatusBadRequest, slog.LevelInfo, false, 0},
+		{"invalid JSON", "application/json", `{"level":`, http.StatusBadRequest, slog.LevelInfo, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ah := newTestHandlers(t, &fakeBackend{}, nil)
+			ah.LogLevels = service.NewLogLevelController(slog.LevelInfo, time.Hour)
+
+			r := httptest.NewRequest(http.MethodPost, "/admin/loglevel", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			ah.HandleSetLogLevel(w, r)
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+
+			state := ah.LogLevels.State()
+			assert.Equal(t, tt.wantLevel, state.Level)
+			assert.Equal(t, tt.wantExpires, state.ExpiresAt != nil)
+			assert.Len(t, state.Overrides, tt.wantOverrides)
+			if tt.wantStatus == http.StatusOK {
+				var answered service.LogLevelState
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &answered))
+				assert.Equal(t, state.Level, answered.Level, "the new levels are answered")
+			}
+		})
+	}
+}
+
+func TestHandleResetLogLevel(t *testing.T) {
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+	ah.LogLevels = service.NewLogLevelController(slog.LevelInfo, time.Hour)
+	ah.LogLevels.SetLevel(slog.LevelDebug, 0)
+	target := service.LogLevelTarget{Kind: service.LogTargetPackage, Name: "client"}
+	require.NoError(t, ah.LogLevels.SetOverride(target, slog.LevelDebug, 0))
+
+	w := httptest.NewRecorder()
+	ah.HandleResetLogLevel(w, httptest.NewRequest(http.MethodDelete, "/admin/loglevel?target=package&name=client", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, ah.LogLevels.State().Overrides)
+	assert.Equal(t, slog.LevelDebug, ah.LogLevels.State().Level, "only the override is removed")
+
+	w = httptest.NewRecorder()
+	ah.HandleResetLogLevel(w, httptest.NewRequest(http.MethodDelete, "/admin/loglevel", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, slog.LevelInfo, ah.LogLevels.State().Level)
+}
+
+func TestHandleLogLevelUnavailable(t *testing.T) {
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+	for _, handler := range []http.HandlerFunc{ah.HandleGetLogLevel, ah.HandleSetLogLevel, ah.HandleResetLogLevel} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/admin/loglevel", nil))
+		assert.Equal(t, http.StatusNotImplemented, w.Code)
+	}
+}
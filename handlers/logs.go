@@ -1,15 +1,60 @@
 package handlers
 
 import (
-	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/service"
 )
 
-// HandleLogsPage is the HTTP handler for the /logs endpoint.
+// logsPageMessages are the messages logged while serving the logs page, which are not displayed.
+var logsPageMessages = map[string]bool{
+	"Serving logs page":                        true,
+	"Serving logs page content (HTMX request)": true,
+	"Serving logs stream":                      true,
+	"Logs stream closed":                       true,
+}
+
+// logsStreamKeepAlive is the interval of the comments keeping idle log streams open.
+const logsStreamKeepAlive = 15 * time.Second
+
+// logTimeLayouts are the accepted formats of the since and until filters: RFC 3339 and the
+// format of HTML datetime-local inputs, in UTC.
+var logTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04"}
+
+// logsPageData is the data of the logs template.
+type logsPageData struct {
+	Logs   []service.LogEntry
+	Filter logsFilterForm
+	// FilterQuery is the filter as a query string, for the live stream URL.
+	FilterQuery string
+	Levels      []string
+	// CSRFToken is sent with the HTMX requests of the page, see AdminAuth.
+	CSRFToken string
+	// LogLevel is the data of the log level controls, nil if levels cannot be changed.
+	LogLevel *logLevelView
+}
+
+// logsFilterForm holds the filter query parameters, as entered, to fill the filter form.
+type logsFilterForm struct {
+	Level     string
+	RequestID string
+	Model     string
+	Since     string
+	Until     string
+	Search    string
+}
+
+// HandleLogsPage is the HTTP handler for the /admin/logs endpoint.
 // It serves an HTML page displaying recent server logs.
-// If the request is an HTMX request (HX-Request: true header), it serves only the log entries fragment.
+// If the request is an HTMX request (HX-Request: true header), it serves only the log entries fragment,
+// which follows new entries through HandleLogsStream. Entries are filtered by the query parameters level (minimum level), request_id, model, since and
+// until (RFC 3339, or datetime-local in UTC) and q (free text).
 // Log entries related to serving the logs page itself are filtered out from the display.
 func (ah *AppHandlers) HandleLogsPage(w http.ResponseWriter, r *http.Request) {
 	requestID := middleware.GetReqID(r.Context())
@@ -17,44 +62,48 @@ func (ah *AppHandlers) HandleLogsPage(w http.ResponseWriter, r *http.Request) {
 	// The log message "Serving logs page" (and its HTMX variant) will be filtered out before display.
 	localLogger.Info("Serving logs page")
 
-	if ah.LogsTemplate == nil {
+	if ah.LogsTemplate == nil || ah.RingBufferLogger == nil {
 		http.Error(
 			w,
 			"Logs template not loaded. Check server logs for details.",
 			http.StatusInternalServerError,
 		)
-		localLogger.Error("Logs template is nil, cannot render page.")
+		localLogger.Error("Logs template or buffer is nil, cannot render page.")
 		return
 	}
 
-	rawLogs := ah.RingBufferLogger.GetLogs()
-	filteredLogs := make([]string, 0, len(rawLogs))
-	for _, logStr := range rawLogs {
-		var logEntry map[string]interface{}
-		if err := json.Unmarshal([]byte(logStr), &logEntry); err == nil {
-			if msg, ok := logEntry["msg"].(string); ok {
-				// Filter out messages related to serving the logs page itself and its content
-				if msg == "Serving logs page" || msg == "Serving logs page content (HTMX request)" {
-					continue // Skip this log entry
-				}
-			}
+	filter, form, err := parseLogFilter(r.URL.Query())
+	if err != nil {
+		localLogger.Warn("Invalid logs filter", "error", err)
+		http.Error(w, fmt.Sprintf("Invalid filter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	entries := ah.RingBufferLogger.Query(filter)
+	filteredLogs := make([]service.LogEntry, 0, len(entries))
+	for _, entry := range entries {
+		// Filter out messages related to serving the logs page itself and its content
+		if logsPageMessages[entry.Message] {
+			continue // Skip this log entry
 		}
-		filteredLogs = append(filteredLogs, logStr)
+		filteredLogs = append(filteredLogs, entry)
 	}
 
-	data := struct {
-		Logs []string
-	}{
-		Logs: filteredLogs,
+	data := logsPageData{
+		Logs:        filteredLogs,
+		Filter:      form,
+		FilterQuery: form.query().Encode(),
+		Levels:      []string{"DEBUG", "INFO", "WARN", "ERROR"},
+		CSRFToken:   adminCSRFToken(r.Context()),
+		LogLevel:    ah.logLevelView(""),
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	var err error
 	// Check for HTMX request header
 	if r.Header.Get("HX-Request") == "true" {
 		localLogger.Info("Serving logs page content (HTMX request)")
-		// Render only the "logentries" block for HTMX requests
-		err = ah.LogsTemplate.ExecuteTemplate(w, "logentries", data)
+		// Render only the "logview" block (entries and live stream) for HTMX requests
+		err = ah.LogsTemplate.ExecuteTemplate(w, "logview", data)
 	} else {
 		// Render the full page for normal browser requests
 		err = ah.LogsTemplate.Execute(w, data)
@@ -71,3 +120,150 @@ func (ah *AppHandlers) HandleLogsPage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to render logs page", http.StatusInternalServerError)
 	}
 }
+
+// HandleLogsStream is the HTTP handler for the /admin/logs/stream endpoint. It pushes the new log
+// entries matching the filter query parameters of HandleLogsPage as Server-Sent Events: "log"
+// events carry the rendered entries, and "dropped" events the number of entries skipped because
+// the viewer was too slow.
+func (ah *AppHandlers) HandleLogsStream(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := middleware.GetReqID(ctx)
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	if ah.LogsTemplate == nil || ah.RingBufferLogger == nil {
+		localLogger.Error("Logs template or buffer is nil, cannot stream logs.")
+		http.Error(w, "Logs template not loaded. Check server logs for details.", http.StatusInternalServerError)
+		return
+	}
+	filter, _, err := parseLogFilter(r.URL.Query())
+	if err != nil {
+		localLogger.Warn("Invalid logs filter", "error", err)
+		http.Error(w, fmt.Sprintf("Invalid filter: %v", err), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		localLogger.Error("Streaming unsupported by the server")
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+
+	subscription := ah.RingBufferLogger.Subscribe(0)
+	defer subscription.Close()
+	localLogger.Info("Serving logs stream")
+	defer localLogger.Info("Logs stream closed")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(logsStreamKeepAlive)
+	defer keepAlive.Stop()
+	var reportedDropped uint64
+	var rendered strings.Builder
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case raw, ok := <-subscription.Entries():
+			if !ok {
+				return
+			}
+			if dropped := subscription.Dropped(); dropped != reportedDropped {
+				if err := writeSSEEvent(w, "dropped", fmt.Sprint(dropped-reportedDropped)); err != nil {
+					return
+				}
+				reportedDropped = dropped
+			}
+			entry := service.ParseLogEntry(raw)
+			if logsPageMessages[entry.Message] || !filter.Matches(entry) {
+				continue
+			}
+			rendered.Reset()
+			if err := ah.LogsTemplate.ExecuteTemplate(&rendered, "logentry", entry); err != nil {
+				// Logged on return, so that the stream does not try to render its own error.
+				defer localLogger.Error("Failed to render log entry", "error", err)
+				return
+			}
+			if err := writeSSEEvent(w, "log", rendered.String()); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeSSEEvent writes a Server-Sent Event, splitting data into data lines.
+func writeSSEEvent(w io.Writer, event string, data string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "event: %s\n", event)
+	for _, line := range strings.Split(strings.TrimRight(data, "\n"), "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// query returns the non-empty filters of f as query parameters.
+func (f logsFilterForm) query() url.Values {
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"level":      f.Level,
+		"request_id": f.RequestID,
+		"model":      f.Model,
+		"since":      f.Since,
+		"until":      f.Until,
+		"q":          f.Search,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	return query
+}
+
+// parseLogFilter parses the filter query parameters of the logs page.
+func parseLogFilter(query url.Values) (service.LogFilter, logsFilterForm, error) {
+	form := logsFilterForm{
+		Level:     strings.ToUpper(strings.TrimSpace(query.Get("level"))),
+		RequestID: strings.TrimSpace(query.Get("request_id")),
+		Model:     strings.TrimSpace(query.Get("model")),
+		Since:     strings.TrimSpace(query.Get("since")),
+		Until:     strings.TrimSpace(query.Get("until")),
+		Search:    strings.TrimSpace(query.Get("q")),
+	}
+	filter := service.LogFilter{
+		MinLevel:  form.Level,
+		RequestID: form.RequestID,
+		Model:     form.Model,
+		Search:    form.Search,
+	}
+	var err error
+	if filter.Since, err = parseLogTime(form.Since); err != nil {
+		return filter, form, fmt.Errorf("since: %w", err)
+	}
+	if filter.Until, err = parseLogTime(form.Until); err != nil {
+		return filter, form, fmt.Errorf("until: %w", err)
+	}
+	return filter, form, nil
+}
+
+// parseLogTime parses a time filter in one of the logTimeLayouts; an empty value is the zero time.
+func parseLogTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range logTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (want RFC 3339 or YYYY-MM-DDTHH:MM)", value)
+}
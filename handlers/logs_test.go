@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// newLogsHandlers returns AppHandlers logging with the returned logger and serving its logs, with
+// the logs template of the repository.
+func newLogsHandlers(t *testing.T) (*AppHandlers, *slog.Logger) {
+	t.Helper()
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+	ah.RingBufferLogger = service.NewRingBufferLogWriterWithSize(100)
+	tmpl, err := template.ParseFiles("../templates/logger.html")
+	require.NoError(t, err)
+	ah.LogsTemplate = tmpl
+	ah.Logger = slog.New(slog.NewJSONHandler(ah.RingBufferLogger, nil))
+	return ah, ah.Logger
+}
+
+func TestParseLogFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    service.LogFilter
+		wantErr bool
+	}{
+		{"empty", "", service.LogFilter{}, false},
+		{
+			"fields",
+			"level=warn&request_id=req-1&model=GPT-4o&q=+timeout+",
+			service.LogFilter{MinLevel: "WARN", RequestID: "req-1", Model: "GPT-4o", Search: "timeout"},
+			false,
+		},
+		{
+			"RFC 3339 and datetime-local times",
+			"since=2024-05-01T10:00:00Z&until=2024-05-01T12:30",
+			service.LogFilter{
+				Since: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+				Until: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+			},
+			false,
+		},
+		{"invalid time", "since=yesterday", service.LogFilter{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+			filter, form, err := parseLogFilter(query)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, filter)
+			// The form is sent back as the query of the live stream.
+			again, _, err := parseLogFilter(form.query())
+			require.NoError(t, err)
+			assert.Equal(t, filter, again)
+		})
+	}
+}
+
+func TestHandleLogsPage(t *testing.T) {
+	ah, logger := newLogsHandlers(t)
+	logger.Info("Chat completion served", "request_id", "req-1", "model", "GPT-4o")
+	logger.Warn("Query failed", "request_id", "req-2", "model", "Claude-Sonnet-4")
+
+	tests := []struct {
+		name     string
+		query    string
+		htmx     bool
+		want     []string
+		wantNone []string
+	}{
+		{"all", "", false, []string{"<html", "Chat completion served", "Query failed"}, []string{"Serving logs page"}},
+		{"level", "level=WARN", false, []string{"Query failed"}, []string{"Chat completion served"}},
+		{"search", "q=completion", false, []string{"Chat completion served"}, []string{"Query failed"}},
+		{"request ID", "request_id=req-2", false, []string{"Query failed"}, []string{"Chat completion served"}},
+		{"HTMX fragment", "model=GPT-4o", true, []string{`id="logs-view"`, "Chat completion served"}, []string{"<html", "Query failed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/logs?"+tt.query, nil)
+			if tt.htmx {
+				r.Header.Set("HX-Request", "true")
+			}
+			w := httptest.NewRecorder()
+			ah.HandleLogsPage(w, r)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			for _, want := range tt.want {
+				assert.Contains(t, w.Body.String(), want)
+			}
+			for _, unwanted := range tt.wantNone {
+				assert.NotContains(t, w.Body.String(), unwanted)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	ah.HandleLogsPage(w, httptest.NewRequest(http.MethodGet, "/admin/logs?until=later", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleLogsStream(t *testing.T) {
+	ah, logger := newLogsHandlers(t)
+	server := httptest.NewServer(http.HandlerFunc(ah.HandleLogsStream))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/admin/logs/stream?level=WARN", nil)
+	require.NoError(t, err)
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// The stream is subscribed once its headers are sent.
+	logger.Info("Chat completion served", "request_id", "req-1")
+	logger.Warn("Query failed", "request_id", "req-2")
+
+	// Read the first event.
+	reader := bufio.NewReader(resp.Body)
+	var event string
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && event != "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "event: "); ok {
+			event = value
+		}
+		if value, ok := strings.CutPrefix(line, "data: "); ok {
+			data.WriteString(value + "\n")
+		}
+	}
+	assert.Equal(t, "log", event)
+	assert.Contains(t, data.String(), "Query failed", "the first matching entry is streamed")
+	assert.NotContains(t, data.String(), "Chat completion served")
+}
+
+func TestHandleLogsStreamInvalidFilter(t *testing.T) {
+	ah, _ := newLogsHandlers(t)
+	w := httptest.NewRecorder()
+	ah.HandleLogsStream(w, httptest.NewRequest(http.MethodGet, "/admin/logs/stream?since=soon", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
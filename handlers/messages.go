@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// HandleAnthropicMessages is the HTTP handler for the Anthropic-compatible /v1/messages endpoint.
+// The request is converted to the chat completion equivalent and sent to the Poe bot, and the
+// Poe events are mapped back to Anthropic content blocks, streamed as Anthropic SSE events
+// (message_start, content_block_delta, message_delta, message_stop) or returned as a message.
+// The Poe Platform API Key is read from the x-api-key header, or from a Bearer token.
+func (ah *AppHandlers) HandleAnthropicMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := middleware.GetReqID(ctx)
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	poePlatformAPIKey, err := poeAPIKeyFromRequest(r)
+	if err != nil {
+		localLogger.Warn("Rejected request authentication", "reason", err.Error())
+		writeAnthropicError(w, localLogger, http.StatusUnauthorized, "authentication_error", err.Error())
+		return
+	}
+
+	var anthropicReq types.AnthropicMessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&anthropicReq); err != nil {
+		localLogger.Error("Invalid request body JSON", "error", err)
+		writeAnthropicError(
+			w,
+			localLogger,
+			http.StatusBadRequest,
+			"invalid_request_error",
+			fmt.Sprintf("Invalid request body JSON: %v", err),
+		)
+		return
+	}
+	localLogger.Debug("Decoded Anthropic Messages request", "request_object", anthropicReq)
+
+	chatReq, err := service.TransformAnthropicRequestToChatRequest(&anthropicReq)
+	if err != nil {
+		localLogger.Error("Invalid Anthropic Messages request", "error", err)
+		writeAnthropicError(w, localLogger, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	poeQueryReq, err := service.TransformOpenAIRequestToPoeQuery(
+		chatReq,
+		poePlatformAPIKey,
+		service.GenerateID("conv"),
+		service.GenerateID("msg"),
+	)
+	if err != nil {
+		localLogger.Error("Error transforming Anthropic request to Poe query", "error", err)
+		writeAnthropicError(w, localLogger, http.StatusInternalServerError, "api_error", err.Error())
+		return
+	}
+	if err := ah.checkAttachments(ctx, localLogger, chatReq.Model, poeQueryReq, poePlatformAPIKey); err != nil {
+		localLogger.Warn("Rejected attachments", "reason", err.Error())
+		writeAnthropicError(w, localLogger, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	ah.fitContext(ctx, w, localLogger, chatReq.Model, poeQueryReq, poePlatformAPIKey)
+	if err := ah.uploadDataURIAttachments(ctx, poeQueryReq, poePlatformAPIKey); err != nil {
+		localLogger.Error("Error uploading attachments to Poe", "error", err)
+		writeAnthropicError(w, localLogger, http.StatusBadGateway, "api_error", err.Error())
+		return
+	}
+
+	localLogger.Info(
+		"Processing Anthropic Messages request",
+		"model", anthropicReq.Model,
+		"stream", anthropicReq.Stream,
+	)
+
+	stream := service.NewAnthropicStream(service.GenerateID("msg"), anthropicReq.Model)
+	eventChan, errChan := ah.streamPoeQuery(
+		ctx,
+		w,
+		r,
+		localLogger,
+		anthropicReq.Model,
+		poeQueryReq,
+		poePlatformAPIKey,
+		nil, // The Messages API has no seed.
+	)
+
+	if !anthropicReq.Stream {
+		events, err := collectPoeEvents(ctx, eventChan, errChan)
+		if err != nil {
+			localLogger.Error("Error from Poe stream during Anthropic aggregation", "error", err)
+			writeAnthropicError(w, localLogger, http.StatusBadGateway, "api_error", err.Error())
+			return
+		}
+		for _, poeEvent := range events {
+			botEvent, err := service.DecodePoeEvent(poeEvent)
+			if err != nil {
+				continue // Already logged by DecodePoeEvent
+			}
+			if botEvent.Kind == service.BotEventError {
+				localLogger.Error("Poe bot reported an error", "error_text", botEvent.ErrorText)
+				writeAnthropicError(w, localLogger, http.StatusBadGateway, "api_error", botEvent.ErrorText)
+				return
+			}
+			stream.Handle(botEvent)
+		}
+		stream.Finish()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stream.Message()); err != nil {
+			localLogger.Error("Error encoding Anthropic Messages response", "error", err)
+		}
+		localLogger.Info("Successfully sent non-streaming Anthropic Messages response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		localLogger.Error("Streaming unsupported by the server")
+		writeAnthropicError(w, localLogger, http.StatusInternalServerError, "api_error", "Streaming unsupported!")
+		return
+	}
+
+	if err := writeAnthropicEvents(w, localLogger, stream.Start()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-ctx.Done():
+			localLogger.Info("Client disconnected or context timed out during streaming")
+			return
+		case err, ok := <-errChan:
+			if ok && err != nil {
+				localLogger.Error("Error from Poe stream", "error", err)
+				_ = writeAnthropicEvents(w, localLogger, stream.Fail(err.Error()))
+				flusher.Flush()
+				return
+			}
+			// A closed or nil error channel means the query finished; events are handled below.
+			errChan = nil
+		case poeEvent, ok := <-eventChan:
+			if !ok {
+				localLogger.Info("Poe event channel closed by sender. Stream assumed complete.")
+				_ = writeAnthropicEvents(w, localLogger, stream.Finish())
+				flusher.Flush()
+				return
+			}
+			botEvent, err := service.DecodePoeEvent(poeEvent)
+			if err != nil {
+				continue // Already logged by DecodePoeEvent
+			}
+			switch botEvent.Kind {
+			case service.BotEventError:
+				localLogger.Error("Poe bot reported an error", "error_text", botEvent.ErrorText)
+				_ = writeAnthropicEvents(w, localLogger, stream.Fail(botEvent.ErrorText))
+				flusher.Flush()
+				return
+			case service.BotEventDone:
+				if err := writeAnthropicEvents(w, localLogger, stream.Finish()); err == nil {
+					flusher.Flush()
+				}
+				localLogger.Info("Poe 'done' event processed. Anthropic stream completed.")
+				return
+			default:
+				if err := writeAnthropicEvents(w, localLogger, stream.Handle(botEvent)); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// writeAnthropicEvents writes Anthropic Messages API stream events as named SSE events.
+func writeAnthropicEvents(
+	w http.ResponseWriter,
+	localLogger *slog.Logger,
+	events []types.AnthropicStreamEvent,
+) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			localLogger.Error("Error marshalling Anthropic stream event", "error", err)
+			return fmt.Errorf("error marshalling Anthropic stream event: %w", err)
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			localLogger.Error("Error writing Anthropic stream event", "error", err)
+			return fmt.Errorf("error writing Anthropic stream event: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeAnthropicError writes an error response in the Anthropic API format.
+func writeAnthropicError(
+	w http.ResponseWriter,
+	localLogger *slog.Logger,
+	status int,
+	errorType string,
+	message string,
+) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(types.AnthropicErrorResponse{
+		Type:  "error",
+		Error: types.AnthropicError{Type: errorType, Message: message},
+	}); err != nil {
+		localLogger.Error("Error encoding Anthropic error response", "error", err)
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/types"
+)
+
+func TestHandleAnthropicMessages(t *testing.T) {
+	backend := &fakeBackend{}
+	ah := newTestHandlers(t, backend, nil)
+
+	r := newTestRequest(http.MethodPost, "/v1/messages", `{
+		"model": "Claude-3.5-Sonnet",
+		"max_tokens": 100,
+		"system": "Be brief.",
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`)
+	r.Header.Del("Authorization")
+	r.Header.Set("X-Api-Key", testAPIKey)
+	w := httptest.NewRecorder()
+	ah.HandleAnthropicMessages(w, r)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp types.AnthropicMessagesResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "message", resp.Type)
+	assert.Equal(t, "assistant", resp.Role)
+	require.Len(t, resp.Content, 1)
+	assert.Equal(t, "Hello", resp.Content[0].Text)
+	require.NotNil(t, resp.StopReason)
+	assert.Equal(t, "end_turn", *resp.StopReason)
+
+	queries := backend.Queries()
+	require.Len(t, queries, 1)
+	assert.Equal(t, "Claude-3.5-Sonnet", queries[0].BotName)
+	assert.Equal(t, testAPIKey, queries[0].APIKey, "the key is read from x-api-key")
+	require.Len(t, queries[0].Request.Query, 2)
+	assert.Equal(t, "system", queries[0].Request.Query[0].Role)
+	assert.Equal(t, "Be brief.", queries[0].Request.Query[0].Content)
+}
+
+func TestHandleAnthropicMessagesErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		events     []types.PoeSSEEvent
+		wantStatus int
+		wantType   string
+	}{
+		{"invalid JSON", `{`, nil, http.StatusBadRequest, "invalid_request_error"},
+		{"no messages", `{"model":"Claude-3.5-Sonnet","messages":[]}`, nil, http.StatusBadRequest, "invalid_request_error"},
+		{
+			"bot error",
+			`{"model":"Claude-3.5-Sonnet","messages":[{"role":"user","content":"Hi"}]}`,
+			[]types.PoeSSEEvent{{Event: "error", Data: `{"text":"Bot unavailable"}`}},
+			http.StatusBadGateway,
+			"api_error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{respond: func(string, *types.PoeQueryRequest) []types.PoeSSEEvent { return tt.events }}
+			ah := newTestHandlers(t, backend, nil)
+
+			w := httptest.NewRecorder()
+			ah.HandleAnthropicMessages(w, newTestRequest(http.MethodPost, "/v1/messages", tt.body))
+			assert.Equal(t, tt.wantStatus, w.Code)
+			var resp types.AnthropicErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+			assert.Equal(t, "error", resp.Type)
+			assert.Equal(t, tt.wantType, resp.Error.Type)
+		})
+	}
+}
+
+func TestHandleAnthropicMessagesStream(t *testing.T) {
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+
+	w := httptest.NewRecorder()
+	ah.HandleAnthropicMessages(w, newTestRequest(http.MethodPost, "/v1/messages",
+		`{"model":"Claude-3.5-Sonnet","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"Hi"}]}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+
+	var eventTypes []string
+	var text strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event types.AnthropicStreamEvent
+		require.NoError(t, json.Unmarshal([]byte(data), &event))
+		eventTypes = append(eventTypes, event.Type)
+		if event.Type == "content_block_delta" {
+			text.WriteString(event.Delta.Text)
+		}
+	}
+	assert.Equal(t, []string{
+		"message_start",
+		"content_block_start",
+		"content_block_delta",
+		"content_block_stop",
+		"message_delta",
+		"message_stop",
+	}, eventTypes)
+	assert.Equal(t, "Hello", text.String())
+}
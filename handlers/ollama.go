@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// HandleOllamaChat is the HTTP handler for the Ollama-compatible /api/chat endpoint.
+// Responses are streamed as newline-delimited JSON unless the request sets "stream": false.
+func (ah *AppHandlers) HandleOllamaChat(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetReqID(r.Context())
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	var ollamaReq types.OllamaChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&ollamaReq); err != nil {
+		localLogger.Error("Invalid request body JSON", "error", err)
+		writeOllamaError(w, localLogger, http.StatusBadRequest, fmt.Sprintf("invalid request body JSON: %v", err))
+		return
+	}
+	localLogger.Debug("Decoded Ollama chat request", "request_object", ollamaReq)
+
+	chatReq := service.TransformOllamaChatRequestToChatRequest(&ollamaReq)
+	ah.runOllamaQuery(w, r, localLogger, ollamaReq.Model, chatReq, ollamaReq.Stream, true)
+}
+
+// HandleOllamaGenerate is the HTTP handler for the Ollama-compatible /api/generate endpoint.
+// Responses are streamed as newline-delimited JSON unless the request sets "stream": false.
+func (ah *AppHandlers) HandleOllamaGenerate(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetReqID(r.Context())
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	var ollamaReq types.OllamaGenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&ollamaReq); err != nil {
+		localLogger.Error("Invalid request body JSON", "error", err)
+		writeOllamaError(w, localLogger, http.StatusBadRequest, fmt.Sprintf("invalid request body JSON: %v", err))
+		return
+	}
+	localLogger.Debug("Decoded Ollama generate request", "request_object", ollamaReq)
+
+	if ollamaReq.Prompt == "" {
+		// Ollama clients send an empty prompt to load a model; there is nothing to load for Poe bots.
+		response := ""
+		writeOllamaJSON(w, localLogger, types.OllamaResponse{
+			Model:      ollamaReq.Model,
+			CreatedAt:  time.Now().UTC().Format(time.RFC3339Nano),
+			Response:   &response,
+			Done:       true,
+			DoneReason: "load",
+		})
+		return
+	}
+
+	chatReq := service.TransformOllamaGenerateRequestToChatRequest(&ollamaReq)
+	ah.runOllamaQuery(w, r, localLogger, ollamaReq.Model, chatReq, ollamaReq.Stream, false)
+}
+
+// HandleOllamaTags is the HTTP handler for the Ollama-compatible /api/tags endpoint.
+// It lists the bots of the model catalog as Ollama models.
+func (ah *AppHandlers) HandleOllamaTags(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetReqID(r.Context())
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	now := time.Now()
+	models := ah.Runtime.For(r.Context()).Catalog.List()
+	tagsResp := types.OllamaTagsResponse{Models: make([]types.OllamaModel, 0, len(models))}
+	for _, model := range models {
+		tagsResp.Models = append(tagsResp.Models, service.ModelInfoToOllamaModel(model, now))
+	}
+	writeOllamaJSON(w, localLogger, tagsResp)
+}
+
+// HandleOllamaShow is the HTTP handler for the Ollama-compatible /api/show endpoint.
+// Bots missing from the model catalog are still described, with default details.
+func (ah *AppHandlers) HandleOllamaShow(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetReqID(r.Context())
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	var showReq types.OllamaShowRequest
+	if err := json.NewDecoder(r.Body).Decode(&showReq); err != nil {
+		localLogger.Error("Invalid request body JSON", "error", err)
+		writeOllamaError(w, localLogger, http.StatusBadRequest, fmt.Sprintf("invalid request body JSON: %v", err))
+		return
+	}
+	modelName := showReq.Model
+	if modelName == "" {
+		modelName = showReq.Name
+	}
+	if modelName == "" {
+		writeOllamaError(w, localLogger, http.StatusBadRequest, "model is required")
+		return
+	}
+
+	botName := service.OllamaModelToPoeBot(modelName)
+	model, ok := ah.Runtime.For(r.Context()).Catalog.Get(botName)
+	if !ok {
+		localLogger.Debug("Model not in catalog, describing it with defaults", "bot_name", botName)
+		model = service.ModelInfo{ID: botName}
+	}
+	writeOllamaJSON(w, localLogger, service.ModelInfoToOllamaShow(model, time.Now()))
+}
+
+// ollamaAPIKey returns the Poe Platform API Key for an Ollama request. Ollama clients usually
+// send no credentials; the configured default key is then used if anonymous Ollama requests are
+// allowed (auth.allow_anonymous_ollama), and the request is rejected otherwise.
+func (ah *AppHandlers) ollamaAPIKey(r *http.Request) (string, error) {
+	poePlatformAPIKey, err := poeAPIKeyFromRequest(r)
+	if err == nil {
+		return poePlatformAPIKey, nil
+	}
+	keys := ah.Runtime.For(r.Context()).Keys
+	if !keys.AllowAnonymousOllama() {
+		return "", err
+	}
+	if keys.DefaultPoeAPIKey() == "" {
+		return "", fmt.Errorf("%w, and no default Poe API key is configured", err)
+	}
+	return keys.DefaultPoeAPIKey(), nil
+}
+
+// runOllamaQuery sends the chat request to Poe and writes the Ollama response, shared by /api/chat
+// (isChat, the text is sent in "message") and /api/generate (the text is sent in "response").
+func (ah *AppHandlers) runOllamaQuery(
+	w http.ResponseWriter,
+	r *http.Request,
+	localLogger *slog.Logger,
+	requestModel string,
+	chatReq *types.OpenAIChatCompletionRequest,
+	streamOpt *bool,
+	isChat bool,
+) {
+	ctx := r.Context()
+	startTime := time.Now()
+	stream := streamOpt == nil || *streamOpt // Ollama streams by default.
+
+	poePlatformAPIKey, err := ah.ollamaAPIKey(r)
+	if err != nil {
+		localLogger.Warn("Rejected request authentication", "reason", err.Error())
+		writeOllamaError(w, localLogger, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	poeQueryReq, err := service.TransformOpenAIRequestToPoeQuery(
+		chatReq,
+		poePlatformAPIKey,
+		service.GenerateID("conv"),
+		service.GenerateID("msg"),
+	)
+	if err != nil {
+		localLogger.Error("Error transforming Ollama request to Poe query", "error", err)
+		writeOllamaError(w, localLogger, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := ah.checkAttachments(ctx, localLogger, chatReq.Model, poeQueryReq, poePlatformAPIKey); err != nil {
+		localLogger.Warn("Rejected attachments", "reason", err.Error())
+		writeOllamaError(w, localLogger, http.StatusBadRequest, err.Error())
+		return
+	}
+	ah.fitContext(ctx, w, localLogger, chatReq.Model, poeQueryReq, poePlatformAPIKey)
+	if err := ah.uploadDataURIAttachments(ctx, poeQueryReq, poePlatformAPIKey); err != nil {
+		localLogger.Error("Error uploading attachments to Poe", "error", err)
+		writeOllamaError(w, localLogger, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	localLogger.Info(
+		"Processing Ollama request",
+		"model", chatReq.Model,
+		"stream", stream,
+		"chat", isChat,
+	)
+
+	// chunk builds an Ollama response chunk carrying text (and tool calls, for chat).
+	chunk := func(text string, toolCalls []types.OpenAIToolCall) types.OllamaResponse {
+		resp := types.OllamaResponse{
+			Model:     requestModel,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		if isChat {
+			resp.Message = &types.OllamaMessage{Role: "assistant", Content: text}
+			if len(toolCalls) > 0 {
+				resp.Message.ToolCalls = service.OpenAIToolCallsToOllama(toolCalls)
+			}
+		} else {
+			resp.Response = &text
+		}
+		return resp
+	}
+	finalChunk := func(text string, toolCalls []types.OpenAIToolCall) types.OllamaResponse {
+		resp := chunk(text, toolCalls)
+		resp.Done = true
+		resp.DoneReason = "stop"
+		resp.TotalDuration = time.Since(startTime).Nanoseconds()
+		return resp
+	}
+
+	eventChan, errChan := ah.streamPoeQuery(
+		ctx,
+		w,
+		r,
+		localLogger,
+		chatReq.Model,
+		poeQueryReq,
+		poePlatformAPIKey,
+		chatReq.Seed,
+	)
+
+	if !stream {
+		events, err := collectPoeEvents(ctx, eventChan, errChan)
+		if err != nil {
+			localLogger.Error("Error from Poe stream during Ollama aggregation", "error", err)
+			writeOllamaError(w, localLogger, http.StatusBadGateway, err.Error())
+			return
+		}
+		botResp := service.AggregateBotEvents(events)
+		if botResp.ErrorText != "" {
+			localLogger.Error("Poe bot reported an error", "error_text", botResp.ErrorText)
+			writeOllamaError(w, localLogger, http.StatusBadGateway, botResp.ErrorText)
+			return
+		}
+		writeOllamaJSON(w, localLogger, finalChunk(botResp.Text, botResp.ToolCalls))
+		localLogger.Info("Successfully sent non-streaming Ollama response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		localLogger.Error("Streaming unsupported by the server")
+		writeOllamaError(w, localLogger, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	encoder := json.NewEncoder(w)
+	writeLine := func(line any) bool {
+		if err := encoder.Encode(line); err != nil {
+			localLogger.Error("Error writing Ollama stream line", "error", err)
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	var toolCalls service.ToolCallAccumulator
+	for {
+		select {
+		case <-ctx.Done():
+			localLogger.Info("Client disconnected or context timed out during streaming")
+			return
+		case err, ok := <-errChan:
+			if ok && err != nil {
+				localLogger.Error("Error from Poe stream", "error", err)
+				writeLine(map[string]string{"error": err.Error()})
+				return
+			}
+			// A closed or nil error channel means the query finished; events are handled below.
+			errChan = nil
+		case poeEvent, ok := <-eventChan:
+			if !ok {
+				localLogger.Info("Poe event channel closed by sender. Stream assumed complete.")
+				writeLine(finalChunk("", toolCalls.ToolCalls()))
+				return
+			}
+			botEvent, err := service.DecodePoeEvent(poeEvent)
+			if err != nil {
+				continue // Already logged by DecodePoeEvent
+			}
+			switch botEvent.Kind {
+			case service.BotEventText, service.BotEventReplace:
+				if botEvent.Text != "" && !writeLine(chunk(botEvent.Text, nil)) {
+					return
+				}
+			case service.BotEventFile:
+				if !writeLine(chunk(service.RenderPoeFileDelta(*botEvent.File), nil)) {
+					return
+				}
+			case service.BotEventToolCalls:
+				// Ollama sends complete tool calls, so fragments are accumulated until the end.
+				toolCalls.Add(botEvent.ToolCalls)
+				if botEvent.Text != "" && !writeLine(chunk(botEvent.Text, nil)) {
+					return
+				}
+			case service.BotEventError:
+				localLogger.Error("Poe bot reported an error", "error_text", botEvent.ErrorText)
+				writeLine(map[string]string{"error": botEvent.ErrorText})
+				return
+			case service.BotEventDone:
+				writeLine(finalChunk("", toolCalls.ToolCalls()))
+				localLogger.Info("Poe 'done' event processed. Ollama stream completed.")
+				return
+			}
+		}
+	}
+}
+
+// writeOllamaJSON writes a JSON response body.
+func writeOllamaJSON(w http.ResponseWriter, localLogger *slog.Logger, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		localLogger.Error("Error encoding Ollama response", "error", err)
+	}
+}
+
+// writeOllamaError writes an error response in the Ollama API format.
+func writeOllamaError(w http.ResponseWriter, localLogger *slog.Logger, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"error": message}); err != nil {
+		localLogger.Error("Error encoding Ollama error response", "error", err)
+	}
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// newOllamaRequest returns an Ollama request to path, without credentials as Ollama clients send.
+func newOllamaRequest(method string, path string, body string) *http.Request {
+	r := newTestRequest(method, path, body)
+	r.Header.Del("Authorization")
+	return r
+}
+
+// allowAnonymousOllama makes ah serve Ollama requests without credentials with poeAPIKey.
+func allowAnonymousOllama(ah *AppHandlers, poeAPIKey string) {
+	current := ah.Runtime.Current()
+	next := *current
+	next.Keys = service.NewKeyStore(nil, poeAPIKey, true, true)
+	ah.Runtime.Swap(&next, service.ReloadSourceFile)
+}
+
+func TestHandleOllamaChat(t *testing.T) {
+	backend := &fakeBackend{}
+	ah := newTestHandlers(t, backend, nil)
+	allowAnonymousOllama(ah, testAPIKey)
+
+	w := httptest.NewRecorder()
+	ah.HandleOllamaChat(w, newOllamaRequest(http.MethodPost, "/api/chat", `{
+		"model": "GPT-4o:latest",
+		"messages": [{"role": "user", "content": "Hi"}],
+		"options": {"temperature": 0.5},
+		"stream": false
+	}`))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp types.OllamaResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "GPT-4o:latest", resp.Model)
+	assert.True(t, resp.Done)
+	assert.Equal(t, "stop", resp.DoneReason)
+	require.NotNil(t, resp.Message)
+	assert.Equal(t, "assistant", resp.Message.Role)
+	assert.Equal(t, "Hello", resp.Message.Content)
+
+	queries := backend.Queries()
+	require.Len(t, queries, 1)
+	assert.Equal(t, "GPT-4o", queries[0].BotName, "the :latest tag is stripped")
+	assert.Equal(t, testAPIKey, queries[0].APIKey, "the default key is used")
+	require.NotNil(t, queries[0].Request.Temperature)
+	assert.Equal(t, 0.5, *queries[0].Request.Temperature)
+}
+
+func TestHandleOllamaChatStream(t *testing.T) {
+	backend := &fakeBackend{respond: func(string, *types.PoeQueryRequest) []types.PoeSSEEvent {
+		return []types.PoeSSEEvent{
+			{Event: "text", Data: `{"text":"Hel"}`},
+			{Event: "text", Data: `{"text":"lo"}`},
+			{Event: "done", Data: "{}"},
+		}
+	}}
+	ah := newTestHandlers(t, backend, nil)
+
+	w := httptest.NewRecorder()
+	ah.HandleOllamaChat(w, newTestRequest(http.MethodPost, "/api/chat",
+		`{"model":"GPT-4o","messages":[{"role":"user","content":"Hi"}]}`))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	var lines []types.OllamaResponse
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for scanner.Scan() {
+		var line types.OllamaResponse
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 3)
+	assert.Equal(t, "Hel", lines[0].Message.Content)
+	assert.Equal(t, "lo", lines[1].Message.Content)
+	assert.False(t, lines[1].Done)
+	assert.True(t, lines[2].Done)
+	assert.Equal(t, "stop", lines[2].DoneReason)
+}
+
+func TestHandleOllamaChatCredentials(t *testing.T) {
+	tests := []struct {
+		name       string
+		anonymous  bool
+		defaultKey string
+		wantStatus int
+	}{
+		{"anonymous requests not allowed", false, testAPIKey, http.StatusUnauthorized},
+		{"no default key", true, "", http.StatusUnauthorized},
+		{"anonymous requests allowed", true, testAPIKey, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{}
+			ah := newTestHandlers(t, backend, nil)
+			if tt.anonymous {
+				allowAnonymousOllama(ah, tt.defaultKey)
+			}
+
+			w := httptest.NewRecorder()
+			ah.HandleOllamaChat(w, newOllamaRequest(http.MethodPost, "/api/chat",
+				`{"model":"GPT-4o","messages":[{"role":"user","content":"Hi"}],"stream":false}`))
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus != http.StatusOK {
+				assert.Empty(t, backend.Queries())
+			}
+		})
+	}
+}
+
+func TestHandleOllamaGenerate(t *testing.T) {
+	backend := &fakeBackend{}
+	ah := newTestHandlers(t, backend, nil)
+
+	// Clients load models with an empty prompt, which does not query Poe.
+	w := httptest.NewRecorder()
+	ah.HandleOllamaGenerate(w, newTestRequest(http.MethodPost, "/api/generate", `{"model":"GPT-4o"}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	var loaded types.OllamaResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &loaded))
+	assert.True(t, loaded.Done)
+	assert.Equal(t, "load", loaded.DoneReason)
+	assert.Empty(t, backend.Queries())
+
+	w = httptest.NewRecorder()
+	ah.HandleOllamaGenerate(w, newTestRequest(http.MethodPost, "/api/generate",
+		`{"model":"GPT-4o","system":"Be brief.","prompt":"Hi","stream":false}`))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp types.OllamaResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.NotNil(t, resp.Response)
+	assert.Equal(t, "Hello", *resp.Response)
+	assert.Nil(t, resp.Message)
+
+	queries := backend.Queries()
+	require.Len(t, queries, 1)
+	require.Len(t, queries[0].Request.Query, 2)
+	assert.Equal(t, "system", queries[0].Request.Query[0].Role)
+	assert.Equal(t, "Hi", queries[0].Request.Query[1].Content)
+}
+
+func TestHandleOllamaBotError(t *testing.T) {
+	backend := &fakeBackend{respond: func(string, *types.PoeQueryRequest) []types.PoeSSEEvent {
+		return []types.PoeSSEEvent{{Event: "error", Data: `{"text":"Bot unavailable"}`}}
+	}}
+	ah := newTestHandlers(t, backend, nil)
+
+	w := httptest.NewRecorder()
+	ah.HandleOllamaChat(w, newTestRequest(http.MethodPost, "/api/chat",
+		`{"model":"GPT-4o","messages":[{"role":"user","content":"Hi"}],"stream":false}`))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.JSONEq(t, `{"error":"Bot unavailable"}`, w.Body.String())
+}
+
+func TestHandleOllamaTags(t *testing.T) {
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+
+	w := httptest.NewRecorder()
+	ah.HandleOllamaTags(w, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp types.OllamaTagsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Models, len(ah.Runtime.Current().Catalog.List()))
+	require.NotEmpty(t, resp.Models)
+	assert.NotEmpty(t, resp.Models[0].Name)
+	assert.NotEmpty(t, resp.Models[0].Digest)
+}
+
+func TestHandleOllamaShow(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"catalog model", `{"model":"GPT-4o:latest"}`, http.StatusOK},
+		{"deprecated name", `{"name":"GPT-4o"}`, http.StatusOK},
+		{"unknown model", `{"model":"My-Custom-Bot"}`, http.StatusOK},
+		{"no model", `{}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ah := newTestHandlers(t, &fakeBackend{}, nil)
+
+			w := httptest.NewRecorder()
+			ah.HandleOllamaShow(w, newTestRequest(http.MethodPost, "/api/show", tt.body))
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp types.OllamaShowResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.NotEmpty(t, resp.Details.Family)
+			assert.NotEmpty(t, resp.Capabilities)
+		})
+	}
+}
+
+func TestHandleOllamaShowSettings(t *testing.T) {
+	allowAttachments := false
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+	ah.Runtime.Current().Catalog.SetSettings("GPT-4o", &types.PoeSettingsResponse{
+		AllowAttachments:      &allowAttachments,
+		IntroductionMessage:   "Hi, I am GPT-4o.",
+		ServerBotDependencies: map[string]int{"DALL-E-3": 1},
+	})
+
+	w := httptest.NewRecorder()
+	ah.HandleOllamaShow(w, newTestRequest(http.MethodPost, "/api/show", `{"model":"GPT-4o"}`))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp types.OllamaShowResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, false, resp.ModelInfo["poe.allow_attachments"])
+	assert.Equal(t, "Hi, I am GPT-4o.", resp.ModelInfo["poe.introduction_message"])
+	assert.Equal(t, map[string]any{"DALL-E-3": float64(1)}, resp.ModelInfo["poe.server_bot_dependencies"])
+	assert.NotContains(t, resp.ModelInfo, "poe.context_clear_window_secs")
+	assert.NotContains(t, resp.Capabilities, "vision", "bots refusing attachments have no vision")
+}
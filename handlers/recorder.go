@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// maxRecordedRequestBytes caps the part of a request body kept in a recorded exchange.
+const maxRecordedRequestBytes = 10 << 20 // 10 MiB
+
+// maxRecordedResponseBytes caps the part of a response body kept in a recorded exchange.
+const maxRecordedResponseBytes = 10 << 20 // 10 MiB
+
+// unrecordedHeaders are the request headers left out of recorded exchanges, as they carry credentials.
+var unrecordedHeaders = map[string]bool{
+	"Authorization": true,
+	"X-Api-Key":     true,
+	"Cookie":        true,
+}
+
+// redactedHeaders returns a copy of header where the values of the unrecordedHeaders are
+// replaced, to log it.
+func redactedHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for name := range redacted {
+		if unrecordedHeaders[name] {
+			redacted[name] = []string{"[REDACTED]"}
+		}
+	}
+	return redacted
+}
+
+// RecordExchanges is a middleware recording every request it serves, with the Poe queries it led
+// to and its response, to sinks. It must be installed after middleware.RequestID.
+func RecordExchanges(logger *slog.Logger, sinks ...service.ExchangeSink) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := middleware.GetReqID(r.Context())
+			localLogger := logger.With("request_id", requestID)
+
+			requestBody, err := io.ReadAll(io.LimitReader(r.Body, maxRecordedRequestBytes+1))
+			if err != nil {
+				localLogger.Error("Failed to read request body for recording", "error", err)
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			// The handler reads the captured part of the body again, followed by the rest.
+			r.Body = replayedBody{
+				Reader: io.MultiReader(bytes.NewReader(requestBody), r.Body),
+				Closer: r.Body,
+			}
+			requestTruncated := len(requestBody) > maxRecordedRequestBytes
+			if requestTruncated {
+				requestBody = requestBody[:maxRecordedRequestBytes]
+			}
+
+			exchange := &service.Exchange{
+				ID:      requestID,
+				Time:    time.Now().UTC(),
+				Method:  r.Method,
+				Path:    r.URL.RequestURI(),
+				Headers: make(map[string]string),
+				Request: string(requestBody),
+
+				RequestTruncated: requestTruncated,
+			}
+			for name, values := range r.Header {
+				if !unrecordedHeaders[name] && len(values) > 0 {
+					exchange.Headers[name] = values[0]
+				}
+			}
+
+			capture := &captureResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(capture, r.WithContext(service.WithExchange(r.Context(), exchange)))
+
+			exchange.Status = capture.status
+			exchange.Response = capture.body.String()
+			exchange.Truncated = capture.truncated
+			exchange.Duration = time.Since(exchange.Time)
+			// Queries abandoned by the handler keep draining upstream; the exchange is recorded
+			// once they are over, without holding up the response.
+			go func() {
+				exchange.Wait()
+				for _, sink := range sinks {
+					if err := sink.Record(exchange); err != nil {
+						localLogger.Error("Failed to record exchange", "error", err)
+					}
+				}
+			}()
+		})
+	}
+}
+
+// replayedBody is a request body whose beginning was already read, closing the original body.
+type replayedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// captureResponseWriter is an http.ResponseWriter keeping a copy of the status and body of the
+// response, up to maxRecordedResponseBytes.
+type captureResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	truncated   bool
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (cw *captureResponseWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.status = status
+		cw.wroteHeader = true
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (cw *captureResponseWriter) Write(b []byte) (int, error) {
+	cw.wroteHeader = true
+	if room := maxRecordedResponseBytes - cw.body.Len(); room < len(b) {
+		cw.body.Write(b[:max(room, 0)])
+		cw.truncated = true
+	} else {
+		cw.body.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, so that streaming handlers keep working.
+func (cw *captureResponseWriter) Flush() {
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
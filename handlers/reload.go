@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/service"
+)
+
+// configStatus is the response of the admin configuration endpoints: the outcome of the loads
+// of the runtime configuration, and a summary of the current one.
+type configStatus struct {
+	service.ReloadStatus
+	Models  int `json:"models"`
+	Aliases int `json:"aliases"`
+	Keys    int `json:"keys"`
+}
+
+// HandleConfigStatus is the HTTP handler for GET /admin/config. It returns the outcome of the
+// last reloads of the runtime configuration as JSON.
+func (ah *AppHandlers) HandleConfigStatus(w http.ResponseWriter, r *http.Request) {
+	ah.writeConfigStatus(w, http.StatusOK, ah.Runtime.Status())
+}
+
+// HandleReloadConfig is the HTTP handler for POST /admin/config/reload. It reloads the runtime
+// configuration, and answers like HandleConfigStatus, with a 422 status if the new configuration
+// is invalid, in which case the current one is kept.
+func (ah *AppHandlers) HandleReloadConfig(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetReqID(r.Context())
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	if ah.ReloadConfig == nil {
+		http.Error(w, "The configuration cannot be reloaded", http.StatusNotImplemented)
+		return
+	}
+	localLogger.Info("Configuration reload requested from the admin endpoint")
+	status, err := ah.ReloadConfig(service.ReloadSourceAdmin)
+	if err != nil {
+		ah.writeConfigStatus(w, http.StatusUnprocessableEntity, status)
+		return
+	}
+	ah.writeConfigStatus(w, http.StatusOK, status)
+}
+
+// writeConfigStatus writes status with a summary of the current runtime configuration as JSON.
+func (ah *AppHandlers) writeConfigStatus(w http.ResponseWriter, code int, status service.ReloadStatus) {
+	current := ah.Runtime.Current()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(configStatus{
+		ReloadStatus: status,
+		Models:       len(current.Catalog.List()),
+		Aliases:      current.Router.Aliases(),
+		Keys:         current.Keys.Len(),
+	}); err != nil {
+		ah.Logger.Error("Failed to encode configuration status", "error", err)
+	}
+}
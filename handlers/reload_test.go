@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/service"
+)
+
+func TestHandleConfigStatus(t *testing.T) {
+	ah := newTestHandlers(t, &fakeBackend{}, map[string]string{"fast": "GPT-4o-Mini"})
+
+	rec := httptest.NewRecorder()
+	ah.HandleConfigStatus(rec, newTestRequest(http.MethodGet, "/admin/config", ""))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var status configStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, 1, status.Generation)
+	assert.Equal(t, service.ReloadSourceStartup, status.Source)
+	assert.Empty(t, status.LastError)
+	assert.Equal(t, len(service.DefaultModelCatalog().List()), status.Models)
+	assert.Equal(t, 1, status.Aliases)
+	assert.Equal(t, 0, status.Keys)
+}
+
+func TestHandleReloadConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		reloadErr  error
+		wantStatus int
+		wantAlias  string
+	}{
+		{"valid configuration", nil, http.StatusOK, "Claude-Haiku-3.5"},
+		{"invalid configuration", errors.New("invalid alias"), http.StatusUnprocessableEntity, "GPT-4o-Mini"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ah := newTestHandlers(t, &fakeBackend{}, map[string]string{"fast": "GPT-4o-Mini"})
+			ah.ReloadConfig = func(source string) (service.ReloadStatus, error) {
+				if tt.reloadErr != nil {
+					return ah.Runtime.ReloadFailed(source, tt.reloadErr), tt.reloadErr
+				}
+				current := ah.Runtime.Current()
+				next := *current
+				next.Router = service.NewModelRouter(map[string]string{"fast": "Claude-Haiku-3.5", "smart": "GPT-4o"})
+				return ah.Runtime.Swap(&next, source), nil
+			}
+
+			rec := httptest.NewRecorder()
+			ah.HandleReloadConfig(rec, newTestRequest(http.MethodPost, "/admin/config/reload", ""))
+			require.Equal(t, tt.wantStatus, rec.Code)
+
+			var status configStatus
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+			assert.Equal(t, tt.wantAlias, ah.Runtime.Current().Router.Resolve("fast"))
+			if tt.reloadErr != nil {
+				assert.Equal(t, 1, status.Generation)
+				assert.Contains(t, status.LastError, tt.reloadErr.Error())
+				assert.Equal(t, 1, status.Aliases)
+			} else {
+				assert.Equal(t, 2, status.Generation)
+				assert.Equal(t, service.ReloadSourceAdmin, status.Source)
+				assert.Equal(t, 2, status.Aliases)
+			}
+		})
+	}
+}
+
+func TestHandleReloadConfigUnavailable(t *testing.T) {
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+
+	rec := httptest.NewRecorder()
+	ah.HandleReloadConfig(rec, newTestRequest(http.MethodPost, "/admin/config/reload", ""))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/supergeoff/poepenai/service"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// HandleResponses is the HTTP handler for the OpenAI-compatible /v1/responses endpoint.
+// The request's instructions, input items and tools are converted to the chat completion
+// equivalent and sent to the Poe bot; the Poe events are mapped back to Responses API output
+// items, either streamed as typed events or returned as a single response object.
+// If previous_response_id is set, the conversation of that response is prepended to the input.
+func (ah *AppHandlers) HandleResponses(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := middleware.GetReqID(ctx)
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	poePlatformAPIKey, err := poeAPIKeyFromRequest(r)
+	if err != nil {
+		localLogger.Warn("Rejected request authentication", "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var responsesReq types.ResponsesRequest
+	if err := json.NewDecoder(r.Body).Decode(&responsesReq); err != nil {
+		localLogger.Error("Invalid request body JSON", "error", err)
+		http.Error(w, fmt.Sprintf("Invalid request body JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	localLogger.Debug("Decoded Responses API request", "request_object", responsesReq)
+
+	owner := callerFingerprint(r, poePlatformAPIKey)
+	var history []types.OpenAIMessage
+	conversationID := service.GenerateID("conv")
+	if responsesReq.PreviousResponseID != "" {
+		// Responses of other keys are reported as missing, not to reveal that they exist.
+		previous, ok := ah.ResponseStore.Get(responsesReq.PreviousResponseID)
+		if !ok || !previous.OwnedBy(owner) {
+			localLogger.Warn(
+				"Previous response not found",
+				"previous_response_id", responsesReq.PreviousResponseID,
+			)
+			http.Error(
+				w,
+				fmt.Sprintf("Previous response %s not found", responsesReq.PreviousResponseID),
+				http.StatusNotFound,
+			)
+			return
+		}
+		history = previous.Messages
+		conversationID = previous.ConversationID
+	}
+
+	messages, err := service.ResponsesInputToChatMessages(&responsesReq)
+	if err != nil {
+		localLogger.Error("Invalid Responses API input", "error", err)
+		http.Error(w, fmt.Sprintf("Invalid input: %v", err), http.StatusBadRequest)
+		return
+	}
+	chatReq := service.TransformResponsesRequestToChatRequest(&responsesReq, history, messages)
+
+	poeQueryReq, err := service.TransformOpenAIRequestToPoeQuery(
+		chatReq,
+		poePlatformAPIKey,
+		conversationID,
+		service.GenerateID("msg"),
+	)
+	if err != nil {
+		localLogger.Error("Error transforming Responses request to Poe query", "error", err)
+		http.Error(
+			w,
+			fmt.Sprintf("Error transforming request: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	if err := ah.checkAttachments(ctx, localLogger, chatReq.Model, poeQueryReq, poePlatformAPIKey); err != nil {
+		localLogger.Warn("Rejected attachments", "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ah.fitContext(ctx, w, localLogger, chatReq.Model, poeQueryReq, poePlatformAPIKey)
+	if err := ah.uploadDataURIAttachments(ctx, poeQueryReq, poePlatformAPIKey); err != nil {
+		localLogger.Error("Error uploading attachments to Poe", "error", err)
+		http.Error(w, fmt.Sprintf("Error uploading attachments: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	localLogger.Info(
+		"Processing Responses API request",
+		"model", responsesReq.Model,
+		"stream", responsesReq.Stream,
+		"previous_response_id", responsesReq.PreviousResponseID,
+	)
+
+	stream := service.NewResponsesStream(service.GenerateID("resp"), &responsesReq)
+	eventChan, errChan := ah.streamPoeQuery(
+		ctx,
+		w,
+		r,
+		localLogger,
+		responsesReq.Model,
+		poeQueryReq,
+		poePlatformAPIKey,
+		nil, // The Responses API has no seed.
+	)
+
+	// Messages carried over to later responses exclude the instructions, as they do not carry over.
+	if responsesReq.Instructions != "" {
+		messages = messages[1:]
+	}
+	storeResponse := func() {
+		if responsesReq.Store != nil && !*responsesReq.Store {
+			return
+		}
+		conversation := append(append([]types.OpenAIMessage{}, history...), messages...)
+		conversation = append(
+			conversation,
+			service.ResponsesOutputToChatMessages(stream.Response().Output)...,
+		)
+		ah.ResponseStore.Put(&service.StoredResponse{
+			Response:         stream.Response(),
+			Messages:         conversation,
+			ConversationID:   conversationID,
+			OwnerFingerprint: owner,
+		})
+	}
+
+	if !responsesReq.Stream {
+		events, err := collectPoeEvents(ctx, eventChan, errChan)
+		if err != nil {
+			localLogger.Error("Error from Poe stream during Responses aggregation", "error", err)
+			http.Error(w, fmt.Sprintf("Error from Poe: %v", err), http.StatusBadGateway)
+			return
+		}
+		status := http.StatusOK
+		failed := false
+		for _, poeEvent := range events {
+			botEvent, err := service.DecodePoeEvent(poeEvent)
+			if err != nil {
+				continue // Already logged by DecodePoeEvent
+			}
+			if botEvent.Kind == service.BotEventError {
+				localLogger.Error("Poe bot reported an error", "error_text", botEvent.ErrorText)
+				stream.Fail("server_error", botEvent.ErrorText)
+				status = http.StatusBadGateway
+				failed = true
+				break
+			}
+			stream.Handle(botEvent)
+		}
+		if !failed {
+			stream.Finish()
+			storeResponse()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(stream.Response()); err != nil {
+			localLogger.Error("Error encoding Responses API response", "error", err)
+		}
+		localLogger.Info("Sent non-streaming Responses API response", "status", stream.Response().Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		localLogger.Error("Streaming unsupported by the server")
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeResponsesEvents(w, localLogger, stream.Start()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-ctx.Done():
+			localLogger.Info("Client disconnected or context timed out during streaming")
+			return
+		case err, ok := <-errChan:
+			if ok && err != nil {
+				localLogger.Error("Error from Poe stream", "error", err)
+				_ = writeResponsesEvents(w, localLogger, stream.Fail("server_error", err.Error()))
+				flusher.Flush()
+				return
+			}
+			// A closed or nil error channel means the query finished; events are handled below.
+			errChan = nil
+		case poeEvent, ok := <-eventChan:
+			if !ok {
+				// The stream ended without a "done" event; complete the response anyway.
+				localLogger.Info("Poe event channel closed by sender. Stream assumed complete.")
+				_ = writeResponsesEvents(w, localLogger, stream.Finish())
+				flusher.Flush()
+				storeResponse()
+				return
+			}
+			botEvent, err := service.DecodePoeEvent(poeEvent)
+			if err != nil {
+				continue // Already logged by DecodePoeEvent
+			}
+			switch botEvent.Kind {
+			case service.BotEventError:
+				localLogger.Error("Poe bot reported an error", "error_text", botEvent.ErrorText)
+				_ = writeResponsesEvents(w, localLogger, stream.Fail("server_error", botEvent.ErrorText))
+				flusher.Flush()
+				return
+			case service.BotEventDone:
+				if err := writeResponsesEvents(w, localLogger, stream.Finish()); err == nil {
+					flusher.Flush()
+				}
+				storeResponse()
+				localLogger.Info("Poe 'done' event processed. Responses stream completed.")
+				return
+			default:
+				if err := writeResponsesEvents(w, localLogger, stream.Handle(botEvent)); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// HandleGetResponse is the HTTP handler for GET /v1/responses/{responseID}.
+// It returns a response previously stored by HandleResponses with the same key.
+func (ah *AppHandlers) HandleGetResponse(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetReqID(r.Context())
+	localLogger := ah.Logger.With("request_id", requestID)
+
+	poePlatformAPIKey, err := poeAPIKeyFromRequest(r)
+	if err != nil {
+		localLogger.Warn("Rejected request authentication", "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	responseID := chi.URLParam(r, "responseID")
+	stored, ok := ah.ResponseStore.Get(responseID)
+	if !ok || !stored.OwnedBy(callerFingerprint(r, poePlatformAPIKey)) {
+		http.Error(w, fmt.Sprintf("Response %s not found", responseID), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stored.Response); err != nil {
+		localLogger.Error("Error encoding stored response", "error", err)
+	}
+}
+
+// writeResponsesEvents writes Responses API stream events as named SSE events.
+func writeResponsesEvents(
+	w http.ResponseWriter,
+	localLogger *slog.Logger,
+	events []types.ResponsesStreamEvent,
+) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			localLogger.Error("Error marshalling Responses stream event", "error", err)
+			return fmt.Errorf("error marshalling Responses stream event: %w", err)
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			localLogger.Error("Error writing Responses stream event", "error", err)
+			return fmt.Errorf("error writing Responses stream event: %w", err)
+		}
+	}
+	return nil
+}
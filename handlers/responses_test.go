@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// postResponse sends a Responses API request to ah with apiKey, and decodes the response.
+func postResponse(t *testing.T, ah *AppHandlers, apiKey string, body string) (int, types.ResponsesResponse) {
+	t.Helper()
+	r := newTestRequest(http.MethodPost, "/v1/responses", body)
+	r.Header.Set("Authorization", "Bearer "+apiKey)
+	w := httptest.NewRecorder()
+	ah.HandleResponses(w, r)
+	var resp types.ResponsesResponse
+	if w.Code == http.StatusOK || w.Code == http.StatusBadGateway {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+	}
+	return w.Code, resp
+}
+
+func TestHandleResponses(t *testing.T) {
+	backend := &fakeBackend{}
+	ah := newTestHandlers(t, backend, nil)
+
+	code, first := postResponse(t, ah, testAPIKey, `{"model":"GPT-4o","instructions":"Be brief.","input":"Hi"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "response", first.Object)
+	assert.Equal(t, "completed", first.Status)
+	assert.Equal(t, "Be brief.", first.Instructions)
+	require.Len(t, first.Output, 1)
+	assert.Equal(t, "message", first.Output[0].Type)
+	require.Len(t, first.Output[0].Content, 1)
+	assert.Equal(t, "Hello", first.Output[0].Content[0].Text)
+
+	// A follow-up continues the conversation of the previous response, without its instructions.
+	code, second := postResponse(t, ah, testAPIKey, `{"model":"GPT-4o","input":"How are you?","previous_response_id":"`+first.ID+`"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, first.ID, second.PreviousResponseID)
+	queries := backend.Queries()
+	require.Len(t, queries, 2)
+	assert.Equal(t, queries[0].Request.ConversationID, queries[1].Request.ConversationID)
+	var contents []string
+	for _, message := range queries[1].Request.Query {
+		contents = append(contents, message.Content)
+	}
+	assert.Equal(t, []string{"Hi", "Hello", "How are you?"}, contents)
+
+	// Responses are only served to the key that created them.
+	router := chi.NewRouter()
+	router.Get("/v1/responses/{responseID}", ah.HandleGetResponse)
+	for _, tt := range []struct {
+		apiKey     string
+		wantStatus int
+	}{{testAPIKey, http.StatusOK}, {"another-poe-key", http.StatusNotFound}} {
+		r := newTestRequest(http.MethodGet, "/v1/responses/"+first.ID, "")
+		r.Header.Set("Authorization", "Bearer "+tt.apiKey)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, tt.wantStatus, w.Code)
+	}
+	code, _ = postResponse(t, ah, "another-poe-key", `{"model":"GPT-4o","input":"Hi","previous_response_id":"`+first.ID+`"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestHandleResponsesNotStored(t *testing.T) {
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+	code, resp := postResponse(t, ah, testAPIKey, `{"model":"GPT-4o","input":"Hi","store":false}`)
+	require.Equal(t, http.StatusOK, code)
+	_, ok := ah.ResponseStore.Get(resp.ID)
+	assert.False(t, ok)
+}
+
+func TestHandleResponsesBotError(t *testing.T) {
+	backend := &fakeBackend{respond: func(string, *types.PoeQueryRequest) []types.PoeSSEEvent {
+		return []types.PoeSSEEvent{{Event: "error", Data: `{"text":"Bot unavailable"}`}}
+	}}
+	ah := newTestHandlers(t, backend, nil)
+	code, resp := postResponse(t, ah, testAPIKey, `{"model":"GPT-4o","input":"Hi"}`)
+	assert.Equal(t, http.StatusBadGateway, code)
+	assert.Equal(t, "failed", resp.Status)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, "Bot unavailable", resp.Error.Message)
+	_, ok := ah.ResponseStore.Get(resp.ID)
+	assert.False(t, ok, "failed responses are not stored")
+}
+
+func TestHandleResponsesInvalidInput(t *testing.T) {
+	ah := newTestHandlers(t, &fakeBackend{}, nil)
+	code, _ := postResponse(t, ah, testAPIKey, `{"model":"GPT-4o","input":42}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = postResponse(t, ah, testAPIKey, `{"model":"GPT-4o","input":"Hi","previous_response_id":"resp_unknown"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestHandleResponsesStream(t *testing.T) {
+	backend := &fakeBackend{respond: func(string, *types.PoeQueryRequest) []types.PoeSSEEvent {
+		return []types.PoeSSEEvent{
+			{Event: "text", Data: `{"text":"Hel"}`},
+			{Event: "text", Data: `{"text":"lo"}`},
+			{Event: "done", Data: "{}"},
+		}
+	}}
+	ah := newTestHandlers(t, backend, nil)
+
+	w := httptest.NewRecorder()
+	ah.HandleResponses(w, newTestRequest(http.MethodPost, "/v1/responses", `{"model":"GPT-4o","input":"Hi","stream":true}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+
+	var eventTypes, deltas []string
+	var completed *types.ResponsesResponse
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event types.ResponsesStreamEvent
+		require.NoError(t, json.Unmarshal([]byte(data), &event))
+		assert.Equal(t, len(eventTypes), event.SequenceNumber)
+		eventTypes = append(eventTypes, event.Type)
+		switch event.Type {
+		case "response.output_text.delta":
+			deltas = append(deltas, event.Delta)
+		case "response.completed":
+			completed = event.Response
+		}
+	}
+	require.NotEmpty(t, eventTypes)
+	assert.Equal(t, "response.created", eventTypes[0])
+	assert.Equal(t, "response.completed", eventTypes[len(eventTypes)-1])
+	assert.Equal(t, []string{"Hel", "lo"}, deltas)
+	require.NotNil(t, completed)
+	_, ok := ah.ResponseStore.Get(completed.ID)
+	assert.True(t, ok, "streamed responses are stored")
+}
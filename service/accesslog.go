@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// tokenEvents are the Poe events carrying response content, timing the first token.
+var tokenEvents = map[string]bool{
+	"text":             true,
+	"replace_response": true,
+	"json":             true,
+	"file":             true,
+}
+
+// RequestStats are the details of a request reported by the access log, collected while it is
+// served. It is safe for concurrent use.
+type RequestStats struct {
+	mu         sync.Mutex
+	start      time.Time
+	botName    string
+	retries    int
+	firstToken time.Duration
+}
+
+// NewRequestStats creates the RequestStats of a request started now.
+func NewRequestStats() *RequestStats {
+	return &RequestStats{start: time.Now()}
+}
+
+// requestStatsContextKey is the context key of the RequestStats of a request.
+type requestStatsContextKey struct{}
+
+// WithRequestStats returns a copy of ctx carrying stats.
+func WithRequestStats(ctx context.Context, stats *RequestStats) context.Context {
+	return context.WithValue(ctx, requestStatsContextKey{}, stats)
+}
+
+// RequestStatsFromContext returns the RequestStats of the request of ctx, if any.
+func RequestStatsFromContext(ctx context.Context) (*RequestStats, bool) {
+	stats, ok := ctx.Value(requestStatsContextKey{}).(*RequestStats)
+	return stats, ok
+}
+
+// SetBot records the Poe bot queried for the request; the last one wins.
+func (s *RequestStats) SetBot(botName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.botName = botName
+}
+
+// AddRetry counts a retry of a Poe query.
+func (s *RequestStats) AddRetry() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries++
+}
+
+// markFirstToken records the time to the first token, unless already known.
+func (s *RequestStats) markFirstToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.firstToken == 0 {
+		s.firstToken = time.Since(s.start)
+	}
+}
+
+// Snapshot returns the Poe bot, the number of retries, and the time from the start of the
+// request to the first token (0 if none was received).
+func (s *RequestStats) Snapshot() (botName string, retries int, firstToken time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.botName, s.retries, s.firstToken
+}
+
+// TimeFirstToken forwards the event stream of a Poe query, with the same channel semantics as
+// PoeClient.StreamQuery, recording the time to its first content event in stats.
+func TimeFirstToken(
+	ctx context.Context,
+	stats *RequestStats,
+	upstreamEvents <-chan types.PoeSSEEvent,
+	upstreamErrs <-chan error,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	eventChan := make(chan types.PoeSSEEvent)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(eventChan)
+		defer close(errChan)
+		for upstreamEvents != nil || upstreamErrs != nil {
+			select {
+			case err, ok := <-upstreamErrs:
+				if !ok {
+					upstreamErrs = nil
+					continue
+				}
+				if err != nil {
+					errChan <- err
+				}
+			case event, ok := <-upstreamEvents:
+				if !ok {
+					upstreamEvents = nil
+					continue
+				}
+				if tokenEvents[event.Event] {
+					stats.markFirstToken()
+				}
+				select {
+				case eventChan <- event:
+				case <-ctx.Done():
+					// The client is gone; drain upstream until the query stops.
+				}
+			}
+		}
+	}()
+
+	return eventChan, errChan
+}
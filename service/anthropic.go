@@ -0,0 +1,340 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// TransformAnthropicRequestToChatRequest builds the OpenAI chat completion request equivalent to
+// an Anthropic Messages API request, so that it can go through TransformOpenAIRequestToPoeQuery.
+// Images become image_url parts (base64 sources as data URIs), and tool_use/tool_result blocks
+// are rendered as text like the Responses API function calls.
+func TransformAnthropicRequestToChatRequest(
+	req *types.AnthropicMessagesRequest,
+) (*types.OpenAIChatCompletionRequest, error) {
+	if req == nil {
+		slog.Error("Attempted to transform nil Anthropic request")
+		return nil, fmt.Errorf("anthropic request is nil")
+	}
+	if len(req.Messages) == 0 {
+		return nil, fmt.Errorf("messages must not be empty")
+	}
+
+	var messages []types.OpenAIMessage
+	system, err := anthropicTextContent(req.System)
+	if err != nil {
+		return nil, fmt.Errorf("invalid system: %w", err)
+	}
+	if system != "" {
+		messages = append(messages, types.OpenAIMessage{Role: "system", Content: system})
+	}
+
+	for i, msg := range req.Messages {
+		blocks, err := anthropicContentBlocks(msg.Content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid content of message %d: %w", i, err)
+		}
+		var parts []types.OpenAIContentPart
+		for _, block := range blocks {
+			switch block.Type {
+			case "text":
+				parts = append(parts, types.OpenAIContentPart{Type: "text", Text: block.Text})
+			case "image":
+				if block.Source == nil {
+					return nil, fmt.Errorf("image block of message %d has no source", i)
+				}
+				url := block.Source.URL
+				if block.Source.Type == "base64" {
+					url = fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data)
+				}
+				parts = append(parts, types.OpenAIContentPart{
+					Type:     "image_url",
+					ImageURL: &types.OpenAIImageURL{URL: url},
+				})
+			case "tool_use":
+				parts = append(parts, types.OpenAIContentPart{
+					Type: "text",
+					Text: RenderFunctionCall(block.ID, block.Name, string(block.Input)),
+				})
+			case "tool_result":
+				output, err := anthropicTextContent(block.Content)
+				if err != nil {
+					return nil, fmt.Errorf("invalid tool_result content of message %d: %w", i, err)
+				}
+				if block.IsError {
+					output = "[Error] " + output
+				}
+				parts = append(parts, types.OpenAIContentPart{
+					Type: "text",
+					Text: RenderFunctionCallOutput(block.ToolUseID, output),
+				})
+			default:
+				slog.Warn("Unsupported Anthropic content block type, skipping it", "type", block.Type, "message_index", i)
+			}
+		}
+		messages = append(messages, types.OpenAIMessage{Role: msg.Role, Content: parts})
+	}
+
+	chatReq := &types.OpenAIChatCompletionRequest{
+		Model:    req.Model,
+		Messages: messages,
+		Stream:   req.Stream,
+		Stop:     req.StopSequences,
+	}
+	if req.MaxTokens > 0 {
+		maxTokens := req.MaxTokens
+		chatReq.MaxTokens = &maxTokens
+	}
+	chatReq.Temperature = req.Temperature
+	if req.TopP != nil {
+		chatReq.TopP = *req.TopP
+	}
+	if req.TopK != nil {
+		slog.Warn("Anthropic 'top_k' is not supported by Poe, ignoring it", "top_k", *req.TopK)
+	}
+	if req.Metadata != nil {
+		chatReq.User = req.Metadata.UserID
+	}
+	for _, tool := range req.Tools {
+		chatReq.Tools = append(chatReq.Tools, types.OpenAITool{
+			Type: "function",
+			Function: types.OpenAIFunctionTool{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+	if req.ToolChoice != nil {
+		switch req.ToolChoice.Type {
+		case "auto", "none":
+			chatReq.ToolChoice = req.ToolChoice.Type
+		case "any":
+			chatReq.ToolChoice = "required"
+		case "tool":
+			chatReq.ToolChoice = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]interface{}{"name": req.ToolChoice.Name},
+			}
+		}
+	}
+	return chatReq, nil
+}
+
+// anthropicContentBlocks decodes Anthropic message content, either a string or a list of blocks.
+func anthropicContentBlocks(content any) ([]types.AnthropicContentBlock, error) {
+	switch c := content.(type) {
+	case string:
+		return []types.AnthropicContentBlock{{Type: "text", Text: c}}, nil
+	case []interface{}:
+		contentBytes, err := json.Marshal(c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-encode content blocks: %w", err)
+		}
+		var blocks []types.AnthropicContentBlock
+		if err := json.Unmarshal(contentBytes, &blocks); err != nil {
+			return nil, fmt.Errorf("invalid content blocks: %w", err)
+		}
+		return blocks, nil
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("content must be a string or a list of blocks, got %T", content)
+	}
+}
+
+// anthropicTextContent flattens content made of text (a string or a list of text blocks) into a string.
+func anthropicTextContent(content any) (string, error) {
+	blocks, err := anthropicContentBlocks(content)
+	if err != nil {
+		return "", err
+	}
+	texts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// AnthropicStream turns decoded Poe events into Anthropic Messages API content blocks and stream events.
+// Like ResponsesStream, it serves both streaming and non-streaming responses.
+type AnthropicStream struct {
+	message types.AnthropicMessagesResponse
+	// openBlock is the index of the open content block, or -1 if none is open.
+	openBlock int
+	// toolCallBlocks maps Poe tool call indexes to content block indexes.
+	toolCallBlocks map[int]int
+	toolCalls      ToolCallAccumulator
+	// arguments accumulates the raw JSON arguments of tool_use blocks, by block index.
+	arguments map[int]*strings.Builder
+}
+
+// NewAnthropicStream creates an AnthropicStream for a new message responding to a request for model.
+func NewAnthropicStream(messageID string, model string) *AnthropicStream {
+	return &AnthropicStream{
+		message: types.AnthropicMessagesResponse{
+			ID:      messageID,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   model,
+			Content: []types.AnthropicContentBlock{},
+		},
+		openBlock:      -1,
+		toolCallBlocks: make(map[int]int),
+		arguments:      make(map[int]*strings.Builder),
+	}
+}
+
+// Message returns the message in its current state.
+func (s *AnthropicStream) Message() *types.AnthropicMessagesResponse {
+	return &s.message
+}
+
+// Start returns the message_start event.
+func (s *AnthropicStream) Start() []types.AnthropicStreamEvent {
+	message := s.message
+	message.Content = []types.AnthropicContentBlock{}
+	return []types.AnthropicStreamEvent{{Type: "message_start", Message: &message}}
+}
+
+// Handle processes a decoded Poe event and returns the resulting stream events.
+func (s *AnthropicStream) Handle(botEvent BotEvent) []types.AnthropicStreamEvent {
+	switch botEvent.Kind {
+	case BotEventText:
+		return s.appendText(botEvent.Text)
+	case BotEventReplace:
+		// Anthropic streams cannot retract text already sent, so replacements are appended like the chat endpoint does.
+		slog.Debug("Processing Poe 'replace_response' as text delta for Anthropic stream.")
+		return s.appendText(botEvent.Text)
+	case BotEventFile:
+		return s.appendText(RenderPoeFileDelta(*botEvent.File))
+	case BotEventToolCalls:
+		events := s.appendText(botEvent.Text)
+		return append(events, s.appendToolCalls(botEvent.ToolCalls)...)
+	default:
+		return nil
+	}
+}
+
+// closeBlock returns the content_block_stop event of the open block, if any.
+func (s *AnthropicStream) closeBlock() []types.AnthropicStreamEvent {
+	if s.openBlock < 0 {
+		return nil
+	}
+	event := types.AnthropicStreamEvent{Type: "content_block_stop", Index: intPtr(s.openBlock)}
+	s.openBlock = -1
+	return []types.AnthropicStreamEvent{event}
+}
+
+// appendText appends text to the open text block, opening a new one if needed.
+func (s *AnthropicStream) appendText(text string) []types.AnthropicStreamEvent {
+	if text == "" {
+		return nil
+	}
+	var events []types.AnthropicStreamEvent
+	if s.openBlock < 0 || s.message.Content[s.openBlock].Type != "text" {
+		events = append(events, s.closeBlock()...)
+		s.openBlock = len(s.message.Content)
+		s.message.Content = append(s.message.Content, types.AnthropicContentBlock{Type: "text"})
+		events = append(events, types.AnthropicStreamEvent{
+			Type:         "content_block_start",
+			Index:        intPtr(s.openBlock),
+			ContentBlock: map[string]any{"type": "text", "text": ""},
+		})
+	}
+	s.message.Content[s.openBlock].Text += text
+	return append(events, types.AnthropicStreamEvent{
+		Type:  "content_block_delta",
+		Index: intPtr(s.openBlock),
+		Delta: &types.AnthropicStreamDelta{Type: "text_delta", Text: text},
+	})
+}
+
+// appendToolCalls merges tool call fragments, opening a tool_use block for each new call.
+func (s *AnthropicStream) appendToolCalls(deltas []ToolCallDelta) []types.AnthropicStreamEvent {
+	var events []types.AnthropicStreamEvent
+	started := s.toolCalls.Add(deltas)
+	for i, delta := range deltas {
+		call := s.toolCalls.Call(delta.Index)
+		if started[i] {
+			events = append(events, s.closeBlock()...)
+			if call.ID == "" {
+				call.ID = GenerateID("toolu")
+			}
+			s.openBlock = len(s.message.Content)
+			s.toolCallBlocks[delta.Index] = s.openBlock
+			s.arguments[s.openBlock] = &strings.Builder{}
+			s.message.Content = append(s.message.Content, types.AnthropicContentBlock{
+				Type: "tool_use",
+				ID:   call.ID,
+				Name: call.Function.Name,
+			})
+			events = append(events, types.AnthropicStreamEvent{
+				Type:  "content_block_start",
+				Index: intPtr(s.openBlock),
+				ContentBlock: map[string]any{
+					"type":  "tool_use",
+					"id":    call.ID,
+					"name":  call.Function.Name,
+					"input": map[string]any{},
+				},
+			})
+		}
+		blockIndex := s.toolCallBlocks[delta.Index]
+		s.message.Content[blockIndex].Name = call.Function.Name
+		if delta.Arguments != "" {
+			s.arguments[blockIndex].WriteString(delta.Arguments)
+			partialJSON := delta.Arguments
+			events = append(events, types.AnthropicStreamEvent{
+				Type:  "content_block_delta",
+				Index: intPtr(blockIndex),
+				Delta: &types.AnthropicStreamDelta{Type: "input_json_delta", PartialJSON: &partialJSON},
+			})
+		}
+	}
+	return events
+}
+
+// Finish closes the open block, fills in the tool_use inputs and the stop reason, and returns
+// the final message_delta and message_stop events.
+func (s *AnthropicStream) Finish() []types.AnthropicStreamEvent {
+	events := s.closeBlock()
+	stopReason := "end_turn"
+	for blockIndex, arguments := range s.arguments {
+		stopReason = "tool_use"
+		input := json.RawMessage(arguments.String())
+		if len(input) == 0 {
+			input = json.RawMessage("{}")
+		} else if !json.Valid(input) {
+			slog.Warn(
+				"Tool call arguments from Poe are not valid JSON, using an empty input",
+				"tool_name", s.message.Content[blockIndex].Name,
+			)
+			input = json.RawMessage("{}")
+		}
+		s.message.Content[blockIndex].Input = input
+	}
+	s.message.StopReason = &stopReason
+	return append(events,
+		types.AnthropicStreamEvent{
+			Type:  "message_delta",
+			Delta: &types.AnthropicStreamDelta{StopReason: &stopReason},
+			Usage: &types.AnthropicUsage{}, // Poe does not provide token usage.
+		},
+		types.AnthropicStreamEvent{Type: "message_stop"},
+	)
+}
+
+// Fail returns the error event reporting that the bot failed to respond.
+func (s *AnthropicStream) Fail(message string) []types.AnthropicStreamEvent {
+	return []types.AnthropicStreamEvent{{
+		Type:  "error",
+		Error: &types.AnthropicError{Type: "api_error", Message: message},
+	}}
+}
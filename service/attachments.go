@@ -0,0 +1,73 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// OpenAIImageURLToPoeAttachment maps the URL of an OpenAI image_url content part to a Poe attachment.
+// The URL is kept as is, so data URIs must be uploaded to Poe (see IsDataURI) before the query is sent.
+// index is the position of the image in its message, used to name the attachment.
+func OpenAIImageURLToPoeAttachment(url string, index int) types.PoeAttachment {
+	contentType := "image/png"
+	if mediaType, ok := DataURIMediaType(url); ok {
+		contentType = mediaType
+	} else if ext := path.Ext(strings.SplitN(url, "?", 2)[0]); ext != "" {
+		if byExt := mime.TypeByExtension(ext); byExt != "" {
+			contentType = byExt
+		}
+	}
+	name := fmt.Sprintf("image-%d", index+1)
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		name += exts[0]
+	}
+	return types.PoeAttachment{URL: url, ContentType: contentType, Name: name}
+}
+
+// IsDataURI reports whether url is a data URI (e.g., "data:image/png;base64,...").
+func IsDataURI(url string) bool {
+	return strings.HasPrefix(url, "data:")
+}
+
+// dataURIHeader returns the media type and the payload of a base64 data URI, without decoding
+// the payload. ok is false if url is not a base64 data URI.
+func dataURIHeader(url string) (mediaType string, payload string, ok bool) {
+	if !IsDataURI(url) {
+		return "", "", false
+	}
+	header, payload, found := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !found || !strings.HasSuffix(header, ";base64") {
+		return "", "", false
+	}
+	mediaType = strings.TrimSuffix(header, ";base64")
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	return mediaType, payload, true
+}
+
+// DataURIMediaType returns the media type of a base64 data URI, reading only its header.
+// ok is false if url is not a base64 data URI.
+func DataURIMediaType(url string) (mediaType string, ok bool) {
+	mediaType, _, ok = dataURIHeader(url)
+	return mediaType, ok
+}
+
+// ParseDataURI decodes a base64 data URI and returns its media type and content.
+// ok is false if url is not a valid base64 data URI.
+func ParseDataURI(url string) (mediaType string, data []byte, ok bool) {
+	mediaType, payload, ok := dataURIHeader(url)
+	if !ok {
+		return "", nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", nil, false
+	}
+	return mediaType, data, true
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDataURI(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		wantMediaType string
+		wantData      string
+		wantOK        bool
+	}{
+		{"png", "data:image/png;base64,aGVsbG8=", "image/png", "hello", true},
+		{"no media type", "data:;base64,aGVsbG8=", "application/octet-stream", "hello", true},
+		{"not base64", "data:text/plain,hello", "", "", false},
+		{"invalid base64", "data:image/png;base64,!!!", "", "", false},
+		{"no payload separator", "data:image/png;base64", "", "", false},
+		{"regular URL", "https://example.com/cat.png", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mediaType, data, ok := ParseDataURI(tt.url)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantMediaType, mediaType)
+			assert.Equal(t, tt.wantData, string(data))
+		})
+	}
+}
+
+func TestOpenAIImageURLToPoeAttachment(t *testing.T) {
+	tests := []struct {
+		name            string
+		url             string
+		wantContentType string
+	}{
+		// The payload is not decoded to find the media type.
+		{"data URI", "data:image/jpeg;base64,not-decoded", "image/jpeg"},
+		{"URL with extension", "https://example.com/cat.gif?size=large", "image/gif"},
+		{"URL without extension", "https://example.com/cat", "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachment := OpenAIImageURLToPoeAttachment(tt.url, 0)
+			assert.Equal(t, tt.wantContentType, attachment.ContentType)
+			assert.Equal(t, tt.url, attachment.URL)
+		})
+	}
+}
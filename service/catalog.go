@@ -0,0 +1,124 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// ModelInfo describes a Poe bot exposed as a model by the adapter's front-ends.
+type ModelInfo struct {
+	// ID is the Poe bot name, used as the model name by clients.
+	ID string `json:"id"`
+	// OwnedBy is the organization providing the underlying model.
+	OwnedBy string `json:"owned_by,omitempty"`
+	// Family is the model family, e.g., "gpt" or "claude".
+	Family string `json:"family,omitempty"`
+	// Description is a short human-readable description of the bot.
+	Description string `json:"description,omitempty"`
+	// ContextWindow is the size of the bot's context window in tokens, 0 if unknown.
+	ContextWindow int `json:"context_window,omitempty"`
+	// Vision indicates whether the bot accepts image inputs.
+	Vision bool `json:"vision,omitempty"`
+	// ImageGeneration indicates whether the bot generates images.
+	ImageGeneration bool `json:"image_generation,omitempty"`
+	// Settings are the settings the bot returned to a settings request, nil until they are
+	// fetched. They must not be modified.
+	Settings *types.PoeSettingsResponse `json:"settings,omitempty"`
+}
+
+// ModelCatalog is the list of Poe bots known to the adapter. It is safe for concurrent use.
+type ModelCatalog struct {
+	mu     sync.RWMutex
+	models map[string]ModelInfo
+}
+
+// NewModelCatalog creates a ModelCatalog holding the given models.
+func NewModelCatalog(models []ModelInfo) *ModelCatalog {
+	catalog := &ModelCatalog{models: make(map[string]ModelInfo, len(models))}
+	for _, model := range models {
+		catalog.models[model.ID] = model
+	}
+	return catalog
+}
+
+// DefaultModelCatalog returns a catalog of commonly used Poe bots.
+func DefaultModelCatalog() *ModelCatalog {
+	return NewModelCatalog([]ModelInfo{
+		{ID: "GPT-4o", OwnedBy: "openai", Family: "gpt", ContextWindow: 128000, Vision: true},
+		{ID: "GPT-4o-Mini", OwnedBy: "openai", Family: "gpt", ContextWindow: 128000, Vision: true},
+		{ID: "GPT-4.1", OwnedBy: "openai", Family: "gpt", ContextWindow: 1000000, Vision: true},
+		{ID: "o3-mini", OwnedBy: "openai", Family: "gpt", ContextWindow: 200000},
+		{ID: "Claude-3.7-Sonnet", OwnedBy: "anthropic", Family: "claude", ContextWindow: 200000, Vision: true},
+		{ID: "Claude-3.5-Haiku", OwnedBy: "anthropic", Family: "claude", ContextWindow: 200000},
+		{ID: "Gemini-2.0-Flash", OwnedBy: "google", Family: "gemini", ContextWindow: 1000000, Vision: true},
+		{ID: "Llama-3.3-70B", OwnedBy: "meta", Family: "llama", ContextWindow: 128000},
+		{ID: "DeepSeek-R1", OwnedBy: "deepseek", Family: "deepseek", ContextWindow: 64000},
+		{ID: "DALL-E-3", OwnedBy: "openai", Family: "dall-e", ImageGeneration: true},
+		{ID: "FLUX-pro-1.1", OwnedBy: "black-forest-labs", Family: "flux", ImageGeneration: true},
+	})
+}
+
+// List returns all models, sorted by ID.
+func (c *ModelCatalog) List() []ModelInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	models := make([]ModelInfo, 0, len(c.models))
+	for _, model := range c.models {
+		models = append(models, model)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models
+}
+
+// Get returns the model with the given ID. Lookups are case-insensitive, as Poe bot names are.
+func (c *ModelCatalog) Get(id string) (ModelInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if model, ok := c.models[id]; ok {
+		return model, true
+	}
+	for modelID, model := range c.models {
+		if strings.EqualFold(modelID, id) {
+			return model, true
+		}
+	}
+	return ModelInfo{}, false
+}
+
+// Put adds or replaces a model in the catalog.
+func (c *ModelCatalog) Put(model ModelInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.models[model.ID] = model
+}
+
+// SetSettings records the settings fetched from the bot of the model with the given ID, looked up
+// like Get. A bot that does not accept attachments loses its Vision capability. It returns false
+// if the model is not in the catalog.
+func (c *ModelCatalog) SetSettings(id string, settings *types.PoeSettingsResponse) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	modelID := id
+	if _, ok := c.models[modelID]; !ok {
+		modelID = ""
+		for candidate := range c.models {
+			if strings.EqualFold(candidate, id) {
+				modelID = candidate
+				break
+			}
+		}
+		if modelID == "" {
+			return false
+		}
+	}
+	model := c.models[modelID]
+	model.Settings = settings
+	if settings.AllowAttachments != nil && !*settings.AllowAttachments {
+		model.Vision = false
+	}
+	c.models[modelID] = model
+	return true
+}
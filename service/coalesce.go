@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// QueryCoalescedHeader is set to "true" on responses that shared the upstream query of an
+// identical in-flight request.
+const QueryCoalescedHeader = "X-Poe-Coalesced"
+
+// QueryCoalescingStats counts the queries of a QueryCoalescer: the leaders sent upstream and the
+// followers that joined them.
+type QueryCoalescingStats struct {
+	Leaders   int64 `json:"leaders"`
+	Followers int64 `json:"followers"`
+}
+
+// CoalesceConfig configures the coalescing of identical in-flight queries.
+type CoalesceConfig struct {
+	// Enabled turns coalescing on.
+	Enabled bool
+	// NonDeterministic also coalesces queries that are not deterministic, whose responses would
+	// otherwise differ. Only queries with an explicit temperature of 0 or a seed are
+	// deterministic: bots sample with their own default temperature when none is set.
+	NonDeterministic bool
+}
+
+// QueryCoalescer lets identical concurrent queries share one upstream query: the first one (the
+// leader) is sent to Poe and its events are fanned out to every query joining while it is in flight.
+// Late joiners first receive the events already sent. It is safe for concurrent use.
+type QueryCoalescer struct {
+	config CoalesceConfig
+
+	mu      sync.Mutex
+	flights map[string]*flight
+	stats   QueryCoalescingStats
+}
+
+// flight is an upstream query shared by the queries with the same key.
+type flight struct {
+	coalescer *QueryCoalescer
+	key       string
+	cancel    context.CancelFunc // Cancels the upstream query.
+
+	mu          sync.Mutex
+	events      []types.PoeSSEEvent
+	err         error
+	done        bool
+	updated     chan struct{} // Closed and replaced whenever events, err or done change.
+	subscribers int
+	abandoned   bool // Set when every subscriber left; the flight can no longer be joined.
+}
+
+// NewQueryCoalescer creates a QueryCoalescer.
+func NewQueryCoalescer(config CoalesceConfig) *QueryCoalescer {
+	return &QueryCoalescer{config: config, flights: make(map[string]*flight)}
+}
+
+// Eligible reports whether poeQuery may share an upstream query. seeded reports whether the client
+// request has a seed, which must then be part of the key given to Join (see CanonicalQueryKey),
+// as queries only differing by their seed are not identical.
+func (c *QueryCoalescer) Eligible(poeQuery *types.PoeQueryRequest, seeded bool) bool {
+	if !c.config.Enabled {
+		return false
+	}
+	if c.config.NonDeterministic || seeded {
+		return true
+	}
+	return poeQuery.Temperature != nil && *poeQuery.Temperature == 0
+}
+
+// Stats returns the counts of the queries of c.
+func (c *QueryCoalescer) Stats() QueryCoalescingStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// CoalesceQuery implements client.QueryCoalescer: queries eligible with the seed of options (see
+// Eligible) join the in-flight query with the same CanonicalQueryKey.
+func (c *QueryCoalescer) CoalesceQuery(
+	ctx context.Context,
+	botName string,
+	poeQuery *types.PoeQueryRequest,
+	apiKey string,
+	options client.QueryOptions,
+	start client.QueryStarter,
+) (<-chan types.PoeSSEEvent, <-chan error, bool) {
+	if !c.Eligible(poeQuery, options.Seed != nil) {
+		eventChan, errChan := start(ctx)
+		return eventChan, errChan, false
+	}
+	key := CanonicalQueryKey(botName, poeQuery, apiKey, options.Seed)
+	eventChan, errChan, joined := c.Join(ctx, key, start)
+	if joined {
+		slog.Info("Sharing the in-flight Poe query of an identical request", "bot_name", botName, "query_key", key)
+	}
+	return eventChan, errChan, joined
+}
+
+// Join returns the events of the in-flight query with the given key, or starts it with start if
+// there is none. The upstream query runs until it completes or until every joined query is
+// cancelled. joined reports whether an in-flight query was joined.
+func (c *QueryCoalescer) Join(
+	ctx context.Context,
+	key string,
+	start client.QueryStarter,
+) (eventChan <-chan types.PoeSSEEvent, errChan <-chan error, joined bool) {
+	c.mu.Lock()
+	f, joined := c.flights[key]
+	if joined {
+		f.mu.Lock()
+		joined = !f.abandoned
+		if joined {
+			f.subscribers++
+		}
+		f.mu.Unlock()
+	}
+	if joined {
+		c.stats.Followers++
+	} else {
+		// The upstream query must outlive the leader if followers are still waiting.
+		upstreamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{
+			coalescer:   c,
+			key:         key,
+			cancel:      cancel,
+			updated:     make(chan struct{}),
+			subscribers: 1,
+		}
+		c.flights[key] = f
+		c.stats.Leaders++
+		upstreamEvents, upstreamErrs := start(upstreamCtx)
+		go f.pump(upstreamEvents, upstreamErrs)
+	}
+	c.mu.Unlock()
+
+	eventChan, errChan = f.subscribe(ctx)
+	return eventChan, errChan, joined
+}
+
+// pump buffers the events of the upstream query of f, and removes f once the query is over.
+func (f *flight) pump(
+	upstreamEvents <-chan types.PoeSSEEvent,
+	upstreamErrs <-chan error,
+) {
+	var upstreamErr error
+	for upstreamEvents != nil || upstreamErrs != nil {
+		select {
+		case err, ok := <-upstreamErrs:
+			if !ok {
+				upstreamErrs = nil
+				continue
+			}
+			if err != nil {
+				upstreamErr = err
+			}
+		case event, ok := <-upstreamEvents:
+			if !ok {
+				upstreamEvents = nil
+				continue
+			}
+			f.mu.Lock()
+			f.events = append(f.events, event)
+			f.broadcastLocked()
+			f.mu.Unlock()
+		}
+	}
+
+	f.remove() // Later identical queries start a new flight.
+
+	f.mu.Lock()
+	f.err = upstreamErr
+	f.done = true
+	f.broadcastLocked()
+	f.mu.Unlock()
+	f.cancel()
+}
+
+// broadcastLocked wakes up the subscribers of f. f.mu must be held.
+func (f *flight) broadcastLocked() {
+	close(f.updated)
+	f.updated = make(chan struct{})
+}
+
+// remove removes f from the in-flight queries of its coalescer.
+func (f *flight) remove() {
+	f.coalescer.mu.Lock()
+	defer f.coalescer.mu.Unlock()
+	if f.coalescer.flights[f.key] == f {
+		delete(f.coalescer.flights, f.key)
+	}
+}
+
+// leave unsubscribes a cancelled query from f. If it was the last one, the upstream query is
+// cancelled and f is abandoned, so that later identical queries start a new flight.
+func (f *flight) leave() {
+	f.mu.Lock()
+	f.subscribers--
+	abandon := f.subscribers == 0 && !f.done
+	if abandon {
+		f.abandoned = true
+	}
+	f.mu.Unlock()
+	if abandon {
+		f.remove()
+		f.cancel()
+	}
+}
+
+// subscribe streams the events of f, from the first one, with the channel semantics of
+// PoeClient.StreamQuery.
+func (f *flight) subscribe(ctx context.Context) (<-chan types.PoeSSEEvent, <-chan error) {
+	eventChan := make(chan types.PoeSSEEvent)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(eventChan)
+		defer close(errChan)
+
+		next := 0
+		for {
+			f.mu.Lock()
+			if next < len(f.events) {
+				event := f.events[next]
+				f.mu.Unlock()
+				next++
+				select {
+				case eventChan <- event:
+					continue
+				case <-ctx.Done():
+					f.leave()
+					errChan <- fmt.Errorf("context cancelled while sending coalesced event: %w", ctx.Err())
+					return
+				}
+			}
+			if f.done {
+				err := f.err
+				f.mu.Unlock()
+				if err != nil {
+					errChan <- err
+				}
+				return
+			}
+			updated := f.updated
+			f.mu.Unlock()
+
+			select {
+			case <-updated:
+			case <-ctx.Done():
+				f.leave()
+				errChan <- fmt.Errorf("context cancelled while waiting for coalesced events: %w", ctx.Err())
+				return
+			}
+		}
+	}()
+
+	return eventChan, errChan
+}
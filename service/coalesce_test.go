@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/types"
+)
+
+func TestQueryCoalescerEligible(t *testing.T) {
+	zero, half := 0.0, 0.5
+	tests := []struct {
+		name        string
+		config      CoalesceConfig
+		temperature *float64
+		seeded      bool
+		want        bool
+	}{
+		{"disabled", CoalesceConfig{}, &zero, true, false},
+		{"no temperature", CoalesceConfig{Enabled: true}, nil, false, false},
+		{"zero temperature", CoalesceConfig{Enabled: true}, &zero, false, true},
+		{"non-zero temperature", CoalesceConfig{Enabled: true}, &half, false, false},
+		{"seeded", CoalesceConfig{Enabled: true}, &half, true, true},
+		{"non-deterministic", CoalesceConfig{Enabled: true, NonDeterministic: true}, nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coalescer := NewQueryCoalescer(tt.config)
+			poeQuery := &types.PoeQueryRequest{Temperature: tt.temperature}
+			assert.Equal(t, tt.want, coalescer.Eligible(poeQuery, tt.seeded))
+		})
+	}
+}
+
+// fakeUpstream is a QueryStarter whose events are sent by the test.
+type fakeUpstream struct {
+	mu      sync.Mutex
+	starts  int
+	events  chan types.PoeSSEEvent
+	errs    chan error
+	started chan context.Context
+}
+
+func newFakeUpstream() *fakeUpstream {
+	return &fakeUpstream{started: make(chan context.Context, 10)}
+}
+
+func (u *fakeUpstream) start(ctx context.Context) (<-chan types.PoeSSEEvent, <-chan error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.starts++
+	u.events = make(chan types.PoeSSEEvent)
+	u.errs = make(chan error, 1)
+	u.started <- ctx
+	return u.events, u.errs
+}
+
+// finish closes the channels of the last upstream query, failing it with err if not nil.
+func (u *fakeUpstream) finish(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err != nil {
+		u.errs <- err
+	}
+	close(u.events)
+	close(u.errs)
+}
+
+// collect reads every event and the error of a coalesced query.
+func collect(t *testing.T, eventChan <-chan types.PoeSSEEvent, errChan <-chan error) ([]string, error) {
+	t.Helper()
+	var texts []string
+	for event := range eventChan {
+		texts = append(texts, event.Data)
+	}
+	return texts, <-errChan
+}
+
+func TestQueryCoalescerJoin(t *testing.T) {
+	upstream := newFakeUpstream()
+	coalescer := NewQueryCoalescer(CoalesceConfig{Enabled: true})
+	ctx := context.Background()
+
+	leaderEvents, leaderErrs, joined := coalescer.Join(ctx, "key", upstream.start)
+	require.False(t, joined, "first query joined an in-flight query")
+	<-upstream.started
+	upstream.events <- types.PoeSSEEvent{Event: "text", Data: "a"}
+
+	// The follower joins after the first event and must still receive it.
+	followerEvents, followerErrs, joined := coalescer.Join(ctx, "key", upstream.start)
+	require.True(t, joined, "identical query did not join the in-flight query")
+	upstream.events <- types.PoeSSEEvent{Event: "text", Data: "b"}
+	upstreamErr := errors.New("upstream failed")
+	upstream.finish(upstreamErr)
+
+	for name, channels := range map[string]struct {
+		events <-chan types.PoeSSEEvent
+		errs   <-chan error
+	}{
+		"leader":   {leaderEvents, leaderErrs},
+		"follower": {followerEvents, followerErrs},
+	} {
+		texts, err := collect(t, channels.events, channels.errs)
+		assert.Equal(t, []string{"a", "b"}, texts, "%s events", name)
+		assert.ErrorIs(t, err, upstreamErr, "%s error", name)
+	}
+	assert.Equal(t, 1, upstream.starts, "upstream starts")
+
+	// Once the query is over, an identical query starts a new one.
+	_, _, joined = coalescer.Join(ctx, "key", upstream.start)
+	assert.False(t, joined, "query joined a completed query")
+	<-upstream.started
+	upstream.finish(nil)
+}
+
+func TestQueryCoalescerCancellation(t *testing.T) {
+	upstream := newFakeUpstream()
+	coalescer := NewQueryCoalescer(CoalesceConfig{Enabled: true})
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	_, leaderErrs, _ := coalescer.Join(leaderCtx, "key", upstream.start)
+	upstreamCtx := <-upstream.started
+	followerCtx, cancelFollower := context.WithCancel(context.Background())
+	_, followerErrs, _ := coalescer.Join(followerCtx, "key", upstream.start)
+
+	// The upstream query outlives the leader while a follower waits.
+	cancelLeader()
+	assert.ErrorIs(t, <-leaderErrs, context.Canceled, "leader error")
+	select {
+	case <-upstreamCtx.Done():
+		require.Fail(t, "upstream query cancelled while a follower waits")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// It is cancelled when the last query leaves, and can no longer be joined.
+	cancelFollower()
+	assert.ErrorIs(t, <-followerErrs, context.Canceled, "follower error")
+	select {
+	case <-upstreamCtx.Done():
+	case <-time.After(time.Second):
+		require.Fail(t, "upstream query not cancelled after every query left")
+	}
+	_, _, joined := coalescer.Join(context.Background(), "key", upstream.start)
+	assert.False(t, joined, "query joined an abandoned query")
+	<-upstream.started
+	upstream.finish(nil)
+}
+
+func TestQueryCoalescerSeeds(t *testing.T) {
+	coalescer := NewQueryCoalescer(CoalesceConfig{Enabled: true})
+	temperature := 0.7
+	query := &types.PoeQueryRequest{Query: []types.PoeProtocolMessage{{Role: "user", Content: "Pick a number"}}, Temperature: &temperature}
+	seed1, seed2 := 1, 2
+	require.True(t, coalescer.Eligible(query, true))
+
+	upstream1, upstream2 := newFakeUpstream(), newFakeUpstream()
+	_, _, joined := coalescer.Join(context.Background(), CanonicalQueryKey("GPT-4o", query, "key", &seed1), upstream1.start)
+	require.False(t, joined)
+	<-upstream1.started
+	// A concurrent query with another seed gets its own upstream query.
+	_, _, joined = coalescer.Join(context.Background(), CanonicalQueryKey("GPT-4o", query, "key", &seed2), upstream2.start)
+	assert.False(t, joined, "query with another seed joined the in-flight query")
+	<-upstream2.started
+	upstream1.finish(nil)
+	upstream2.finish(nil)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// CompletionPrompts returns the prompts of a legacy completion request, whose 'prompt' field is
+// either a string or a list of strings. Token arrays cannot be sent to Poe and are rejected.
+func CompletionPrompts(prompt any) ([]string, error) {
+	switch p := prompt.(type) {
+	case string:
+		return []string{p}, nil
+	case []interface{}:
+		if len(p) == 0 {
+			return nil, fmt.Errorf("prompt must not be empty")
+		}
+		prompts := make([]string, 0, len(p))
+		for i, item := range p {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf(
+					"prompt item %d is a %T: only string prompts are supported, not token arrays",
+					i,
+					item,
+				)
+			}
+			prompts = append(prompts, str)
+		}
+		return prompts, nil
+	case []string:
+		if len(p) == 0 {
+			return nil, fmt.Errorf("prompt must not be empty")
+		}
+		return p, nil
+	case nil:
+		return nil, fmt.Errorf("prompt is required")
+	default:
+		return nil, fmt.Errorf("prompt must be a string or a list of strings, got %T", prompt)
+	}
+}
+
+// TransformOpenAICompletionRequestToPoeQuery converts one prompt of a legacy OpenAI completion
+// request into a PoeQueryRequest holding a single user message.
+// 'n', 'echo' and multiple prompts are handled by the caller, which sends one query per completion.
+func TransformOpenAICompletionRequestToPoeQuery(
+	completionReq *types.OpenAICompletionRequest,
+	prompt string,
+	poeAPIKey string, // The Poe Platform API Key for authentication.
+	conversationID string, // Identifier for the conversation session.
+	lastMessageID string, // Unique identifier for this specific query.
+) (*types.PoeQueryRequest, error) {
+	if completionReq == nil {
+		slog.Error("Attempted to transform nil OpenAI completion request to Poe query")
+		return nil, fmt.Errorf("openAI completion request is nil")
+	}
+
+	slog.Debug(
+		"Transforming OpenAI completion request to Poe query",
+		"model", completionReq.Model,
+		"prompt_length", len(prompt),
+	)
+
+	if completionReq.Suffix != "" {
+		slog.Warn("OpenAI completion 'suffix' (insertion) is not supported by Poe, ignoring it")
+	}
+	if completionReq.LogProbs != nil {
+		slog.Warn("OpenAI completion 'logprobs' is not supported by Poe, logprobs will be null")
+	}
+	warnUnenforcedTokenLimit(completionReq.MaxTokens)
+
+	poeQuery := &types.PoeQueryRequest{
+		Version: poeProtocolVersion,
+		Type:    poeRequestTypeQuery,
+		Query: []types.PoeProtocolMessage{
+			{Role: "user", Content: prompt},
+		},
+		UserID:           completionReq.User,
+		ConversationID:   conversationID,
+		MessageID:        lastMessageID,
+		APIKey:           poeAPIKey,
+		Temperature:      completionReq.Temperature,
+		SkipSystemPrompt: true,
+		StopSequences:    OpenAIStopToPoeStopSequences(completionReq.Stop),
+		LogitBias:        openAILogitBiasToPoe(completionReq.LogitBias),
+	}
+	slog.Debug(
+		"Successfully transformed OpenAI completion request to Poe query",
+		"poe_query_message_id",
+		poeQuery.MessageID,
+	)
+	return poeQuery, nil
+}
+
+// NewOpenAICompletionChunk builds the SSE line of a legacy completion stream chunk carrying text
+// for the choice at index. A non-empty finishReason marks the end of that choice.
+func NewOpenAICompletionChunk(
+	completionID string,
+	createdTimestamp int64,
+	model string,
+	index int,
+	text string,
+	finishReason string,
+) ([]byte, error) {
+	choice := types.OpenAICompletionChoice{Text: text, Index: index}
+	if finishReason != "" {
+		choice.FinishReason = &finishReason
+	}
+	chunk := types.OpenAICompletionResponse{
+		ID:      completionID,
+		Object:  "text_completion",
+		Created: createdTimestamp,
+		Model:   model,
+		Choices: []types.OpenAICompletionChoice{choice},
+	}
+	chunkBytes, err := json.Marshal(chunk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal completion chunk: %w", err)
+	}
+	return []byte(fmt.Sprintf("data: %s\n\n", string(chunkBytes))), nil
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// ContextStrategy is the way a conversation exceeding a bot's context window is shortened.
+type ContextStrategy string
+
+const (
+	// ContextStrategyNone sends conversations as is; Poe rejects those that are too long.
+	ContextStrategyNone ContextStrategy = "none"
+	// ContextStrategyDropOldest removes the oldest non-system messages.
+	ContextStrategyDropOldest ContextStrategy = "drop_oldest"
+	// ContextStrategyMiddleOut removes messages from the middle of the conversation,
+	// keeping its beginning and its end.
+	ContextStrategyMiddleOut ContextStrategy = "middle_out"
+	// ContextStrategySummarize replaces the oldest non-system messages with a summary
+	// written by a cheap bot.
+	ContextStrategySummarize ContextStrategy = "summarize"
+)
+
+// Response headers reporting how the context was shortened.
+const (
+	ContextStrategyHeader        = "X-Poe-Context-Strategy"
+	ContextRemovedMessagesHeader = "X-Poe-Context-Removed-Messages"
+)
+
+// tokensPerMessage is the estimated token overhead of each message (role, separators).
+const tokensPerMessage = 4
+
+// ParseContextStrategy validates a context strategy name.
+func ParseContextStrategy(name string) (ContextStrategy, error) {
+	switch strategy := ContextStrategy(strings.ToLower(name)); strategy {
+	case ContextStrategyNone, ContextStrategyDropOldest, ContextStrategyMiddleOut, ContextStrategySummarize:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown context strategy %q (expected none, drop_oldest, middle_out or summarize)",
+			name,
+		)
+	}
+}
+
+// ContextConfig configures context-window management.
+type ContextConfig struct {
+	// Strategy is applied to conversations exceeding the context budget.
+	Strategy ContextStrategy
+	// DefaultContextWindow is the context window, in tokens, of bots whose window is not in the
+	// catalog. If 0, conversations sent to these bots are never shortened.
+	DefaultContextWindow int
+	// ReserveTokens is the part of the context window kept free for the bot reply.
+	ReserveTokens int
+	// SummaryBot is the Poe bot writing summaries for ContextStrategySummarize.
+	SummaryBot string
+}
+
+// ContextFitResult reports how a conversation was fitted into the context budget.
+type ContextFitResult struct {
+	// Strategy is the strategy actually applied, ContextStrategyNone if nothing was removed.
+	Strategy ContextStrategy
+	// Removed is the number of messages removed (replaced by a summary, for ContextStrategySummarize).
+	Removed int
+	// Budget is the context budget of the bot in tokens, 0 if unlimited.
+	Budget int
+	// EstimatedTokens is the estimated size of the conversation sent to the bot.
+	EstimatedTokens int
+}
+
+// Summarizer writes a summary of messages, typically by querying a cheap Poe bot.
+type Summarizer func(ctx context.Context, messages []types.PoeProtocolMessage) (string, error)
+
+// ContextManager fits Poe queries into the context window of their bot.
+type ContextManager struct {
+	config  ContextConfig
+	runtime *RuntimeConfigStore
+}
+
+// NewContextManager creates a ContextManager taking context windows from the models catalog of
+// runtime.
+func NewContextManager(config ContextConfig, runtime *RuntimeConfigStore) *ContextManager {
+	if config.Strategy == "" {
+		config.Strategy = ContextStrategyNone
+	}
+	return &ContextManager{config: config, runtime: runtime}
+}
+
+// SummaryBot returns the Poe bot writing summaries.
+func (m *ContextManager) SummaryBot() string {
+	return m.config.SummaryBot
+}
+
+// Budget returns the context budget of a bot in tokens: its context window minus the tokens
+// reserved for the reply. It returns 0 if the bot's context window is unknown. Context windows
+// come from the catalog of the runtime configuration of ctx (see RuntimeConfigStore.For), so that
+// reloads apply to the requests that follow them.
+func (m *ContextManager) Budget(ctx context.Context, botName string) int {
+	contextWindow := m.config.DefaultContextWindow
+	if model, ok := m.runtime.For(ctx).Catalog.Get(botName); ok && model.ContextWindow > 0 {
+		contextWindow = model.ContextWindow
+	}
+	if contextWindow <= 0 {
+		return 0
+	}
+	return max(contextWindow-m.config.ReserveTokens, contextWindow/2)
+}
+
+// EstimateTokens returns a rough estimate of the number of tokens of messages,
+// counting about four characters per token.
+func EstimateTokens(messages []types.PoeProtocolMessage) int {
+	tokens := 0
+	for _, msg := range messages {
+		tokens += tokensPerMessage + (len(msg.Content)+3)/4
+	}
+	return tokens
+}
+
+// Fit shortens the messages of poeQuery, sent to botName, so that they fit into the bot's
+// context budget, using the configured strategy. summarize is only called for
+// ContextStrategySummarize; if it fails, the oldest messages are dropped instead.
+func (m *ContextManager) Fit(
+	ctx context.Context,
+	botName string,
+	poeQuery *types.PoeQueryRequest,
+	summarize Summarizer,
+) ContextFitResult {
+	result := ContextFitResult{
+		Strategy:        ContextStrategyNone,
+		Budget:          m.Budget(ctx, botName),
+		EstimatedTokens: EstimateTokens(poeQuery.Query),
+	}
+	if m.config.Strategy == ContextStrategyNone || result.Budget == 0 || result.EstimatedTokens <= result.Budget {
+		return result
+	}
+
+	var kept, removed []types.PoeProtocolMessage
+	switch m.config.Strategy {
+	case ContextStrategyMiddleOut:
+		kept, removed = trimMiddleOut(poeQuery.Query, result.Budget)
+	default:
+		kept, removed = trimOldest(poeQuery.Query, result.Budget)
+	}
+	if len(removed) == 0 {
+		slog.Warn(
+			"Conversation exceeds the context budget but no message can be removed",
+			"bot_name", botName,
+			"budget", result.Budget,
+			"estimated_tokens", result.EstimatedTokens,
+		)
+		return result
+	}
+	result.Strategy = m.config.Strategy
+	result.Removed = len(removed)
+
+	if m.config.Strategy == ContextStrategySummarize {
+		summary, err := summarize(ctx, removed)
+		if err != nil {
+			slog.Warn("Failed to summarize older messages, dropping them instead", "error", err)
+			result.Strategy = ContextStrategyDropOldest
+		} else {
+			kept = insertSummary(kept, summary)
+		}
+	}
+
+	poeQuery.Query = kept
+	result.EstimatedTokens = EstimateTokens(kept)
+	slog.Info(
+		"Shortened conversation to fit the bot context window",
+		"bot_name", botName,
+		"strategy", result.Strategy,
+		"removed_messages", result.Removed,
+		"budget", result.Budget,
+		"estimated_tokens", result.EstimatedTokens,
+	)
+	return result
+}
+
+// nonSystemIndices returns the indices of the non-system messages.
+func nonSystemIndices(messages []types.PoeProtocolMessage) []int {
+	var indices []int
+	for i, msg := range messages {
+		if msg.Role != "system" {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// filterMessages splits messages into those kept and those whose index is in removedIndices.
+func filterMessages(
+	messages []types.PoeProtocolMessage,
+	removedIndices map[int]bool,
+) (kept, removed []types.PoeProtocolMessage) {
+	for i, msg := range messages {
+		if removedIndices[i] {
+			removed = append(removed, msg)
+		} else {
+			kept = append(kept, msg)
+		}
+	}
+	return kept, removed
+}
+
+// trimOldest removes the oldest non-system messages until messages fit into budget, always keeping
+// the last message. Leading bot messages left after trimming are removed too, so that the
+// conversation starts with a user message.
+func trimOldest(messages []types.PoeProtocolMessage, budget int) (kept, removed []types.PoeProtocolMessage) {
+	indices := nonSystemIndices(messages)
+	removedIndices := make(map[int]bool)
+	tokens := EstimateTokens(messages)
+	next := 0
+	for tokens > budget && next < len(indices)-1 {
+		tokens -= EstimateTokens(messages[indices[next] : indices[next]+1])
+		removedIndices[indices[next]] = true
+		next++
+	}
+	for next > 0 && next < len(indices)-1 && messages[indices[next]].Role == "bot" {
+		removedIndices[indices[next]] = true
+		next++
+	}
+	return filterMessages(messages, removedIndices)
+}
+
+// trimMiddleOut removes non-system messages from the middle of the conversation until messages
+// fit into budget, always keeping the first and the last non-system messages.
+func trimMiddleOut(messages []types.PoeProtocolMessage, budget int) (kept, removed []types.PoeProtocolMessage) {
+	indices := nonSystemIndices(messages)
+	removedIndices := make(map[int]bool)
+	tokens := EstimateTokens(messages)
+	for tokens > budget && len(indices) > 2 {
+		middle := len(indices) / 2
+		tokens -= EstimateTokens(messages[indices[middle] : indices[middle]+1])
+		removedIndices[indices[middle]] = true
+		indices = append(indices[:middle], indices[middle+1:]...)
+	}
+	return filterMessages(messages, removedIndices)
+}
+
+// insertSummary adds a system message holding summary after the leading system messages.
+func insertSummary(messages []types.PoeProtocolMessage, summary string) []types.PoeProtocolMessage {
+	position := 0
+	for position < len(messages) && messages[position].Role == "system" {
+		position++
+	}
+	summaryMessage := types.PoeProtocolMessage{
+		Role:    "system",
+		Content: "Summary of the earlier part of the conversation:\n" + summary,
+	}
+	result := make([]types.PoeProtocolMessage, 0, len(messages)+1)
+	result = append(result, messages[:position]...)
+	result = append(result, summaryMessage)
+	return append(result, messages[position:]...)
+}
+
+// BuildSummaryQuery builds the Poe query asking the summary bot to summarize messages.
+func BuildSummaryQuery(
+	messages []types.PoeProtocolMessage,
+	poeAPIKey string,
+	conversationID string,
+	lastMessageID string,
+) *types.PoeQueryRequest {
+	var transcript strings.Builder
+	transcript.WriteString(
+		"Summarize the following conversation excerpt concisely, keeping the facts, decisions and " +
+			"open questions needed to continue the conversation. Reply with the summary only.\n\n",
+	)
+	for _, msg := range messages {
+		fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, msg.Content)
+	}
+	return &types.PoeQueryRequest{
+		Version: poeProtocolVersion,
+		Type:    poeRequestTypeQuery,
+		Query: []types.PoeProtocolMessage{
+			{Role: "user", Content: transcript.String()},
+		},
+		ConversationID:   conversationID,
+		MessageID:        lastMessageID,
+		APIKey:           poeAPIKey,
+		SkipSystemPrompt: true,
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// contextTestMessages builds messages from "role:content" specs, padding each content to size
+// characters so that it takes size/4 tokens.
+func contextTestMessages(size int, specs ...string) []types.PoeProtocolMessage {
+	messages := make([]types.PoeProtocolMessage, 0, len(specs))
+	for _, spec := range specs {
+		role, content, _ := strings.Cut(spec, ":")
+		messages = append(messages, types.PoeProtocolMessage{
+			Role:    role,
+			Content: content + strings.Repeat(".", size-len(content)),
+		})
+	}
+	return messages
+}
+
+// contextTestLabels returns the content labels of messages, without their padding, or nil if
+// there are none.
+func contextTestLabels(messages []types.PoeProtocolMessage) []string {
+	var labels []string
+	for _, msg := range messages {
+		labels = append(labels, msg.Role+":"+strings.TrimRight(msg.Content, "."))
+	}
+	return labels
+}
+
+// Each test message takes 4 + 40/4 = 14 tokens.
+const contextTestMessageSize = 40
+
+func TestTrimOldest(t *testing.T) {
+	tests := []struct {
+		name        string
+		messages    []string
+		budget      int
+		wantKept    []string
+		wantRemoved []string
+	}{
+		{
+			name:     "fits",
+			messages: []string{"system:s", "user:u1", "bot:b1", "user:u2"},
+			budget:   56,
+			wantKept: []string{"system:s", "user:u1", "bot:b1", "user:u2"},
+		},
+		{
+			name:        "drops oldest and keeps system",
+			messages:    []string{"system:s", "user:u1", "bot:b1", "user:u2", "bot:b2", "user:u3"},
+			budget:      56,
+			wantKept:    []string{"system:s", "user:u2", "bot:b2", "user:u3"},
+			wantRemoved: []string{"user:u1", "bot:b1"},
+		},
+		{
+			name:        "drops leading bot messages",
+			messages:    []string{"user:u1", "bot:b1", "user:u2", "bot:b2", "user:u3"},
+			budget:      60,
+			wantKept:    []string{"user:u2", "bot:b2", "user:u3"},
+			wantRemoved: []string{"user:u1", "bot:b1"},
+		},
+		{
+			name:        "keeps the last message",
+			messages:    []string{"user:u1", "bot:b1", "user:u2"},
+			budget:      1,
+			wantKept:    []string{"user:u2"},
+			wantRemoved: []string{"user:u1", "bot:b1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, removed := trimOldest(contextTestMessages(contextTestMessageSize, tt.messages...), tt.budget)
+			assert.Equal(t, tt.wantKept, contextTestLabels(kept), "kept")
+			assert.Equal(t, tt.wantRemoved, contextTestLabels(removed), "removed")
+		})
+	}
+}
+
+func TestTrimMiddleOut(t *testing.T) {
+	tests := []struct {
+		name        string
+		messages    []string
+		budget      int
+		wantKept    []string
+		wantRemoved []string
+	}{
+		{
+			name:     "fits",
+			messages: []string{"user:u1", "bot:b1", "user:u2"},
+			budget:   42,
+			wantKept: []string{"user:u1", "bot:b1", "user:u2"},
+		},
+		{
+			name:        "removes from the middle",
+			messages:    []string{"system:s", "user:u1", "bot:b1", "user:u2", "bot:b2", "user:u3"},
+			budget:      56,
+			wantKept:    []string{"system:s", "user:u1", "bot:b1", "user:u3"},
+			wantRemoved: []string{"user:u2", "bot:b2"},
+		},
+		{
+			name:        "keeps the first and last messages",
+			messages:    []string{"user:u1", "bot:b1", "user:u2", "bot:b2", "user:u3"},
+			budget:      1,
+			wantKept:    []string{"user:u1", "user:u3"},
+			wantRemoved: []string{"bot:b1", "user:u2", "bot:b2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, removed := trimMiddleOut(contextTestMessages(contextTestMessageSize, tt.messages...), tt.budget)
+			assert.Equal(t, tt.wantKept, contextTestLabels(kept), "kept")
+			assert.Equal(t, tt.wantRemoved, contextTestLabels(removed), "removed")
+		})
+	}
+}
+
+func TestContextManagerFit(t *testing.T) {
+	messages := []string{"system:s", "user:u1", "bot:b1", "user:u2", "bot:b2", "user:u3"}
+	summarizeOK := func(context.Context, []types.PoeProtocolMessage) (string, error) { return "sum", nil }
+	summarizeFail := func(context.Context, []types.PoeProtocolMessage) (string, error) {
+		return "", errors.New("summary bot unavailable")
+	}
+	tests := []struct {
+		name         string
+		config       ContextConfig
+		summarize    Summarizer
+		wantStrategy ContextStrategy
+		wantRemoved  int
+		wantLabels   []string
+	}{
+		{
+			name:         "none by default",
+			config:       ContextConfig{DefaultContextWindow: 56},
+			wantStrategy: ContextStrategyNone,
+			wantLabels:   messages,
+		},
+		{
+			name:         "unknown context window",
+			config:       ContextConfig{Strategy: ContextStrategyDropOldest},
+			wantStrategy: ContextStrategyNone,
+			wantLabels:   messages,
+		},
+		{
+			name:         "drop oldest",
+			config:       ContextConfig{Strategy: ContextStrategyDropOldest, DefaultContextWindow: 56},
+			wantStrategy: ContextStrategyDropOldest,
+			wantRemoved:  2,
+			wantLabels:   []string{"system:s", "user:u2", "bot:b2", "user:u3"},
+		},
+		{
+			name:         "summarize",
+			config:       ContextConfig{Strategy: ContextStrategySummarize, DefaultContextWindow: 56},
+			summarize:    summarizeOK,
+			wantStrategy: ContextStrategySummarize,
+			wantRemoved:  2,
+			wantLabels: []string{
+				"system:s",
+				"system:Summary of the earlier part of the conversation:\nsum",
+				"user:u2",
+				"bot:b2",
+				"user:u3",
+			},
+		},
+		{
+			name:         "summarize falls back to drop oldest",
+			config:       ContextConfig{Strategy: ContextStrategySummarize, DefaultContextWindow: 56},
+			summarize:    summarizeFail,
+			wantStrategy: ContextStrategyDropOldest,
+			wantRemoved:  2,
+			wantLabels:   []string{"system:s", "user:u2", "bot:b2", "user:u3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewContextManager(tt.config, NewRuntimeConfigStore(&RuntimeConfig{Catalog: NewModelCatalog(nil)}))
+			poeQuery := &types.PoeQueryRequest{Query: contextTestMessages(contextTestMessageSize, messages...)}
+			result := manager.Fit(context.Background(), "Some-Bot", poeQuery, tt.summarize)
+			assert.Equal(t, tt.wantStrategy, result.Strategy)
+			assert.Equal(t, tt.wantRemoved, result.Removed)
+			assert.Equal(t, tt.wantLabels, contextTestLabels(poeQuery.Query))
+		})
+	}
+}
+
+func TestContextManagerBudgetFollowsReloads(t *testing.T) {
+	catalog := func(contextWindow int) *RuntimeConfig {
+		return &RuntimeConfig{Catalog: NewModelCatalog([]ModelInfo{{ID: "Some-Bot", ContextWindow: contextWindow}})}
+	}
+	runtime := NewRuntimeConfigStore(catalog(1000))
+	manager := NewContextManager(ContextConfig{ReserveTokens: 100}, runtime)
+	pinned := runtime.Pin(context.Background())
+
+	runtime.Swap(catalog(2000), ReloadSourceAdmin)
+	assert.Equal(t, 1900, manager.Budget(context.Background(), "Some-Bot"), "unpinned requests use the reloaded catalog")
+	assert.Equal(t, 900, manager.Budget(pinned, "Some-Bot"), "pinned requests keep their catalog")
+}
@@ -0,0 +1,333 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// ConversationIDHeader is the request header clients set to name their conversation explicitly.
+// The conversation ID is echoed back in the same response header.
+const ConversationIDHeader = "X-Conversation-ID"
+
+// defaultConversationStoreSize is the default number of conversations kept by a MemoryConversationStore.
+const defaultConversationStoreSize = 1000
+
+// ConversationMessage is a message of a stored conversation.
+type ConversationMessage struct {
+	// MessageID is the Poe message ID, stable across the requests replaying this message.
+	MessageID string `json:"message_id"`
+	// Timestamp is the time the message was first seen, in Unix milliseconds.
+	Timestamp int64 `json:"timestamp"`
+	// Role is the Poe role of the message: "system", "user" or "bot".
+	Role string `json:"role"`
+	// Content is the text of the message.
+	Content string `json:"content"`
+	// Fingerprint identifies the role and content, to match messages replayed by clients.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Conversation is the history of a Poe conversation, as stored by a ConversationStore.
+type Conversation struct {
+	ID        string                `json:"id"`
+	Messages  []ConversationMessage `json:"messages"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+}
+
+// ConversationStore stores conversations by ID.
+type ConversationStore interface {
+	// Get returns the conversation with the given ID, if any.
+	Get(id string) (*Conversation, bool, error)
+	// Put stores a conversation, keyed by its ID.
+	Put(conversation *Conversation) error
+}
+
+// ConversationManager assigns stable conversation and message IDs to Poe queries, and records
+// the conversations in a ConversationStore. It is safe for concurrent use.
+type ConversationManager struct {
+	mu    sync.Mutex // Serializes read-modify-write cycles on the store.
+	store ConversationStore
+}
+
+// NewConversationManager creates a ConversationManager backed by store.
+func NewConversationManager(store ConversationStore) *ConversationManager {
+	return &ConversationManager{store: store}
+}
+
+// DeriveConversationID returns the conversation ID of a query made with the key whose
+// KeyFingerprint is owner. A client-provided ID (from ConversationIDHeader) names the
+// conversation; otherwise it is named by the message prefix, i.e., the messages up to and
+// including the first user message, which stays the same as the conversation grows. Either way,
+// the ID is a hash including owner, so that clients with different keys never share a
+// conversation, even when they pick the same ID or open with the same messages.
+func DeriveConversationID(owner string, clientID string, messages []types.PoeProtocolMessage) string {
+	hash := sha256.New()
+	hash.Write([]byte(owner + "\x00"))
+	if clientID != "" {
+		hash.Write([]byte("client\x00" + clientID))
+	} else {
+		for _, msg := range messages {
+			hash.Write([]byte(messageFingerprint(msg.Role, msg.Content)))
+			if msg.Role == "user" {
+				break
+			}
+		}
+	}
+	return "conv-" + hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+// messageFingerprint identifies a message by its role and content.
+func messageFingerprint(role, content string) string {
+	sum := sha256.Sum256([]byte(role + "\x00" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Prepare sets the conversation ID of poeQuery, made with the key whose KeyFingerprint is owner
+// (see DeriveConversationID), and gives each of its messages a persistent
+// MessageID and Timestamp. Messages already in the stored conversation keep their IDs; from the
+// first message that differs (e.g., an edited message), the stored history is replaced. The
+// MessageID of poeQuery is that of its last message, the current one, as stored.
+// The updated conversation is stored and returned, to be completed with RecordReply.
+func (m *ConversationManager) Prepare(owner string, clientID string, poeQuery *types.PoeQueryRequest) (*Conversation, error) {
+	conversationID := DeriveConversationID(owner, clientID, poeQuery.Query)
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conversation, ok, err := m.store.Get(conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conversation %s: %w", conversationID, err)
+	}
+	if !ok {
+		conversation = &Conversation{ID: conversationID, CreatedAt: now}
+	}
+
+	messages := make([]ConversationMessage, 0, len(poeQuery.Query)+1)
+	diverged := false
+	for i := range poeQuery.Query {
+		poeMsg := &poeQuery.Query[i]
+		fingerprint := messageFingerprint(poeMsg.Role, poeMsg.Content)
+		if !diverged && i < len(conversation.Messages) && conversation.Messages[i].Fingerprint == fingerprint {
+			stored := conversation.Messages[i]
+			poeMsg.MessageID = stored.MessageID
+			poeMsg.Timestamp = stored.Timestamp
+			messages = append(messages, stored)
+			continue
+		}
+		if !diverged && i < len(conversation.Messages) {
+			slog.Debug(
+				"Conversation history diverges from stored history, replacing the rest",
+				"conversation_id", conversationID,
+				"message_index", i,
+			)
+		}
+		diverged = true
+		poeMsg.MessageID = GenerateID("msg")
+		poeMsg.Timestamp = now.UnixMilli()
+		messages = append(messages, ConversationMessage{
+			MessageID:   poeMsg.MessageID,
+			Timestamp:   poeMsg.Timestamp,
+			Role:        poeMsg.Role,
+			Content:     poeMsg.Content,
+			Fingerprint: fingerprint,
+		})
+	}
+
+	conversation.Messages = messages
+	conversation.UpdatedAt = now
+	if err := m.store.Put(conversation); err != nil {
+		return nil, fmt.Errorf("failed to store conversation %s: %w", conversationID, err)
+	}
+	poeQuery.ConversationID = conversationID
+	if len(messages) > 0 {
+		poeQuery.MessageID = messages[len(messages)-1].MessageID
+	}
+	return conversation, nil
+}
+
+// RecordReply appends the bot reply, with a new message ID, to the conversation as prepared by
+// Prepare. The conversation is reloaded from the store, so that the messages stored
+// meanwhile by other queries are kept. If another query replaced the prepared messages (e.g.,
+// with an edited message), the reply no longer belongs to the stored history and is dropped.
+func (m *ConversationManager) RecordReply(prepared *Conversation, content string) error {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conversation, ok, err := m.store.Get(prepared.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load conversation %s: %w", prepared.ID, err)
+	}
+	if !ok {
+		// Evicted since it was prepared.
+		conversation = copyConversation(prepared)
+	}
+	if !hasMessagePrefix(conversation.Messages, prepared.Messages) {
+		slog.Debug(
+			"Conversation history replaced since the query was prepared, not recording the reply",
+			"conversation_id", prepared.ID,
+		)
+		return nil
+	}
+
+	conversation.Messages = append(conversation.Messages, ConversationMessage{
+		MessageID:   GenerateID("msg"),
+		Timestamp:   now.UnixMilli(),
+		Role:        "bot",
+		Content:     content,
+		Fingerprint: messageFingerprint("bot", content),
+	})
+	conversation.UpdatedAt = now
+	if err := m.store.Put(conversation); err != nil {
+		return fmt.Errorf("failed to store conversation %s: %w", conversation.ID, err)
+	}
+	return nil
+}
+
+// hasMessagePrefix reports whether messages start with the messages of prefix, identified by
+// their message IDs.
+func hasMessagePrefix(messages, prefix []ConversationMessage) bool {
+	if len(messages) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if messages[i].MessageID != prefix[i].MessageID {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the stored conversation with the given ID, if any.
+func (m *ConversationManager) Get(id string) (*Conversation, bool, error) {
+	return m.store.Get(id)
+}
+
+// MemoryConversationStore is a bounded in-memory ConversationStore. When full, the least
+// recently stored conversation is evicted. It is safe for concurrent use.
+type MemoryConversationStore struct {
+	mu            sync.Mutex
+	conversations map[string]*Conversation
+	order         []string // Conversation IDs, least recently stored first.
+	size          int
+}
+
+// NewMemoryConversationStore creates a MemoryConversationStore keeping at most size conversations.
+// If size is less than or equal to 0, defaultConversationStoreSize is used.
+func NewMemoryConversationStore(size int) *MemoryConversationStore {
+	if size <= 0 {
+		size = defaultConversationStoreSize
+	}
+	return &MemoryConversationStore{
+		conversations: make(map[string]*Conversation),
+		size:          size,
+	}
+}
+
+// Get implements ConversationStore. The returned conversation is a copy.
+func (s *MemoryConversationStore) Get(id string) (*Conversation, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conversation, ok := s.conversations[id]
+	if !ok {
+		return nil, false, nil
+	}
+	return copyConversation(conversation), true, nil
+}
+
+// Put implements ConversationStore.
+func (s *MemoryConversationStore) Put(conversation *Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, id := range s.order {
+		if id == conversation.ID {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	s.order = append(s.order, conversation.ID)
+	s.conversations[conversation.ID] = copyConversation(conversation)
+	for len(s.order) > s.size {
+		delete(s.conversations, s.order[0])
+		s.order = s.order[1:]
+	}
+	return nil
+}
+
+// copyConversation returns a copy of conversation that does not share its messages.
+func copyConversation(conversation *Conversation) *Conversation {
+	conversationCopy := *conversation
+	conversationCopy.Messages = append([]ConversationMessage(nil), conversation.Messages...)
+	return &conversationCopy
+}
+
+// FileConversationStore is a ConversationStore keeping each conversation in a JSON file of a
+// directory, so conversations survive restarts and can be audited. It is safe for concurrent use.
+type FileConversationStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewFileConversationStore creates a FileConversationStore in dir, creating the directory if needed.
+func NewFileConversationStore(dir string) (*FileConversationStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create conversation store directory %s: %w", dir, err)
+	}
+	return &FileConversationStore{dir: dir}, nil
+}
+
+// path returns the file of a conversation. IDs may come from clients, so they are hashed
+// rather than used as file names.
+func (s *FileConversationStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Get implements ConversationStore.
+func (s *FileConversationStore) Get(id string) (*Conversation, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read conversation file: %w", err)
+	}
+	var conversation Conversation
+	if err := json.Unmarshal(data, &conversation); err != nil {
+		return nil, false, fmt.Errorf("failed to parse conversation file: %w", err)
+	}
+	return &conversation, true, nil
+}
+
+// Put implements ConversationStore. The file is replaced atomically.
+func (s *FileConversationStore) Put(conversation *Conversation) error {
+	data, err := json.MarshalIndent(conversation, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversation: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.path(conversation.ID)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o640); err != nil {
+		return fmt.Errorf("failed to write conversation file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace conversation file: %w", err)
+	}
+	return nil
+}
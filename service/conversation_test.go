@@ -0,0 +1,137 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// conversationTestQuery returns a Poe query with the given alternating user and bot messages.
+func conversationTestQuery(contents ...string) *types.PoeQueryRequest {
+	query := &types.PoeQueryRequest{MessageID: GenerateID("msg")}
+	for i, content := range contents {
+		role := "user"
+		if i%2 == 1 {
+			role = "bot"
+		}
+		query.Query = append(query.Query, types.PoeProtocolMessage{Role: role, Content: content})
+	}
+	return query
+}
+
+func TestDeriveConversationID(t *testing.T) {
+	opening := []types.PoeProtocolMessage{{Role: "system", Content: "You are terse."}, {Role: "user", Content: "Hello"}}
+	base := DeriveConversationID("owner", "", opening)
+	grown := append(slices.Clone(opening),
+		types.PoeProtocolMessage{Role: "bot", Content: "Hi!"},
+		types.PoeProtocolMessage{Role: "user", Content: "Bye"},
+	)
+	assert.Regexp(t, `^conv-[0-9a-f]{32}$`, base)
+
+	tests := []struct {
+		name     string
+		owner    string
+		clientID string
+		messages []types.PoeProtocolMessage
+		wantSame bool
+	}{
+		{"same prefix", "owner", "", opening, true},
+		{"grown conversation", "owner", "", grown, true},
+		{"other prefix", "owner", "", conversationTestQuery("Hello").Query, false},
+		{"other owner", "other-owner", "", opening, false},
+		{"client ID", "owner", "conversation-1", opening, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DeriveConversationID(tt.owner, tt.clientID, tt.messages)
+			if tt.wantSame {
+				assert.Equal(t, base, got)
+			} else {
+				assert.NotEqual(t, base, got)
+			}
+		})
+	}
+
+	// A client-provided ID names the conversation whatever its messages, per owner.
+	withClientID := DeriveConversationID("owner", "conversation-1", opening)
+	assert.Equal(t, withClientID, DeriveConversationID("owner", "conversation-1", conversationTestQuery("Other").Query))
+	assert.NotEqual(t, withClientID, DeriveConversationID("other-owner", "conversation-1", opening))
+}
+
+func TestConversationManagerPrepare(t *testing.T) {
+	manager := NewConversationManager(NewMemoryConversationStore(0))
+
+	first := conversationTestQuery("Hello")
+	prepared, err := manager.Prepare("owner", "", first)
+	require.NoError(t, err)
+	require.Len(t, prepared.Messages, 1)
+	assert.Equal(t, prepared.ID, first.ConversationID)
+	assert.Equal(t, first.Query[0].MessageID, first.MessageID, "query message ID")
+	assert.Equal(t, prepared.Messages[0].MessageID, first.MessageID, "stored message ID")
+	require.NoError(t, manager.RecordReply(prepared, "Hi!"))
+
+	// The next turn replays the history, which keeps its IDs.
+	second := conversationTestQuery("Hello", "Hi!", "How are you?")
+	prepared, err = manager.Prepare("owner", "", second)
+	require.NoError(t, err)
+	assert.Equal(t, first.ConversationID, second.ConversationID)
+	require.Len(t, prepared.Messages, 3)
+	assert.Equal(t, first.MessageID, second.Query[0].MessageID)
+	for i, message := range prepared.Messages {
+		assert.Equal(t, message.MessageID, second.Query[i].MessageID, "message %d", i)
+	}
+	assert.NotEqual(t, second.Query[1].MessageID, second.Query[2].MessageID)
+	assert.Equal(t, second.Query[2].MessageID, second.MessageID, "query message ID")
+
+	// Another key never shares the conversation.
+	other := conversationTestQuery("Hello")
+	_, err = manager.Prepare("other-owner", "", other)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ConversationID, other.ConversationID)
+}
+
+func TestConversationManagerEditedHistory(t *testing.T) {
+	manager := NewConversationManager(NewMemoryConversationStore(0))
+	query := conversationTestQuery("Hello", "Hi!", "Tell me a joke")
+	prepared, err := manager.Prepare("owner", "", query)
+	require.NoError(t, err)
+
+	// An edited message replaces the rest of the history, and the pending reply is dropped.
+	edited := conversationTestQuery("Hello", "Hi!", "Tell me a story")
+	_, err = manager.Prepare("owner", "", edited)
+	require.NoError(t, err)
+	assert.Equal(t, query.Query[1].MessageID, edited.Query[1].MessageID)
+	assert.NotEqual(t, query.Query[2].MessageID, edited.Query[2].MessageID)
+	require.NoError(t, manager.RecordReply(prepared, "A joke"))
+
+	stored, ok, err := manager.Get(edited.ConversationID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Len(t, stored.Messages, 3)
+	assert.Equal(t, "Tell me a story", stored.Messages[2].Content)
+}
+
+func TestConversationStores(t *testing.T) {
+	fileStore, err := NewFileConversationStore(t.TempDir())
+	require.NoError(t, err)
+	for name, store := range map[string]ConversationStore{
+		"memory": NewMemoryConversationStore(0),
+		"file":   fileStore,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, ok, err := store.Get("conv-missing")
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			conversation := &Conversation{ID: "conv-1", Messages: []ConversationMessage{{MessageID: "msg-1", Role: "user", Content: "Hello"}}}
+			require.NoError(t, store.Put(conversation))
+			stored, ok, err := store.Get("conv-1")
+			require.NoError(t, err)
+			require.True(t, ok)
+			assert.Equal(t, conversation.Messages, stored.Messages)
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// BotEventKind classifies a decoded Poe SSE event.
+type BotEventKind string
+
+const (
+	// BotEventText is an incremental piece of response text.
+	BotEventText BotEventKind = "text"
+	// BotEventReplace replaces all response text produced so far.
+	BotEventReplace BotEventKind = "replace"
+	// BotEventToolCalls carries streamed tool call fragments.
+	BotEventToolCalls BotEventKind = "tool_calls"
+	// BotEventFile is a file sent by the bot.
+	BotEventFile BotEventKind = "file"
+	// BotEventError is an error reported by the bot; the stream should be terminated.
+	BotEventError BotEventKind = "error"
+	// BotEventDone marks the end of the response.
+	BotEventDone BotEventKind = "done"
+	// BotEventIgnored is an event with no equivalent in the front-end APIs (meta, suggested replies...).
+	BotEventIgnored BotEventKind = "ignored"
+)
+
+// ToolCallDelta is a streamed fragment of a tool call, keyed by its index in the response.
+// The first fragment of a call usually carries its ID and name, the following ones only
+// pieces of the JSON arguments.
+type ToolCallDelta struct {
+	Index     int
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// BotEvent is the front-end independent representation of a Poe SSE event.
+// It is used by the API front-ends (Responses, Anthropic Messages...) that do not map
+// Poe events straight to OpenAI chat chunks.
+type BotEvent struct {
+	Kind BotEventKind
+	// Text is set for BotEventText and BotEventReplace events, and for "json" events carrying content.
+	Text string
+	// ToolCalls is set for BotEventToolCalls events.
+	ToolCalls []ToolCallDelta
+	// File is set for BotEventFile events.
+	File *types.PoeFileEventData
+	// ErrorText is set for BotEventError events.
+	ErrorText string
+	// ErrorType is the machine-readable Poe error type of BotEventError events, if provided.
+	ErrorType string
+}
+
+// DecodePoeEvent decodes a raw Poe SSE event into a BotEvent.
+// Unknown event types are reported as BotEventIgnored rather than as an error.
+func DecodePoeEvent(poeEvent types.PoeSSEEvent) (BotEvent, error) {
+	switch poeEvent.Event {
+	case "text", "replace_response":
+		var poeRespData types.PoePartialResponseData
+		if err := json.Unmarshal([]byte(poeEvent.Data), &poeRespData); err != nil {
+			slog.Error(
+				"Error unmarshalling Poe text event data",
+				"event_type", poeEvent.Event, "error", err, "data", poeEvent.Data,
+			)
+			return BotEvent{}, fmt.Errorf(
+				"error unmarshalling Poe '%s' event data: %w",
+				poeEvent.Event,
+				err,
+			)
+		}
+		if poeEvent.Event == "replace_response" {
+			return BotEvent{Kind: BotEventReplace, Text: poeRespData.Text}, nil
+		}
+		return BotEvent{Kind: BotEventText, Text: poeRespData.Text}, nil
+	case "json":
+		var poeRespData types.PoePartialResponseData
+		if err := json.Unmarshal([]byte(poeEvent.Data), &poeRespData); err != nil {
+			slog.Error(
+				"Error unmarshalling Poe 'json' event data",
+				"error", err, "data", poeEvent.Data,
+			)
+			return BotEvent{}, fmt.Errorf("error unmarshalling Poe 'json' event data: %w", err)
+		}
+		return decodePoeJSONEvent(poeRespData.Data), nil
+	case "file":
+		var poeFileData types.PoeFileEventData
+		if err := json.Unmarshal([]byte(poeEvent.Data), &poeFileData); err != nil {
+			slog.Error(
+				"Error unmarshalling Poe 'file' event data",
+				"error", err, "data", poeEvent.Data,
+			)
+			return BotEvent{}, fmt.Errorf("error unmarshalling Poe 'file' event data: %w", err)
+		}
+		return BotEvent{Kind: BotEventFile, File: &poeFileData}, nil
+	case "error":
+		botEvent := BotEvent{Kind: BotEventError, ErrorText: "Poe bot reported an error."}
+		var poeErrData types.PoeErrorEventData
+		if err := json.Unmarshal([]byte(poeEvent.Data), &poeErrData); err != nil {
+			slog.Error(
+				"Error unmarshalling Poe 'error' event data",
+				"error", err, "data", poeEvent.Data,
+			)
+			botEvent.ErrorText = poeEvent.Data
+			return botEvent, nil
+		}
+		if poeErrData.Text != nil && *poeErrData.Text != "" {
+			botEvent.ErrorText = *poeErrData.Text
+		}
+		if poeErrData.ErrorType != nil {
+			botEvent.ErrorType = *poeErrData.ErrorType
+		}
+		return botEvent, nil
+	case "done":
+		return BotEvent{Kind: BotEventDone}, nil
+	case "meta", "suggested_reply":
+		return BotEvent{Kind: BotEventIgnored}, nil
+	default:
+		slog.Warn(
+			"Unhandled Poe event type, ignoring it.",
+			"event_type", poeEvent.Event,
+			"data", poeEvent.Data,
+		)
+		return BotEvent{Kind: BotEventIgnored}, nil
+	}
+}
+
+// decodePoeJSONEvent decodes the data of a Poe "json" event. Bots wrapping OpenAI models send
+// tool calls (and sometimes content) in an OpenAI chunk-like structure, either under
+// choices[0].delta or directly under tool_calls.
+func decodePoeJSONEvent(data map[string]interface{}) BotEvent {
+	var toolCallsData []interface{}
+	var content string
+	if choicesList, ok := data["choices"].([]interface{}); ok && len(choicesList) > 0 {
+		if choiceMap, ok := choicesList[0].(map[string]interface{}); ok {
+			if deltaMap, ok := choiceMap["delta"].(map[string]interface{}); ok {
+				toolCallsData, _ = deltaMap["tool_calls"].([]interface{})
+				content, _ = deltaMap["content"].(string)
+			}
+		}
+	} else {
+		toolCallsData, _ = data["tool_calls"].([]interface{})
+	}
+
+	if len(toolCallsData) > 0 {
+		deltas := make([]ToolCallDelta, 0, len(toolCallsData))
+		for i, item := range toolCallsData {
+			tcMap, ok := item.(map[string]interface{})
+			if !ok {
+				slog.Warn("Poe tool_call item is not a map, skipping it", "item_index", i)
+				continue
+			}
+			delta := ToolCallDelta{Index: i}
+			if index, ok := tcMap["index"].(float64); ok {
+				delta.Index = int(index)
+			}
+			delta.ID, _ = tcMap["id"].(string)
+			if fnMap, ok := tcMap["function"].(map[string]interface{}); ok {
+				delta.Name, _ = fnMap["name"].(string)
+				delta.Arguments, _ = fnMap["arguments"].(string)
+			}
+			deltas = append(deltas, delta)
+		}
+		return BotEvent{Kind: BotEventToolCalls, ToolCalls: deltas, Text: content}
+	}
+	if content != "" {
+		return BotEvent{Kind: BotEventText, Text: content}
+	}
+	slog.Warn("Poe 'json' event data has no tool calls or content, ignoring it", "data", data)
+	return BotEvent{Kind: BotEventIgnored}
+}
+
+// ToolCallAccumulator merges streamed tool call fragments into complete tool calls.
+type ToolCallAccumulator struct {
+	calls map[int]*types.OpenAIToolCall
+}
+
+// Add merges tool call fragments into the accumulator and returns, for each fragment,
+// whether it started a new tool call.
+func (a *ToolCallAccumulator) Add(deltas []ToolCallDelta) []bool {
+	if a.calls == nil {
+		a.calls = make(map[int]*types.OpenAIToolCall)
+	}
+	started := make([]bool, len(deltas))
+	for i, delta := range deltas {
+		call, ok := a.calls[delta.Index]
+		if !ok {
+			call = &types.OpenAIToolCall{Type: "function"}
+			a.calls[delta.Index] = call
+			started[i] = true
+		}
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Name != "" {
+			call.Function.Name = delta.Name
+		}
+		call.Function.Arguments += delta.Arguments
+	}
+	return started
+}
+
+// ToolCalls returns the accumulated tool calls ordered by index.
+// Calls that never received an ID are assigned a generated one.
+func (a *ToolCallAccumulator) ToolCalls() []types.OpenAIToolCall {
+	indexes := make([]int, 0, len(a.calls))
+	for index := range a.calls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	toolCalls := make([]types.OpenAIToolCall, 0, len(indexes))
+	for _, index := range indexes {
+		call := *a.calls[index]
+		if call.ID == "" {
+			call.ID = GenerateID("call")
+		}
+		toolCalls = append(toolCalls, call)
+	}
+	return toolCalls
+}
+
+// Call returns the tool call accumulated at index, or nil if there is none.
+func (a *ToolCallAccumulator) Call(index int) *types.OpenAIToolCall {
+	return a.calls[index]
+}
+
+// BotResponse is the aggregated result of a complete Poe response stream.
+type BotResponse struct {
+	// Text is the response text, with files rendered as markdown.
+	Text string
+	// ToolCalls are the complete tool calls requested by the bot.
+	ToolCalls []types.OpenAIToolCall
+	// Files are the files sent by the bot.
+	Files []types.PoeFileEventData
+	// ErrorText is set if the bot reported an error.
+	ErrorText string
+	// ErrorType is the Poe error type, if the bot reported one.
+	ErrorType string
+}
+
+// AggregateBotEvents decodes and aggregates a complete list of Poe SSE events into a BotResponse.
+func AggregateBotEvents(poeEvents []types.PoeSSEEvent) BotResponse {
+	var text strings.Builder
+	var toolCalls ToolCallAccumulator
+	var response BotResponse
+	for _, poeEvent := range poeEvents {
+		botEvent, err := DecodePoeEvent(poeEvent)
+		if err != nil {
+			continue // Already logged by DecodePoeEvent
+		}
+		switch botEvent.Kind {
+		case BotEventText:
+			text.WriteString(botEvent.Text)
+		case BotEventReplace:
+			text.Reset()
+			text.WriteString(botEvent.Text)
+		case BotEventToolCalls:
+			toolCalls.Add(botEvent.ToolCalls)
+			text.WriteString(botEvent.Text)
+		case BotEventFile:
+			response.Files = append(response.Files, *botEvent.File)
+		case BotEventError:
+			response.ErrorText = botEvent.ErrorText
+			response.ErrorType = botEvent.ErrorType
+		}
+	}
+	response.Text = resolvePoeFileReferences(text.String(), response.Files)
+	response.ToolCalls = toolCalls.ToolCalls()
+	return response
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// Lifecycle tracks the uptime of the adapter and whether it is draining before shutting down.
+// It is safe for concurrent use.
+type Lifecycle struct {
+	startedAt time.Time
+	draining  atomic.Bool
+}
+
+// NewLifecycle creates the Lifecycle of an adapter started now.
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{startedAt: time.Now()}
+}
+
+// StartedAt returns the time the adapter started.
+func (l *Lifecycle) StartedAt() time.Time {
+	return l.startedAt
+}
+
+// Uptime returns the time since the adapter started.
+func (l *Lifecycle) Uptime() time.Duration {
+	return time.Since(l.startedAt)
+}
+
+// StartDraining marks the adapter as shutting down: it is no longer ready for new requests.
+func (l *Lifecycle) StartDraining() {
+	l.draining.Store(true)
+}
+
+// Draining reports whether the adapter is shutting down.
+func (l *Lifecycle) Draining() bool {
+	return l.draining.Load()
+}
+
+// ProbeStatus is the outcome of the upstream probes, queries sent to a Poe bot to check that Poe
+// is reachable.
+type ProbeStatus struct {
+	Bot       string    `json:"bot"`
+	Healthy   bool      `json:"healthy"`
+	CheckedAt time.Time `json:"checked_at,omitzero"`
+	LatencyMS float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	Checks    int64     `json:"checks"`
+	Failures  int64     `json:"failures"`
+}
+
+// UpstreamStats are the statistics of the queries sent to Poe.
+type UpstreamStats struct {
+	Queries       int64     `json:"queries"`
+	Failures      int64     `json:"failures"`
+	Retries       int64     `json:"retries"`
+	LastSuccessAt time.Time `json:"last_success_at,omitzero"`
+	LastFailureAt time.Time `json:"last_failure_at,omitzero"`
+	LastError     string    `json:"last_error,omitempty"`
+	// Probe is the outcome of the upstream probes, nil if they are disabled.
+	Probe *ProbeStatus `json:"probe,omitempty"`
+}
+
+// UpstreamHealth collects the health statistics of Poe: the outcome of the queries sent to it,
+// and of the upstream probes if enabled. It is safe for concurrent use.
+type UpstreamHealth struct {
+	mu    sync.Mutex
+	stats UpstreamStats
+}
+
+// NewUpstreamHealth creates an UpstreamHealth, probing probeBot unless it is empty.
+func NewUpstreamHealth(probeBot string) *UpstreamHealth {
+	health := &UpstreamHealth{}
+	if probeBot != "" {
+		health.stats.Probe = &ProbeStatus{Bot: probeBot}
+	}
+	return health
+}
+
+// RecordQuery records the outcome of a Poe query, made in attempts attempts and failed with err
+// if it is not nil. Queries cancelled by their client are not counted.
+func (h *UpstreamHealth) RecordQuery(attempts int, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stats.Queries++
+	h.stats.Retries += int64(max(attempts-1, 0))
+	if err != nil {
+		h.stats.Failures++
+		h.stats.LastFailureAt = time.Now()
+		h.stats.LastError = err.Error()
+		return
+	}
+	h.stats.LastSuccessAt = time.Now()
+}
+
+// RecordProbe records the outcome of an upstream probe that took latency.
+func (h *UpstreamHealth) RecordProbe(latency time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	probe := h.stats.Probe
+	if probe == nil {
+		return
+	}
+	probe.Checks++
+	probe.CheckedAt = time.Now()
+	probe.LatencyMS = float64(latency.Microseconds()) / 1000
+	probe.Healthy = err == nil
+	probe.Error = ""
+	if err != nil {
+		probe.Failures++
+		probe.Error = err.Error()
+	}
+}
+
+// Probe returns the outcome of the upstream probes, and false if they are disabled.
+func (h *UpstreamHealth) Probe() (ProbeStatus, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stats.Probe == nil {
+		return ProbeStatus{}, false
+	}
+	return *h.stats.Probe, true
+}
+
+// Stats returns the statistics of the queries sent to Poe.
+func (h *UpstreamHealth) Stats() UpstreamStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := h.stats
+	if stats.Probe != nil {
+		probe := *stats.Probe
+		stats.Probe = &probe
+	}
+	return stats
+}
+
+// RunProbes calls probe with a timeout every interval until ctx is done, recording its outcome
+// in h; the first probe runs immediately. Probes are cached this way, so that readiness checks
+// do not query Poe.
+func (h *UpstreamHealth) RunProbes(
+	ctx context.Context,
+	logger *slog.Logger,
+	interval time.Duration,
+	timeout time.Duration,
+	probe func(ctx context.Context) error,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := probe(probeCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		h.RecordProbe(time.Since(start), err)
+		if err != nil {
+			logger.Warn("Upstream probe failed", "error", err)
+		} else {
+			logger.Debug("Upstream probe succeeded", "latency", time.Since(start))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// BuildProbeQuery builds the minimal query of an upstream probe.
+func BuildProbeQuery(poeAPIKey string) *types.PoeQueryRequest {
+	return &types.PoeQueryRequest{
+		Version:          poeProtocolVersion,
+		Type:             poeRequestTypeQuery,
+		Query:            []types.PoeProtocolMessage{{Role: "user", Content: "Reply with OK."}},
+		ConversationID:   GenerateID("probe"),
+		MessageID:        GenerateID("msg"),
+		APIKey:           poeAPIKey,
+		SkipSystemPrompt: true,
+	}
+}
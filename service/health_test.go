@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamHealthRecordQuery(t *testing.T) {
+	health := NewUpstreamHealth("")
+	health.RecordQuery(1, nil)
+	health.RecordQuery(3, errors.New("bad gateway"))
+	health.RecordQuery(1, context.Canceled)
+
+	stats := health.Stats()
+	assert.Equal(t, int64(2), stats.Queries, "cancelled queries are not counted")
+	assert.Equal(t, int64(1), stats.Failures)
+	assert.Equal(t, int64(2), stats.Retries)
+	assert.Equal(t, "bad gateway", stats.LastError)
+	assert.False(t, stats.LastSuccessAt.IsZero())
+	assert.Nil(t, stats.Probe)
+	_, probed := health.Probe()
+	assert.False(t, probed, "probes are disabled without a bot")
+}
+
+func TestUpstreamHealthRunProbes(t *testing.T) {
+	health := NewUpstreamHealth("GPT-4o")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var probes atomic.Int64
+	go health.RunProbes(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), time.Millisecond, time.Second, func(context.Context) error {
+		if probes.Add(1) == 1 {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+
+	assert.Eventually(t, func() bool {
+		probe, _ := health.Probe()
+		return probe.Checks >= 2 && probe.Healthy
+	}, time.Second, time.Millisecond)
+	probe, ok := health.Probe()
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), probe.Failures)
+	assert.Empty(t, probe.Error, "the error of a failed probe is cleared once one succeeds")
+}
+
+func TestLifecycle(t *testing.T) {
+	lifecycle := NewLifecycle()
+	assert.False(t, lifecycle.Draining())
+	lifecycle.StartDraining()
+	assert.True(t, lifecycle.Draining())
+	assert.GreaterOrEqual(t, lifecycle.Uptime(), time.Duration(0))
+}
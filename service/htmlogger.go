@@ -1,20 +1,50 @@
 package service
 
 import (
+	"bufio"
 	"container/ring"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // defaultBufferSize is the default capacity of the ring buffer for log entries.
-const defaultBufferSize = 25
+const defaultBufferSize = 1000
+
+// defaultSubscriptionBufferSize is the default number of entries queued for a log subscriber.
+const defaultSubscriptionBufferSize = 256
+
+// logFilePrefix and logFileDateLayout name the daily files of on-disk log retention.
+const (
+	logFilePrefix     = "logs-"
+	logFileDateLayout = "2006-01-02"
+)
 
 // RingBufferLogWriter implements io.Writer to store log entries in a fixed-size ring buffer.
 // This is useful for displaying recent logs, for example, on an admin page.
+// Entries can also be retained on disk, in one JSONL file per day, and are then reloaded on startup.
 // It is safe for concurrent use.
 type RingBufferLogWriter struct {
 	mu     sync.Mutex
 	buffer *ring.Ring // The ring buffer itself.
 	size   int        // The capacity of the buffer.
+
+	subscribers map[*LogSubscription]struct{} // Receive new entries as they are written.
+
+	// On-disk retention, disabled if dir is empty.
+	dir       string
+	retention time.Duration
+	file      *os.File
+	fileDate  string // The day of file, in logFileDateLayout.
 }
 
 // NewRingBufferLogWriter creates a new RingBufferLogWriter with the default buffer size (defaultBufferSize).
@@ -34,6 +64,30 @@ func NewRingBufferLogWriterWithSize(size int) *RingBufferLogWriter {
 	}
 }
 
+// NewRingBufferLogWriterWithRetention creates a RingBufferLogWriter of the specified size that
+// also appends entries to daily files in dir, removing the files older than retention (kept
+// forever if retention is 0). The buffer is filled with the most recent retained entries.
+func NewRingBufferLogWriterWithRetention(size int, dir string, retention time.Duration) (*RingBufferLogWriter, error) {
+	w := NewRingBufferLogWriterWithSize(size)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create log directory %s: %w", dir, err)
+	}
+	w.dir = dir
+	w.retention = retention
+	w.pruneLogFiles(time.Now())
+
+	files, err := w.logFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		if err := w.loadLogFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
 // Write implements the io.Writer interface. It writes a log entry to the ring buffer.
 // The input byte slice p is expected to be a single log entry (e.g., one line of JSON from slog).
 // A copy of p is stored to prevent issues if the logger reuses the buffer.
@@ -48,9 +102,112 @@ func (w *RingBufferLogWriter) Write(p []byte) (n int, err error) {
 	w.buffer.Value = string(entry) // Store the log entry as a string.
 	w.buffer = w.buffer.Next()     // Advance the ring buffer pointer to the next slot.
 
+	for subscription := range w.subscribers {
+		subscription.send(string(entry))
+	}
+
+	if w.dir != "" {
+		// Failures cannot be logged without recursing, and must not fail the other log writers.
+		if err := w.retainLocked(entry); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to retain log entry: %v\n", err)
+		}
+	}
+
 	return len(p), nil
 }
 
+// retainLocked appends entry to the file of the day, switching files at midnight. w.mu must be held.
+func (w *RingBufferLogWriter) retainLocked(entry []byte) error {
+	now := time.Now()
+	date := now.UTC().Format(logFileDateLayout)
+	if w.file == nil || w.fileDate != date {
+		if w.file != nil {
+			_ = w.file.Close()
+		}
+		path := filepath.Join(w.dir, logFilePrefix+date+".jsonl")
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			w.file = nil
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		w.file = file
+		w.fileDate = date
+		w.pruneLogFiles(now)
+	}
+	if len(entry) > 0 && entry[len(entry)-1] != '\n' {
+		entry = append(entry, '\n')
+	}
+	if _, err := w.file.Write(entry); err != nil {
+		return fmt.Errorf("failed to write log file: %w", err)
+	}
+	return nil
+}
+
+// logFiles returns the retained log files, oldest first.
+func (w *RingBufferLogWriter) logFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(w.dir, logFilePrefix+"*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log files: %w", err)
+	}
+	sort.Strings(files) // Dates sort chronologically.
+	return files, nil
+}
+
+// pruneLogFiles removes the log files of the days older than the retention period.
+func (w *RingBufferLogWriter) pruneLogFiles(now time.Time) {
+	if w.retention <= 0 {
+		return
+	}
+	files, err := w.logFiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prune log files: %v\n", err)
+		return
+	}
+	oldest := now.Add(-w.retention).UTC().Format(logFileDateLayout)
+	for _, path := range files {
+		date := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), logFilePrefix), ".jsonl")
+		if date < oldest {
+			if err := os.Remove(path); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to remove old log file: %v\n", err)
+			}
+		}
+	}
+}
+
+// loadLogFile adds the entries of a retained log file to the buffer.
+func (w *RingBufferLogWriter) loadLogFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			w.buffer.Value = line + "\n"
+			w.buffer = w.buffer.Next()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read log file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Close closes the current log file, if any.
+func (w *RingBufferLogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
 // GetLogs retrieves all log entries currently stored in the buffer.
 // Entries are returned in chronological order (oldest to newest).
 // It filters out any uninitialized (nil) slots in the ring buffer.
@@ -70,3 +227,242 @@ func (w *RingBufferLogWriter) GetLogs() []string {
 	})
 	return finalLogs
 }
+
+// Subscribe returns a subscription receiving the entries written from now on. Up to bufferSize
+// entries are queued for it (defaultSubscriptionBufferSize if not positive); when its queue is full,
+// entries are dropped rather than blocking the writer. The subscription must be closed.
+func (w *RingBufferLogWriter) Subscribe(bufferSize int) *LogSubscription {
+	if bufferSize <= 0 {
+		bufferSize = defaultSubscriptionBufferSize
+	}
+	subscription := &LogSubscription{writer: w, entries: make(chan string, bufferSize)}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.subscribers == nil {
+		w.subscribers = make(map[*LogSubscription]struct{})
+	}
+	w.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// LogSubscription receives the entries written to a RingBufferLogWriter, see Subscribe.
+type LogSubscription struct {
+	writer  *RingBufferLogWriter
+	entries chan string
+	dropped atomic.Uint64
+}
+
+// Entries returns the channel of the new log entries. It is closed by Close.
+func (s *LogSubscription) Entries() <-chan string {
+	return s.entries
+}
+
+// Dropped returns the number of entries dropped because the subscriber was too slow.
+func (s *LogSubscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// send queues entry, or drops it if the queue is full. The writer lock must be held.
+func (s *LogSubscription) send(entry string) {
+	select {
+	case s.entries <- entry:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Close unsubscribes s and closes its channel.
+func (s *LogSubscription) Close() {
+	s.writer.mu.Lock()
+	defer s.writer.mu.Unlock()
+	if _, ok := s.writer.subscribers[s]; ok {
+		delete(s.writer.subscribers, s)
+		close(s.entries)
+	}
+}
+
+// Query returns the buffered log entries matching filter, in chronological order.
+func (w *RingBufferLogWriter) Query(filter LogFilter) []LogEntry {
+	rawLogs := w.GetLogs()
+	entries := make([]LogEntry, 0, len(rawLogs))
+	for _, raw := range rawLogs {
+		entry := ParseLogEntry(raw)
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// LogAttr is an attribute of a log entry, with nested groups flattened into dotted keys.
+type LogAttr struct {
+	Key   string
+	Value string
+}
+
+// LogEntry is a structured log entry, as parsed from a JSON line written by slog.
+type LogEntry struct {
+	Raw       string
+	Time      time.Time
+	Level     string
+	Message   string
+	RequestID string
+	Model     string // The "model" or "bot_name" attribute
+	Attrs     []LogAttr
+}
+
+// ParseLogEntry parses a JSON log line written by slog. Lines that are not JSON objects are
+// returned as entries with the line as message.
+func ParseLogEntry(raw string) LogEntry {
+	entry := LogEntry{Raw: strings.TrimRight(raw, "\n")}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		entry.Message = entry.Raw
+		return entry
+	}
+
+	for key, value := range fields {
+		switch key {
+		case slog.TimeKey:
+			if s, ok := value.(string); ok {
+				entry.Time, _ = time.Parse(time.RFC3339Nano, s)
+			}
+		case slog.LevelKey:
+			entry.Level, _ = value.(string)
+		case slog.MessageKey:
+			entry.Message, _ = value.(string)
+		default:
+			entry.Attrs = flattenLogAttr(entry.Attrs, key, value)
+		}
+	}
+	sort.Slice(entry.Attrs, func(i, j int) bool { return entry.Attrs[i].Key < entry.Attrs[j].Key })
+	for _, attr := range entry.Attrs {
+		switch attr.Key {
+		case "request_id":
+			entry.RequestID = attr.Value
+		case "model", "bot_name":
+			if entry.Model == "" {
+				entry.Model = attr.Value
+			}
+		}
+	}
+	return entry
+}
+
+// flattenLogAttr appends the attribute key with value to attrs, flattening groups.
+func flattenLogAttr(attrs []LogAttr, key string, value any) []LogAttr {
+	switch v := value.(type) {
+	case map[string]any:
+		for childKey, childValue := range v {
+			attrs = flattenLogAttr(attrs, key+"."+childKey, childValue)
+		}
+		return attrs
+	case string:
+		return append(attrs, LogAttr{Key: key, Value: v})
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return append(attrs, LogAttr{Key: key, Value: fmt.Sprint(v)})
+		}
+		return append(attrs, LogAttr{Key: key, Value: string(data)})
+	}
+}
+
+// LogFilter selects log entries. Zero fields match every entry.
+type LogFilter struct {
+	// MinLevel is the least severe level of the entries, e.g. "WARN".
+	MinLevel string
+	// RequestID and Model match the request_id and model attributes exactly.
+	RequestID string
+	Model     string
+	// Since and Until bound the entry times.
+	Since time.Time
+	Until time.Time
+	// Search is searched in the raw entries, case-insensitively.
+	Search string
+}
+
+// Matches reports whether entry is selected by f.
+func (f LogFilter) Matches(entry LogEntry) bool {
+	if f.MinLevel != "" && logLevelRank(entry.Level) < logLevelRank(f.MinLevel) {
+		return false
+	}
+	if f.RequestID != "" && entry.RequestID != f.RequestID {
+		return false
+	}
+	if f.Model != "" && !strings.EqualFold(entry.Model, f.Model) {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	if f.Search != "" && !strings.Contains(strings.ToLower(entry.Raw), strings.ToLower(f.Search)) {
+		return false
+	}
+	return true
+}
+
+// logLevelRank orders slog level names; unknown levels rank with INFO.
+func logLevelRank(level string) int {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return int(slog.LevelInfo)
+	}
+	return int(parsed)
+}
+
+// MultiHandler is a slog.Handler passing records to several handlers, such as a text handler
+// for stdout and the JSON handler feeding a RingBufferLogWriter, which parses JSON entries.
+type MultiHandler struct {
+	handlers []slog.Handler
+}
+
+// NewMultiHandler creates a MultiHandler passing records to handlers.
+func NewMultiHandler(handlers ...slog.Handler) *MultiHandler {
+	return &MultiHandler{handlers: handlers}
+}
+
+// Enabled implements slog.Handler.
+func (h *MultiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle implements slog.Handler, returning the errors of all handlers.
+func (h *MultiHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, record.Level) {
+			if err := handler.Handle(ctx, record.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *MultiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return &MultiHandler{handlers: handlers}
+}
+
+// WithGroup implements slog.Handler.
+func (h *MultiHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return &MultiHandler{handlers: handlers}
+}
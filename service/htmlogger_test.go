@@ -0,0 +1,118 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingBufferLogWriterWraps(t *testing.T) {
+	w := NewRingBufferLogWriterWithSize(2)
+	for _, entry := range []string{"a\n", "b\n", "c\n"} {
+		_, err := w.Write([]byte(entry))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"b\n", "c\n"}, w.GetLogs(), "the oldest entries are dropped")
+}
+
+func TestRingBufferLogWriterRetention(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, logFilePrefix+time.Now().AddDate(0, 0, -10).UTC().Format(logFileDateLayout)+".jsonl")
+	require.NoError(t, os.WriteFile(old, []byte(`{"msg":"old"}`+"\n"), 0o640))
+	recent := filepath.Join(dir, logFilePrefix+time.Now().AddDate(0, 0, -1).UTC().Format(logFileDateLayout)+".jsonl")
+	require.NoError(t, os.WriteFile(recent, []byte(`{"msg":"yesterday"}`+"\n"), 0o640))
+
+	w, err := NewRingBufferLogWriterWithRetention(10, dir, 7*24*time.Hour)
+	require.NoError(t, err)
+	assert.NoFileExists(t, old, "files older than the retention are removed")
+	_, err = w.Write([]byte(`{"msg":"today"}`))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	// A new writer reloads the retained entries, oldest first.
+	w, err = NewRingBufferLogWriterWithRetention(10, dir, 7*24*time.Hour)
+	require.NoError(t, err)
+	defer func() { _ = w.Close() }()
+	var messages []string
+	for _, entry := range w.Query(LogFilter{}) {
+		messages = append(messages, entry.Message)
+	}
+	assert.Equal(t, []string{"yesterday", "today"}, messages)
+}
+
+func TestParseLogEntry(t *testing.T) {
+	entry := ParseLogEntry(
+		`{"time":"2024-05-01T10:00:00.5Z","level":"WARN","msg":"Query failed","request_id":"req-1",` +
+			`"bot_name":"GPT-4o","status":502,"upstream":{"host":"api.poe.com"}}` + "\n",
+	)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 500_000_000, time.UTC), entry.Time)
+	assert.Equal(t, "WARN", entry.Level)
+	assert.Equal(t, "Query failed", entry.Message)
+	assert.Equal(t, "req-1", entry.RequestID)
+	assert.Equal(t, "GPT-4o", entry.Model)
+	assert.Equal(t, []LogAttr{
+		{Key: "bot_name", Value: "GPT-4o"},
+		{Key: "request_id", Value: "req-1"},
+		{Key: "status", Value: "502"},
+		{Key: "upstream.host", Value: "api.poe.com"},
+	}, entry.Attrs)
+
+	text := ParseLogEntry("plain text line\n")
+	assert.Equal(t, "plain text line", text.Message, "lines that are not JSON are kept as messages")
+}
+
+func TestLogFilterMatches(t *testing.T) {
+	entry := ParseLogEntry(
+		`{"time":"2024-05-01T10:00:00Z","level":"WARN","msg":"Query failed","request_id":"req-1","model":"GPT-4o"}`,
+	)
+	tests := []struct {
+		name   string
+		filter LogFilter
+		want   bool
+	}{
+		{"no filter", LogFilter{}, true},
+		{"lower level", LogFilter{MinLevel: "INFO"}, true},
+		{"higher level", LogFilter{MinLevel: "ERROR"}, false},
+		{"request ID", LogFilter{RequestID: "req-1"}, true},
+		{"other request ID", LogFilter{RequestID: "req-2"}, false},
+		{"model in other case", LogFilter{Model: "gpt-4o"}, true},
+		{"other model", LogFilter{Model: "Claude-Sonnet-4"}, false},
+		{"since", LogFilter{Since: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)}, true},
+		{"after the entry", LogFilter{Since: time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)}, false},
+		{"before the entry", LogFilter{Until: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)}, false},
+		{"search", LogFilter{Search: "query FAILED"}, true},
+		{"search attributes", LogFilter{Search: "req-1"}, true},
+		{"search miss", LogFilter{Search: "timeout"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Matches(entry))
+		})
+	}
+}
+
+func TestRingBufferLogWriterSubscribe(t *testing.T) {
+	w := NewRingBufferLogWriterWithSize(10)
+	_, err := w.Write([]byte("before\n"))
+	require.NoError(t, err)
+
+	subscription := w.Subscribe(2)
+	for _, entry := range []string{"a\n", "b\n", "c\n"} {
+		_, err := w.Write([]byte(entry))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "a\n", <-subscription.Entries(), "only new entries are sent")
+	assert.Equal(t, "b\n", <-subscription.Entries())
+	assert.Equal(t, uint64(1), subscription.Dropped(), "entries are dropped when the queue is full")
+
+	subscription.Close()
+	_, ok := <-subscription.Entries()
+	assert.False(t, ok, "the channel is closed")
+	_, err = w.Write([]byte("after\n"))
+	require.NoError(t, err, "closed subscriptions do not block the writer")
+	subscription.Close()
+}
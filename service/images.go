@@ -0,0 +1,182 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// defaultImageBot is the Poe bot used for images API requests that do not specify a model.
+const defaultImageBot = "DALL-E-3"
+
+// ImageBotProfile describes how OpenAI images API parameters are translated into
+// the prompt parameters understood by a specific Poe image bot.
+// Parameters with no matching entry are dropped with a debug log.
+type ImageBotProfile struct {
+	// SizeParams maps an OpenAI size (e.g., "1792x1024") to a prompt parameter (e.g., "--aspect 16:9").
+	SizeParams map[string]string `json:"size_params,omitempty"`
+	// QualityParams maps an OpenAI quality (e.g., "hd") to a prompt parameter.
+	QualityParams map[string]string `json:"quality_params,omitempty"`
+	// StyleParams maps an OpenAI style (e.g., "natural") to a prompt parameter.
+	StyleParams map[string]string `json:"style_params,omitempty"`
+}
+
+// ImageConfig holds the configuration of the images API endpoints.
+type ImageConfig struct {
+	// DefaultBot is the Poe bot used when a request does not specify a model.
+	DefaultBot string `json:"default_bot,omitempty"`
+	// Bots maps Poe bot names to their parameter translation profile.
+	Bots map[string]ImageBotProfile `json:"bots,omitempty"`
+}
+
+// aspectSizeParams is the size translation shared by bots that accept an "--aspect" parameter.
+var aspectSizeParams = map[string]string{
+	"256x256":   "--aspect 1:1",
+	"512x512":   "--aspect 1:1",
+	"1024x1024": "--aspect 1:1",
+	"1792x1024": "--aspect 16:9",
+	"1024x1792": "--aspect 9:16",
+	"1536x1024": "--aspect 3:2",
+	"1024x1536": "--aspect 2:3",
+}
+
+// DefaultImageConfig returns the built-in images configuration covering common Poe image bots.
+func DefaultImageConfig() ImageConfig {
+	return ImageConfig{
+		DefaultBot: defaultImageBot,
+		Bots: map[string]ImageBotProfile{
+			"DALL-E-3": {
+				SizeParams:    aspectSizeParams,
+				QualityParams: map[string]string{"hd": "--quality hd"},
+				StyleParams:   map[string]string{"natural": "--style natural"},
+			},
+			"FLUX-pro-1.1": {SizeParams: aspectSizeParams},
+			"FLUX-dev":     {SizeParams: aspectSizeParams},
+			"FLUX-schnell": {SizeParams: aspectSizeParams},
+			"Imagen3":      {SizeParams: aspectSizeParams},
+		},
+	}
+}
+
+// LoadImageConfig reads an images configuration JSON file and merges it over DefaultImageConfig.
+// Bot profiles in the file replace the built-in profile of the same name.
+func LoadImageConfig(path string) (ImageConfig, error) {
+	config := DefaultImageConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("Failed to read images config file", "path", path, "error", err)
+		return config, fmt.Errorf("failed to read images config file %s: %w", path, err)
+	}
+	var fileConfig ImageConfig
+	if err := json.Unmarshal(data, &fileConfig); err != nil {
+		slog.Error("Failed to parse images config file", "path", path, "error", err)
+		return config, fmt.Errorf("failed to parse images config file %s: %w", path, err)
+	}
+	if fileConfig.DefaultBot != "" {
+		config.DefaultBot = fileConfig.DefaultBot
+	}
+	for bot, profile := range fileConfig.Bots {
+		config.Bots[bot] = profile
+	}
+	return config, nil
+}
+
+// BuildImagePrompt appends the bot-specific parameters for the requested size, quality
+// and style to the prompt, according to the bot's profile.
+func BuildImagePrompt(imageReq *types.OpenAIImageGenerationRequest, profile ImageBotProfile) string {
+	params := []string{strings.TrimSpace(imageReq.Prompt)}
+	for _, p := range []struct {
+		name    string
+		value   string
+		mapping map[string]string
+	}{
+		{"size", imageReq.Size, profile.SizeParams},
+		{"quality", imageReq.Quality, profile.QualityParams},
+		{"style", imageReq.Style, profile.StyleParams},
+	} {
+		if p.value == "" {
+			continue
+		}
+		if param, ok := p.mapping[p.value]; ok {
+			params = append(params, param)
+		} else {
+			slog.Debug(
+				"No prompt parameter configured for image option, ignoring it",
+				"option", p.name,
+				"value", p.value,
+			)
+		}
+	}
+	return strings.Join(params, " ")
+}
+
+// TransformOpenAIImageRequestToPoeQuery converts an OpenAI images API request into a PoeQueryRequest
+// for an image bot. Attachments, if any, are attached to the single user message (e.g., the image to edit).
+func TransformOpenAIImageRequestToPoeQuery(
+	imageReq *types.OpenAIImageGenerationRequest,
+	profile ImageBotProfile,
+	attachments []types.PoeAttachment,
+	poeAPIKey string,
+	conversationID string,
+	messageID string,
+) (*types.PoeQueryRequest, error) {
+	if imageReq == nil {
+		slog.Error("Attempted to transform nil OpenAI image request to Poe query")
+		return nil, fmt.Errorf("openAI image request is nil")
+	}
+	if strings.TrimSpace(imageReq.Prompt) == "" {
+		return nil, fmt.Errorf("prompt is required")
+	}
+
+	prompt := BuildImagePrompt(imageReq, profile)
+	slog.Debug("Built Poe image bot prompt", "model", imageReq.Model, "prompt", prompt)
+
+	return &types.PoeQueryRequest{
+		Version: poeProtocolVersion,
+		Type:    poeRequestTypeQuery,
+		Query: []types.PoeProtocolMessage{
+			{
+				Role:        "user",
+				Content:     prompt,
+				Attachments: attachments,
+			},
+		},
+		UserID:           imageReq.User,
+		ConversationID:   conversationID,
+		MessageID:        messageID,
+		APIKey:           poeAPIKey,
+		SkipSystemPrompt: true,
+	}, nil
+}
+
+// CollectPoeFiles extracts the files sent by a Poe bot from a complete list of events.
+// It returns an error if the bot reported an error event.
+func CollectPoeFiles(poeEvents []types.PoeSSEEvent) ([]types.PoeFileEventData, error) {
+	var files []types.PoeFileEventData
+	for _, poeEvent := range poeEvents {
+		switch poeEvent.Event {
+		case "file":
+			var poeFileData types.PoeFileEventData
+			if err := json.Unmarshal([]byte(poeEvent.Data), &poeFileData); err != nil {
+				slog.Error(
+					"Error unmarshalling Poe 'file' event data while collecting files",
+					"error", err, "data", poeEvent.Data,
+				)
+				continue
+			}
+			files = append(files, poeFileData)
+		case "error":
+			var poeErrData types.PoeErrorEventData
+			if err := json.Unmarshal([]byte(poeEvent.Data), &poeErrData); err == nil &&
+				poeErrData.Text != nil {
+				return files, fmt.Errorf("poe bot reported an error: %s", *poeErrData.Text)
+			}
+			return files, fmt.Errorf("poe bot reported an error: %s", poeEvent.Data)
+		}
+	}
+	return files, nil
+}
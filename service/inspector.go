@@ -0,0 +1,183 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultExchangeStoreSize is the default number of exchanges kept by an ExchangeStore.
+const defaultExchangeStoreSize = 200
+
+// ExchangeSink receives the exchanges captured by the recording middleware.
+type ExchangeSink interface {
+	// Record stores a completed exchange.
+	Record(exchange *Exchange) error
+}
+
+// ExchangeSummary describes an exchange in the request inspector list.
+type ExchangeSummary struct {
+	ID       string
+	Time     time.Time
+	Method   string
+	Path     string
+	Model    string
+	Stream   *bool // Unset if the request does not say
+	Status   int
+	Duration time.Duration
+	Queries  int // Number of Poe queries
+	// Usage is the token usage reported in the response, if any.
+	Usage *ExchangeUsage
+}
+
+// ExchangeUsage is the token usage of an exchange, from the OpenAI or Anthropic usage fields.
+type ExchangeUsage struct {
+	InputTokens  int
+	OutputTokens int
+}
+
+// SummarizeExchange extracts the summary of exchange from its request and response bodies.
+func SummarizeExchange(exchange *Exchange) ExchangeSummary {
+	exchange.mu.Lock()
+	queries := len(exchange.Queries)
+	exchange.mu.Unlock()
+
+	summary := ExchangeSummary{
+		ID:       exchange.ID,
+		Time:     exchange.Time,
+		Method:   exchange.Method,
+		Path:     exchange.Path,
+		Status:   exchange.Status,
+		Duration: exchange.Duration,
+		Queries:  queries,
+	}
+	var request struct {
+		Model  string `json:"model"`
+		Stream *bool  `json:"stream"`
+	}
+	if err := json.Unmarshal([]byte(exchange.Request), &request); err == nil {
+		summary.Model = request.Model
+		summary.Stream = request.Stream
+	}
+	summary.Usage = responseUsage(exchange.Response)
+	return summary
+}
+
+// responseUsage returns the last token usage found in a JSON response body or in the data lines
+// of a streamed one.
+func responseUsage(response string) *ExchangeUsage {
+	documents := []string{response}
+	if strings.HasPrefix(strings.TrimSpace(response), "data:") || strings.HasPrefix(response, "event:") {
+		documents = documents[:0]
+		for _, line := range strings.Split(response, "\n") {
+			if data, ok := strings.CutPrefix(line, "data:"); ok {
+				documents = append(documents, strings.TrimSpace(data))
+			}
+		}
+	}
+
+	var usage *ExchangeUsage
+	for _, document := range documents {
+		var body struct {
+			Usage *struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+				InputTokens      int `json:"input_tokens"`
+				OutputTokens     int `json:"output_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(document), &body); err != nil || body.Usage == nil {
+			continue
+		}
+		usage = &ExchangeUsage{
+			InputTokens:  body.Usage.PromptTokens + body.Usage.InputTokens,
+			OutputTokens: body.Usage.CompletionTokens + body.Usage.OutputTokens,
+		}
+	}
+	return usage
+}
+
+// ExchangeStore keeps the most recent exchanges for the request inspector, optionally persisting
+// them with a Recorder so that they survive restarts. It implements ExchangeSink and is safe for
+// concurrent use.
+type ExchangeStore struct {
+	mu        sync.Mutex
+	size      int
+	exchanges []*Exchange // Oldest first
+	summaries []ExchangeSummary
+	recorder  *Recorder // Nil if not persisted
+}
+
+// NewExchangeStore creates an ExchangeStore keeping size exchanges (defaultExchangeStoreSize if
+// not positive). If dir is not empty, exchanges are also recorded there, and the most recent
+// ones recorded by a previous run are loaded.
+func NewExchangeStore(size int, dir string) (*ExchangeStore, error) {
+	if size <= 0 {
+		size = defaultExchangeStoreSize
+	}
+	store := &ExchangeStore{size: size}
+	if dir == "" {
+		return store, nil
+	}
+
+	previous, err := ReadExchanges(filepath.Join(dir, recorderFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, exchange := range previous {
+		store.add(exchange)
+	}
+	store.recorder, err = NewRecorder(dir, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Record implements ExchangeSink.
+func (s *ExchangeStore) Record(exchange *Exchange) error {
+	s.mu.Lock()
+	s.add(exchange)
+	s.mu.Unlock()
+	if s.recorder != nil {
+		return s.recorder.Record(exchange)
+	}
+	return nil
+}
+
+// add appends exchange, evicting the oldest one when full. s.mu must be held, or s not yet shared.
+func (s *ExchangeStore) add(exchange *Exchange) {
+	s.exchanges = append(s.exchanges, exchange)
+	s.summaries = append(s.summaries, SummarizeExchange(exchange))
+	if len(s.exchanges) > s.size {
+		s.exchanges = s.exchanges[1:]
+		s.summaries = s.summaries[1:]
+	}
+}
+
+// List returns the summaries of the stored exchanges, most recent first.
+func (s *ExchangeStore) List() []ExchangeSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summaries := make([]ExchangeSummary, 0, len(s.summaries))
+	for i := len(s.summaries) - 1; i >= 0; i-- {
+		summaries = append(summaries, s.summaries[i])
+	}
+	return summaries
+}
+
+// Get returns the stored exchange with the given request ID, if any.
+func (s *ExchangeStore) Get(id string) (*Exchange, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.exchanges) - 1; i >= 0; i-- {
+		if s.exchanges[i].ID == id {
+			return s.exchanges[i], true
+		}
+	}
+	return nil, false
+}
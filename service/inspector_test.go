@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeExchange(t *testing.T) {
+	stream := true
+	tests := []struct {
+		name       string
+		request    string
+		response   string
+		wantModel  string
+		wantStream *bool
+		wantUsage  *ExchangeUsage
+	}{
+		{
+			"OpenAI response",
+			`{"model":"GPT-4o","messages":[]}`,
+			`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3}}`,
+			"GPT-4o", nil, &ExchangeUsage{InputTokens: 12, OutputTokens: 3},
+		},
+		{
+			"Anthropic stream",
+			`{"model":"Claude-Sonnet-4","stream":true}`,
+			"event: message_start\ndata: {\"type\":\"message_start\"}\n\n" +
+				"event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"input_tokens\":7,\"output_tokens\":5}}\n\n",
+			"Claude-Sonnet-4", &stream, &ExchangeUsage{InputTokens: 7, OutputTokens: 5},
+		},
+		{"no usage", `{"model":"GPT-4o"}`, "data: [DONE]\n\n", "GPT-4o", nil, nil},
+		{"not JSON", "prompt=Hi", "Bad Request", "", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := SummarizeExchange(&Exchange{
+				ID:       "req-1",
+				Request:  tt.request,
+				Response: tt.response,
+				Queries:  []*RecordedQuery{{BotName: "GPT-4o"}},
+			})
+			assert.Equal(t, "req-1", summary.ID)
+			assert.Equal(t, tt.wantModel, summary.Model)
+			assert.Equal(t, tt.wantStream, summary.Stream)
+			assert.Equal(t, tt.wantUsage, summary.Usage)
+			assert.Equal(t, 1, summary.Queries)
+		})
+	}
+}
+
+func TestExchangeStore(t *testing.T) {
+	store, err := NewExchangeStore(2, "")
+	require.NoError(t, err)
+	for i := range 3 {
+		require.NoError(t, store.Record(&Exchange{ID: fmt.Sprintf("req-%d", i), Time: time.Now()}))
+	}
+
+	var ids []string
+	for _, summary := range store.List() {
+		ids = append(ids, summary.ID)
+	}
+	assert.Equal(t, []string{"req-2", "req-1"}, ids, "the most recent exchanges are listed first")
+	_, ok := store.Get("req-0")
+	assert.False(t, ok, "the oldest exchange is evicted")
+	exchange, ok := store.Get("req-1")
+	require.True(t, ok)
+	assert.Equal(t, "req-1", exchange.ID)
+}
+
+func TestExchangeStorePersists(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewExchangeStore(0, dir)
+	require.NoError(t, err)
+	require.NoError(t, store.Record(&Exchange{ID: "req-1", Request: `{"model":"GPT-4o"}`}))
+
+	// A new store loads the exchanges recorded by the previous one.
+	reloaded, err := NewExchangeStore(0, dir)
+	require.NoError(t, err)
+	summaries := reloaded.List()
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "req-1", summaries[0].ID)
+	assert.Equal(t, "GPT-4o", summaries[0].Model)
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VirtualKey is an API key issued by the adapter to a client, standing for a Poe Platform API Key
+// that the client never sees.
+type VirtualKey struct {
+	// Name identifies the key in logs and rate limits.
+	Name string
+	Key  string
+	// PoeAPIKey is the Poe Platform API Key sent to Poe for the requests made with the key.
+	PoeAPIKey string
+	// Models are the models the key may request, case-insensitively; all if empty.
+	Models []string
+	// RequestsPerMinute overrides the default rate limit of the key if positive.
+	RequestsPerMinute int
+}
+
+// AllowsModel reports whether k may request model.
+func (k VirtualKey) AllowsModel(model string) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+	for _, allowed := range k.Models {
+		if strings.EqualFold(allowed, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyStore holds the virtual keys accepted from clients.
+type KeyStore struct {
+	// keys maps the SHA-256 hashes of the keys to them, so that lookups do not leak the keys
+	// through their timing.
+	keys                 map[[sha256.Size]byte]VirtualKey
+	defaultPoeAPIKey     string
+	allowPassthrough     bool
+	allowAnonymousOllama bool
+}
+
+// NewKeyStore creates a KeyStore holding keys, those without a Poe Platform API Key using
+// defaultPoeAPIKey. allowPassthrough lets clients send Poe Platform API Keys instead, and
+// allowAnonymousOllama lets Ollama clients send no credentials, using defaultPoeAPIKey.
+func NewKeyStore(keys []VirtualKey, defaultPoeAPIKey string, allowPassthrough, allowAnonymousOllama bool) *KeyStore {
+	store := &KeyStore{
+		keys:                 make(map[[sha256.Size]byte]VirtualKey, len(keys)),
+		defaultPoeAPIKey:     defaultPoeAPIKey,
+		allowPassthrough:     allowPassthrough,
+		allowAnonymousOllama: allowAnonymousOllama,
+	}
+	for _, key := range keys {
+		if key.PoeAPIKey == "" {
+			key.PoeAPIKey = defaultPoeAPIKey
+		}
+		store.keys[sha256.Sum256([]byte(key.Key))] = key
+	}
+	return store
+}
+
+// Lookup returns the virtual key apiKey, if it is one.
+func (s *KeyStore) Lookup(apiKey string) (VirtualKey, bool) {
+	key, ok := s.keys[sha256.Sum256([]byte(apiKey))]
+	return key, ok
+}
+
+// DefaultPoeAPIKey returns the Poe Platform API Key of the virtual keys without their own, also
+// used for the Ollama clients sending no credentials if allowed. It is empty unless configured.
+func (s *KeyStore) DefaultPoeAPIKey() string {
+	return s.defaultPoeAPIKey
+}
+
+// AllowPassthrough reports whether clients may send Poe Platform API Keys instead of virtual keys.
+func (s *KeyStore) AllowPassthrough() bool {
+	return s.allowPassthrough
+}
+
+// AllowAnonymousOllama reports whether Ollama clients may send no credentials, their requests
+// being sent to Poe with DefaultPoeAPIKey.
+func (s *KeyStore) AllowAnonymousOllama() bool {
+	return s.allowAnonymousOllama
+}
+
+// Len returns the number of virtual keys of s.
+func (s *KeyStore) Len() int {
+	return len(s.keys)
+}
+
+// KeyFingerprint returns a short, non-reversible identifier of apiKey, for logs and rate limits.
+func KeyFingerprint(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:6])
+}
+
+// rateLimiterSweepInterval is the interval between removals of the idle clients of a RateLimiter.
+const rateLimiterSweepInterval = time.Minute
+
+// RateLimiter limits the rate of the requests of each client with a token bucket. It is safe for
+// concurrent use.
+type RateLimiter struct {
+	mu                sync.Mutex
+	requestsPerMinute int
+	burst             int
+	buckets           map[string]*tokenBucket
+	lastSweep         time.Time
+}
+
+// tokenBucket is the request allowance of a client: tokens refill at the rate limit of the
+// client, up to its burst, and each request takes one.
+type tokenBucket struct {
+	tokens    float64
+	capacity  float64
+	perSecond float64
+	updated   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing requestsPerMinute requests per minute to each
+// client by default (no limit if 0), and burst requests at once (requestsPerMinute if 0).
+func NewRateLimiter(requestsPerMinute, burst int) *RateLimiter {
+	return &RateLimiter{
+		requestsPerMinute: requestsPerMinute,
+		burst:             burst,
+		buckets:           make(map[string]*tokenBucket),
+		lastSweep:         time.Now(),
+	}
+}
+
+// SetLimits changes the default rate limit and burst of l, see NewRateLimiter. The requests
+// already counted still apply.
+func (l *RateLimiter) SetLimits(requestsPerMinute, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requestsPerMinute, l.burst = requestsPerMinute, burst
+}
+
+// Allow reports whether the client identified by clientID may make a request now, with a rate
+// limit of requestsPerMinute if positive, or the default one. Otherwise, it returns the time
+// after which the client may retry.
+func (l *RateLimiter) Allow(clientID string, requestsPerMinute int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if requestsPerMinute <= 0 {
+		requestsPerMinute = l.requestsPerMinute
+	}
+	if requestsPerMinute <= 0 {
+		return true, 0
+	}
+	capacity := float64(requestsPerMinute)
+	if l.burst > 0 {
+		capacity = float64(l.burst)
+	}
+	perSecond := float64(requestsPerMinute) / 60
+
+	now := time.Now()
+	l.sweep(now)
+
+	bucket, ok := l.buckets[clientID]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, capacity: capacity, updated: now}
+		l.buckets[clientID] = bucket
+	}
+	bucket.capacity, bucket.perSecond = capacity, perSecond
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond)
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+	return false, wait
+}
+
+// sweep removes the buckets of the clients idle for long enough to have refilled, at most once
+// per rateLimiterSweepInterval. It must be called with l.mu held.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimiterSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for clientID, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*bucket.perSecond >= bucket.capacity {
+			delete(l.buckets, clientID)
+		}
+	}
+}
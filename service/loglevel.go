@@ -0,0 +1,392 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of log level override targets.
+const (
+	// LogTargetModel overrides the level of the logs of the requests to a model (or Poe bot).
+	LogTargetModel = "model"
+	// LogTargetPackage overrides the level of the logs emitted by a Go package.
+	LogTargetPackage = "package"
+)
+
+// modelLogKeys are the log attributes naming the model or Poe bot a record is about.
+var modelLogKeys = []string{"model", "bot_name", "poe_bot"}
+
+// ParseLogLevel parses a log level name (debug, info, warn, error), case-insensitively.
+func ParseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", value)
+	}
+	return level, nil
+}
+
+// LogLevelTarget is the target of a log level override: a model or a package, by name. Packages
+// are named by their import path or its last element (e.g. client).
+type LogLevelTarget struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// LogLevelOverride is a log level applying to the records of a target instead of the global level.
+type LogLevelOverride struct {
+	LogLevelTarget
+	Level     slog.Level `json:"level"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Permanent if nil
+}
+
+// LogLevelState describes the current log levels.
+type LogLevelState struct {
+	Level        slog.Level         `json:"level"`
+	DefaultLevel slog.Level         `json:"default_level"`
+	ExpiresAt    *time.Time         `json:"expires_at,omitempty"` // When Level reverts to DefaultLevel
+	Overrides    []LogLevelOverride `json:"overrides"`
+}
+
+// logLevelSetting is a level set at runtime, with its revert timer.
+type logLevelSetting struct {
+	level     slog.Level
+	expiresAt time.Time // Zero if permanent
+	timer     *time.Timer
+}
+
+// LogLevelController holds the log level, adjustable at runtime, and its overrides for models
+// and packages. Levels set for a duration revert on their own. Its Handler filters records
+// accordingly. It is safe for concurrent use.
+type LogLevelController struct {
+	mu           sync.Mutex
+	defaultLevel slog.Level
+	revertAfter  time.Duration
+	global       *logLevelSetting
+	overrides    map[LogLevelTarget]*logLevelSetting
+
+	// minLevel is the most verbose level in effect, checked first by Handler, and globalLevel a
+	// copy of the global level, read without locking.
+	minLevel    slog.LevelVar
+	globalLevel slog.LevelVar
+	// hasOverrides avoids looking up the package and model of records when there are none.
+	hasOverrides atomic.Bool
+	// requestModels maps the IDs of the requests in progress to their model, so that model
+	// overrides apply to all their records.
+	requestModels sync.Map
+	// packages caches the packages of program counters.
+	packages sync.Map
+}
+
+// NewLogLevelController creates a LogLevelController whose global level is level, until changed.
+// revertAfter is the default duration of changes, used by ToggleDebug and offered to callers.
+func NewLogLevelController(level slog.Level, revertAfter time.Duration) *LogLevelController {
+	c := &LogLevelController{
+		defaultLevel: level,
+		revertAfter:  revertAfter,
+		global:       &logLevelSetting{level: level},
+		overrides:    make(map[LogLevelTarget]*logLevelSetting),
+	}
+	c.minLevel.Set(level)
+	c.globalLevel.Set(level)
+	return c
+}
+
+// Level implements slog.Leveler, returning the most verbose level in effect. Handlers wrapped
+// by Handler should use c as their level.
+func (c *LogLevelController) Level() slog.Level {
+	return c.minLevel.Level()
+}
+
+// SetLevel sets the global level, reverting to the default level after duration unless it is 0.
+func (c *LogLevelController) SetLevel(level slog.Level, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.global.stop()
+	c.global = c.newSetting(level, duration, func(setting *logLevelSetting) bool {
+		if c.global != setting {
+			return false
+		}
+		c.global = &logLevelSetting{level: c.defaultLevel}
+		return true
+	}, "Log level reverted", "level", c.defaultLevel)
+	c.updateLocked()
+}
+
+// RevertAfter returns the default duration of changes.
+func (c *LogLevelController) RevertAfter() time.Duration {
+	return c.revertAfter
+}
+
+// ToggleDebug sets the global level to debug for the default duration of changes, or back to
+// the default level if it is already debug. It returns the new level. The start command calls it
+// on SIGUSR1, as SIGHUP reloads the configuration.
+func (c *LogLevelController) ToggleDebug() slog.Level {
+	if c.globalLevel.Level() <= slog.LevelDebug {
+		c.ResetLevel()
+	} else {
+		c.SetLevel(slog.LevelDebug, c.revertAfter)
+	}
+	return c.globalLevel.Level()
+}
+
+// ResetLevel sets the global level back to the default level.
+func (c *LogLevelController) ResetLevel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.global.stop()
+	c.global = &logLevelSetting{level: c.defaultLevel}
+	c.updateLocked()
+}
+
+// SetOverride sets the level of the records of target, removing the override after duration
+// unless it is 0.
+func (c *LogLevelController) SetOverride(target LogLevelTarget, level slog.Level, duration time.Duration) error {
+	if target.Kind != LogTargetModel && target.Kind != LogTargetPackage {
+		return fmt.Errorf("invalid log level target kind %q (want %s or %s)", target.Kind, LogTargetModel, LogTargetPackage)
+	}
+	if target.Name == "" {
+		return fmt.Errorf("missing %s name of the log level override", target.Kind)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.overrides[target].stop()
+	c.overrides[target] = c.newSetting(level, duration, func(setting *logLevelSetting) bool {
+		if c.overrides[target] != setting {
+			return false
+		}
+		delete(c.overrides, target)
+		return true
+	}, "Log level override expired", "target", target.Kind, "name", target.Name)
+	c.updateLocked()
+	return nil
+}
+
+// RemoveOverride removes the override of target, if any.
+func (c *LogLevelController) RemoveOverride(target LogLevelTarget) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.overrides[target].stop()
+	delete(c.overrides, target)
+	c.updateLocked()
+}
+
+// State returns the current levels, with the overrides sorted by target.
+func (c *LogLevelController) State() LogLevelState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := LogLevelState{
+		Level:        c.global.level,
+		DefaultLevel: c.defaultLevel,
+		ExpiresAt:    c.global.expiry(),
+		Overrides:    make([]LogLevelOverride, 0, len(c.overrides)),
+	}
+	for target, setting := range c.overrides {
+		state.Overrides = append(state.Overrides, LogLevelOverride{
+			LogLevelTarget: target,
+			Level:          setting.level,
+			ExpiresAt:      setting.expiry(),
+		})
+	}
+	sort.Slice(state.Overrides, func(i, j int) bool {
+		if state.Overrides[i].Kind != state.Overrides[j].Kind {
+			return state.Overrides[i].Kind < state.Overrides[j].Kind
+		}
+		return state.Overrides[i].Name < state.Overrides[j].Name
+	})
+	return state
+}
+
+// TrackRequest associates the records of a request in progress with its model, for model
+// overrides; call the returned function once the request is over.
+func (c *LogLevelController) TrackRequest(requestID string, model string) func() {
+	if requestID == "" || model == "" {
+		return func() {}
+	}
+	c.requestModels.Store(requestID, model)
+	return func() { c.requestModels.Delete(requestID) }
+}
+
+// newSetting creates a setting of level, calling revert with c.mu held after duration unless
+// it is 0. If revert reports that the setting was still in effect, message is logged with args.
+// c.mu must be held.
+func (c *LogLevelController) newSetting(
+	level slog.Level,
+	duration time.Duration,
+	revert func(setting *logLevelSetting) bool,
+	message string,
+	args ...any,
+) *logLevelSetting {
+	setting := &logLevelSetting{level: level}
+	if duration > 0 {
+		setting.expiresAt = time.Now().Add(duration)
+		setting.timer = time.AfterFunc(duration, func() {
+			c.mu.Lock()
+			reverted := revert(setting)
+			c.updateLocked()
+			c.mu.Unlock()
+			// Logged without the lock, which the log handler takes.
+			if reverted {
+				slog.Info(message, args...)
+			}
+		})
+	}
+	return setting
+}
+
+// updateLocked updates the cached minimum level. c.mu must be held.
+func (c *LogLevelController) updateLocked() {
+	minLevel := c.global.level
+	for _, setting := range c.overrides {
+		minLevel = min(minLevel, setting.level)
+	}
+	c.minLevel.Set(minLevel)
+	c.globalLevel.Set(c.global.level)
+	c.hasOverrides.Store(len(c.overrides) > 0)
+}
+
+// stop stops the revert timer of s, if any; s may be nil.
+func (s *logLevelSetting) stop() {
+	if s != nil && s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// expiry returns the expiration time of s, or nil if it is permanent.
+func (s *logLevelSetting) expiry() *time.Time {
+	if s.expiresAt.IsZero() {
+		return nil
+	}
+	expiresAt := s.expiresAt
+	return &expiresAt
+}
+
+// effectiveLevel returns the level applying to a record about model, emitted at pc: the most
+// verbose of the matching overrides, or the global level if none matches.
+func (c *LogLevelController) effectiveLevel(pc uintptr, model string) slog.Level {
+	if !c.hasOverrides.Load() {
+		return c.globalLevel.Level()
+	}
+	pkg := c.packageOf(pc)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matched := false
+	var level slog.Level
+	apply := func(target LogLevelTarget) {
+		if setting, ok := c.overrides[target]; ok {
+			if !matched || setting.level < level {
+				level = setting.level
+			}
+			matched = true
+		}
+	}
+	if model != "" {
+		apply(LogLevelTarget{Kind: LogTargetModel, Name: model})
+	}
+	if pkg != "" {
+		apply(LogLevelTarget{Kind: LogTargetPackage, Name: pkg})
+		if i := strings.LastIndex(pkg, "/"); i >= 0 {
+			apply(LogLevelTarget{Kind: LogTargetPackage, Name: pkg[i+1:]})
+		}
+	}
+	if !matched {
+		return c.global.level
+	}
+	return level
+}
+
+// packageOf returns the import path of the package of the function at pc.
+func (c *LogLevelController) packageOf(pc uintptr) string {
+	if pc == 0 {
+		return ""
+	}
+	if pkg, ok := c.packages.Load(pc); ok {
+		return pkg.(string)
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	// Functions are named like github.com/org/module/pkg.(*Type).Method.func1.
+	pkg := frame.Function
+	slash := max(strings.LastIndex(pkg, "/"), 0)
+	if dot := strings.Index(pkg[slash:], "."); dot >= 0 {
+		pkg = pkg[:slash+dot]
+	}
+	c.packages.Store(pc, pkg)
+	return pkg
+}
+
+// Handler returns a slog.Handler passing to next the records enabled by the levels of c.
+func (c *LogLevelController) Handler(next slog.Handler) slog.Handler {
+	return &levelHandler{controller: c, next: next}
+}
+
+// levelHandler filters records by the levels of a LogLevelController. It remembers the request
+// ID and model of the attributes added to it, to find the model of records.
+type levelHandler struct {
+	controller *LogLevelController
+	next       slog.Handler
+	requestID  string
+	model      string
+}
+
+// Enabled implements slog.Handler.
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.controller.Level() && h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	model := h.model
+	if h.controller.hasOverrides.Load() {
+		record.Attrs(func(attr slog.Attr) bool {
+			for _, key := range modelLogKeys {
+				if attr.Key == key {
+					model = attr.Value.String()
+					return false
+				}
+			}
+			return true
+		})
+		if model == "" && h.requestID != "" {
+			if requestModel, ok := h.controller.requestModels.Load(h.requestID); ok {
+				model = requestModel.(string)
+			}
+		}
+	}
+	if record.Level < h.controller.effectiveLevel(record.PC, model) {
+		return nil
+	}
+	return h.next.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *h
+	derived.next = h.next.WithAttrs(attrs)
+	for _, attr := range attrs {
+		if attr.Key == "request_id" {
+			derived.requestID = attr.Value.String()
+		}
+		for _, key := range modelLogKeys {
+			if attr.Key == key {
+				derived.model = attr.Value.String()
+			}
+		}
+	}
+	return &derived
+}
+
+// WithGroup implements slog.Handler.
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	derived := *h
+	derived.next = h.next.WithGroup(name)
+	return &derived
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"debug", slog.LevelDebug, false},
+		{" WARN ", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			level, err := ParseLogLevel(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, level)
+		})
+	}
+}
+
+func TestLogLevelControllerRevert(t *testing.T) {
+	c := NewLogLevelController(slog.LevelInfo, time.Hour)
+
+	c.SetLevel(slog.LevelDebug, 0)
+	assert.Equal(t, slog.LevelDebug, c.Level())
+	assert.Nil(t, c.State().ExpiresAt, "levels set without a duration are permanent")
+
+	c.SetLevel(slog.LevelError, 10*time.Millisecond)
+	state := c.State()
+	assert.Equal(t, slog.LevelError, state.Level)
+	require.NotNil(t, state.ExpiresAt)
+	require.Eventually(t, func() bool { return c.State().Level == slog.LevelInfo }, time.Second, time.Millisecond,
+		"the level reverts to the default level")
+
+	require.NoError(t, c.SetOverride(LogLevelTarget{Kind: LogTargetModel, Name: "GPT-4o"}, slog.LevelDebug, 10*time.Millisecond))
+	assert.Equal(t, slog.LevelDebug, c.Level(), "the most verbose level is in effect")
+	require.Eventually(t, func() bool { return len(c.State().Overrides) == 0 }, time.Second, time.Millisecond,
+		"the override expires")
+	assert.Equal(t, slog.LevelInfo, c.Level())
+}
+
+func TestLogLevelControllerToggleDebug(t *testing.T) {
+	c := NewLogLevelController(slog.LevelWarn, time.Hour)
+	assert.Equal(t, slog.LevelDebug, c.ToggleDebug())
+	assert.NotNil(t, c.State().ExpiresAt, "debug logs are turned on for the default duration")
+	assert.Equal(t, slog.LevelWarn, c.ToggleDebug())
+	assert.Nil(t, c.State().ExpiresAt)
+}
+
+func TestLogLevelControllerOverrides(t *testing.T) {
+	c := NewLogLevelController(slog.LevelWarn, 0)
+	require.NoError(t, c.SetOverride(LogLevelTarget{Kind: LogTargetModel, Name: "GPT-4o"}, slog.LevelDebug, 0))
+	require.NoError(t, c.SetOverride(LogLevelTarget{Kind: LogTargetPackage, Name: "client"}, slog.LevelDebug, 0))
+	assert.Error(t, c.SetOverride(LogLevelTarget{Kind: "user", Name: "bob"}, slog.LevelDebug, 0))
+	assert.Error(t, c.SetOverride(LogLevelTarget{Kind: LogTargetModel}, slog.LevelDebug, 0))
+	assert.Equal(t, []LogLevelOverride{
+		{LogLevelTarget: LogLevelTarget{Kind: LogTargetModel, Name: "GPT-4o"}, Level: slog.LevelDebug},
+		{LogLevelTarget: LogLevelTarget{Kind: LogTargetPackage, Name: "client"}, Level: slog.LevelDebug},
+	}, c.State().Overrides)
+
+	var buf bytes.Buffer
+	logger := slog.New(c.Handler(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: c})))
+	defer c.TrackRequest("req-1", "GPT-4o")()
+
+	logger.Debug("other model", "model", "Claude-Sonnet-4")
+	logger.Debug("model attribute", "bot_name", "GPT-4o")
+	logger.With("model", "GPT-4o").Debug("model of the logger")
+	logger.With("request_id", "req-1").Debug("model of the request")
+	logger.With("request_id", "req-2").Debug("other request")
+	logger.Warn("global level")
+
+	logged := buf.String()
+	assert.NotContains(t, logged, "other model")
+	assert.Contains(t, logged, "model attribute")
+	assert.Contains(t, logged, "model of the logger")
+	assert.Contains(t, logged, "model of the request")
+	assert.NotContains(t, logged, "other request")
+	assert.Contains(t, logged, "global level")
+
+	// Records emitted by this package do not match the client package override.
+	c.RemoveOverride(LogLevelTarget{Kind: LogTargetModel, Name: "GPT-4o"})
+	buf.Reset()
+	logger.Debug("service package", "model", "GPT-4o")
+	assert.Empty(t, buf.String())
+	require.NoError(t, c.SetOverride(LogLevelTarget{Kind: LogTargetPackage, Name: "service"}, slog.LevelDebug, 0))
+	logger.Debug("service package")
+	assert.Contains(t, buf.String(), "service package")
+}
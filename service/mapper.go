@@ -99,6 +99,7 @@ func TransformOpenAIRequestToPoeQuery(
 	poeMessages := make([]types.PoeProtocolMessage, len(openAIReq.Messages))
 	for i, msg := range openAIReq.Messages {
 		var contentStr string
+		var attachments []types.PoeAttachment
 		switch c := msg.Content.(type) {
 		case string:
 			contentStr = c
@@ -109,7 +110,11 @@ func TransformOpenAIRequestToPoeQuery(
 				case "text":
 					textParts = append(textParts, part.Text)
 				case "image_url":
-					slog.Warn("Image_url content part received in OpenAI request (typed []OpenAIContentPart), mapping to Poe attachment not yet implemented.", "message_index", i)
+					if part.ImageURL == nil || part.ImageURL.URL == "" {
+						slog.Warn("Image_url content part has no URL, skipping it.", "message_index", i)
+						continue
+					}
+					attachments = append(attachments, OpenAIImageURLToPoeAttachment(part.ImageURL.URL, len(attachments)))
 				default:
 					slog.Warn("Unknown OpenAI content part type (typed []OpenAIContentPart)", "type", part.Type, "message_index", i)
 				}
@@ -129,9 +134,13 @@ func TransformOpenAIRequestToPoeQuery(
 							slog.Warn("OpenAI 'text' content part has non-string text field.", "message_index", i, "part_index", partIdx, "text_field", partMap["text"])
 						}
 					case "image_url":
-						// imageURLData, _ := partMap["image_url"].(map[string]interface{})
-						// url, _ := imageURLData["url"].(string)
-						slog.Warn("Image_url content part received in OpenAI request (unmarshalled []interface{}), mapping to Poe attachment not yet implemented.", "message_index", i, "part_index", partIdx)
+						imageURLData, _ := partMap["image_url"].(map[string]interface{})
+						url, _ := imageURLData["url"].(string)
+						if url == "" {
+							slog.Warn("Image_url content part has no URL, skipping it.", "message_index", i, "part_index", partIdx)
+							continue
+						}
+						attachments = append(attachments, OpenAIImageURLToPoeAttachment(url, len(attachments)))
 					default:
 						slog.Warn("Unknown OpenAI content part type in []interface{}", "type", partType, "message_index", i, "part_index", partIdx)
 					}
@@ -151,42 +160,15 @@ func TransformOpenAIRequestToPoeQuery(
 		}
 
 		poeMessages[i] = types.PoeProtocolMessage{
-			Role:    OpenAIToPoeRole(msg.Role),
-			Content: contentStr,
-		}
-	}
-
-	var poeStopSequences []string
-	if openAIReq.Stop != nil {
-		switch v := openAIReq.Stop.(type) {
-		case string:
-			if v != "" {
-				poeStopSequences = []string{v}
-			}
-		case []interface{}:
-			for _, sVal := range v {
-				if str, ok := sVal.(string); ok {
-					poeStopSequences = append(poeStopSequences, str)
-				}
-			}
-		case []string:
-			poeStopSequences = v
+			Role:        OpenAIToPoeRole(msg.Role),
+			Content:     contentStr,
+			Attachments: attachments,
 		}
 	}
 
-	var poeTemperature *float64
-	if openAIReq.Temperature != 0 {
-		temp := openAIReq.Temperature
-		poeTemperature = &temp
-	}
+	poeStopSequences := OpenAIStopToPoeStopSequences(openAIReq.Stop)
 
-	var poeLogitBias map[string]float64
-	if openAIReq.LogitBias != nil {
-		poeLogitBias = make(map[string]float64)
-		for tokenID, bias := range openAIReq.LogitBias {
-			poeLogitBias[tokenID] = float64(bias)
-		}
-	}
+	poeLogitBias := openAILogitBiasToPoe(openAIReq.LogitBias)
 
 	if openAIReq.N > 1 {
 		slog.Warn(
@@ -195,11 +177,7 @@ func TransformOpenAIRequestToPoeQuery(
 		)
 	}
 
-	if openAIReq.MaxTokens != nil || openAIReq.MaxCompletionTokens != nil {
-		slog.Warn(
-			"OpenAI 'max_tokens' or 'max_completion_tokens' provided, but not directly supported by Poe QueryRequest. This limit will not be enforced by Poe.",
-		)
-	}
+	warnUnenforcedTokenLimit(openAIReq.MaxTokens, openAIReq.MaxCompletionTokens)
 
 	poeTools := OpenAIModelsToPoeToolDefinitions(openAIReq.Tools)
 	if openAIReq.ToolChoice != nil {
@@ -240,7 +218,7 @@ func TransformOpenAIRequestToPoeQuery(
 		ConversationID:   conversationID,
 		MessageID:        lastMessageID,
 		APIKey:           poeAPIKey,
-		Temperature:      poeTemperature,
+		Temperature:      openAIReq.Temperature,
 		SkipSystemPrompt: skipSystemPrompt,
 		StopSequences:    poeStopSequences,
 		Tools:            poeTools,
@@ -254,6 +232,53 @@ func TransformOpenAIRequestToPoeQuery(
 	return poeQuery, nil
 }
 
+// OpenAIStopToPoeStopSequences converts the OpenAI 'stop' field, a string or a list of strings,
+// into Poe stop sequences. Empty or unrecognized values yield no stop sequences.
+func OpenAIStopToPoeStopSequences(stop any) []string {
+	var poeStopSequences []string
+	switch v := stop.(type) {
+	case string:
+		if v != "" {
+			poeStopSequences = []string{v}
+		}
+	case []interface{}:
+		for _, sVal := range v {
+			if str, ok := sVal.(string); ok {
+				poeStopSequences = append(poeStopSequences, str)
+			}
+		}
+	case []string:
+		poeStopSequences = v
+	}
+	return poeStopSequences
+}
+
+// openAILogitBiasToPoe converts an OpenAI logit bias map into the Poe format.
+func openAILogitBiasToPoe(logitBias map[string]int) map[string]float64 {
+	if logitBias == nil {
+		return nil
+	}
+	poeLogitBias := make(map[string]float64, len(logitBias))
+	for tokenID, bias := range logitBias {
+		poeLogitBias[tokenID] = float64(bias)
+	}
+	return poeLogitBias
+}
+
+// warnUnenforcedTokenLimit logs a warning if any OpenAI token limit (e.g., 'max_tokens') is set,
+// as Poe QueryRequest has no equivalent and the limit is not enforced.
+func warnUnenforcedTokenLimit(limits ...*int) {
+	for _, limit := range limits {
+		if limit != nil {
+			slog.Warn(
+				"OpenAI 'max_tokens' or 'max_completion_tokens' provided, but not directly supported by Poe QueryRequest. This limit will not be enforced by Poe.",
+				"max_tokens", *limit,
+			)
+			return
+		}
+	}
+}
+
 // MapPoeRoleToOpenAIRole maps Poe message roles back to their OpenAI equivalents.
 func MapPoeRoleToOpenAIRole(poeRole string) string {
 	slog.Debug("Mapping Poe role to OpenAI role", "poe_role", poeRole)
@@ -282,6 +307,8 @@ func MapPoeRoleToOpenAIRole(poeRole string) string {
 //     content-producing delta, used to set the 'role' field in the OpenAI chunk.
 //   - hasMadeToolCall: Pointer to a boolean flag, managed by the caller, to track if any tool calls
 //     have been processed, used to determine the 'finish_reason'.
+//   - includeAttachments: Whether "file" events should also be listed in the delta's
+//     attachments extension field, in addition to being rendered as markdown content.
 //
 // Returns the formatted SSE data as bytes, or an error.
 func TransformPoeEventToOpenAIChatCompletionChunk(
@@ -291,6 +318,7 @@ func TransformPoeEventToOpenAIChatCompletionChunk(
 	createdTimestamp int64,
 	isFirstContentChunk *bool,
 	hasMadeToolCall *bool,
+	includeAttachments bool,
 ) ([]byte, error) {
 	slog.Debug(
 		"Transforming Poe event to OpenAI chunk",
@@ -333,6 +361,28 @@ func TransformPoeEventToOpenAIChatCompletionChunk(
 			roleWasSetThisChunk = true
 		}
 		slog.Debug("Processed 'replace_response' as text delta for OpenAI chunk.")
+	case "file": // Files sent by the bot, e.g., images from image-generation bots
+		var poeFileData types.PoeFileEventData
+		if err := json.Unmarshal([]byte(poeEvent.Data), &poeFileData); err != nil {
+			slog.Error(
+				"Error unmarshalling Poe 'file' event data",
+				"error", err, "data", poeEvent.Data,
+			)
+			return nil, fmt.Errorf("error unmarshalling Poe 'file' event data: %w", err)
+		}
+		choice.Delta.Content = RenderPoeFileDelta(poeFileData)
+		if includeAttachments {
+			choice.Delta.Attachments = []types.OpenAIAttachment{poeFileToOpenAIAttachment(poeFileData)}
+		}
+		if *isFirstContentChunk {
+			choice.Delta.Role = "assistant"
+			roleWasSetThisChunk = true
+		}
+		slog.Debug(
+			"Processed Poe 'file' event as markdown content",
+			"file_name", poeFileData.Name,
+			"content_type", poeFileData.ContentType,
+		)
 	case "suggested_reply": // OpenAI doesn't have a direct equivalent in stream chunks
 		slog.Debug("Ignoring Poe 'suggested_reply' event for OpenAI stream.")
 		return nil, nil // Skip this event
@@ -472,6 +522,67 @@ func TransformPoeEventToOpenAIChatCompletionChunk(
 	return buffer.Bytes(), nil
 }
 
+// RenderPoeFileAsMarkdown renders a file sent by a Poe bot as markdown.
+// Images are rendered as inline images, any other content type as a link.
+func RenderPoeFileAsMarkdown(file types.PoeFileEventData) string {
+	name := file.Name
+	if name == "" {
+		name = "file"
+	}
+	if strings.HasPrefix(file.ContentType, "image/") {
+		return fmt.Sprintf("![%s](%s)", name, file.URL)
+	}
+	return fmt.Sprintf("[%s](%s)", name, file.URL)
+}
+
+// RenderPoeFileDelta renders a file sent by a Poe bot as a streamed markdown text delta.
+// Files with an inline_ref are referenced in the bot's text as ![alt][inline_ref], so a markdown
+// reference definition is emitted for them, letting the client's renderer resolve the reference
+// whichever event came first. Other files are rendered with RenderPoeFileAsMarkdown.
+func RenderPoeFileDelta(file types.PoeFileEventData) string {
+	if file.InlineRef != nil && *file.InlineRef != "" {
+		return fmt.Sprintf("\n\n[%s]: %s\n", *file.InlineRef, file.URL)
+	}
+	return "\n\n" + RenderPoeFileAsMarkdown(file) + "\n"
+}
+
+// poeFileToOpenAIAttachment maps a Poe file event to the OpenAI attachments extension.
+func poeFileToOpenAIAttachment(file types.PoeFileEventData) types.OpenAIAttachment {
+	return types.OpenAIAttachment{
+		URL:         file.URL,
+		ContentType: file.ContentType,
+		Name:        file.Name,
+	}
+}
+
+// resolvePoeFileReferences renders the files sent by a Poe bot into the aggregated response text.
+// Files with an inline_ref that the text references as "[alt][inline_ref]" are resolved in place
+// to "[alt](url)"; all other files are appended to the text as markdown.
+func resolvePoeFileReferences(text string, files []types.PoeFileEventData) string {
+	var appended []string
+	for _, file := range files {
+		if file.InlineRef != nil && *file.InlineRef != "" {
+			ref := "][" + *file.InlineRef + "]"
+			if strings.Contains(text, ref) {
+				text = strings.ReplaceAll(text, ref, "]("+file.URL+")")
+				continue
+			}
+			slog.Debug(
+				"Poe file inline_ref not referenced in response text, appending file instead",
+				"inline_ref", *file.InlineRef,
+			)
+		}
+		appended = append(appended, RenderPoeFileAsMarkdown(file))
+	}
+	if len(appended) == 0 {
+		return text
+	}
+	if text == "" {
+		return strings.Join(appended, "\n\n")
+	}
+	return text + "\n\n" + strings.Join(appended, "\n\n")
+}
+
 // mapPoeToolCallDataToOpenAI attempts to parse tool call data from a Poe "json" event.
 // Poe bots (especially those wrapping OpenAI models) might send tool calls in a structure
 // similar to OpenAI's own streaming chunks, nested within the "json" event's data field.
@@ -534,11 +645,14 @@ func mapPoeToolCallDataToOpenAI(poeToolCallsData []interface{}) ([]types.OpenAIT
 
 // AggregatePoeEventsToOpenAIResponse processes a complete list of Poe SSE events
 // and aggregates them into a single, non-streaming OpenAI chat completion response.
+// Files sent by the bot are rendered as markdown in the content; if includeAttachments is true
+// they are also listed in the message's attachments extension field.
 func AggregatePoeEventsToOpenAIResponse(
 	poeEvents []types.PoeSSEEvent,
 	requestModelID string,
 	completionID string,
 	createdTimestamp int64,
+	includeAttachments bool,
 ) (*types.OpenAIChatCompletionResponse, error) {
 	slog.Debug(
 		"Aggregating Poe events to OpenAI non-streaming response",
@@ -549,6 +663,7 @@ func AggregatePoeEventsToOpenAIResponse(
 	finalRole := "assistant" // Default role for the aggregated message.
 	finalFinishReason := "stop"
 	var openAIToolCalls []types.OpenAIToolCall
+	var poeFiles []types.PoeFileEventData
 	var poeReportedErrorText string
 
 	for _, poeEvent := range poeEvents {
@@ -653,6 +768,16 @@ func AggregatePoeEventsToOpenAIResponse(
 			// Note: The OpenAI spec doesn't have a top-level error field in ChatCompletionResponse for bot errors.
 			// Errors are typically HTTP status codes or in the stream. We include it in content here.
 
+		case "file":
+			var poeFileData types.PoeFileEventData
+			if err := json.Unmarshal([]byte(poeEvent.Data), &poeFileData); err != nil {
+				slog.Error(
+					"Error unmarshalling Poe 'file' event data during aggregation",
+					"error", err, "data", poeEvent.Data,
+				)
+				continue
+			}
+			poeFiles = append(poeFiles, poeFileData)
 		case "meta", "suggested_reply": // Ignore these for aggregated response
 			slog.Debug("Ignoring Poe event during aggregation", "event_type", poeEvent.Event)
 		default:
@@ -663,7 +788,13 @@ func AggregatePoeEventsToOpenAIResponse(
 		}
 	}
 
-	contentStr := responseContent.String()
+	contentStr := resolvePoeFileReferences(responseContent.String(), poeFiles)
+	var attachments []types.OpenAIAttachment
+	if includeAttachments {
+		for _, file := range poeFiles {
+			attachments = append(attachments, poeFileToOpenAIAttachment(file))
+		}
+	}
 	var contentPtr *string
 	// OpenAI spec: `content` is nullable. It should be null if `tool_calls` is present and there's no text content.
 	if contentStr != "" || (len(openAIToolCalls) == 0 && contentStr == "") {
@@ -683,9 +814,10 @@ func AggregatePoeEventsToOpenAIResponse(
 			{
 				Index: 0,
 				Message: types.OpenAIResponseMessage{
-					Role:      finalRole, // Should always be "assistant"
-					Content:   contentPtr,
-					ToolCalls: openAIToolCalls,
+					Role:        finalRole, // Should always be "assistant"
+					Content:     contentPtr,
+					ToolCalls:   openAIToolCalls,
+					Attachments: attachments,
 				},
 				FinishReason: finalFinishReason,
 			},
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// fileEvent returns the Poe event of a file sent by a bot.
+func fileEvent(t *testing.T, file types.PoeFileEventData) types.PoeSSEEvent {
+	t.Helper()
+	data, err := json.Marshal(file)
+	require.NoError(t, err)
+	return types.PoeSSEEvent{Event: "file", Data: string(data)}
+}
+
+func TestAggregatePoeFileEvents(t *testing.T) {
+	ref := "img-1"
+	image := types.PoeFileEventData{URL: "https://pfst.cf2.poecdn.net/cat.png", ContentType: "image/png", Name: "cat.png"}
+	referenced := image
+	referenced.InlineRef = &ref
+	report := types.PoeFileEventData{URL: "https://pfst.cf2.poecdn.net/report.pdf", ContentType: "application/pdf", Name: "report.pdf"}
+
+	tests := []struct {
+		name               string
+		text               string
+		files              []types.PoeFileEventData
+		includeAttachments bool
+		wantContent        string
+	}{
+		{
+			name:        "image without text",
+			files:       []types.PoeFileEventData{image},
+			wantContent: "![cat.png](https://pfst.cf2.poecdn.net/cat.png)",
+		},
+		{
+			name:        "other files are links",
+			text:        "Here it is.",
+			files:       []types.PoeFileEventData{report},
+			wantContent: "Here it is.\n\n[report.pdf](https://pfst.cf2.poecdn.net/report.pdf)",
+		},
+		{
+			name:               "inline reference resolved",
+			text:               "A cat: ![cat][img-1]",
+			files:              []types.PoeFileEventData{referenced},
+			includeAttachments: true,
+			wantContent:        "A cat: ![cat](https://pfst.cf2.poecdn.net/cat.png)",
+		},
+		{
+			name:        "inline reference not in the text",
+			text:        "A cat.",
+			files:       []types.PoeFileEventData{referenced},
+			wantContent: "A cat.\n\n![cat.png](https://pfst.cf2.poecdn.net/cat.png)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []types.PoeSSEEvent
+			if tt.text != "" {
+				events = append(events, types.PoeSSEEvent{Event: "text", Data: `{"text":"` + tt.text + `"}`})
+			}
+			for _, file := range tt.files {
+				events = append(events, fileEvent(t, file))
+			}
+			events = append(events, types.PoeSSEEvent{Event: "done", Data: "{}"})
+
+			resp, err := AggregatePoeEventsToOpenAIResponse(events, "GPT-Image-1", "chatcmpl-1", 0, tt.includeAttachments)
+			require.NoError(t, err)
+			require.Len(t, resp.Choices, 1)
+			message := resp.Choices[0].Message
+			require.NotNil(t, message.Content)
+			assert.Equal(t, tt.wantContent, *message.Content)
+			if !tt.includeAttachments {
+				assert.Empty(t, message.Attachments)
+				return
+			}
+			require.Len(t, message.Attachments, len(tt.files))
+			assert.Equal(t, tt.files[0].URL, message.Attachments[0].URL)
+			assert.Equal(t, tt.files[0].ContentType, message.Attachments[0].ContentType)
+			assert.Equal(t, tt.files[0].Name, message.Attachments[0].Name)
+		})
+	}
+}
+
+func TestTransformPoeFileEvent(t *testing.T) {
+	ref := "img-1"
+	image := types.PoeFileEventData{URL: "https://pfst.cf2.poecdn.net/cat.png", ContentType: "image/png", Name: "cat.png"}
+	referenced := image
+	referenced.InlineRef = &ref
+
+	tests := []struct {
+		name               string
+		file               types.PoeFileEventData
+		includeAttachments bool
+		wantContent        string
+	}{
+		{"image", image, false, "\n\n![cat.png](https://pfst.cf2.poecdn.net/cat.png)\n"},
+		{"inline reference definition", referenced, false, "\n\n[img-1]: https://pfst.cf2.poecdn.net/cat.png\n"},
+		{"with attachments", image, true, "\n\n![cat.png](https://pfst.cf2.poecdn.net/cat.png)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isFirstContentChunk, hasMadeToolCall := true, false
+			data, err := TransformPoeEventToOpenAIChatCompletionChunk(
+				fileEvent(t, tt.file), "GPT-Image-1", "chatcmpl-1", 0, &isFirstContentChunk, &hasMadeToolCall, tt.includeAttachments,
+			)
+			require.NoError(t, err)
+			var chunk types.OpenAIChatCompletionChunk
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(string(data), "data: "))), &chunk))
+			require.Len(t, chunk.Choices, 1)
+			delta := chunk.Choices[0].Delta
+			assert.Equal(t, tt.wantContent, delta.Content)
+			assert.Equal(t, "assistant", delta.Role, "the first content chunk has the role")
+			assert.False(t, isFirstContentChunk)
+			if tt.includeAttachments {
+				require.Len(t, delta.Attachments, 1)
+				assert.Equal(t, tt.file.URL, delta.Attachments[0].URL)
+			} else {
+				assert.Empty(t, delta.Attachments)
+			}
+		})
+	}
+
+	isFirstContentChunk, hasMadeToolCall := true, false
+	_, err := TransformPoeEventToOpenAIChatCompletionChunk(
+		types.PoeSSEEvent{Event: "file", Data: "not json"}, "GPT-Image-1", "chatcmpl-1", 0, &isFirstContentChunk, &hasMadeToolCall, false,
+	)
+	assert.Error(t, err)
+}
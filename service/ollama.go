@@ -0,0 +1,208 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// ollamaDefaultTag is the tag Ollama clients append to model names without one.
+const ollamaDefaultTag = ":latest"
+
+// OllamaModelToPoeBot maps an Ollama model name to the Poe bot name, stripping the ":latest" tag.
+func OllamaModelToPoeBot(model string) string {
+	return strings.TrimSuffix(model, ollamaDefaultTag)
+}
+
+// TransformOllamaChatRequestToChatRequest builds the OpenAI chat completion request equivalent
+// to an Ollama /api/chat request.
+func TransformOllamaChatRequestToChatRequest(
+	req *types.OllamaChatRequest,
+) *types.OpenAIChatCompletionRequest {
+	messages := make([]types.OpenAIMessage, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		content := msg.Content
+		for _, toolCall := range msg.ToolCalls {
+			arguments, _ := json.Marshal(toolCall.Function.Arguments)
+			content += "\n" + RenderFunctionCall("", toolCall.Function.Name, string(arguments))
+		}
+		messages = append(messages, types.OpenAIMessage{
+			Role:    msg.Role,
+			Content: ollamaContent(content, msg.Images),
+		})
+	}
+	chatReq := &types.OpenAIChatCompletionRequest{
+		Model:    OllamaModelToPoeBot(req.Model),
+		Messages: messages,
+		Tools:    req.Tools,
+	}
+	applyOllamaOptions(chatReq, req.Options, req.Format)
+	return chatReq
+}
+
+// TransformOllamaGenerateRequestToChatRequest builds the OpenAI chat completion request equivalent
+// to an Ollama /api/generate request: an optional system message and a single user message.
+func TransformOllamaGenerateRequestToChatRequest(
+	req *types.OllamaGenerateRequest,
+) *types.OpenAIChatCompletionRequest {
+	var messages []types.OpenAIMessage
+	if req.System != "" {
+		messages = append(messages, types.OpenAIMessage{Role: "system", Content: req.System})
+	}
+	if req.Suffix != "" {
+		slog.Warn("Ollama 'suffix' (fill-in-the-middle) is not supported by Poe, ignoring it")
+	}
+	messages = append(messages, types.OpenAIMessage{
+		Role:    "user",
+		Content: ollamaContent(req.Prompt, req.Images),
+	})
+	chatReq := &types.OpenAIChatCompletionRequest{
+		Model:    OllamaModelToPoeBot(req.Model),
+		Messages: messages,
+	}
+	applyOllamaOptions(chatReq, req.Options, req.Format)
+	return chatReq
+}
+
+// ollamaContent builds OpenAI message content from Ollama text and base64-encoded images.
+// Images become image_url parts with data URIs, which are uploaded to Poe before the query is sent.
+func ollamaContent(text string, images []string) any {
+	if len(images) == 0 {
+		return text
+	}
+	parts := []types.OpenAIContentPart{{Type: "text", Text: text}}
+	for i, image := range images {
+		data, err := base64.StdEncoding.DecodeString(image)
+		if err != nil {
+			slog.Warn("Ollama image is not valid base64, skipping it", "image_index", i, "error", err)
+			continue
+		}
+		parts = append(parts, types.OpenAIContentPart{
+			Type: "image_url",
+			ImageURL: &types.OpenAIImageURL{
+				URL: fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(data), image),
+			},
+		})
+	}
+	return parts
+}
+
+// applyOllamaOptions maps Ollama model options to the OpenAI chat completion request.
+func applyOllamaOptions(
+	chatReq *types.OpenAIChatCompletionRequest,
+	options *types.OllamaOptions,
+	format any,
+) {
+	if format == "json" {
+		chatReq.ResponseFormat = &types.ResponseFormat{Type: "json_object"}
+	} else if format != nil {
+		slog.Warn("Ollama structured output 'format' is not supported by Poe, ignoring it")
+	}
+	if options == nil {
+		return
+	}
+	chatReq.Temperature = options.Temperature
+	if options.TopP != nil {
+		chatReq.TopP = *options.TopP
+	}
+	chatReq.Seed = options.Seed
+	if len(options.Stop) > 0 {
+		chatReq.Stop = options.Stop
+	}
+	if options.NumPredict != nil && *options.NumPredict > 0 {
+		chatReq.MaxTokens = options.NumPredict
+	}
+}
+
+// OpenAIToolCallsToOllama converts tool calls to the Ollama format, decoding the JSON arguments.
+func OpenAIToolCallsToOllama(toolCalls []types.OpenAIToolCall) []types.OllamaToolCall {
+	ollamaToolCalls := make([]types.OllamaToolCall, 0, len(toolCalls))
+	for _, toolCall := range toolCalls {
+		arguments := map[string]any{}
+		if toolCall.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments); err != nil {
+				slog.Warn(
+					"Tool call arguments from Poe are not a JSON object, using empty arguments",
+					"tool_name", toolCall.Function.Name,
+					"error", err,
+				)
+			}
+		}
+		ollamaToolCalls = append(ollamaToolCalls, types.OllamaToolCall{
+			Function: types.OllamaToolCallFunction{
+				Name:      toolCall.Function.Name,
+				Arguments: arguments,
+			},
+		})
+	}
+	return ollamaToolCalls
+}
+
+// ollamaModelDetails builds the Ollama details of a catalog model.
+func ollamaModelDetails(model ModelInfo) types.OllamaModelDetails {
+	family := model.Family
+	if family == "" {
+		family = "poe"
+	}
+	return types.OllamaModelDetails{
+		Format:   "poe",
+		Family:   family,
+		Families: []string{family},
+	}
+}
+
+// ModelInfoToOllamaModel converts a catalog model into an Ollama /api/tags entry.
+// Poe bots have no local weights, so the size is 0 and the digest is derived from the name.
+func ModelInfoToOllamaModel(model ModelInfo, modifiedAt time.Time) types.OllamaModel {
+	digest := sha256.Sum256([]byte(model.ID))
+	return types.OllamaModel{
+		Name:       model.ID + ollamaDefaultTag,
+		Model:      model.ID + ollamaDefaultTag,
+		ModifiedAt: modifiedAt.Format(time.RFC3339),
+		Digest:     hex.EncodeToString(digest[:]),
+		Details:    ollamaModelDetails(model),
+	}
+}
+
+// ModelInfoToOllamaShow converts a catalog model into an Ollama /api/show response. The settings
+// fetched from the bot, if any, are reported in its model info under the "poe." prefix.
+func ModelInfoToOllamaShow(model ModelInfo, modifiedAt time.Time) types.OllamaShowResponse {
+	details := ollamaModelDetails(model)
+	capabilities := []string{"completion"}
+	if model.Vision {
+		capabilities = append(capabilities, "vision")
+	}
+	modelInfo := map[string]any{"general.architecture": details.Family}
+	if model.ContextWindow > 0 {
+		modelInfo[details.Family+".context_length"] = model.ContextWindow
+	}
+	if settings := model.Settings; settings != nil {
+		if settings.AllowAttachments != nil {
+			modelInfo["poe.allow_attachments"] = *settings.AllowAttachments
+		}
+		if settings.IntroductionMessage != "" {
+			modelInfo["poe.introduction_message"] = settings.IntroductionMessage
+		}
+		if len(settings.ServerBotDependencies) > 0 {
+			modelInfo["poe.server_bot_dependencies"] = settings.ServerBotDependencies
+		}
+		if settings.ContextClearWindowSecs != nil {
+			modelInfo["poe.context_clear_window_secs"] = *settings.ContextClearWindowSecs
+		}
+	}
+	return types.OllamaShowResponse{
+		Modelfile:    fmt.Sprintf("# Poe bot %s served by poepenai\nFROM %s\n", model.ID, model.ID),
+		Details:      details,
+		ModelInfo:    modelInfo,
+		Capabilities: capabilities,
+		ModifiedAt:   modifiedAt.Format(time.RFC3339),
+	}
+}
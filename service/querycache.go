@@ -0,0 +1,455 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// QueryCacheHeader is the header reporting whether a response was served from the query cache
+// ("hit", "miss" or "bypass"). Clients also set it on requests to opt in ("on") or out ("off").
+const QueryCacheHeader = "X-Poe-Cache"
+
+// Defaults of the query cache.
+const (
+	defaultQueryCacheSize = 1000
+	defaultQueryCacheTTL  = 24 * time.Hour
+)
+
+// QueryCacheStats counts the lookups of a QueryCacheManager, and the queries it stored.
+type QueryCacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Bypasses counts the queries that were not eligible for caching.
+	Bypasses int64 `json:"bypasses"`
+	Stores   int64 `json:"stores"`
+	Errors   int64 `json:"errors"`
+}
+
+// CachedQuery is the complete event stream of a Poe query, as stored by a QueryCache.
+type CachedQuery struct {
+	Key       string       `json:"key"`
+	BotName   string       `json:"bot_name"`
+	Events    []TimedEvent `json:"events"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// QueryCache stores the event streams of Poe queries by canonical key.
+// Implementations must not return entries older than their TTL.
+type QueryCache interface {
+	// Get returns the cached query with the given key, if any.
+	Get(key string) (*CachedQuery, bool, error)
+	// Put stores a cached query, keyed by its Key.
+	Put(cached *CachedQuery) error
+}
+
+// canonicalQuery holds the fields of a PoeQueryRequest that determine the bot's response.
+// IDs, timestamps and the user ID are left out so that identical requests share a key.
+type canonicalQuery struct {
+	BotName          string                    `json:"bot_name"`
+	APIKeyHash       string                    `json:"api_key_hash"`
+	Messages         []canonicalMessage        `json:"messages"`
+	Temperature      *float64                  `json:"temperature"`
+	SkipSystemPrompt bool                      `json:"skip_system_prompt"`
+	LogitBias        map[string]float64        `json:"logit_bias"`
+	StopSequences    []string                  `json:"stop_sequences"`
+	Tools            []types.PoeToolDefinition `json:"tools"`
+	// Seed is left out of the keys of unseeded queries, so that their keys do not change.
+	Seed *int `json:"seed,omitempty"`
+}
+
+// canonicalMessage holds the fields of a PoeProtocolMessage that are part of its canonical form.
+type canonicalMessage struct {
+	Role        string   `json:"role"`
+	Content     string   `json:"content"`
+	ContentType string   `json:"content_type"`
+	Attachments []string `json:"attachments"`
+}
+
+// CanonicalQueryKey returns the canonical hash of a Poe query to botName: the bot, the messages,
+// the sampling parameters, the tools and the seed of the client request, if any, which Poe queries
+// do not carry. The API key is hashed in, so that users never share entries.
+func CanonicalQueryKey(botName string, poeQuery *types.PoeQueryRequest, apiKey string, seed *int) string {
+	apiKeyHash := sha256.Sum256([]byte(apiKey))
+	canonical := canonicalQuery{
+		BotName:          strings.ToLower(botName),
+		APIKeyHash:       hex.EncodeToString(apiKeyHash[:]),
+		Messages:         make([]canonicalMessage, 0, len(poeQuery.Query)),
+		Temperature:      poeQuery.Temperature,
+		SkipSystemPrompt: poeQuery.SkipSystemPrompt,
+		LogitBias:        poeQuery.LogitBias, // Maps are marshaled with sorted keys.
+		StopSequences:    poeQuery.StopSequences,
+		Tools:            poeQuery.Tools,
+		Seed:             seed,
+	}
+	for _, msg := range poeQuery.Query {
+		canonicalMsg := canonicalMessage{
+			Role:        msg.Role,
+			Content:     msg.Content,
+			ContentType: msg.ContentType,
+		}
+		for _, attachment := range msg.Attachments {
+			canonicalMsg.Attachments = append(canonicalMsg.Attachments, attachment.URL)
+		}
+		canonical.Messages = append(canonical.Messages, canonicalMsg)
+	}
+	data, err := json.Marshal(canonical)
+	if err != nil {
+		// Only unsupported values (e.g., NaN temperatures) fail; such queries get a unique key.
+		slog.Warn("Failed to marshal canonical Poe query", "error", err)
+		return GenerateID("uncacheable")
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// QueryCacheConfig configures when queries are cached and how cached streams are replayed.
+type QueryCacheConfig struct {
+	// Disabled turns the cache off, whatever the requests opt in to.
+	Disabled bool
+	// Models are the Poe bots whose queries are always cached (case-insensitive).
+	Models []string
+	// ReplayMaxGap caps the delay between replayed events. Cached streams are replayed with their
+	// original pacing, shortened to at most ReplayMaxGap between events; 0 replays without delay.
+	ReplayMaxGap time.Duration
+}
+
+// QueryCacheManager decides which queries are cached, and serves and records them. It is safe
+// for concurrent use.
+type QueryCacheManager struct {
+	cache  QueryCache
+	config QueryCacheConfig
+
+	mu    sync.Mutex
+	stats QueryCacheStats
+}
+
+// NewQueryCacheManager creates a QueryCacheManager backed by cache.
+func NewQueryCacheManager(cache QueryCache, config QueryCacheConfig) *QueryCacheManager {
+	return &QueryCacheManager{cache: cache, config: config}
+}
+
+// Enabled reports whether a query to botName is cached. Caching is opt-in: per request with the
+// QueryCacheHeader set to "on" or when the request has a seed, or per model through the config.
+// A QueryCacheHeader set to "off" or a Disabled config always disables it.
+func (m *QueryCacheManager) Enabled(botName string, requestHeader string, seeded bool) bool {
+	if m.config.Disabled {
+		return false
+	}
+	switch strings.ToLower(requestHeader) {
+	case "on", "true", "1":
+		return true
+	case "off", "false", "0":
+		return false
+	}
+	if seeded {
+		return true
+	}
+	for _, model := range m.config.Models {
+		if strings.EqualFold(model, botName) {
+			return true
+		}
+	}
+	return false
+}
+
+// CacheQuery implements client.QueryCache: queries opted in by options (see Enabled) are keyed by
+// their CanonicalQueryKey, and only complete responses are cached (see RecordQuery).
+func (m *QueryCacheManager) CacheQuery(
+	ctx context.Context,
+	botName string,
+	poeQuery *types.PoeQueryRequest,
+	apiKey string,
+	options client.QueryOptions,
+	start client.QueryStarter,
+) (<-chan types.PoeSSEEvent, <-chan error, string) {
+	if !m.Enabled(botName, options.Cache, options.Seed != nil) {
+		m.Bypass()
+		eventChan, errChan := start(ctx)
+		return eventChan, errChan, "bypass"
+	}
+	key := CanonicalQueryKey(botName, poeQuery, apiKey, options.Seed)
+	if cached, ok := m.Lookup(key); ok {
+		slog.Info("Serving Poe query from cache", "bot_name", botName, "cache_key", key)
+		eventChan, errChan := m.Replay(ctx, cached)
+		return eventChan, errChan, "hit"
+	}
+	slog.Debug("Poe query not in cache, querying the bot", "bot_name", botName, "cache_key", key)
+	eventChan, errChan := start(ctx)
+	eventChan, errChan = RecordQuery(ctx, eventChan, errChan, func(events []TimedEvent) {
+		m.Store(key, botName, events)
+	})
+	return eventChan, errChan, "miss"
+}
+
+// Stats returns the counts of the lookups and stores of m.
+func (m *QueryCacheManager) Stats() QueryCacheStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// count increments a counter of the stats of m.
+func (m *QueryCacheManager) count(counter *int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*counter++
+}
+
+// Bypass counts a query that is not eligible for caching.
+func (m *QueryCacheManager) Bypass() {
+	m.count(&m.stats.Bypasses)
+}
+
+// Lookup returns the cached query with the given key, counting the hit or miss.
+func (m *QueryCacheManager) Lookup(key string) (*CachedQuery, bool) {
+	cached, ok, err := m.cache.Get(key)
+	if err != nil {
+		m.count(&m.stats.Errors)
+		slog.Warn("Failed to read query cache, treating as a miss", "key", key, "error", err)
+		ok = false
+	}
+	if ok {
+		m.count(&m.stats.Hits)
+	} else {
+		m.count(&m.stats.Misses)
+	}
+	return cached, ok
+}
+
+// Store caches the events of a completed query.
+func (m *QueryCacheManager) Store(key string, botName string, events []TimedEvent) {
+	cached := &CachedQuery{
+		Key:       key,
+		BotName:   botName,
+		Events:    events,
+		CreatedAt: time.Now(),
+	}
+	if err := m.cache.Put(cached); err != nil {
+		m.count(&m.stats.Errors)
+		slog.Warn("Failed to store query in cache", "key", key, "error", err)
+		return
+	}
+	m.count(&m.stats.Stores)
+	slog.Debug("Stored query in cache", "key", key, "bot_name", botName, "event_count", len(events))
+}
+
+// Replay streams the events of a cached query with the same channel semantics as
+// PoeClient.StreamQuery, pacing them as configured.
+func (m *QueryCacheManager) Replay(ctx context.Context, cached *CachedQuery) (<-chan types.PoeSSEEvent, <-chan error) {
+	eventChan := make(chan types.PoeSSEEvent)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(eventChan)
+		defer close(errChan)
+
+		var previousOffset time.Duration
+		for _, event := range cached.Events {
+			gap := min(event.Offset-previousOffset, m.config.ReplayMaxGap)
+			previousOffset = event.Offset
+			if gap > 0 {
+				timer := time.NewTimer(gap)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					errChan <- fmt.Errorf("context cancelled during cache replay: %w", ctx.Err())
+					return
+				case <-timer.C:
+				}
+			}
+			select {
+			case eventChan <- types.PoeSSEEvent{Event: event.Event, Data: event.Data}:
+			case <-ctx.Done():
+				errChan <- fmt.Errorf("context cancelled during cache replay: %w", ctx.Err())
+				return
+			}
+		}
+	}()
+
+	return eventChan, errChan
+}
+
+// RecordQuery forwards the event stream of a Poe query, with the same channel semantics as
+// PoeClient.StreamQuery, and calls onComplete with the recorded events if the query completes
+// successfully: no error, no bot error event, and a final "done" event.
+func RecordQuery(
+	ctx context.Context,
+	upstreamEvents <-chan types.PoeSSEEvent,
+	upstreamErrs <-chan error,
+	onComplete func(events []TimedEvent),
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	return TeeQuery(ctx, upstreamEvents, upstreamErrs, func(events []TimedEvent, err error) {
+		if err != nil || len(events) == 0 || events[len(events)-1].Event != "done" {
+			return
+		}
+		for _, event := range events {
+			if event.Event == "error" {
+				return
+			}
+		}
+		onComplete(events)
+	})
+}
+
+// MemoryQueryCache is a bounded in-memory QueryCache with a TTL. When full, the oldest
+// entry is evicted. It is safe for concurrent use.
+type MemoryQueryCache struct {
+	mu      sync.Mutex
+	entries map[string]*CachedQuery
+	order   []string // Keys, oldest first.
+	size    int
+	ttl     time.Duration
+}
+
+// NewMemoryQueryCache creates a MemoryQueryCache keeping at most size entries for ttl.
+// Non-positive values select defaultQueryCacheSize and defaultQueryCacheTTL.
+func NewMemoryQueryCache(size int, ttl time.Duration) *MemoryQueryCache {
+	if size <= 0 {
+		size = defaultQueryCacheSize
+	}
+	if ttl <= 0 {
+		ttl = defaultQueryCacheTTL
+	}
+	return &MemoryQueryCache{
+		entries: make(map[string]*CachedQuery),
+		size:    size,
+		ttl:     ttl,
+	}
+}
+
+// Get implements QueryCache.
+func (c *MemoryQueryCache) Get(key string) (*CachedQuery, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.entries[key]
+	if !ok || time.Since(cached.CreatedAt) > c.ttl {
+		return nil, false, nil
+	}
+	return cached, true, nil
+}
+
+// Put implements QueryCache.
+func (c *MemoryQueryCache) Put(cached *CachedQuery) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[cached.Key]; !exists {
+		c.order = append(c.order, cached.Key)
+	}
+	c.entries[cached.Key] = cached
+	for len(c.order) > c.size {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	return nil
+}
+
+// FileQueryCache is a QueryCache keeping each entry in a JSON file of a directory, so the
+// cache survives restarts. When full, the oldest files are removed. It is safe for concurrent use.
+type FileQueryCache struct {
+	mu   sync.Mutex
+	dir  string
+	size int
+	ttl  time.Duration
+}
+
+// NewFileQueryCache creates a FileQueryCache in dir keeping at most size entries for ttl,
+// creating the directory if needed. Non-positive values select the defaults.
+func NewFileQueryCache(dir string, size int, ttl time.Duration) (*FileQueryCache, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create query cache directory %s: %w", dir, err)
+	}
+	if size <= 0 {
+		size = defaultQueryCacheSize
+	}
+	if ttl <= 0 {
+		ttl = defaultQueryCacheTTL
+	}
+	return &FileQueryCache{dir: dir, size: size, ttl: ttl}, nil
+}
+
+// path returns the file of a cache entry. Keys are hex hashes, safe as file names.
+func (c *FileQueryCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// Get implements QueryCache. Expired entries are removed.
+func (c *FileQueryCache) Get(key string) (*CachedQuery, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read query cache file: %w", err)
+	}
+	var cached CachedQuery
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, false, fmt.Errorf("failed to parse query cache file: %w", err)
+	}
+	if time.Since(cached.CreatedAt) > c.ttl {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to remove expired query cache file", "path", path, "error", err)
+		}
+		return nil, false, nil
+	}
+	return &cached, true, nil
+}
+
+// Put implements QueryCache. The file is replaced atomically.
+func (c *FileQueryCache) Put(cached *CachedQuery) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cached query: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := c.path(cached.Key)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o640); err != nil {
+		return fmt.Errorf("failed to write query cache file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace query cache file: %w", err)
+	}
+	return c.evict()
+}
+
+// evict removes the oldest entries beyond the size limit. c.mu must be held.
+func (c *FileQueryCache) evict() error {
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list query cache files: %w", err)
+	}
+	if len(files) <= c.size {
+		return nil
+	}
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return modTimes[files[i]].Before(modTimes[files[j]]) })
+	for _, file := range files[:len(files)-c.size] {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to evict query cache file: %w", err)
+		}
+	}
+	return nil
+}
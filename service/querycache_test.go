@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/client"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// queryCacheTestQuery returns a Poe query with its IDs set to id, so that queries only differing
+// by id must share a key.
+func queryCacheTestQuery(id string) *types.PoeQueryRequest {
+	return &types.PoeQueryRequest{
+		Version: "1.1",
+		Type:    "query",
+		Query: []types.PoeProtocolMessage{
+			{Role: "system", Content: "Be brief.", MessageID: id + "-1", Timestamp: 1},
+			{Role: "user", Content: "Hello", MessageID: id + "-2", Timestamp: 2},
+		},
+		ConversationID: "conv-" + id,
+		MessageID:      "msg-" + id,
+		UserID:         "user-" + id,
+	}
+}
+
+func TestCanonicalQueryKey(t *testing.T) {
+	temperature := 0.5
+	seed := 1
+	base := CanonicalQueryKey("GPT-4o", queryCacheTestQuery("a"), "key-1", nil)
+
+	tests := []struct {
+		name     string
+		botName  string
+		modify   func(*types.PoeQueryRequest)
+		apiKey   string
+		seed     *int
+		wantSame bool
+	}{
+		{"different IDs", "GPT-4o", func(*types.PoeQueryRequest) {}, "key-1", nil, true},
+		{"bot name case", "gpt-4o", func(*types.PoeQueryRequest) {}, "key-1", nil, true},
+		{"different bot", "Claude-3.5-Sonnet", func(*types.PoeQueryRequest) {}, "key-1", nil, false},
+		{"different key", "GPT-4o", func(*types.PoeQueryRequest) {}, "key-2", nil, false},
+		{"different content", "GPT-4o", func(q *types.PoeQueryRequest) { q.Query[1].Content = "Hi" }, "key-1", nil, false},
+		{"different role", "GPT-4o", func(q *types.PoeQueryRequest) { q.Query[0].Role = "user" }, "key-1", nil, false},
+		{"temperature", "GPT-4o", func(q *types.PoeQueryRequest) { q.Temperature = &temperature }, "key-1", nil, false},
+		{"stop sequences", "GPT-4o", func(q *types.PoeQueryRequest) { q.StopSequences = []string{"\n"} }, "key-1", nil, false},
+		{"seed", "GPT-4o", func(*types.PoeQueryRequest) {}, "key-1", &seed, false},
+		{
+			"attachment",
+			"GPT-4o",
+			func(q *types.PoeQueryRequest) {
+				q.Query[1].Attachments = []types.PoeAttachment{{URL: "https://example.com/cat.png"}}
+			},
+			"key-1",
+			nil,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := queryCacheTestQuery("b")
+			tt.modify(query)
+			key := CanonicalQueryKey(tt.botName, query, tt.apiKey, tt.seed)
+			assert.Equal(t, tt.wantSame, key == base, "same key as the base query")
+		})
+	}
+}
+
+func TestQueryCacheDifferentSeedsMiss(t *testing.T) {
+	manager := NewQueryCacheManager(NewMemoryQueryCache(0, 0), QueryCacheConfig{})
+	seed1, seed2 := 1, 2
+	key1 := CanonicalQueryKey("GPT-4o", queryCacheTestQuery("a"), "key-1", &seed1)
+	key2 := CanonicalQueryKey("GPT-4o", queryCacheTestQuery("b"), "key-1", &seed2)
+	manager.Store(key1, "GPT-4o", []TimedEvent{{Event: "done", Data: "{}"}})
+
+	_, ok := manager.Lookup(key1)
+	assert.True(t, ok, "same seed hits the cache")
+	_, ok = manager.Lookup(key2)
+	assert.False(t, ok, "different seed misses the cache")
+}
+
+func TestQueryCacheManagerCacheQuery(t *testing.T) {
+	manager := NewQueryCacheManager(NewMemoryQueryCache(0, 0), QueryCacheConfig{})
+	queries := 0
+	start := func(ctx context.Context) (<-chan types.PoeSSEEvent, <-chan error) {
+		queries++
+		eventChan := make(chan types.PoeSSEEvent, 2)
+		errChan := make(chan error)
+		eventChan <- types.PoeSSEEvent{Event: "text", Data: `{"text":"Hello"}`}
+		eventChan <- types.PoeSSEEvent{Event: "done", Data: "{}"}
+		close(eventChan)
+		close(errChan)
+		return eventChan, errChan
+	}
+	cacheQuery := func(options client.QueryOptions) ([]types.PoeSSEEvent, string) {
+		eventChan, errChan, outcome := manager.CacheQuery(
+			context.Background(), "GPT-4o", queryCacheTestQuery("a"), "key-1", options, start)
+		var events []types.PoeSSEEvent
+		for event := range eventChan {
+			events = append(events, event)
+		}
+		require.NoError(t, <-errChan)
+		return events, outcome
+	}
+
+	_, outcome := cacheQuery(client.QueryOptions{})
+	assert.Equal(t, "bypass", outcome)
+
+	seed := 1
+	events, outcome := cacheQuery(client.QueryOptions{Seed: &seed})
+	assert.Equal(t, "miss", outcome)
+	require.Len(t, events, 2)
+	assert.Eventually(t, func() bool { return manager.Stats().Stores == 1 }, time.Second, time.Millisecond)
+
+	cachedEvents, outcome := cacheQuery(client.QueryOptions{Seed: &seed})
+	assert.Equal(t, "hit", outcome)
+	assert.Equal(t, events, cachedEvents)
+
+	otherSeed := 2
+	_, outcome = cacheQuery(client.QueryOptions{Seed: &otherSeed})
+	assert.Equal(t, "miss", outcome)
+	_, outcome = cacheQuery(client.QueryOptions{Cache: "off", Seed: &seed})
+	assert.Equal(t, "bypass", outcome)
+
+	assert.Equal(t, 4, queries)
+	assert.Eventually(t, func() bool { return manager.Stats().Stores == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, QueryCacheStats{Hits: 1, Misses: 2, Bypasses: 2, Stores: 2}, manager.Stats())
+}
+
+func TestQueryCacheManagerEnabled(t *testing.T) {
+	manager := NewQueryCacheManager(NewMemoryQueryCache(0, 0), QueryCacheConfig{Models: []string{"GPT-4o"}})
+	tests := []struct {
+		name    string
+		botName string
+		header  string
+		seeded  bool
+		want    bool
+	}{
+		{"not opted in", "Claude-3.5-Sonnet", "", false, false},
+		{"header on", "Claude-3.5-Sonnet", "on", false, true},
+		{"seeded", "Claude-3.5-Sonnet", "", true, true},
+		{"configured model", "gpt-4o", "", false, true},
+		{"header off wins", "GPT-4o", "off", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, manager.Enabled(tt.botName, tt.header, tt.seeded))
+		})
+	}
+
+	disabled := NewQueryCacheManager(NewMemoryQueryCache(0, 0), QueryCacheConfig{Disabled: true, Models: []string{"GPT-4o"}})
+	assert.False(t, disabled.Enabled("GPT-4o", "on", true), "a disabled cache is never enabled")
+}
+
+func TestMemoryQueryCache(t *testing.T) {
+	cache := NewMemoryQueryCache(2, time.Hour)
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, cache.Put(&CachedQuery{Key: key, CreatedAt: time.Now()}))
+	}
+	require.NoError(t, cache.Put(&CachedQuery{Key: "expired", CreatedAt: time.Now().Add(-2 * time.Hour)}))
+
+	for key, want := range map[string]bool{"a": false, "b": false, "c": true, "expired": false} {
+		_, ok, _ := cache.Get(key)
+		assert.Equal(t, want, ok, "Get(%s) found", key)
+	}
+}
+
+func TestFileQueryCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileQueryCache(dir, 2, time.Hour)
+	require.NoError(t, err)
+
+	// Entries are evicted by modification time, set apart so that the order is deterministic.
+	now := time.Now()
+	for i, key := range []string{"a", "b", "c"} {
+		require.NoError(t, cache.Put(&CachedQuery{Key: key, CreatedAt: now}))
+		modTime := now.Add(time.Duration(i-3) * time.Minute)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, key+".json"), modTime, modTime))
+	}
+
+	for key, want := range map[string]bool{"a": false, "b": true, "c": true} {
+		cached, ok, err := cache.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, want, ok, "Get(%s) found", key)
+		if ok {
+			assert.Equal(t, key, cached.Key)
+		}
+	}
+}
+
+func TestFileQueryCacheExpiry(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileQueryCache(dir, 10, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, cache.Put(&CachedQuery{Key: "old", CreatedAt: time.Now().Add(-2 * time.Hour)}))
+
+	_, ok, err := cache.Get("old")
+	require.NoError(t, err)
+	assert.False(t, ok, "expired entry found")
+	_, err = os.Stat(filepath.Join(dir, "old.json"))
+	assert.True(t, os.IsNotExist(err), "expired entry file not removed: %v", err)
+}
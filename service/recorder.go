@@ -0,0 +1,325 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// Defaults of the exchange recorder.
+const (
+	defaultRecorderMaxBytes = 100 << 20 // 100 MiB per file
+	defaultRecorderMaxFiles = 10
+	// recorderFileName is the file exchanges are appended to; rotated files get a timestamp suffix.
+	recorderFileName = "exchanges.jsonl"
+	// redactedAPIKey replaces API keys in recorded Poe queries.
+	redactedAPIKey = "[REDACTED]"
+)
+
+// TimedEvent is a Poe SSE event with the time it was received.
+type TimedEvent struct {
+	Event string `json:"event"`
+	Data  string `json:"data"`
+	// Offset is the time elapsed between the start of the query and the event.
+	Offset time.Duration `json:"offset"`
+}
+
+// TeeQuery forwards the event stream of a Poe query, with the same channel semantics as
+// PoeClient.StreamQuery, and calls onFinish with the received events once the query is over.
+// err is the query error, or the context error if events were dropped because ctx was cancelled.
+func TeeQuery(
+	ctx context.Context,
+	upstreamEvents <-chan types.PoeSSEEvent,
+	upstreamErrs <-chan error,
+	onFinish func(events []TimedEvent, err error),
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	eventChan := make(chan types.PoeSSEEvent)
+	errChan := make(chan error, 1)
+
+	go func() {
+		start := time.Now()
+		var events []TimedEvent
+		var queryErr error
+		dropped := false
+		for upstreamEvents != nil || upstreamErrs != nil {
+			select {
+			case err, ok := <-upstreamErrs:
+				if !ok {
+					upstreamErrs = nil
+					continue
+				}
+				if err != nil {
+					queryErr = err
+					errChan <- err
+				}
+			case event, ok := <-upstreamEvents:
+				if !ok {
+					upstreamEvents = nil
+					continue
+				}
+				events = append(events, TimedEvent{Event: event.Event, Data: event.Data, Offset: time.Since(start)})
+				select {
+				case eventChan <- event:
+				case <-ctx.Done():
+					// The client is gone; drain upstream until the query stops.
+					dropped = true
+				}
+			}
+		}
+		// Close before calling onFinish, so that clients do not wait for it.
+		close(errChan)
+		close(eventChan)
+		if queryErr == nil && dropped {
+			queryErr = ctx.Err()
+		}
+		onFinish(events, queryErr)
+	}()
+
+	return eventChan, errChan
+}
+
+// RecordedQuery is a Poe query made while serving an exchange.
+type RecordedQuery struct {
+	BotName string                 `json:"bot_name"`
+	Query   *types.PoeQueryRequest `json:"query"` // The API key is redacted.
+	Events  []TimedEvent           `json:"events"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// Exchange is a request served by the adapter, as captured by a Recorder: the client request,
+// the Poe queries it led to, and the response sent back. It is safe for concurrent use.
+type Exchange struct {
+	mu      sync.Mutex
+	pending sync.WaitGroup // Queries still in progress
+
+	ID        string            `json:"id"` // The request ID
+	Time      time.Time         `json:"time"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Headers   map[string]string `json:"headers,omitempty"` // Without credentials
+	Request   string            `json:"request"`
+	Queries   []*RecordedQuery  `json:"queries"`
+	Status    int               `json:"status"`
+	Response  string            `json:"response"`
+	Truncated bool              `json:"truncated,omitempty"` // Whether Response was cut at the capture limit
+	Duration  time.Duration     `json:"duration"`
+
+	RequestTruncated bool `json:"request_truncated,omitempty"` // Whether Request was cut at the capture limit
+}
+
+// exchangeContextKey is the context key of the Exchange being recorded.
+type exchangeContextKey struct{}
+
+// WithExchange returns a copy of ctx carrying exchange.
+func WithExchange(ctx context.Context, exchange *Exchange) context.Context {
+	return context.WithValue(ctx, exchangeContextKey{}, exchange)
+}
+
+// ExchangeFromContext returns the Exchange being recorded for ctx, if any.
+func ExchangeFromContext(ctx context.Context) (*Exchange, bool) {
+	exchange, ok := ctx.Value(exchangeContextKey{}).(*Exchange)
+	return exchange, ok
+}
+
+// RecordExchangeQuery adds a Poe query to botName to the exchange being recorded for ctx, if any,
+// and returns its events, recorded as they are consumed. It is the client.QueryRecorder of the
+// exchange recorder.
+func RecordExchangeQuery(
+	ctx context.Context,
+	botName string,
+	poeQuery *types.PoeQueryRequest,
+	events <-chan types.PoeSSEEvent,
+	errs <-chan error,
+) (<-chan types.PoeSSEEvent, <-chan error) {
+	exchange, ok := ExchangeFromContext(ctx)
+	if !ok {
+		return events, errs
+	}
+	return TeeQuery(ctx, events, errs, exchange.AddQuery(botName, poeQuery))
+}
+
+// AddQuery records a Poe query to botName, with its API key redacted, and returns a function
+// to call with the query events once it is over; Wait waits for that call.
+func (e *Exchange) AddQuery(
+	botName string,
+	poeQuery *types.PoeQueryRequest,
+) func(events []TimedEvent, err error) {
+	redacted := *poeQuery
+	if redacted.APIKey != "" {
+		redacted.APIKey = redactedAPIKey
+	}
+	recorded := &RecordedQuery{BotName: botName, Query: &redacted}
+
+	e.mu.Lock()
+	e.Queries = append(e.Queries, recorded)
+	e.mu.Unlock()
+	e.pending.Add(1)
+
+	return func(events []TimedEvent, err error) {
+		defer e.pending.Done()
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		recorded.Events = events
+		if err != nil {
+			recorded.Error = err.Error()
+		}
+	}
+}
+
+// Wait waits for the queries added to e to be over.
+func (e *Exchange) Wait() {
+	e.pending.Wait()
+}
+
+// MarshalJSON implements json.Marshaler, holding the lock of e.
+func (e *Exchange) MarshalJSON() ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	type exchange Exchange // Drops the methods, to avoid recursion.
+	return json.Marshal((*exchange)(e))
+}
+
+// Recorder appends exchanges as JSON lines to a file of a directory, rotating it when it
+// exceeds a size limit and keeping a bounded number of rotated files. It is safe for concurrent use.
+type Recorder struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// NewRecorder creates a Recorder writing to dir, creating the directory if needed.
+// Non-positive maxBytes and maxFiles select the defaults.
+func NewRecorder(dir string, maxBytes int64, maxFiles int) (*Recorder, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultRecorderMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultRecorderMaxFiles
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create recorder directory %s: %w", dir, err)
+	}
+	recorder := &Recorder{dir: dir, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := recorder.open(); err != nil {
+		return nil, err
+	}
+	return recorder, nil
+}
+
+// open opens the current file for appending. r.mu must be held, or r not yet shared.
+func (r *Recorder) open() error {
+	path := filepath.Join(r.dir, recorderFileName)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open recorder file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat recorder file: %w", err)
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Record appends exchange to the current file, rotating it first if it is full.
+func (r *Recorder) Record(exchange *Exchange) error {
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return fmt.Errorf("failed to marshal exchange: %w", err)
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && r.size+int64(len(line)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write exchange: %w", err)
+	}
+	return nil
+}
+
+// rotate renames the current file with a timestamp, opens a new one and removes the oldest
+// rotated files beyond the limit. r.mu must be held.
+func (r *Recorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("failed to close recorder file: %w", err)
+	}
+	rotatedName := fmt.Sprintf(
+		"%s-%s.jsonl",
+		strings.TrimSuffix(recorderFileName, ".jsonl"),
+		time.Now().UTC().Format("20060102T150405.000000000"),
+	)
+	if err := os.Rename(filepath.Join(r.dir, recorderFileName), filepath.Join(r.dir, rotatedName)); err != nil {
+		return fmt.Errorf("failed to rotate recorder file: %w", err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	rotated, err := filepath.Glob(filepath.Join(r.dir, strings.TrimSuffix(recorderFileName, ".jsonl")+"-*.jsonl"))
+	if err != nil {
+		return fmt.Errorf("failed to list rotated recorder files: %w", err)
+	}
+	sort.Strings(rotated) // Timestamps sort chronologically.
+	for len(rotated) > r.maxFiles-1 {
+		if err := os.Remove(rotated[0]); err != nil {
+			return fmt.Errorf("failed to remove old recorder file: %w", err)
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// Close closes the current file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// ReadExchanges reads the exchanges of a JSONL file written by a Recorder.
+func ReadExchanges(path string) ([]*Exchange, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open exchanges file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var exchanges []*Exchange
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1<<20), 64<<20) // Exchanges can hold long conversations.
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var exchange Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, fmt.Errorf("invalid exchange on line %d: %w", lineNumber, err)
+		}
+		exchanges = append(exchanges, &exchange)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read exchanges file: %w", err)
+	}
+	return exchanges, nil
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/types"
+)
+
+func TestRecorderRotate(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxFiles    int
+		exchanges   int
+		wantRotated int
+	}{
+		{"no rotation", 3, 1, 0},
+		{"under the file limit", 3, 3, 2},
+		{"oldest files removed", 3, 6, 2},
+		{"single file", 1, 4, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// Every exchange exceeds the file size limit, so that each one after the first rotates.
+			recorder, err := NewRecorder(dir, 1, tt.maxFiles)
+			require.NoError(t, err)
+			defer func() { _ = recorder.Close() }()
+			for i := range tt.exchanges {
+				require.NoError(t, recorder.Record(&Exchange{ID: fmt.Sprintf("exchange-%d", i)}))
+			}
+
+			rotated, err := filepath.Glob(filepath.Join(dir, "exchanges-*.jsonl"))
+			require.NoError(t, err)
+			assert.Len(t, rotated, tt.wantRotated)
+			exchanges, err := ReadExchanges(filepath.Join(dir, recorderFileName))
+			require.NoError(t, err)
+			require.Len(t, exchanges, 1)
+			assert.Equal(t, fmt.Sprintf("exchange-%d", tt.exchanges-1), exchanges[0].ID)
+		})
+	}
+}
+
+func TestRecorderAppends(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, 0, 0)
+	require.NoError(t, err)
+	for _, id := range []string{"a", "b"} {
+		require.NoError(t, recorder.Record(&Exchange{ID: id, RequestTruncated: id == "b"}))
+	}
+	require.NoError(t, recorder.Close())
+
+	// A new recorder appends to the current file.
+	recorder, err = NewRecorder(dir, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, recorder.Record(&Exchange{ID: "c"}))
+	_ = recorder.Close()
+
+	exchanges, err := ReadExchanges(filepath.Join(dir, recorderFileName))
+	require.NoError(t, err)
+	require.Len(t, exchanges, 3)
+	assert.Equal(t, "a", exchanges[0].ID)
+	assert.Equal(t, "c", exchanges[2].ID)
+	assert.False(t, exchanges[0].RequestTruncated)
+	assert.True(t, exchanges[1].RequestTruncated, "request truncation is recorded")
+}
+
+func TestRecordExchangeQuery(t *testing.T) {
+	// record runs a query answering events and err through RecordExchangeQuery, and returns its error.
+	record := func(ctx context.Context, botName string, query *types.PoeQueryRequest, events []types.PoeSSEEvent, err error) error {
+		eventChan := make(chan types.PoeSSEEvent, len(events))
+		errChan := make(chan error, 1)
+		for _, event := range events {
+			eventChan <- event
+		}
+		if err != nil {
+			errChan <- err
+		}
+		close(eventChan)
+		close(errChan)
+
+		recordedEvents, recordedErrs := RecordExchangeQuery(ctx, botName, query, eventChan, errChan)
+		for range recordedEvents {
+		}
+		return <-recordedErrs
+	}
+	events := []types.PoeSSEEvent{{Event: "text", Data: `{"text":"Hello"}`}, {Event: "done", Data: "{}"}}
+	query := &types.PoeQueryRequest{APIKey: "poe-secret-key"}
+
+	exchange := &Exchange{ID: "exchange"}
+	ctx := WithExchange(context.Background(), exchange)
+	require.NoError(t, record(ctx, "GPT-4o", query, events, nil))
+	assert.Error(t, record(ctx, "Claude-3.5-Sonnet", query, nil, errors.New("unavailable")))
+	exchange.Wait()
+
+	require.Len(t, exchange.Queries, 2)
+	assert.Equal(t, "GPT-4o", exchange.Queries[0].BotName)
+	assert.Equal(t, redactedAPIKey, exchange.Queries[0].Query.APIKey)
+	require.Len(t, exchange.Queries[0].Events, 2)
+	assert.Equal(t, "text", exchange.Queries[0].Events[0].Event)
+	assert.Equal(t, "Claude-3.5-Sonnet", exchange.Queries[1].BotName)
+	assert.Equal(t, "unavailable", exchange.Queries[1].Error)
+	assert.Equal(t, "poe-secret-key", query.APIKey, "the query sent is not redacted")
+
+	// Queries are passed through without an exchange.
+	require.NoError(t, record(context.Background(), "GPT-4o", query, events, nil))
+	assert.Len(t, exchange.Queries, 2)
+}
@@ -0,0 +1,501 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// defaultRedactedContentLength is the default number of characters kept of truncated content.
+const defaultRedactedContentLength = 200
+
+// defaultSecretKeys are the field, attribute and header names whose values are always redacted,
+// normalized by normalizeRedactionKey.
+var defaultSecretKeys = []string{
+	"api_key",
+	"apikey",
+	"x_api_key",
+	"poe_api_key",
+	"authorization",
+	"proxy_authorization",
+	"cookie",
+	"set_cookie",
+	"password",
+	"secret",
+	"token",
+	"access_token",
+	"refresh_token",
+	"x_csrf_token",
+}
+
+// contentKeys are the field and attribute names holding message content, redacted according to
+// the ContentRedaction of a Redactor.
+var contentKeys = map[string]bool{
+	"content":      true,
+	"text":         true,
+	"prompt":       true,
+	"input":        true,
+	"instructions": true,
+	"system":       true,
+}
+
+// ContentRedaction is how a Redactor treats message content.
+type ContentRedaction string
+
+const (
+	// ContentKeep keeps message content, subject to the redaction rules.
+	ContentKeep ContentRedaction = "keep"
+	// ContentHash replaces message content with a short hash, so that identical content can
+	// still be correlated.
+	ContentHash ContentRedaction = "hash"
+	// ContentTruncate keeps the beginning of message content, subject to the redaction rules.
+	ContentTruncate ContentRedaction = "truncate"
+)
+
+// ParseContentRedaction parses a ContentRedaction from its flag value.
+func ParseContentRedaction(value string) (ContentRedaction, error) {
+	switch mode := ContentRedaction(strings.ToLower(strings.TrimSpace(value))); mode {
+	case ContentKeep, ContentHash, ContentTruncate:
+		return mode, nil
+	case "":
+		return ContentKeep, nil
+	default:
+		return "", fmt.Errorf("invalid content redaction %q (want keep, hash or truncate)", value)
+	}
+}
+
+// RedactionRule masks the matches of a pattern in logged and recorded strings.
+type RedactionRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Validate filters the matches to mask, if set.
+	Validate func(match string) bool
+}
+
+// builtinRedactionRules are the redaction rules available by name.
+var builtinRedactionRules = map[string]RedactionRule{
+	"email": {
+		Name:    "email",
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	"card": {
+		Name:     "card",
+		Pattern:  regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		Validate: luhnValid,
+	},
+}
+
+// ParseRedactionRule parses a redaction rule from its flag value: the name of a built-in rule
+// (email, card), or name=regexp for a custom one.
+func ParseRedactionRule(spec string) (RedactionRule, error) {
+	spec = strings.TrimSpace(spec)
+	if rule, ok := builtinRedactionRules[strings.ToLower(spec)]; ok {
+		return rule, nil
+	}
+	name, expr, ok := strings.Cut(spec, "=")
+	if !ok || name == "" || expr == "" {
+		return RedactionRule{}, fmt.Errorf("invalid redaction rule %q (want email, card or name=regexp)", spec)
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return RedactionRule{}, fmt.Errorf("invalid pattern of redaction rule %s: %w", name, err)
+	}
+	return RedactionRule{Name: name, Pattern: pattern}, nil
+}
+
+// luhnValid reports whether the digits of number pass the Luhn checksum of card numbers.
+func luhnValid(number string) bool {
+	sum, double := 0, false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// RedactConfig configures a Redactor.
+type RedactConfig struct {
+	// SecretKeys are names of secret fields, attributes or headers, in addition to the defaults.
+	SecretKeys []string
+	// Content is how message content is treated (ContentKeep if empty).
+	Content ContentRedaction
+	// ContentLength is the number of characters kept by ContentTruncate
+	// (defaultRedactedContentLength if not positive).
+	ContentLength int
+	Rules         []RedactionRule
+}
+
+// Redactor masks secrets and personal data in log records and recorded exchanges: the values of
+// secret fields, attributes and headers, message content as configured, and the matches of its
+// rules. JSON documents found in strings (request bodies, SSE data) are redacted field by field.
+type Redactor struct {
+	secretKeys    map[string]bool
+	content       ContentRedaction
+	contentLength int
+	rules         []RedactionRule
+}
+
+// NewRedactor creates a Redactor from config.
+func NewRedactor(config RedactConfig) *Redactor {
+	r := &Redactor{
+		secretKeys:    make(map[string]bool),
+		content:       config.Content,
+		contentLength: config.ContentLength,
+		rules:         config.Rules,
+	}
+	if r.content == "" {
+		r.content = ContentKeep
+	}
+	if r.contentLength <= 0 {
+		r.contentLength = defaultRedactedContentLength
+	}
+	for _, key := range append(defaultSecretKeys, config.SecretKeys...) {
+		r.secretKeys[normalizeRedactionKey(key)] = true
+	}
+	return r
+}
+
+// normalizeRedactionKey normalizes field, attribute and header names, so that X-Api-Key matches
+// x_api_key.
+func normalizeRedactionKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "-", "_")
+}
+
+// isSecret reports whether the values of key are secrets.
+func (r *Redactor) isSecret(key string) bool {
+	return r.secretKeys[normalizeRedactionKey(key)]
+}
+
+// SecretsOnly returns a Redactor masking the same secrets as r, but keeping message content and
+// ignoring the redaction rules, so that recorded Poe queries still match for replay.
+func (r *Redactor) SecretsOnly() *Redactor {
+	return &Redactor{secretKeys: r.secretKeys, content: ContentKeep, contentLength: r.contentLength}
+}
+
+// String redacts s, the value of the field or attribute key.
+func (r *Redactor) String(key string, s string) string {
+	if r.isSecret(key) && s != "" {
+		return redactedAPIKey
+	}
+	isContent := contentKeys[normalizeRedactionKey(key)]
+	if isContent && r.content == ContentHash {
+		sum := sha256.Sum256([]byte(s))
+		return "[sha256:" + hex.EncodeToString(sum[:6]) + "]"
+	}
+	redacted, ok := r.documents(key, s)
+	if !ok {
+		redacted = r.applyRules(s)
+	}
+	if isContent && r.content == ContentTruncate && utf8.RuneCountInString(redacted) > r.contentLength {
+		runes := []rune(redacted)
+		return fmt.Sprintf("%s... [%d characters]", string(runes[:r.contentLength]), len(runes))
+	}
+	return redacted
+}
+
+// documents redacts s field by field if it is a JSON document, or made of lines of JSON
+// documents, optionally prefixed by "data:" (SSE events, NDJSON).
+func (r *Redactor) documents(key string, s string) (string, bool) {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s, false
+	}
+	if trimmed[0] == '{' || trimmed[0] == '[' {
+		if redacted, ok := r.document(key, trimmed); ok {
+			return redacted, true
+		}
+	}
+	if !strings.Contains(s, "\n") && !strings.HasPrefix(trimmed, "data:") {
+		return s, false
+	}
+
+	lines := strings.Split(s, "\n")
+	found := false
+	for i, line := range lines {
+		prefix, document := "", strings.TrimSpace(line)
+		if data, ok := strings.CutPrefix(document, "data:"); ok {
+			prefix, document = "data: ", strings.TrimSpace(data)
+		}
+		if document == "" || (document[0] != '{' && document[0] != '[') {
+			lines[i] = r.applyRules(line)
+			continue
+		}
+		if redacted, ok := r.document(key, document); ok {
+			lines[i] = prefix + redacted
+			found = true
+		} else {
+			lines[i] = r.applyRules(line)
+		}
+	}
+	return strings.Join(lines, "\n"), found
+}
+
+// document redacts a JSON document; it returns false if document is not JSON.
+func (r *Redactor) document(key string, document string) (string, bool) {
+	decoder := json.NewDecoder(strings.NewReader(document))
+	decoder.UseNumber()
+	var tree any
+	if err := decoder.Decode(&tree); err != nil || decoder.More() {
+		return "", false
+	}
+	redacted, err := marshalRedacted(r.tree(key, tree))
+	if err != nil {
+		return "", false
+	}
+	return string(redacted), true
+}
+
+// tree redacts a decoded JSON value, the value of the field key.
+func (r *Redactor) tree(key string, value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for field, fieldValue := range v {
+			if r.isSecret(field) {
+				if fieldValue != nil && fieldValue != "" {
+					v[field] = redactedAPIKey
+				}
+				continue
+			}
+			v[field] = r.tree(field, fieldValue)
+		}
+		return v
+	case []any:
+		for i, element := range v {
+			v[i] = r.tree(key, element)
+		}
+		return v
+	case string:
+		return r.String(key, v)
+	default:
+		return v
+	}
+}
+
+// applyRules masks the matches of the rules of r in s.
+func (r *Redactor) applyRules(s string) string {
+	for _, rule := range r.rules {
+		s = rule.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if rule.Validate != nil && !rule.Validate(match) {
+				return match
+			}
+			return "[REDACTED:" + rule.Name + "]"
+		})
+	}
+	return s
+}
+
+// marshalRedacted marshals a redacted value without escaping HTML characters, which would make
+// logged bodies harder to read.
+func marshalRedacted(value any) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(b.Bytes(), "\n"), nil
+}
+
+// Attr redacts a log attribute.
+func (r *Redactor) Attr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	if r.isSecret(attr.Key) {
+		return slog.String(attr.Key, redactedAPIKey)
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(r.String(attr.Key, attr.Value.String()))
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, member := range group {
+			redacted[i] = r.Attr(member)
+		}
+		attr.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		attr.Value = r.anyValue(attr.Key, attr.Value.Any())
+	}
+	return attr
+}
+
+// anyValue redacts an arbitrary attribute value (structs, maps, headers...) through its JSON
+// encoding.
+func (r *Redactor) anyValue(key string, value any) slog.Value {
+	if err, ok := value.(error); ok {
+		return slog.StringValue(r.applyRules(err.Error()))
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return slog.StringValue(r.applyRules(fmt.Sprint(value)))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var tree any
+	if err := decoder.Decode(&tree); err != nil {
+		return slog.StringValue(r.applyRules(fmt.Sprint(value)))
+	}
+	redacted, err := marshalRedacted(r.tree(key, tree))
+	if err != nil {
+		return slog.StringValue(r.applyRules(fmt.Sprint(value)))
+	}
+	return slog.AnyValue(redactedJSON(redacted))
+}
+
+// redactedJSON is a redacted attribute value: it is logged as JSON by JSON handlers, and as its
+// JSON text by text handlers.
+type redactedJSON []byte
+
+// MarshalJSON implements json.Marshaler.
+func (j redactedJSON) MarshalJSON() ([]byte, error) {
+	return j, nil
+}
+
+// String implements fmt.Stringer.
+func (j redactedJSON) String() string {
+	return string(j)
+}
+
+// Exchange returns a redacted copy of exchange, for recording. Redacting message content or
+// applying rules to it makes the recorded queries unsuitable for exact replay matching; see
+// SecretsOnly.
+func (r *Redactor) Exchange(exchange *Exchange) *Exchange {
+	exchange.mu.Lock()
+	defer exchange.mu.Unlock()
+
+	redacted := &Exchange{
+		ID:        exchange.ID,
+		Time:      exchange.Time,
+		Method:    exchange.Method,
+		Path:      r.applyRules(exchange.Path),
+		Headers:   make(map[string]string, len(exchange.Headers)),
+		Request:   r.String("body", exchange.Request),
+		Queries:   make([]*RecordedQuery, 0, len(exchange.Queries)),
+		Status:    exchange.Status,
+		Response:  r.String("body", exchange.Response),
+		Truncated: exchange.Truncated,
+		Duration:  exchange.Duration,
+
+		RequestTruncated: exchange.RequestTruncated,
+	}
+	for name, value := range exchange.Headers {
+		redacted.Headers[name] = r.String(name, value)
+	}
+	for _, query := range exchange.Queries {
+		redactedQuery := &RecordedQuery{
+			BotName: query.BotName,
+			Query:   r.poeQuery(query.Query),
+			Events:  make([]TimedEvent, len(query.Events)),
+			Error:   r.applyRules(query.Error),
+		}
+		for i, event := range query.Events {
+			redactedQuery.Events[i] = TimedEvent{
+				Event:  event.Event,
+				Data:   r.String("data", event.Data),
+				Offset: event.Offset,
+			}
+		}
+		redacted.Queries = append(redacted.Queries, redactedQuery)
+	}
+	return redacted
+}
+
+// poeQuery returns a redacted copy of a recorded Poe query.
+func (r *Redactor) poeQuery(query *types.PoeQueryRequest) *types.PoeQueryRequest {
+	if query == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(query)
+	if err != nil {
+		return query
+	}
+	var redacted types.PoeQueryRequest
+	if err := json.Unmarshal([]byte(r.String("query", string(encoded))), &redacted); err != nil {
+		return query
+	}
+	return &redacted
+}
+
+// RedactingSink is an ExchangeSink recording redacted copies of exchanges to other sinks.
+type RedactingSink struct {
+	redactor *Redactor
+	sinks    []ExchangeSink
+}
+
+// NewRedactingSink creates a RedactingSink recording to sinks.
+func NewRedactingSink(redactor *Redactor, sinks ...ExchangeSink) *RedactingSink {
+	return &RedactingSink{redactor: redactor, sinks: sinks}
+}
+
+// Record implements ExchangeSink, returning the errors of all sinks.
+func (s *RedactingSink) Record(exchange *Exchange) error {
+	redacted := s.redactor.Exchange(exchange)
+	var errs []error
+	for _, sink := range s.sinks {
+		if err := sink.Record(redacted); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RedactingHandler is a slog.Handler redacting records with a Redactor before passing them to
+// another handler.
+type RedactingHandler struct {
+	next     slog.Handler
+	redactor *Redactor
+}
+
+// NewRedactingHandler creates a RedactingHandler passing redacted records to next.
+func NewRedactingHandler(next slog.Handler, redactor *Redactor) *RedactingHandler {
+	return &RedactingHandler{next: next, redactor: redactor}
+}
+
+// Enabled implements slog.Handler.
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *RedactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.redactor.applyRules(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.redactor.Attr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = h.redactor.Attr(attr)
+	}
+	return &RedactingHandler{next: h.next.WithAttrs(redacted), redactor: h.redactor}
+}
+
+// WithGroup implements slog.Handler.
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{next: h.next.WithGroup(name), redactor: h.redactor}
+}
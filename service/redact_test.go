@@ -0,0 +1,186 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/types"
+)
+
+// redactTestRules returns the built-in rules and a custom one.
+func redactTestRules(t *testing.T) []RedactionRule {
+	t.Helper()
+	var rules []RedactionRule
+	for _, spec := range []string{"email", "card", "ticket=TICKET-[0-9]+"} {
+		rule, err := ParseRedactionRule(spec)
+		require.NoError(t, err)
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func TestRedactorString(t *testing.T) {
+	rules := redactTestRules(t)
+	tests := []struct {
+		name   string
+		config RedactConfig
+		key    string
+		value  string
+		want   string
+	}{
+		{"secret key", RedactConfig{}, "Authorization", "Bearer sk-1", redactedAPIKey},
+		{"empty secret", RedactConfig{}, "api_key", "", ""},
+		{"custom secret key", RedactConfig{SecretKeys: []string{"X-Session"}}, "x_session", "abc", redactedAPIKey},
+		{"plain value", RedactConfig{Rules: rules}, "msg", "hello", "hello"},
+		{"email", RedactConfig{Rules: rules}, "msg", "mail bob@example.com now", "mail [REDACTED:email] now"},
+		{"valid card", RedactConfig{Rules: rules}, "msg", "card 4111 1111 1111 1111", "card [REDACTED:card]"},
+		{"invalid card", RedactConfig{Rules: rules}, "msg", "order 1234567890123456", "order 1234567890123456"},
+		{"custom rule", RedactConfig{Rules: rules}, "msg", "see TICKET-42", "see [REDACTED:ticket]"},
+		{
+			"JSON document",
+			RedactConfig{Rules: rules},
+			"body",
+			`{"api_key":"sk-1","content":"bob@example.com","n":1}`,
+			`{"api_key":"[REDACTED]","content":"[REDACTED:email]","n":1}`,
+		},
+		{
+			"SSE events",
+			RedactConfig{Rules: rules},
+			"body",
+			"event: text\ndata: {\"text\":\"bob@example.com\"}\n",
+			"event: text\ndata: {\"text\":\"[REDACTED:email]\"}\n",
+		},
+		{"hashed content", RedactConfig{Content: ContentHash}, "content", "hello", "[sha256:2cf24dba5fb0]"},
+		{"hash only content", RedactConfig{Content: ContentHash}, "msg", "hello", "hello"},
+		{
+			"truncated content",
+			RedactConfig{Content: ContentTruncate, ContentLength: 3},
+			"prompt",
+			"héllo",
+			"hél... [5 characters]",
+		},
+		{"short content", RedactConfig{Content: ContentTruncate, ContentLength: 10}, "text", "hello", "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, NewRedactor(tt.config).String(tt.key, tt.value))
+		})
+	}
+}
+
+func TestParseRedactionRule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"email", "email", false},
+		{" Card ", "card", false},
+		{"id=[0-9]+", "id", false},
+		{"phone", "", true},
+		{"=[0-9]+", "", true},
+		{"id=(", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			rule, err := ParseRedactionRule(tt.spec)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rule.Name)
+		})
+	}
+}
+
+func TestRedactorExchange(t *testing.T) {
+	exchange := &Exchange{
+		Request:  `{"messages":[{"content":"bob@example.com"}]}`,
+		Response: `{"id":"1"}`,
+		Queries: []*RecordedQuery{{
+			BotName: "GPT-4o",
+			Query: &types.PoeQueryRequest{
+				APIKey: "poe-key",
+				Query:  []types.PoeProtocolMessage{{Role: "user", Content: "bob@example.com"}},
+			},
+		}},
+	}
+	redactor := NewRedactor(RedactConfig{
+		Content: ContentHash,
+		Rules:   []RedactionRule{{Name: "email", Pattern: regexp.MustCompile(`\S+@\S+`)}},
+	})
+
+	tests := []struct {
+		name        string
+		redactor    *Redactor
+		wantContent string
+	}{
+		{"content rules", redactor, "[sha256:5ff860bf1190]"},
+		{"secrets only", redactor.SecretsOnly(), "bob@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := tt.redactor.Exchange(exchange).Queries[0].Query
+			assert.Equal(t, redactedAPIKey, query.APIKey)
+			assert.Equal(t, tt.wantContent, query.Query[0].Content)
+		})
+	}
+}
+
+func TestParseContentRedaction(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ContentRedaction
+		wantErr bool
+	}{
+		{"", ContentKeep, false},
+		{"keep", ContentKeep, false},
+		{" Hash ", ContentHash, false},
+		{"truncate", ContentTruncate, false},
+		{"drop", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			mode, err := ParseContentRedaction(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, mode)
+		})
+	}
+}
+
+func TestRedactingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	redactor := NewRedactor(RedactConfig{Rules: redactTestRules(t)})
+	logger := slog.New(NewRedactingHandler(slog.NewJSONHandler(&buf, nil), redactor)).
+		With("api_key", "poe-key")
+
+	header := http.Header{"Authorization": {"Bearer sk-1"}, "Accept": {"text/event-stream"}}
+	logger.WithGroup("request").Info(
+		"Mail from bob@example.com",
+		"headers", header,
+		"error", errors.New("unknown ticket TICKET-7"),
+		"count", 3,
+	)
+
+	logged := buf.String()
+	for _, secret := range []string{"poe-key", "sk-1", "bob@example.com", "TICKET-7"} {
+		assert.NotContains(t, logged, secret)
+	}
+	assert.Contains(t, logged, `"api_key":"[REDACTED]"`)
+	assert.Contains(t, logged, `"msg":"Mail from [REDACTED:email]"`)
+	assert.Contains(t, logged, `"Authorization":"[REDACTED]"`)
+	assert.Contains(t, logged, `"Accept":["text/event-stream"]`)
+	assert.Contains(t, logged, `"error":"unknown ticket [REDACTED:ticket]"`)
+	assert.Contains(t, logged, `"count":3`)
+}
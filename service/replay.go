@@ -0,0 +1,215 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// volatileFieldPattern matches the JSON fields of adapter responses that differ between runs of
+// the same exchange: generated IDs, timestamps and durations.
+var volatileFieldPattern = regexp.MustCompile(
+	`"(id|created|created_at|completed_at|total_duration|response_id|previous_response_id)":\s*("(?:[^"\\]|\\.)*"|-?[0-9.]+)`,
+)
+
+// ReplayTransport is an http.RoundTripper standing in for Poe: it answers the bot queries made
+// to it with the events of recorded queries. Each query is answered by the first unused recorded
+// query with the same canonical key (see CanonicalQueryKey), or else by the first unused one to
+// the same bot, which counts as a mismatch: the adapter no longer sends the recorded query.
+// Failed recorded queries are never used up, as the client retries them. Other requests, such
+// as file uploads, fail. It is safe for concurrent use.
+type ReplayTransport struct {
+	mu         sync.Mutex
+	queries    []*RecordedQuery
+	keys       []string
+	used       []bool
+	mismatches int
+}
+
+// NewReplayTransport creates a ReplayTransport answering with the events of queries.
+func NewReplayTransport(queries []*RecordedQuery) *ReplayTransport {
+	t := &ReplayTransport{
+		queries: queries,
+		keys:    make([]string, len(queries)),
+		used:    make([]bool, len(queries)),
+	}
+	for i, query := range queries {
+		if query.Query != nil { // Queries without body only match by bot.
+			t.keys[i] = CanonicalQueryKey(query.BotName, query.Query, "", nil)
+		}
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || !strings.HasPrefix(req.URL.Path, "/bot/") {
+		return nil, fmt.Errorf("replay backend does not support %s %s", req.Method, req.URL)
+	}
+	botName := strings.TrimPrefix(req.URL.Path, "/bot/")
+	if req.Body == nil {
+		return nil, fmt.Errorf("replay backend received a query to bot %s without body", botName)
+	}
+	var poeQuery types.PoeQueryRequest
+	err := json.NewDecoder(req.Body).Decode(&poeQuery)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("replay backend received an invalid query to bot %s: %w", botName, err)
+	}
+
+	query, ok := t.take(botName, CanonicalQueryKey(botName, &poeQuery, "", nil))
+	if !ok {
+		return nil, fmt.Errorf("replay backend has no recorded query left for bot %s", botName)
+	}
+	if len(query.Events) == 0 && query.Error != "" {
+		return replayResponse(req, http.StatusBadGateway, "text/plain", query.Error), nil
+	}
+
+	var body strings.Builder
+	for _, event := range query.Events {
+		fmt.Fprintf(&body, "event: %s\ndata: %s\n\n", event.Event, event.Data)
+	}
+	return replayResponse(req, http.StatusOK, "text/event-stream", body.String()), nil
+}
+
+// take returns the recorded query answering a query to botName with the given canonical key.
+func (t *ReplayTransport) take(botName string, key string) (*RecordedQuery, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	found := -1
+	for i := range t.queries {
+		if !t.used[i] && t.keys[i] == key {
+			found = i
+			break
+		}
+	}
+	if found < 0 {
+		for i, query := range t.queries {
+			if !t.used[i] && strings.EqualFold(query.BotName, botName) {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return nil, false
+		}
+		t.mismatches++
+	}
+	query := t.queries[found]
+	t.used[found] = query.Error == "" || len(query.Events) > 0
+	return query, true
+}
+
+// Mismatches returns the number of queries answered by a recorded query with another key.
+func (t *ReplayTransport) Mismatches() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mismatches
+}
+
+// Remaining returns the number of successful recorded queries not replayed.
+func (t *ReplayTransport) Remaining() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	remaining := 0
+	for i, query := range t.queries {
+		if !t.used[i] && (query.Error == "" || len(query.Events) > 0) {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+// replayResponse builds the response of a replayed request.
+func replayResponse(req *http.Request, status int, contentType string, body string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {contentType}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// NormalizeResponse replaces the values of the volatile fields of a response body, so that
+// responses to the same exchange can be compared. JSON documents, whole bodies or lines of SSE
+// events and NDJSON, are re-encoded with sorted fields first, as recorded responses are (see
+// Redactor.Exchange).
+func NormalizeResponse(body string) string {
+	if canonical, ok := canonicalJSON(strings.TrimSpace(body)); ok {
+		body = canonical
+	} else {
+		lines := strings.Split(body, "\n")
+		for i, line := range lines {
+			prefix, document := "", line
+			if data, ok := strings.CutPrefix(line, "data:"); ok {
+				prefix, document = "data: ", strings.TrimSpace(data)
+			}
+			if canonical, ok := canonicalJSON(document); ok {
+				lines[i] = prefix + canonical
+			}
+		}
+		body = strings.Join(lines, "\n")
+	}
+	return volatileFieldPattern.ReplaceAllString(body, `"$1":"<volatile>"`)
+}
+
+// canonicalJSON re-encodes a JSON object or array with sorted fields; it returns false if
+// document is not one.
+func canonicalJSON(document string) (string, bool) {
+	if document == "" || (document[0] != '{' && document[0] != '[') {
+		return "", false
+	}
+	decoder := json.NewDecoder(strings.NewReader(document))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return "", false
+	}
+	canonical, err := marshalRedacted(value)
+	if err != nil {
+		return "", false
+	}
+	return string(canonical), true
+}
+
+// DiffResponses compares two normalized response bodies line by line. It returns an empty
+// string if they are equal, and otherwise the differing lines, prefixed with "-" for want and
+// "+" for got.
+func DiffResponses(want string, got string) string {
+	wantLines := strings.Split(strings.TrimRight(want, "\n"), "\n")
+	gotLines := strings.Split(strings.TrimRight(got, "\n"), "\n")
+
+	var diff strings.Builder
+	for i := 0; i < max(len(wantLines), len(gotLines)); i++ {
+		var wantLine, gotLine string
+		if i < len(wantLines) {
+			wantLine = wantLines[i]
+		}
+		if i < len(gotLines) {
+			gotLine = gotLines[i]
+		}
+		if wantLine == gotLine {
+			continue
+		}
+		fmt.Fprintf(&diff, "@@ line %d @@\n", i+1)
+		if i < len(wantLines) {
+			fmt.Fprintf(&diff, "-%s\n", wantLine)
+		}
+		if i < len(gotLines) {
+			fmt.Fprintf(&diff, "+%s\n", gotLine)
+		}
+	}
+	return diff.String()
+}
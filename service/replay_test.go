@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		recorded string
+		replayed string
+	}{
+		{
+			"volatile fields",
+			`{"id":"chatcmpl-1","created":1700000000,"model":"GPT-4o"}`,
+			`{"id":"chatcmpl-2","created":1700000042,"model":"GPT-4o"}`,
+		},
+		{
+			"sorted fields",
+			`{"choices":[{"index":0,"message":{"content":"Hello","role":"assistant"}}],"object":"chat.completion"}`,
+			`{"object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"Hello"}}]}` + "\n",
+		},
+		{
+			"server-sent events",
+			"data: {\"choices\":[],\"id\":\"chatcmpl-1\"}\n\ndata: [DONE]\n\n",
+			"data: {\"id\":\"chatcmpl-2\",\"choices\":[]}\n\ndata: [DONE]\n\n",
+		},
+		{
+			"NDJSON",
+			"{\"done\":false,\"model\":\"GPT-4o\"}\n{\"done\":true,\"total_duration\":12}\n",
+			"{\"model\":\"GPT-4o\",\"done\":false}\n{\"total_duration\":34,\"done\":true}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Empty(t, DiffResponses(NormalizeResponse(tt.recorded), NormalizeResponse(tt.replayed)))
+		})
+	}
+
+	assert.NotEmpty(t, DiffResponses(
+		NormalizeResponse(`{"content":"Hello"}`),
+		NormalizeResponse(`{"content":"Goodbye"}`),
+	), "other fields are compared")
+	assert.Equal(t, "Not JSON", NormalizeResponse("Not JSON"))
+}
@@ -0,0 +1,427 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// ResponsesInputToChatMessages converts the instructions and input of a Responses API request
+// into OpenAI chat messages, so that the request can go through TransformOpenAIRequestToPoeQuery.
+// Function calls and their outputs are rendered as text, as Poe messages only carry text.
+func ResponsesInputToChatMessages(req *types.ResponsesRequest) ([]types.OpenAIMessage, error) {
+	var messages []types.OpenAIMessage
+	if req.Instructions != "" {
+		messages = append(messages, types.OpenAIMessage{Role: "system", Content: req.Instructions})
+	}
+
+	switch input := req.Input.(type) {
+	case string:
+		messages = append(messages, types.OpenAIMessage{Role: "user", Content: input})
+	case []interface{}:
+		// Re-decode the generic items into typed input items.
+		inputBytes, err := json.Marshal(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-encode input items: %w", err)
+		}
+		var items []types.ResponsesInputItem
+		if err := json.Unmarshal(inputBytes, &items); err != nil {
+			return nil, fmt.Errorf("invalid input items: %w", err)
+		}
+		for i, item := range items {
+			message, err := responsesInputItemToChatMessage(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid input item %d: %w", i, err)
+			}
+			messages = append(messages, message)
+		}
+	case nil:
+		return nil, fmt.Errorf("input is required")
+	default:
+		return nil, fmt.Errorf("input must be a string or a list of items, got %T", req.Input)
+	}
+	return messages, nil
+}
+
+// responsesInputItemToChatMessage converts a single Responses API input item into an OpenAI chat message.
+func responsesInputItemToChatMessage(item types.ResponsesInputItem) (types.OpenAIMessage, error) {
+	itemType := item.Type
+	if itemType == "" && item.Role != "" {
+		itemType = "message"
+	}
+	switch itemType {
+	case "message":
+		return types.OpenAIMessage{
+			Role:    item.Role,
+			Content: responsesContentToChatContent(item.Content),
+		}, nil
+	case "function_call":
+		return types.OpenAIMessage{
+			Role:    "assistant",
+			Content: RenderFunctionCall(item.CallID, item.Name, item.Arguments),
+			ToolCalls: []types.OpenAIToolCall{{
+				ID:   item.CallID,
+				Type: "function",
+				Function: types.OpenAIFunctionCall{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			}},
+		}, nil
+	case "function_call_output":
+		return types.OpenAIMessage{
+			Role:       "tool",
+			Content:    RenderFunctionCallOutput(item.CallID, item.Output),
+			ToolCallID: item.CallID,
+		}, nil
+	default:
+		return types.OpenAIMessage{}, fmt.Errorf("unsupported input item type %q", item.Type)
+	}
+}
+
+// responsesContentToChatContent converts Responses API message content (a string or a list of
+// input_text/input_image/output_text parts) into OpenAI chat message content.
+func responsesContentToChatContent(content any) any {
+	parts, ok := content.([]interface{})
+	if !ok {
+		return content // A plain string, used as is.
+	}
+	chatParts := make([]types.OpenAIContentPart, 0, len(parts))
+	for i, part := range parts {
+		partMap, ok := part.(map[string]interface{})
+		if !ok {
+			slog.Warn("Responses content part is not an object, skipping it", "part_index", i)
+			continue
+		}
+		partType, _ := partMap["type"].(string)
+		switch partType {
+		case "input_text", "output_text", "text":
+			text, _ := partMap["text"].(string)
+			chatParts = append(chatParts, types.OpenAIContentPart{Type: "text", Text: text})
+		case "input_image":
+			imageURL, _ := partMap["image_url"].(string)
+			chatParts = append(chatParts, types.OpenAIContentPart{
+				Type:     "image_url",
+				ImageURL: &types.OpenAIImageURL{URL: imageURL},
+			})
+		default:
+			slog.Warn("Unsupported Responses content part type, skipping it", "type", partType, "part_index", i)
+		}
+	}
+	return chatParts
+}
+
+// RenderFunctionCall renders a function call made by the model as message text,
+// since Poe protocol messages have no structured tool call field.
+func RenderFunctionCall(callID string, name string, arguments string) string {
+	return fmt.Sprintf("[Called function %s (call_id: %s) with arguments: %s]", name, callID, arguments)
+}
+
+// RenderFunctionCallOutput renders the output of a function call as message text.
+func RenderFunctionCallOutput(callID string, output string) string {
+	return fmt.Sprintf("[Output of function call %s]\n%s", callID, output)
+}
+
+// TransformResponsesRequestToChatRequest builds the OpenAI chat completion request equivalent to
+// a Responses API request. history holds the messages of the previous response, if chained
+// with previous_response_id; they are placed before the request's own messages.
+func TransformResponsesRequestToChatRequest(
+	req *types.ResponsesRequest,
+	history []types.OpenAIMessage,
+	messages []types.OpenAIMessage,
+) *types.OpenAIChatCompletionRequest {
+	chatReq := &types.OpenAIChatCompletionRequest{
+		Model:      req.Model,
+		Messages:   append(append([]types.OpenAIMessage{}, history...), messages...),
+		Stream:     req.Stream,
+		User:       req.User,
+		ToolChoice: req.ToolChoice,
+		MaxTokens:  req.MaxOutputTokens,
+		Tools:      make([]types.OpenAITool, 0, len(req.Tools)),
+	}
+	chatReq.Temperature = req.Temperature
+	if req.TopP != nil {
+		chatReq.TopP = *req.TopP
+	}
+	for _, tool := range req.Tools {
+		chatReq.Tools = append(chatReq.Tools, types.OpenAITool{
+			Type: tool.Type,
+			Function: types.OpenAIFunctionTool{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	if len(chatReq.Tools) == 0 {
+		chatReq.Tools = nil
+	}
+	return chatReq
+}
+
+// ResponsesOutputToChatMessages converts the output items of a response into OpenAI chat messages,
+// used as history when a later request chains onto it with previous_response_id. Function calls
+// keep their tool calls, like function_call input items.
+func ResponsesOutputToChatMessages(output []types.ResponsesOutputItem) []types.OpenAIMessage {
+	var messages []types.OpenAIMessage
+	for _, item := range output {
+		switch item.Type {
+		case "message":
+			for _, part := range item.Content {
+				messages = append(messages, types.OpenAIMessage{Role: "assistant", Content: part.Text})
+			}
+		case "function_call":
+			messages = append(messages, types.OpenAIMessage{
+				Role:    "assistant",
+				Content: RenderFunctionCall(item.CallID, item.Name, item.Arguments),
+				ToolCalls: []types.OpenAIToolCall{{
+					ID:   item.CallID,
+					Type: "function",
+					Function: types.OpenAIFunctionCall{
+						Name:      item.Name,
+						Arguments: item.Arguments,
+					},
+				}},
+			})
+		}
+	}
+	return messages
+}
+
+// ResponsesStream turns decoded Poe events into Responses API output items and typed stream events.
+// It is used for both streaming and non-streaming responses: in the latter case the events
+// are simply discarded and only the final response is kept.
+type ResponsesStream struct {
+	response       types.ResponsesResponse
+	sequenceNumber int
+	// messageIndex is the output index of the open message item, or -1 if none is open.
+	messageIndex int
+	// toolCallIndexes maps Poe tool call indexes to output indexes.
+	toolCallIndexes map[int]int
+	toolCalls       ToolCallAccumulator
+}
+
+// NewResponsesStream creates a ResponsesStream for a new response to req.
+func NewResponsesStream(responseID string, req *types.ResponsesRequest) *ResponsesStream {
+	return &ResponsesStream{
+		response: types.ResponsesResponse{
+			ID:                 responseID,
+			Object:             "response",
+			CreatedAt:          time.Now().Unix(),
+			Status:             "in_progress",
+			Model:              req.Model,
+			Instructions:       req.Instructions,
+			Output:             []types.ResponsesOutputItem{},
+			PreviousResponseID: req.PreviousResponseID,
+			Metadata:           req.Metadata,
+		},
+		messageIndex:    -1,
+		toolCallIndexes: make(map[int]int),
+	}
+}
+
+// Response returns the response object in its current state.
+func (s *ResponsesStream) Response() *types.ResponsesResponse {
+	return &s.response
+}
+
+// event builds the next stream event of the given type.
+func (s *ResponsesStream) event(eventType string) types.ResponsesStreamEvent {
+	event := types.ResponsesStreamEvent{Type: eventType, SequenceNumber: s.sequenceNumber}
+	s.sequenceNumber++
+	return event
+}
+
+// snapshot returns a copy of the response, so that events are not altered by later updates.
+func (s *ResponsesStream) snapshot() *types.ResponsesResponse {
+	response := s.response
+	response.Output = append([]types.ResponsesOutputItem{}, s.response.Output...)
+	return &response
+}
+
+// Start returns the events announcing the creation of the response.
+func (s *ResponsesStream) Start() []types.ResponsesStreamEvent {
+	created := s.event("response.created")
+	created.Response = s.snapshot()
+	inProgress := s.event("response.in_progress")
+	inProgress.Response = s.snapshot()
+	return []types.ResponsesStreamEvent{created, inProgress}
+}
+
+// Handle processes a decoded Poe event and returns the resulting stream events.
+func (s *ResponsesStream) Handle(botEvent BotEvent) []types.ResponsesStreamEvent {
+	switch botEvent.Kind {
+	case BotEventText:
+		return s.appendText(botEvent.Text)
+	case BotEventReplace:
+		// The Responses API cannot retract text already sent, so replacements are appended like the chat endpoint does.
+		slog.Debug("Processing Poe 'replace_response' as text delta for Responses stream.")
+		return s.appendText(botEvent.Text)
+	case BotEventFile:
+		return s.appendText(RenderPoeFileDelta(*botEvent.File))
+	case BotEventToolCalls:
+		events := s.appendText(botEvent.Text)
+		return append(events, s.appendToolCalls(botEvent.ToolCalls)...)
+	default:
+		return nil
+	}
+}
+
+// appendText appends text to the open message item, opening one if needed.
+func (s *ResponsesStream) appendText(text string) []types.ResponsesStreamEvent {
+	if text == "" {
+		return nil
+	}
+	var events []types.ResponsesStreamEvent
+	if s.messageIndex < 0 {
+		s.messageIndex = len(s.response.Output)
+		s.response.Output = append(s.response.Output, types.ResponsesOutputItem{
+			Type:    "message",
+			ID:      GenerateID("msg"),
+			Status:  "in_progress",
+			Role:    "assistant",
+			Content: []types.ResponsesOutputText{},
+		})
+		itemAdded := s.event("response.output_item.added")
+		itemAdded.OutputIndex = intPtr(s.messageIndex)
+		item := s.response.Output[s.messageIndex]
+		itemAdded.Item = &item
+		events = append(events, itemAdded)
+
+		s.response.Output[s.messageIndex].Content = []types.ResponsesOutputText{
+			{Type: "output_text", Text: "", Annotations: []any{}},
+		}
+		partAdded := s.event("response.content_part.added")
+		partAdded.OutputIndex = intPtr(s.messageIndex)
+		partAdded.ContentIndex = intPtr(0)
+		partAdded.ItemID = item.ID
+		partAdded.Part = &types.ResponsesOutputText{Type: "output_text", Annotations: []any{}}
+		events = append(events, partAdded)
+	}
+
+	item := &s.response.Output[s.messageIndex]
+	item.Content[0].Text += text
+	delta := s.event("response.output_text.delta")
+	delta.OutputIndex = intPtr(s.messageIndex)
+	delta.ContentIndex = intPtr(0)
+	delta.ItemID = item.ID
+	delta.Delta = text
+	return append(events, delta)
+}
+
+// closeMessage completes the open message item, if any.
+func (s *ResponsesStream) closeMessage() []types.ResponsesStreamEvent {
+	if s.messageIndex < 0 {
+		return nil
+	}
+	item := &s.response.Output[s.messageIndex]
+	item.Status = "completed"
+
+	textDone := s.event("response.output_text.done")
+	textDone.OutputIndex = intPtr(s.messageIndex)
+	textDone.ContentIndex = intPtr(0)
+	textDone.ItemID = item.ID
+	textDone.Text = item.Content[0].Text
+
+	partDone := s.event("response.content_part.done")
+	partDone.OutputIndex = intPtr(s.messageIndex)
+	partDone.ContentIndex = intPtr(0)
+	partDone.ItemID = item.ID
+	part := item.Content[0]
+	partDone.Part = &part
+
+	itemDone := s.event("response.output_item.done")
+	itemDone.OutputIndex = intPtr(s.messageIndex)
+	itemCopy := *item
+	itemDone.Item = &itemCopy
+
+	s.messageIndex = -1
+	return []types.ResponsesStreamEvent{textDone, partDone, itemDone}
+}
+
+// appendToolCalls merges tool call fragments, opening a function_call item for each new call.
+func (s *ResponsesStream) appendToolCalls(deltas []ToolCallDelta) []types.ResponsesStreamEvent {
+	var events []types.ResponsesStreamEvent
+	started := s.toolCalls.Add(deltas)
+	for i, delta := range deltas {
+		call := s.toolCalls.Call(delta.Index)
+		if started[i] {
+			events = append(events, s.closeMessage()...)
+			if call.ID == "" {
+				call.ID = GenerateID("call")
+			}
+			outputIndex := len(s.response.Output)
+			s.toolCallIndexes[delta.Index] = outputIndex
+			s.response.Output = append(s.response.Output, types.ResponsesOutputItem{
+				Type:   "function_call",
+				ID:     GenerateID("fc"),
+				Status: "in_progress",
+				CallID: call.ID,
+				Name:   call.Function.Name,
+			})
+			itemAdded := s.event("response.output_item.added")
+			itemAdded.OutputIndex = intPtr(outputIndex)
+			item := s.response.Output[outputIndex]
+			itemAdded.Item = &item
+			events = append(events, itemAdded)
+		}
+
+		outputIndex := s.toolCallIndexes[delta.Index]
+		item := &s.response.Output[outputIndex]
+		item.Name = call.Function.Name
+		item.Arguments = call.Function.Arguments
+		if delta.Arguments != "" {
+			argsDelta := s.event("response.function_call_arguments.delta")
+			argsDelta.OutputIndex = intPtr(outputIndex)
+			argsDelta.ItemID = item.ID
+			argsDelta.Delta = delta.Arguments
+			events = append(events, argsDelta)
+		}
+	}
+	return events
+}
+
+// Finish completes all open output items and returns the final events, ending with
+// "response.completed".
+func (s *ResponsesStream) Finish() []types.ResponsesStreamEvent {
+	events := s.closeMessage()
+	for outputIndex := range s.response.Output {
+		item := &s.response.Output[outputIndex]
+		if item.Type != "function_call" || item.Status == "completed" {
+			continue
+		}
+		item.Status = "completed"
+		argsDone := s.event("response.function_call_arguments.done")
+		argsDone.OutputIndex = intPtr(outputIndex)
+		argsDone.ItemID = item.ID
+		argsDone.Arguments = item.Arguments
+		itemDone := s.event("response.output_item.done")
+		itemDone.OutputIndex = intPtr(outputIndex)
+		itemCopy := *item
+		itemDone.Item = &itemCopy
+		events = append(events, argsDone, itemDone)
+	}
+
+	s.response.Status = "completed"
+	s.response.Usage = &types.ResponsesUsage{} // Poe does not provide token usage.
+	completed := s.event("response.completed")
+	completed.Response = s.snapshot()
+	return append(events, completed)
+}
+
+// Fail marks the response as failed and returns the "response.failed" event.
+func (s *ResponsesStream) Fail(code string, message string) []types.ResponsesStreamEvent {
+	s.response.Status = "failed"
+	s.response.Error = &types.ResponsesError{Code: code, Message: message}
+	failed := s.event("response.failed")
+	failed.Response = s.snapshot()
+	return []types.ResponsesStreamEvent{failed}
+}
+
+// intPtr returns a pointer to v.
+func intPtr(v int) *int {
+	return &v
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/supergeoff/poepenai/types"
+)
+
+func TestResponsesInputToChatMessages(t *testing.T) {
+	var req types.ResponsesRequest
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"model": "GPT-4o",
+		"instructions": "Be brief.",
+		"input": [
+			{"role": "user", "content": [
+				{"type": "input_text", "text": "What is in this image?"},
+				{"type": "input_image", "image_url": "https://example.com/cat.png"}
+			]},
+			{"type": "function_call", "call_id": "call_1", "name": "lookup", "arguments": "{\"q\":\"cat\"}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "A cat."}
+		]
+	}`), &req))
+
+	messages, err := ResponsesInputToChatMessages(&req)
+	require.NoError(t, err)
+	require.Len(t, messages, 4)
+	assert.Equal(t, types.OpenAIMessage{Role: "system", Content: "Be brief."}, messages[0])
+	assert.Equal(t, "user", messages[1].Role)
+	assert.Equal(t, []types.OpenAIContentPart{
+		{Type: "text", Text: "What is in this image?"},
+		{Type: "image_url", ImageURL: &types.OpenAIImageURL{URL: "https://example.com/cat.png"}},
+	}, messages[1].Content)
+	assert.Equal(t, "assistant", messages[2].Role)
+	assert.Equal(t, RenderFunctionCall("call_1", "lookup", `{"q":"cat"}`), messages[2].Content)
+	require.Len(t, messages[2].ToolCalls, 1)
+	assert.Equal(t, "lookup", messages[2].ToolCalls[0].Function.Name)
+	assert.Equal(t, "tool", messages[3].Role)
+	assert.Equal(t, "call_1", messages[3].ToolCallID)
+	assert.Equal(t, RenderFunctionCallOutput("call_1", "A cat."), messages[3].Content)
+}
+
+func TestResponsesInputToChatMessagesInvalid(t *testing.T) {
+	for name, input := range map[string]any{
+		"missing":      nil,
+		"number":       42.0,
+		"unknown item": []any{map[string]any{"type": "reasoning"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ResponsesInputToChatMessages(&types.ResponsesRequest{Input: input})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestResponsesStreamToolCalls(t *testing.T) {
+	stream := NewResponsesStream("resp_1", &types.ResponsesRequest{Model: "GPT-4o"})
+	stream.Start()
+	stream.Handle(BotEvent{Kind: BotEventText, Text: "Let me look."})
+	stream.Handle(BotEvent{Kind: BotEventToolCalls, ToolCalls: []ToolCallDelta{
+		{Index: 0, ID: "call_1", Name: "lookup", Arguments: `{"q":`},
+		{Index: 0, Arguments: `"cat"}`},
+	}})
+	stream.Finish()
+
+	resp := stream.Response()
+	assert.Equal(t, "completed", resp.Status)
+	require.Len(t, resp.Output, 2)
+	assert.Equal(t, "message", resp.Output[0].Type)
+	assert.Equal(t, "Let me look.", resp.Output[0].Content[0].Text)
+	assert.Equal(t, "function_call", resp.Output[1].Type)
+	assert.Equal(t, "call_1", resp.Output[1].CallID)
+	assert.Equal(t, `{"q":"cat"}`, resp.Output[1].Arguments)
+
+	// Function calls carry over to the next responses as chat messages.
+	messages := ResponsesOutputToChatMessages(resp.Output)
+	require.Len(t, messages, 2)
+	require.Len(t, messages[1].ToolCalls, 1)
+	assert.Equal(t, "lookup", messages[1].ToolCalls[0].Function.Name)
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/supergeoff/poepenai/types"
+)
+
+// defaultResponseStoreSize is the default number of responses kept by a MemoryResponseStore.
+const defaultResponseStoreSize = 1000
+
+// StoredResponse is a Responses API response kept for retrieval and previous_response_id chaining.
+type StoredResponse struct {
+	// Response is the final response object.
+	Response *types.ResponsesResponse
+	// Messages is the full conversation up to and including the response output,
+	// as OpenAI chat messages. Instructions are not included, as they do not carry over.
+	Messages []types.OpenAIMessage
+	// ConversationID is the Poe conversation ID shared by all responses of a chain.
+	ConversationID string
+	// OwnerFingerprint is the KeyFingerprint of the key that created the response; only requests
+	// made with the same key may retrieve it or chain onto it.
+	OwnerFingerprint string
+}
+
+// OwnedBy reports whether the key with the given fingerprint created the stored response.
+func (s *StoredResponse) OwnedBy(fingerprint string) bool {
+	return s.OwnerFingerprint != "" && s.OwnerFingerprint == fingerprint
+}
+
+// ResponseStore stores Responses API responses by ID.
+type ResponseStore interface {
+	// Get returns the stored response with the given ID, if any.
+	Get(id string) (*StoredResponse, bool)
+	// Put stores a response, keyed by its ID.
+	Put(stored *StoredResponse)
+}
+
+// MemoryResponseStore is a bounded in-memory ResponseStore. When full, the oldest
+// response is evicted. It is safe for concurrent use.
+type MemoryResponseStore struct {
+	mu        sync.Mutex
+	responses map[string]*StoredResponse
+	order     []string // Response IDs, oldest first.
+	size      int
+}
+
+// NewMemoryResponseStore creates a MemoryResponseStore keeping at most size responses.
+// If size is less than or equal to 0, defaultResponseStoreSize is used.
+func NewMemoryResponseStore(size int) *MemoryResponseStore {
+	if size <= 0 {
+		size = defaultResponseStoreSize
+	}
+	return &MemoryResponseStore{
+		responses: make(map[string]*StoredResponse),
+		size:      size,
+	}
+}
+
+// Get implements ResponseStore.
+func (s *MemoryResponseStore) Get(id string) (*StoredResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.responses[id]
+	return stored, ok
+}
+
+// Put implements ResponseStore.
+func (s *MemoryResponseStore) Put(stored *StoredResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := stored.Response.ID
+	if _, exists := s.responses[id]; !exists {
+		s.order = append(s.order, id)
+	}
+	s.responses[id] = stored
+	for len(s.order) > s.size {
+		delete(s.responses, s.order[0])
+		s.order = s.order[1:]
+	}
+}
@@ -0,0 +1,39 @@
+package service
+
+import "strings"
+
+// ModelRouter maps the models requested by clients to the Poe bots queried for them. A nil
+// ModelRouter queries the bot named after the model.
+type ModelRouter struct {
+	// aliases maps lower-cased model names to Poe bot names.
+	aliases map[string]string
+}
+
+// NewModelRouter creates a ModelRouter with aliases mapping model names to Poe bot names. Model
+// names are matched case-insensitively, as Poe bot names are.
+func NewModelRouter(aliases map[string]string) *ModelRouter {
+	router := &ModelRouter{aliases: make(map[string]string, len(aliases))}
+	for alias, botName := range aliases {
+		router.aliases[strings.ToLower(alias)] = botName
+	}
+	return router
+}
+
+// Resolve returns the Poe bot queried for model.
+func (r *ModelRouter) Resolve(model string) string {
+	if r == nil {
+		return model
+	}
+	if botName, ok := r.aliases[strings.ToLower(model)]; ok {
+		return botName
+	}
+	return model
+}
+
+// Aliases returns the number of aliases of r.
+func (r *ModelRouter) Aliases() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.aliases)
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Runtime configuration reload sources.
+const (
+	ReloadSourceStartup = "startup"
+	ReloadSourceFile    = "file"
+	ReloadSourceSignal  = "signal"
+	ReloadSourceAdmin   = "admin"
+)
+
+// RuntimeConfig is the part of the configuration reloaded while the adapter runs. A snapshot is
+// pinned to each request, so that a reload does not change it midway; its values must not be
+// modified once published, except for Limiter, which is shared between snapshots so that the
+// request counts survive reloads.
+type RuntimeConfig struct {
+	Catalog *ModelCatalog
+	Router  *ModelRouter
+	Keys    *KeyStore
+	Limiter *RateLimiter
+}
+
+// ReloadStatus is the outcome of the loads of the runtime configuration.
+type ReloadStatus struct {
+	// Generation is the number of configurations loaded, 1 for the startup one.
+	Generation int       `json:"generation"`
+	LoadedAt   time.Time `json:"loaded_at"`
+	// Source is what triggered the load of the current configuration, see the ReloadSource
+	// constants.
+	Source string `json:"source"`
+	// LastError is the error of the last reload if it failed, and LastErrorAt its time.
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+}
+
+// RuntimeConfigStore holds the current RuntimeConfig, swapped atomically by reloads. It is safe
+// for concurrent use.
+type RuntimeConfigStore struct {
+	current atomic.Pointer[RuntimeConfig]
+	mu      sync.Mutex
+	status  ReloadStatus
+}
+
+// NewRuntimeConfigStore creates a RuntimeConfigStore holding the startup configuration initial.
+func NewRuntimeConfigStore(initial *RuntimeConfig) *RuntimeConfigStore {
+	store := &RuntimeConfigStore{
+		status: ReloadStatus{Generation: 1, LoadedAt: time.Now(), Source: ReloadSourceStartup},
+	}
+	store.current.Store(initial)
+	return store
+}
+
+// Current returns the current runtime configuration.
+func (s *RuntimeConfigStore) Current() *RuntimeConfig {
+	return s.current.Load()
+}
+
+// runtimeConfigContextKey is the context key of the RuntimeConfig pinned to a request.
+type runtimeConfigContextKey struct{}
+
+// Pin returns a copy of ctx carrying the current runtime configuration, used by For for the
+// rest of the request.
+func (s *RuntimeConfigStore) Pin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, runtimeConfigContextKey{}, s.Current())
+}
+
+// For returns the runtime configuration pinned to ctx, or the current one if none is.
+func (s *RuntimeConfigStore) For(ctx context.Context) *RuntimeConfig {
+	if config, ok := ctx.Value(runtimeConfigContextKey{}).(*RuntimeConfig); ok {
+		return config
+	}
+	return s.Current()
+}
+
+// Swap makes next the current runtime configuration, loaded because of source. Requests pinned
+// to the previous one keep it.
+func (s *RuntimeConfigStore) Swap(next *RuntimeConfig, source string) ReloadStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current.Store(next)
+	s.status = ReloadStatus{Generation: s.status.Generation + 1, LoadedAt: time.Now(), Source: source}
+	return s.status
+}
+
+// ReloadFailed records that a reload triggered by source failed with err, keeping the current
+// runtime configuration.
+func (s *RuntimeConfigStore) ReloadFailed(source string, err error) ReloadStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.LastError = source + ": " + err.Error()
+	s.status.LastErrorAt = time.Now()
+	return s.status
+}
+
+// Status returns the outcome of the loads of the runtime configuration.
+func (s *RuntimeConfigStore) Status() ReloadStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeConfigStore(t *testing.T) {
+	initial := &RuntimeConfig{Router: NewModelRouter(nil)}
+	store := NewRuntimeConfigStore(initial)
+	assert.Equal(t, 1, store.Status().Generation)
+	assert.Equal(t, ReloadSourceStartup, store.Status().Source)
+	pinned := store.Pin(context.Background())
+
+	next := &RuntimeConfig{Router: NewModelRouter(map[string]string{"gpt-4o": "GPT-4o"})}
+	status := store.Swap(next, ReloadSourceSignal)
+	assert.Equal(t, 2, status.Generation)
+	assert.Equal(t, ReloadSourceSignal, status.Source)
+	assert.Same(t, next, store.Current())
+	assert.Same(t, next, store.For(context.Background()))
+	assert.Same(t, initial, store.For(pinned), "pinned requests keep their configuration")
+
+	status = store.ReloadFailed(ReloadSourceFile, errors.New("invalid configuration"))
+	assert.Equal(t, 2, status.Generation, "failed reloads keep the current configuration")
+	assert.Equal(t, "file: invalid configuration", status.LastError)
+	assert.False(t, status.LastErrorAt.IsZero())
+	assert.Same(t, next, store.Current())
+
+	status = store.Swap(initial, ReloadSourceAdmin)
+	assert.Empty(t, status.LastError, "successful reloads clear the last error")
+}
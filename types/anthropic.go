@@ -0,0 +1,126 @@
+package types
+
+import "encoding/json"
+
+// AnthropicMessagesRequest is the structure for a request to the Anthropic Messages API (/v1/messages).
+type AnthropicMessagesRequest struct {
+	Model     string `json:"model"`
+	MaxTokens int    `json:"max_tokens"`
+	// System is either a string or a list of text content blocks.
+	System        any                  `json:"system,omitempty"`
+	Messages      []AnthropicMessage   `json:"messages"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	TopK          *int                 `json:"top_k,omitempty"`
+	Tools         []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *AnthropicToolChoice `json:"tool_choice,omitempty"`
+	Metadata      *AnthropicMetadata   `json:"metadata,omitempty"`
+}
+
+// AnthropicMessage is a single message of an Anthropic Messages API conversation.
+type AnthropicMessage struct {
+	Role string `json:"role"` // "user" or "assistant"
+	// Content is either a string or a list of AnthropicContentBlock.
+	Content any `json:"content"`
+}
+
+// AnthropicContentBlock is a content block of an Anthropic message.
+// Only the fields relevant to Type are set.
+type AnthropicContentBlock struct {
+	Type string `json:"type"` // "text", "image", "tool_use", "tool_result"
+	// Text is set for text blocks.
+	Text string `json:"text,omitempty"`
+	// Source is set for image blocks.
+	Source *AnthropicImageSource `json:"source,omitempty"`
+	// ID, Name and Input are set for tool_use blocks. Input is the JSON object of arguments.
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+	// ToolUseID, Content and IsError are set for tool_result blocks.
+	// Content is either a string or a list of text content blocks.
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   any    `json:"content,omitempty"`
+	IsError   bool   `json:"is_error,omitempty"`
+}
+
+// AnthropicImageSource is the source of an image content block.
+type AnthropicImageSource struct {
+	Type      string `json:"type"`                 // "base64" or "url"
+	MediaType string `json:"media_type,omitempty"` // e.g., "image/png", for base64 sources
+	Data      string `json:"data,omitempty"`       // Base64-encoded image, for base64 sources
+	URL       string `json:"url,omitempty"`        // For url sources
+}
+
+// AnthropicTool is a tool definition in the Anthropic Messages API.
+type AnthropicTool struct {
+	Name        string                   `json:"name"`
+	Description string                   `json:"description,omitempty"`
+	InputSchema OpenAIFunctionParameters `json:"input_schema"`
+}
+
+// AnthropicToolChoice controls how the model uses the provided tools.
+type AnthropicToolChoice struct {
+	Type string `json:"type"`           // "auto", "any", "tool" or "none"
+	Name string `json:"name,omitempty"` // Tool to use, for type "tool"
+}
+
+// AnthropicMetadata is the metadata of an Anthropic Messages API request.
+type AnthropicMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+// AnthropicUsage provides token usage statistics for a message.
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicMessagesResponse is the structure of an Anthropic Messages API response.
+type AnthropicMessagesResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"` // Always "message"
+	Role         string                  `json:"role"` // Always "assistant"
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"` // "end_turn", "tool_use"...; null while streaming
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+// AnthropicStreamDelta is the delta of content_block_delta and message_delta stream events.
+type AnthropicStreamDelta struct {
+	Type         string  `json:"type,omitempty"` // "text_delta" or "input_json_delta"
+	Text         string  `json:"text,omitempty"`
+	PartialJSON  *string `json:"partial_json,omitempty"`
+	StopReason   *string `json:"stop_reason,omitempty"`
+	StopSequence *string `json:"stop_sequence,omitempty"`
+}
+
+// AnthropicError describes an error in the Anthropic API format.
+type AnthropicError struct {
+	Type    string `json:"type"` // e.g., "invalid_request_error", "api_error"
+	Message string `json:"message"`
+}
+
+// AnthropicErrorResponse is the body of an Anthropic API error response, and the payload of
+// "error" stream events.
+type AnthropicErrorResponse struct {
+	Type  string         `json:"type"` // Always "error"
+	Error AnthropicError `json:"error"`
+}
+
+// AnthropicStreamEvent is a streamed Anthropic Messages API event.
+// Only the fields relevant to Type are set.
+type AnthropicStreamEvent struct {
+	Type    string                     `json:"type"` // e.g., "message_start", "content_block_delta"
+	Message *AnthropicMessagesResponse `json:"message,omitempty"`
+	Index   *int                       `json:"index,omitempty"`
+	// ContentBlock is the block opened by a content_block_start event. Unlike AnthropicContentBlock,
+	// it must always include the (empty) "text" or "input" field, so it is built as a map.
+	ContentBlock map[string]any        `json:"content_block,omitempty"`
+	Delta        *AnthropicStreamDelta `json:"delta,omitempty"`
+	Usage        *AnthropicUsage       `json:"usage,omitempty"`
+	Error        *AnthropicError       `json:"error,omitempty"`
+}
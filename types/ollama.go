@@ -0,0 +1,113 @@
+package types
+
+// OllamaOptions holds the model parameters of an Ollama request.
+type OllamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	NumPredict  *int     `json:"num_predict,omitempty"` // Maximum number of tokens to generate
+}
+
+// OllamaMessage is a message of an Ollama /api/chat conversation.
+type OllamaMessage struct {
+	Role    string `json:"role"` // "system", "user", "assistant" or "tool"
+	Content string `json:"content"`
+	// Images are base64-encoded images attached to the message.
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+}
+
+// OllamaToolCall is a tool call in an Ollama message. Unlike OpenAI, arguments are a JSON object.
+type OllamaToolCall struct {
+	Function OllamaToolCallFunction `json:"function"`
+}
+
+// OllamaToolCallFunction is the function called by an OllamaToolCall.
+type OllamaToolCallFunction struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+// OllamaChatRequest is the structure for a request to the Ollama /api/chat endpoint.
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Tools    []OpenAITool    `json:"tools,omitempty"` // Same format as OpenAI
+	Format   any             `json:"format,omitempty"`
+	Options  *OllamaOptions  `json:"options,omitempty"`
+	// Stream defaults to true in the Ollama API.
+	Stream    *bool `json:"stream,omitempty"`
+	KeepAlive any   `json:"keep_alive,omitempty"`
+}
+
+// OllamaGenerateRequest is the structure for a request to the Ollama /api/generate endpoint.
+type OllamaGenerateRequest struct {
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	Suffix  string         `json:"suffix,omitempty"`
+	System  string         `json:"system,omitempty"`
+	Images  []string       `json:"images,omitempty"`
+	Format  any            `json:"format,omitempty"`
+	Raw     bool           `json:"raw,omitempty"`
+	Options *OllamaOptions `json:"options,omitempty"`
+	// Stream defaults to true in the Ollama API.
+	Stream    *bool `json:"stream,omitempty"`
+	KeepAlive any   `json:"keep_alive,omitempty"`
+}
+
+// OllamaResponse is a streamed chunk, or the complete response, of the Ollama /api/chat and
+// /api/generate endpoints. Message is set for chat, Response for generate.
+type OllamaResponse struct {
+	Model      string         `json:"model"`
+	CreatedAt  string         `json:"created_at"` // RFC 3339 timestamp
+	Message    *OllamaMessage `json:"message,omitempty"`
+	Response   *string        `json:"response,omitempty"`
+	Done       bool           `json:"done"`
+	DoneReason string         `json:"done_reason,omitempty"`
+	// Durations are in nanoseconds, and only set on the final chunk.
+	TotalDuration   int64 `json:"total_duration,omitempty"`
+	PromptEvalCount int   `json:"prompt_eval_count,omitempty"`
+	EvalCount       int   `json:"eval_count,omitempty"`
+}
+
+// OllamaModelDetails describes a model in the Ollama /api/tags and /api/show responses.
+type OllamaModelDetails struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// OllamaModel is a model listed by the Ollama /api/tags endpoint.
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"` // RFC 3339 timestamp
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+// OllamaTagsResponse is the response of the Ollama /api/tags endpoint.
+type OllamaTagsResponse struct {
+	Models []OllamaModel `json:"models"`
+}
+
+// OllamaShowRequest is the structure for a request to the Ollama /api/show endpoint.
+type OllamaShowRequest struct {
+	Model string `json:"model,omitempty"`
+	Name  string `json:"name,omitempty"` // Deprecated alias of Model
+}
+
+// OllamaShowResponse is the response of the Ollama /api/show endpoint.
+type OllamaShowResponse struct {
+	Modelfile    string             `json:"modelfile"`
+	Parameters   string             `json:"parameters"`
+	Template     string             `json:"template"`
+	Details      OllamaModelDetails `json:"details"`
+	ModelInfo    map[string]any     `json:"model_info"`
+	Capabilities []string           `json:"capabilities"`
+	ModifiedAt   string             `json:"modified_at"` // RFC 3339 timestamp
+}
@@ -8,6 +8,17 @@ type OpenAIMessage struct {
 	Name       string           `json:"name,omitempty"`
 	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`   // Used in assistant responses
 	ToolCallID string           `json:"tool_call_id,omitempty"` // Used in tool messages
+	// Attachments is a poepenai extension listing files produced by the bot (e.g., generated images).
+	// It is only populated in stream deltas when the request sets include_attachments.
+	Attachments []OpenAIAttachment `json:"attachments,omitempty"`
+}
+
+// OpenAIAttachment describes a file returned by a Poe bot. This is a poepenai extension,
+// not part of the OpenAI API; clients opt in via OpenAIChatCompletionRequest.IncludeAttachments.
+type OpenAIAttachment struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type,omitempty"` // MIME type, e.g., "image/png"
+	Name        string `json:"name,omitempty"`
 }
 
 // OpenAIContentPart represents a part of a multi-modal message content.
@@ -60,7 +71,7 @@ type OpenAITool struct {
 type OpenAIChatCompletionRequest struct {
 	Model               string          `json:"model"`
 	Messages            []OpenAIMessage `json:"messages"`
-	Temperature         float64         `json:"temperature,omitempty"`
+	Temperature         *float64        `json:"temperature,omitempty"`
 	TopP                float64         `json:"top_p,omitempty"`
 	N                   int             `json:"n,omitempty"`
 	Stream              bool            `json:"stream,omitempty"`
@@ -77,6 +88,9 @@ type OpenAIChatCompletionRequest struct {
 	Seed                *int            `json:"seed,omitempty"`
 	LogProbs            bool            `json:"logprobs,omitempty"`
 	TopLogProbs         *int            `json:"top_logprobs,omitempty"`
+	// IncludeAttachments is a poepenai extension. When true, files sent by the bot are also
+	// listed in the "attachments" field of the response message (or stream delta).
+	IncludeAttachments bool `json:"include_attachments,omitempty"`
 }
 
 // ResponseFormat specifies the format of the response, e.g., JSON mode.
@@ -89,6 +103,8 @@ type OpenAIResponseMessage struct {
 	Role      string           `json:"role"`
 	Content   *string          `json:"content"` // Null if tool_calls is present
 	ToolCalls []OpenAIToolCall `json:"tool_calls,omitempty"`
+	// Attachments is a poepenai extension, see OpenAIAttachment.
+	Attachments []OpenAIAttachment `json:"attachments,omitempty"`
 }
 
 // OpenAIChoice represents one of the choices generated by the model.
@@ -155,3 +171,69 @@ type OpenAIChatCompletionChunk struct {
 	Usage             *OpenAIUsage         `json:"usage,omitempty"` // Only in the last chunk if stream_options.include_usage is true
 	SystemFingerprint string               `json:"system_fingerprint,omitempty"`
 }
+
+// OpenAIImageGenerationRequest is the structure for a request to the OpenAI /v1/images/generations endpoint.
+// The same fields are read from the multipart form of a /v1/images/edits request.
+type OpenAIImageGenerationRequest struct {
+	Model          string `json:"model,omitempty"` // Poe image bot; the configured default is used if empty
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n,omitempty"`               // Number of images, defaults to 1
+	Size           string `json:"size,omitempty"`            // e.g., "1024x1024", "1792x1024"
+	Quality        string `json:"quality,omitempty"`         // e.g., "standard", "hd"
+	Style          string `json:"style,omitempty"`           // e.g., "vivid", "natural"
+	ResponseFormat string `json:"response_format,omitempty"` // "url" (default) or "b64_json"
+	User           string `json:"user,omitempty"`
+}
+
+// OpenAIImageData is a single generated image in an images API response.
+type OpenAIImageData struct {
+	URL           string `json:"url,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+// OpenAIImagesResponse is the structure for a response from the OpenAI images API.
+type OpenAIImagesResponse struct {
+	Created int64             `json:"created"` // Unix timestamp
+	Data    []OpenAIImageData `json:"data"`
+}
+
+// OpenAICompletionRequest is the structure for a request to the legacy OpenAI /v1/completions endpoint.
+type OpenAICompletionRequest struct {
+	Model            string         `json:"model"`
+	Prompt           any            `json:"prompt"` // string or []string; token arrays are not supported
+	Suffix           string         `json:"suffix,omitempty"`
+	MaxTokens        *int           `json:"max_tokens,omitempty"`
+	Temperature      *float64       `json:"temperature,omitempty"`
+	TopP             float64        `json:"top_p,omitempty"`
+	N                int            `json:"n,omitempty"` // Completions per prompt, defaults to 1
+	Stream           bool           `json:"stream,omitempty"`
+	LogProbs         *int           `json:"logprobs,omitempty"`
+	Echo             bool           `json:"echo,omitempty"` // Prepend the prompt to each completion
+	Stop             any            `json:"stop,omitempty"` // string or []string
+	PresencePenalty  float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64        `json:"frequency_penalty,omitempty"`
+	BestOf           int            `json:"best_of,omitempty"`
+	LogitBias        map[string]int `json:"logit_bias,omitempty"`
+	User             string         `json:"user,omitempty"`
+	Seed             *int           `json:"seed,omitempty"`
+}
+
+// OpenAICompletionChoice is one of the completions in a legacy completions response or stream chunk.
+type OpenAICompletionChoice struct {
+	Text         string  `json:"text"`
+	Index        int     `json:"index"`
+	LogProbs     any     `json:"logprobs"`      // Always null, Poe does not provide log probabilities
+	FinishReason *string `json:"finish_reason"` // Null in stream chunks until the completion ends
+}
+
+// OpenAICompletionResponse is the structure for a response, or a streamed chunk, of the legacy
+// OpenAI /v1/completions endpoint. Object is "text_completion" in both cases.
+type OpenAICompletionResponse struct {
+	ID      string                   `json:"id"`
+	Object  string                   `json:"object"`
+	Created int64                    `json:"created"` // Unix timestamp
+	Model   string                   `json:"model"`
+	Choices []OpenAICompletionChoice `json:"choices"`
+	Usage   *OpenAIUsage             `json:"usage,omitempty"` // Only set on non-streaming responses
+}
@@ -21,7 +21,29 @@ type PoeProtocolMessage struct {
 	Timestamp int64 `json:"timestamp,omitempty"`
 	// MessageID is a unique identifier for the message.
 	MessageID string `json:"message_id,omitempty"`
-	// TODO: Attachments []PoeAttachment `json:"attachments,omitempty"` // For future use if needed
+	// Attachments are files attached to the message, e.g., an image to edit.
+	Attachments []PoeAttachment `json:"attachments,omitempty"`
+}
+
+// PoeAttachment represents a file attached to a PoeProtocolMessage.
+// The file must be reachable by Poe at URL, typically after being uploaded through the Poe file upload API.
+type PoeAttachment struct {
+	// URL of the attached file.
+	URL string `json:"url"`
+	// ContentType (MIME type) of the file.
+	ContentType string `json:"content_type"`
+	// Name of the file.
+	Name string `json:"name"`
+	// ParsedContent is the textual content of the file, if Poe has parsed it.
+	ParsedContent string `json:"parsed_content,omitempty"`
+}
+
+// PoeFileUploadResponse is the JSON body returned by the Poe file upload API.
+type PoeFileUploadResponse struct {
+	// AttachmentURL is the URL at which Poe bots can access the uploaded file.
+	AttachmentURL string `json:"attachment_url"`
+	// MimeType is the content type Poe detected for the uploaded file.
+	MimeType string `json:"mime_type"`
 }
 
 // PoeToolFunctionParamsDefinition defines the JSON schema for parameters of a Poe tool function.
@@ -88,6 +110,55 @@ type PoeQueryRequest struct {
 	// uses the APIKey field in the payload and also as a Bearer token in the HTTP Authorization header.
 }
 
+// PoeSettingsRequest is the structure sent to a Poe bot to request its settings.
+type PoeSettingsRequest struct {
+	// Version of the Poe protocol, e.g., "1.1".
+	Version string `json:"version"`
+	// Type of the request, always "settings".
+	Type string `json:"type"`
+}
+
+// PoeSettingsResponse is the JSON body returned by a Poe bot for a settings request. It
+// describes the capabilities of the bot.
+type PoeSettingsResponse struct {
+	// AllowAttachments indicates if the bot accepts attachments (e.g., images) in queries.
+	AllowAttachments *bool `json:"allow_attachments,omitempty"`
+	// ExpandTextAttachments indicates if Poe sends the parsed content of text attachments.
+	ExpandTextAttachments *bool `json:"expand_text_attachments,omitempty"`
+	// EnableImageComprehension indicates if Poe describes the attached images to the bot.
+	EnableImageComprehension *bool `json:"enable_image_comprehension,omitempty"`
+	// IntroductionMessage is the message the bot sends at the start of a conversation.
+	IntroductionMessage string `json:"introduction_message,omitempty"`
+	// ServerBotDependencies are the bots the bot calls, with the number of calls per query.
+	ServerBotDependencies map[string]int `json:"server_bot_dependencies,omitempty"`
+	// EnforceAuthorRoleAlternation indicates if Poe merges consecutive messages of the same role.
+	EnforceAuthorRoleAlternation bool `json:"enforce_author_role_alternation,omitempty"`
+	// EnableMultiBotChatPrompting indicates if Poe rewrites multi-bot conversations for the bot.
+	EnableMultiBotChatPrompting bool `json:"enable_multi_bot_chat_prompting,omitempty"`
+	// ContextClearWindowSecs is the inactivity after which the context of a conversation is
+	// cleared, if set.
+	ContextClearWindowSecs *int `json:"context_clear_window_secs,omitempty"`
+	// AllowUserContextClear indicates if users can clear the context of a conversation.
+	AllowUserContextClear bool `json:"allow_user_context_clear,omitempty"`
+}
+
+// PoeReportFeedbackRequest is the structure sent to a Poe bot to report the feedback of a user on
+// one of its messages.
+type PoeReportFeedbackRequest struct {
+	// Version of the Poe protocol, e.g., "1.1".
+	Version string `json:"version"`
+	// Type of the request, always "report_feedback".
+	Type string `json:"type"`
+	// MessageID identifies the message the feedback is about.
+	MessageID string `json:"message_id"`
+	// UserID is an anonymized identifier for the user.
+	UserID string `json:"user_id"`
+	// ConversationID is an identifier for the chat session of the message.
+	ConversationID string `json:"conversation_id"`
+	// FeedbackType is the feedback of the user.
+	FeedbackType PoeFeedbackType `json:"feedback_type"`
+}
+
 // PoePartialResponseData is the structure of the JSON data field for Poe SSE events
 // like "text", "replace_response", "suggested_reply", and "json".
 type PoePartialResponseData struct {
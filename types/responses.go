@@ -0,0 +1,114 @@
+package types
+
+// ResponsesRequest is the structure for a request to the OpenAI Responses API (/v1/responses).
+type ResponsesRequest struct {
+	Model string `json:"model"`
+	// Input is either a string (a single user message) or a list of ResponsesInputItem.
+	Input any `json:"input"`
+	// Instructions is a system message inserted before the input.
+	Instructions       string          `json:"instructions,omitempty"`
+	Tools              []ResponsesTool `json:"tools,omitempty"`
+	ToolChoice         any             `json:"tool_choice,omitempty"` // string or object
+	Temperature        *float64        `json:"temperature,omitempty"`
+	TopP               *float64        `json:"top_p,omitempty"`
+	MaxOutputTokens    *int            `json:"max_output_tokens,omitempty"`
+	Stream             bool            `json:"stream,omitempty"`
+	PreviousResponseID string          `json:"previous_response_id,omitempty"`
+	Store              *bool           `json:"store,omitempty"` // Defaults to true
+	User               string          `json:"user,omitempty"`
+	Metadata           map[string]any  `json:"metadata,omitempty"`
+}
+
+// ResponsesInputItem is an item of the Responses API input list.
+// Depending on Type, it is a message, a function call made by the model,
+// or the output of a function call.
+type ResponsesInputItem struct {
+	// Type is "message", "function_call" or "function_call_output".
+	// Items with a role and no type are messages.
+	Type string `json:"type,omitempty"`
+	// Role and Content are set for messages. Content is a string or a list of
+	// input_text/input_image/output_text content parts.
+	Role    string `json:"role,omitempty"`
+	Content any    `json:"content,omitempty"`
+	// CallID is set for function_call and function_call_output items.
+	CallID string `json:"call_id,omitempty"`
+	// Name and Arguments are set for function_call items.
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	// Output is set for function_call_output items.
+	Output string `json:"output,omitempty"`
+}
+
+// ResponsesTool is a tool definition in the Responses API. Unlike chat completions,
+// function fields are not nested under a "function" object.
+type ResponsesTool struct {
+	Type        string                   `json:"type"` // Only "function" is supported
+	Name        string                   `json:"name,omitempty"`
+	Description string                   `json:"description,omitempty"`
+	Parameters  OpenAIFunctionParameters `json:"parameters"`
+}
+
+// ResponsesOutputItem is an item of a Responses API response output.
+type ResponsesOutputItem struct {
+	Type   string `json:"type"` // "message" or "function_call"
+	ID     string `json:"id"`
+	Status string `json:"status"` // "in_progress" or "completed"
+	// Role and Content are set for messages.
+	Role    string                `json:"role,omitempty"`
+	Content []ResponsesOutputText `json:"content,omitempty"`
+	// CallID, Name and Arguments are set for function calls.
+	CallID    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// ResponsesOutputText is an "output_text" content part of a Responses API output message.
+type ResponsesOutputText struct {
+	Type        string `json:"type"` // Always "output_text"
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations"`
+}
+
+// ResponsesUsage provides token usage statistics for a response.
+type ResponsesUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ResponsesError describes why a response failed.
+type ResponsesError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ResponsesResponse is the structure of a Responses API response object.
+type ResponsesResponse struct {
+	ID                 string                `json:"id"`
+	Object             string                `json:"object"` // Always "response"
+	CreatedAt          int64                 `json:"created_at"`
+	Status             string                `json:"status"` // "in_progress", "completed" or "failed"
+	Model              string                `json:"model"`
+	Instructions       string                `json:"instructions,omitempty"`
+	Output             []ResponsesOutputItem `json:"output"`
+	PreviousResponseID string                `json:"previous_response_id,omitempty"`
+	Usage              *ResponsesUsage       `json:"usage,omitempty"`
+	Error              *ResponsesError       `json:"error,omitempty"`
+	Metadata           map[string]any        `json:"metadata,omitempty"`
+}
+
+// ResponsesStreamEvent is a typed event of a streamed Responses API response.
+// Only the fields relevant to Type are set.
+type ResponsesStreamEvent struct {
+	Type           string               `json:"type"` // e.g., "response.output_text.delta"
+	SequenceNumber int                  `json:"sequence_number"`
+	Response       *ResponsesResponse   `json:"response,omitempty"`
+	OutputIndex    *int                 `json:"output_index,omitempty"`
+	ContentIndex   *int                 `json:"content_index,omitempty"`
+	ItemID         string               `json:"item_id,omitempty"`
+	Item           *ResponsesOutputItem `json:"item,omitempty"`
+	Part           *ResponsesOutputText `json:"part,omitempty"`
+	Delta          string               `json:"delta,omitempty"`
+	Text           string               `json:"text,omitempty"`
+	Arguments      string               `json:"arguments,omitempty"`
+}